@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+// completionTimeout bounds the requests to the cluster made to complete a flag, so that a slow cluster never hangs the shell
+const completionTimeout = 5 * time.Second
+
+// registerCompletions completes the values of the flags of the root command, and of the subcommands sharing them:
+// the namespaces and resources from the cluster of the --context, the contexts from the kubeconfig, and the fixed values
+// of the enumerated flags
+func registerCompletions(cmd *cobra.Command) {
+	for _, name := range []string{"namespace", "exclude-namespace", "leader-elect-namespace"} {
+		_ = cmd.RegisterFlagCompletionFunc(name, completeNamespaces)
+	}
+	_ = cmd.RegisterFlagCompletionFunc("context", completeContexts)
+	_ = cmd.RegisterFlagCompletionFunc("resource", completeResources)
+	choices := map[string][]string{
+		"output":          {watcher.OutputYAML, watcher.OutputJSON, watcher.OutputJSONL, watcher.OutputCloudEvents, watcher.OutputDiff, watcher.OutputTable, watcher.OutputWide, "go-template=", "jsonpath="},
+		"color":           {"auto", "always", "never"},
+		"framing":         {watcher.FramingYAML, watcher.FramingJSONSeq, watcher.FramingLengthPrefixed, watcher.FramingNUL},
+		"inject-debug-on": {watcher.DebugOnFailing, watcher.DebugOnNotReady, watcher.DebugOnMatch},
+		"content-type":    {"protobuf", "json"},
+		"log-level":       {"debug", "info", "warn", "error"},
+		"log-format":      {"text", "json", "journald"},
+	}
+	for name, values := range choices {
+		_ = cmd.RegisterFlagCompletionFunc(name, cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp))
+	}
+	lists := map[string][]string{
+		"event-types": {"ADDED", "MODIFIED", "DELETED", watcher.ResyncEvent, watcher.ContainerEvent, watcher.TimelineEvent, watcher.AlertEvent, watcher.MetricsEvent, watcher.ReferenceEvent, watcher.VolumeEvent, watcher.TerminatingEvent, watcher.FinalizedEvent, watcher.InitEvent, watcher.ImageEvent, watcher.SecurityEvent, watcher.BackfillEvent},
+		"phase":       {string(corev1.PodPending), string(corev1.PodRunning), string(corev1.PodSucceeded), string(corev1.PodFailed), string(corev1.PodUnknown)},
+		"notify-on":   {watcher.TriggerDeleted, watcher.TriggerFailed, watcher.TriggerRestarted, watcher.TriggerAlert},
+	}
+	for name, values := range lists {
+		_ = cmd.RegisterFlagCompletionFunc(name, completeList(values))
+	}
+	for _, name := range []string{"output-dir", "capture-dir"} {
+		_ = cmd.MarkFlagDirname(name)
+	}
+}
+
+// completeList completes the values of a comma-separated list flag from a fixed list, after the values already given
+func completeList(values []string) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return withListPrefix(toComplete, values), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+}
+
+// withListPrefix prefixes the completions with the values of a comma-separated list typed so far
+func withListPrefix(toComplete string, values []string) []string {
+	i := strings.LastIndex(toComplete, ",")
+	if i < 0 {
+		return values
+	}
+	completions := make([]string, 0, len(values))
+	for _, value := range values {
+		completions = append(completions, toComplete[:i+1]+value)
+	}
+	return completions
+}
+
+// completeContexts completes --context with the contexts of the kubeconfig
+func completeContexts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	contexts, err := kubeconfigContexts()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	return contexts, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeNamespaces completes a namespace flag with the namespaces of the cluster of the first --context
+func completeNamespaces(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config, err := completionConfig()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+	defer cancel()
+	list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	namespaces := make([]string, 0, len(list.Items))
+	for _, namespace := range list.Items {
+		namespaces = append(namespaces, namespace.Name)
+	}
+	return withListPrefix(toComplete, namespaces), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeResources completes --resource with the watchable resources of the cluster of the first --context,
+// as resource.group, or just the resource for the core group
+func completeResources(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config, err := completionConfig()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	// Partial results are returned with the error of the groups that could not be discovered
+	lists, _ := clientset.Discovery().ServerPreferredResources()
+	var resources []string
+	for _, list := range lists {
+		groupVersion, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") || !slices.Contains(resource.Verbs, "watch") {
+				continue // subresources, and resources that cannot be watched
+			}
+			name := resource.Name
+			if groupVersion.Group != "" {
+				name += "." + groupVersion.Group
+			}
+			resources = append(resources, name)
+		}
+	}
+	sort.Strings(resources)
+	return resources, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completionConfig returns the client config of the first --context, or of the current context, with a short timeout
+func completionConfig() (*rest.Config, error) {
+	var contextName string
+	if len(kubecontexts) > 0 {
+		contextName = kubecontexts[0]
+	}
+	config, err := buildConfig(contextName)
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = completionTimeout
+	return config, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+var (
+	// configReader reads the --config file for configCommand; nil without --config
+	configReader  *viper.Viper
+	configCommand *cobra.Command
+	// configSettings are the settings of the config file currently applied to the flags
+	configSettings map[string]interface{}
+	// commandLineFlags are the flags given on the command line, which take precedence over the config file
+	commandLineFlags map[string]bool
+	// reloading is set once the first watcher has been created, so that the output files of the next are continued
+	reloading bool
+)
+
+// staticFlags only take effect at startup; changing them in the config file requires a restart
+var staticFlags = map[string]bool{
+	"config":       true,
+	"log-level":    true,
+	"log-format":   true,
+	"log-output":   true,
+	"metrics-addr": true,
+	"health-addr":  true,
+}
+
+// loadConfig reads the --config file and applies its settings to the flags not given on the command line
+func loadConfig(cmd *cobra.Command) error {
+	if configFile == "" {
+		return nil
+	}
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("could not read --config %s: %w", configFile, err)
+	}
+	commandLineFlags = make(map[string]bool)
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+	settings := v.AllSettings()
+	if err := applyConfig(cmd, settings, false); err != nil {
+		return err
+	}
+	configReader, configCommand, configSettings = v, cmd, settings
+	return nil
+}
+
+// applyConfig sets the flags not given on the command line from the config settings, keyed by flag name,
+// and resets those the settings leave out to their defaults. When reloading, the static flags are left alone.
+func applyConfig(cmd *cobra.Command, settings map[string]interface{}, reload bool) error {
+	flags := cmd.Flags()
+	profiles, err := parseProfiles(settings["profiles"])
+	if err != nil {
+		return err
+	}
+	watchProfiles = profiles
+	values := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if key == "profiles" {
+			continue
+		}
+		f := flags.Lookup(key)
+		if f == nil {
+			if !knownFlag(cmd.Root(), key) {
+				return fmt.Errorf("unknown setting %q in --config %s", key, configFile)
+			}
+			continue // a flag of another command
+		}
+		values[f.Name] = value
+	}
+	var errs error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if commandLineFlags[f.Name] || (reload && staticFlags[f.Name]) || f.Name == "help" {
+			return
+		}
+		value, ok := values[f.Name]
+		if !ok {
+			resetFlag(f)
+			return
+		}
+		if err := setFlag(f, value); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("invalid setting %q in --config %s: %w", f.Name, configFile, err))
+		}
+	})
+	return errs
+}
+
+// knownFlag reports whether the root command or any of its subcommands has a flag with the given name
+func knownFlag(root *cobra.Command, name string) bool {
+	for _, cmd := range append([]*cobra.Command{root}, root.Commands()...) {
+		if cmd.Flags().Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// setFlag sets a flag from a config setting: a list for repeatable flags, or a scalar in the flag's own syntax
+func setFlag(f *pflag.Flag, value interface{}) error {
+	slice, isSlice := f.Value.(pflag.SliceValue)
+	switch value := value.(type) {
+	case []interface{}:
+		if !isSlice {
+			return fmt.Errorf("a list is only accepted for repeatable flags")
+		}
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		if err := slice.Replace(items); err != nil {
+			return err
+		}
+	case map[string]interface{}:
+		return fmt.Errorf("must be a scalar or a list")
+	default:
+		if isSlice {
+			items := []string{fmt.Sprint(value)}
+			if f.Value.Type() == "stringSlice" {
+				items = strings.Split(items[0], ",")
+			}
+			if err := slice.Replace(items); err != nil {
+				return err
+			}
+		} else if err := f.Value.Set(fmt.Sprint(value)); err != nil {
+			return err
+		}
+	}
+	f.Changed = true
+	return nil
+}
+
+// resetFlag restores the default value of a flag
+func resetFlag(f *pflag.Flag) {
+	if slice, ok := f.Value.(pflag.SliceValue); ok {
+		_ = slice.Replace(nil)
+	} else {
+		_ = f.Value.Set(f.DefValue)
+	}
+	f.Changed = false
+}
+
+// runReloading runs the watcher, replacing it with one configured by the new settings whenever the config file changes.
+// An invalid config file is logged and leaves the current watcher running. The current watcher is stopped, closing its
+// sinks, before the next one opens them again, so that the two never write to the same files or endpoints at once;
+// when the next watcher cannot be created, one is created again with the previous settings.
+func runReloading(ctx context.Context, start time.Time, w *watcher.Watcher) error {
+	changes := make(chan map[string]interface{}, 1)
+	configReader.OnConfigChange(func(fsnotify.Event) {
+		// Viper has re-read the file; keep only the latest settings until they are applied
+		settings := configReader.AllSettings()
+		select {
+		case <-changes:
+		default:
+		}
+		changes <- settings
+	})
+	configReader.WatchConfig()
+
+	if healthAddr != "" {
+		healthCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go watcher.ServeHealth(healthCtx, healthAddr, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			currentWatcher.Load().HealthHandler().ServeHTTP(rw, r)
+		}))
+	}
+	for {
+		runCtx, stop := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- w.Run(runCtx) }()
+		var settings map[string]interface{}
+		for settings == nil {
+			select {
+			case err := <-done:
+				stop()
+				return err
+			case changed := <-changes:
+				if reloadConfig(changed) {
+					settings = changed
+				}
+			}
+		}
+		// Stop the current watcher, delivering its queued events and closing its sinks, before the next one opens them
+		stop()
+		if err := <-done; err != nil {
+			slog.Warn("Watcher stopped with an error during the reload", "error", err)
+		}
+		next, err := newWatcher(start)
+		if err != nil {
+			slog.Error("Invalid config file, restarting the watcher with the previous configuration", "config", configFile, "error", err)
+			if err := applyConfig(configCommand, configSettings, true); err != nil {
+				return fmt.Errorf("could not restore the previous configuration: %w", err)
+			}
+			if next, err = newWatcher(start); err != nil {
+				return fmt.Errorf("could not restart the watcher with the previous configuration: %w", err)
+			}
+		} else {
+			configSettings = settings
+			slog.Info("Configuration reloaded, restarting the watcher")
+		}
+		w = next
+		currentWatcher.Store(w)
+	}
+}
+
+// reloadConfig applies changed config settings to the flags, reporting whether a watcher is to be created with them.
+// Nothing is done when the settings are unchanged, and the previous settings are restored when they are invalid.
+func reloadConfig(settings map[string]interface{}) bool {
+	if reflect.DeepEqual(settings, configSettings) {
+		return false
+	}
+	slog.Info("Config file changed, reloading", "config", configFile)
+	err := applyConfig(configCommand, settings, true)
+	if err == nil {
+		err = validateFlags(configCommand)
+	}
+	if err != nil {
+		slog.Error("Invalid config file, keeping the current configuration", "config", configFile, "error", err)
+		if err := applyConfig(configCommand, configSettings, true); err != nil {
+			slog.Error("Failed to restore the previous configuration", "error", err)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// daemonStatusInterval is how often --daemon reports the status of the watcher to systemd
+const daemonStatusInterval = 5 * time.Second
+
+// notifySystemd reports the state of the current watcher to systemd until the context is canceled, as a service of
+// Type=notify: READY=1 once every watch is established, the status, and the keep-alive pings of WatchdogSec.
+// It does nothing unless systemd gave a notification socket.
+func notifySystemd(ctx context.Context) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		slog.Warn("--daemon was given but systemd did not set $NOTIFY_SOCKET; is the unit of Type=notify?")
+		return
+	}
+	interval := daemonStatusInterval
+	if watchdog := watchdogInterval(); watchdog > 0 && watchdog < interval {
+		interval = watchdog
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	ready := false
+	for {
+		w := currentWatcher.Load()
+		state := "WATCHDOG=1\n"
+		switch {
+		case w != nil && w.Ready():
+			if !ready {
+				state += "READY=1\n"
+				ready = true
+			}
+			state += "STATUS=Watching\n"
+		case ready:
+			state += "STATUS=Reconnecting the watches\n"
+		default:
+			state += "STATUS=Establishing the watches\n"
+		}
+		if err := sdNotify(socket, state); err != nil {
+			slog.Warn("Could not notify systemd", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			_ = sdNotify(socket, "STOPPING=1\nSTATUS=Stopping\n")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchdogInterval returns half the WatchdogSec of the unit, the recommended interval of the keep-alive pings,
+// or 0 if the watchdog is disabled or meant for another process
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdNotify sends the newline-separated assignments of state to the notification socket of systemd,
+// a path or, starting with @, an abstract socket
+func sdNotify(socket, state string) error {
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("could not connect to the notification socket: %w", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+var (
+	diffCluster   string
+	diffNamespace string
+	diffIgnore    []string
+	diffOutput    string
+	diffExitCode  bool
+)
+
+// diffCmd compares two recorded states of the pods
+var diffCmd = &cobra.Command{
+	Use:   "diff BEFORE AFTER",
+	Short: "Compare two recorded snapshots, or the states of an event store at two times",
+	Long: `diff compares two states of the pods and reports those added, removed, and changed between them, with the fields
+that changed. Each state is a stream captured with --output yaml, json, or jsonl, such as the output of
+pod-watcher snapshot (optionally gzip-compressed; - for stdin), or an event store given as sqlite:PATH, and may be
+taken as of a time with @TIME, e.g. sqlite:events.db@14:03. The state of a pod is its last recorded revision, and
+the pods whose last event is a deletion are absent from it.
+Times are RFC 3339 (2024-06-01T14:03:00Z), a time of day today (14:03), or a duration ago (90m).
+metadata.resourceVersion and metadata.managedFields are always ignored.
+
+Examples:
+  pod-watcher diff before-deploy.jsonl after-deploy.jsonl
+  pod-watcher diff sqlite:events.db@14:00 sqlite:events.db@15:00 -n team-a --ignore status
+  pod-watcher snapshot --marker DEBUG_MODE -o jsonl | pod-watcher diff debug-pods.jsonl - -o json
+`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDiff(cmd, args[0], args[1])
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&diffCluster, "cluster", "", "Only compare the pods from this cluster, as named by its context when watching several")
+	diffCmd.Flags().StringVarP(&diffNamespace, "namespace", "n", "", "Only compare the pods in this namespace")
+	diffCmd.Flags().StringSliceVar(&diffIgnore, "ignore", nil, "Ignore the changes of these fields and those under them, e.g. status or metadata.labels.version (comma-separated)")
+	diffCmd.Flags().StringVarP(&diffOutput, "output", "o", "text", "Output format: text, json, or jsonl")
+	diffCmd.Flags().BoolVar(&diffExitCode, "exit-code", false, "Exit with status 1 when the states differ, like diff(1)")
+	rootCmd.AddCommand(diffCmd)
+}
+
+// runDiff reports the differences between the two states
+func runDiff(cmd *cobra.Command, beforeSource, afterSource string) error {
+	switch diffOutput {
+	case "text", watcher.OutputJSON, watcher.OutputJSONL:
+	default:
+		return fmt.Errorf("invalid --output %q: must be text, json, or jsonl", diffOutput)
+	}
+	if beforeSource == "-" && afterSource == "-" {
+		return fmt.Errorf("only one of the states can be read from stdin")
+	}
+	now := time.Now()
+	before, err := readState(cmd, beforeSource, now)
+	if err != nil {
+		return err
+	}
+	after, err := readState(cmd, afterSource, now)
+	if err != nil {
+		return err
+	}
+	diffs, err := watcher.CompareStates(before, after, diffIgnore...)
+	if err != nil {
+		return err
+	}
+	if err := writeDiffs(os.Stdout, diffs); err != nil {
+		return err
+	}
+	if diffExitCode && len(diffs) > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// readState reads the events of a state given as a recorded stream or an event store, optionally as of @TIME
+func readState(cmd *cobra.Command, source string, now time.Time) ([]watcher.Event, error) {
+	var until time.Time
+	if i := strings.LastIndex(source, "@"); i >= 0 {
+		at, err := parseQueryTime(source[i+1:], now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time of %s: %w", source, err)
+		}
+		source, until = source[:i], at
+	}
+	if strings.HasPrefix(source, "sqlite:") {
+		path, err := storePath(source)
+		if err != nil {
+			return nil, err
+		}
+		store, err := watcher.OpenStore(path)
+		if err != nil {
+			return nil, err
+		}
+		defer store.Close()
+		query := watcher.StoreQuery{Cluster: diffCluster, Namespace: diffNamespace, Until: until, Latest: true}
+		return store.Query(cmd.Context(), query)
+	}
+	events, err := readEventFile(source)
+	if err != nil {
+		return nil, err
+	}
+	if events, err = eventsBetween(events, time.Time{}, until); err != nil {
+		return nil, err
+	}
+	var selected []watcher.Event
+	for _, event := range events {
+		if diffCluster != "" && event.Cluster != diffCluster {
+			continue
+		}
+		if namespace, _, ok := strings.Cut(event.Key, "/"); diffNamespace != "" && (!ok || namespace != diffNamespace) {
+			continue
+		}
+		selected = append(selected, event)
+	}
+	return selected, nil
+}
+
+// writeDiffs writes the differences in the --output format; the text format lists the added (+), removed (-)
+// and changed (~) pods, with the changed fields under each, followed by the totals
+func writeDiffs(w io.Writer, diffs []watcher.ObjectDiff) error {
+	switch diffOutput {
+	case watcher.OutputJSON:
+		if diffs == nil {
+			diffs = []watcher.ObjectDiff{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diffs)
+	case watcher.OutputJSONL:
+		encoder := json.NewEncoder(w)
+		for _, diff := range diffs {
+			if err := encoder.Encode(diff); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, diff := range diffs {
+		counts[diff.Change]++
+		name := diff.Name
+		if diff.Namespace != "" {
+			name = diff.Namespace + "/" + name
+		}
+		if diff.Cluster != "" {
+			name = "[" + diff.Cluster + "] " + name
+		}
+		marker := map[string]string{watcher.ObjectAdded: "+", watcher.ObjectRemoved: "-", watcher.ObjectChanged: "~"}[diff.Change]
+		if _, err := fmt.Fprintf(w, "%s %s\n", marker, name); err != nil {
+			return err
+		}
+		for _, field := range diff.Fields {
+			if _, err := fmt.Fprintf(w, "    %s\n", field); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d added, %d removed, %d changed\n", counts[watcher.ObjectAdded], counts[watcher.ObjectRemoved], counts[watcher.ObjectChanged])
+	return err
+}
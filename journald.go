@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// journalSocket receives the entries of the native protocol of journald
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalHandler is the slog handler of --log-format journald, sending each record to journald as an entry of its own,
+// with the attributes as fields of their own so that they can be matched with journalctl, e.g. NAMESPACE=team-a.
+// Records too large for a datagram are written to stderr in the text format instead.
+type journalHandler struct {
+	conn     *net.UnixConn
+	level    slog.Leveler
+	fallback slog.Handler
+	prefix   string // of the field names of the attributes, from the groups
+	fields   []byte // the preformatted fields of the attributes given to WithAttrs
+	mu       *sync.Mutex
+}
+
+func newJournalHandler(options *slog.HandlerOptions) (*journalHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to journald: %w", err)
+	}
+	return &journalHandler{conn: conn, level: options.Level, fallback: slog.NewTextHandler(os.Stderr, options), mu: &sync.Mutex{}}, nil
+}
+
+func (h *journalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *journalHandler) Handle(ctx context.Context, record slog.Record) error {
+	var entry bytes.Buffer
+	appendJournalField(&entry, "MESSAGE", record.Message)
+	appendJournalField(&entry, "PRIORITY", journalPriority(record.Level))
+	appendJournalField(&entry, "SYSLOG_IDENTIFIER", "pod-watcher")
+	entry.Write(h.fields)
+	record.Attrs(func(attr slog.Attr) bool {
+		appendJournalAttr(&entry, h.prefix, attr)
+		return true
+	})
+	h.mu.Lock()
+	_, err := h.conn.Write(entry.Bytes())
+	h.mu.Unlock()
+	if err != nil {
+		return h.fallback.Handle(ctx, record)
+	}
+	return nil
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var fields bytes.Buffer
+	fields.Write(h.fields)
+	for _, attr := range attrs {
+		appendJournalAttr(&fields, h.prefix, attr)
+	}
+	handler := *h
+	handler.fields = fields.Bytes()
+	handler.fallback = h.fallback.WithAttrs(attrs)
+	return &handler
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	handler := *h
+	handler.prefix = h.prefix + name + "_"
+	handler.fallback = h.fallback.WithGroup(name)
+	return &handler
+}
+
+// journalPriority maps a level to a syslog priority
+func journalPriority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3"
+	case level >= slog.LevelWarn:
+		return "4"
+	case level >= slog.LevelInfo:
+		return "6"
+	default:
+		return "7"
+	}
+}
+
+// appendJournalAttr appends an attribute as a field, and the attributes of a group as fields prefixed with its name
+func appendJournalAttr(entry *bytes.Buffer, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "_"
+		}
+		for _, member := range attr.Value.Group() {
+			appendJournalAttr(entry, prefix, member)
+		}
+		return
+	}
+	appendJournalField(entry, prefix+attr.Key, attr.Value.String())
+}
+
+// appendJournalField appends a field in the native protocol, its name made of uppercase letters, digits and underscores,
+// not starting with an underscore, which marks the fields of journald itself
+func appendJournalField(entry *bytes.Buffer, name, value string) {
+	name = strings.TrimLeft(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name), "_0123456789")
+	if name == "" {
+		return
+	}
+	entry.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		entry.WriteByte('=')
+		entry.WriteString(value)
+		entry.WriteByte('\n')
+		return
+	}
+	// Values spanning several lines are given with their length instead
+	entry.WriteByte('\n')
+	_ = binary.Write(entry, binary.LittleEndian, uint64(len(value)))
+	entry.WriteString(value)
+	entry.WriteByte('\n')
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// setupLogging installs the structured logger for the operational logs, configured by the --log-* flags.
+// The logs never go to stdout, which is reserved for the event stream.
+// The klog output of client-go is routed through the same logger so every log line has the same format.
+func setupLogging(level, format, output string) error {
+	var w io.Writer
+	switch output {
+	case "", "stderr":
+		w = os.Stderr
+	case "stdout", "-":
+		return fmt.Errorf("--log-output cannot be stdout, which carries the event stream")
+	default:
+		// The file stays open for the lifetime of the process
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("could not open log file: %w", err)
+		}
+		w = f
+	}
+	return setupLogger(level, format, w)
+}
+
+// setupLogger installs a structured logger writing to w, or to journald, for slog and klog
+func setupLogger(level, format string, w io.Writer) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unsupported log level %q (must be one of debug, info, warn, error)", level)
+	}
+	options := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	case "journald":
+		journal, err := newJournalHandler(options)
+		if err != nil {
+			return err
+		}
+		handler = journal
+	default:
+		return fmt.Errorf("unsupported log format %q (must be text, json, or journald)", format)
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	klog.SetSlogLogger(logger)
+	return nil
+}
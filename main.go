@@ -2,204 +2,1133 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-	"syscall"
+	"sync/atomic"
 	"time"
 
-	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/watch"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	// The oidc auth provider of older kubeconfigs; exec credential plugins are supported by client-go itself
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 
 	"github.com/spf13/cobra"
-	"sigs.k8s.io/yaml"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
 )
 
 var (
-	marker       string
-	stopOnDelete bool
-	kubeconfig   string
-	kubecontext  string
+	markers              []string
+	markerRegexes        []string
+	markerAll            bool
+	stopOnDelete         bool
+	kubeconfig           string
+	kubecontexts         []string
+	resyncPeriod         time.Duration
+	resyncInterval       time.Duration
+	onImageChange        bool
+	specChangesOnly      bool
+	statusChangesOnly    bool
+	outputFile           string
+	outputDir            string
+	gzipOutput           bool
+	watchTimeout         time.Duration
+	maxBackoff           time.Duration
+	breakerThreshold     int
+	breakerPause         time.Duration
+	namespaces           []string
+	allNamespaces        bool
+	labelSelector        string
+	fieldSelector        string
+	outputFormat         string
+	resourceArg          string
+	maxFileSize          string
+	maxFiles             int
+	compressRotate       bool
+	eventTypes           []string
+	metricsAddr          string
+	markerPaths          []string
+	webhookURL           string
+	webhookHeaders       []string
+	webhookTimeout       time.Duration
+	webhookRetries       int
+	webhookBackoff       time.Duration
+	webhookSecret        string
+	webhookBatchSize     int
+	webhookBatchInterval time.Duration
+	webhookCloudEvents   bool
+	tailLogs             bool
+	stripPaths           []string
+	redact               bool
+	redactEnv            string
+	redactAnnotations    string
+	redactPaths          []string
+	auditFields          bool
+	execCommand          string
+	execConcurrency      int
+	execTimeout          time.Duration
+	waitForDeleteAll     bool
+	exitCodeOnDelete     int
+	waitFor              string
+	runTimeout           time.Duration
+	runUntil             string
+	maxEvents            int
+	printSummary         bool
+	summaryFile          string
+	includeEvents        bool
+	followReferences     bool
+	includeSecretData    bool
+	trackVolumes         bool
+	logLevel             string
+	logFormat            string
+	logOutput            string
+	sinkSpecs            []string
+	routeKey             string
+	routes               []string
+	kafkaBrokers         []string
+	kafkaTopic           string
+	kafkaTLS             bool
+	kafkaTLSCA           string
+	kafkaTLSCert         string
+	kafkaTLSKey          string
+	kafkaTLSInsecure     bool
+	kafkaSASL            string
+	kafkaUsername        string
+	kafkaPassword        string
+	kafkaBatchSize       int
+	kafkaBatchTimeout    time.Duration
+	kafkaCloudEvents     bool
+	cloudEventsSource    string
+	natsURL              string
+	natsSubject          string
+	natsJetStream        bool
+	natsAckTimeout       time.Duration
+	natsCredentials      string
+	natsToken            string
+	natsTLS              bool
+	natsTLSCA            string
+	natsTLSCert          string
+	natsTLSKey           string
+	natsTLSInsecure      bool
+	archiveURL           string
+	archiveChunkSize     string
+	archiveChunkInterval time.Duration
+	archiveEncryption    string
+	archiveKMSKey        string
+	archiveRegion        string
+	archiveEndpoint      string
+	sinkQueueDir         string
+	sinkQueueRetention   time.Duration
+	sinkQueueMaxSize     string
+	storeSpec            string
+	minInterval          time.Duration
+	dedupe               bool
+	leaderElect          bool
+	leaseName            string
+	leaseNamespace       string
+	leaseDuration        time.Duration
+	renewDeadline        time.Duration
+	retryPeriod          time.Duration
+	healthAddr           string
+	configFile           string
+	filterCEL            []string
+	phases               []string
+	conditions           []string
+	filterPlugin         string
+	excludeNamespaces    []string
+	excludeMarkers       []string
+	excludeLabelSelector string
+	colorMode            string
+	framing              string
+	allContexts          bool
+	resolveOwners        bool
+	attributeDeletions   bool
+	forWorkload          string
+	trackContainers      bool
+	timeline             bool
+	restartThreshold     int
+	flapThreshold        int
+	flapWindow           time.Duration
+	trackTermination     bool
+	trackInit            bool
+	trackImages          bool
+	securityWatch        bool
+	terminatingThreshold time.Duration
+	includeNode          bool
+	includeMetrics       bool
+	metricsInterval      time.Duration
+	metricsEvents        bool
+	statsInterval        time.Duration
+	heartbeatInterval    time.Duration
+	authCheckInterval    time.Duration
+	captureOnFailure     bool
+	captureDir           string
+	debugImage           string
+	debugTrigger         string
+	slackWebhook         string
+	teamsWebhook         string
+	notifyOn             []string
+	notifyInterval       time.Duration
+	serveAddr            string
+	serveAllowOrigins    []string
+	serveTokenFile       string
+	serveTLSCert         string
+	serveTLSKey          string
+	streamMaxClients     int
+	eventStream          *watcher.EventStream // nil unless --serve-addr
+	tuiMode              bool
+	daemonMode           bool
+	skipPermissionCheck  bool
+	tuiView              *watcher.TUI // nil unless --tui
+	checkpointFile       string
+	checkpointConfigMap  string
+	checkpointInterval   time.Duration
+	emitInitial          bool
+	since                time.Duration
+	skipInitial          bool
+	useWatchList         bool
+	pageSize             int64
+	shards               int
+	shardLabel           string
+	contentType          string
+	clientQPS            float32
+	clientBurst          int
+	proxyURL             string
+	workers              int
+	queueSize            int
+	maxTrackedPods       int
+	drainTimeout         time.Duration
+	otelEndpoint         string
+	watchLabel           string
+	watchAnnotation      string
+	fakeEvents           bool
+	fakeClusters         []watcher.Cluster               // the in-memory cluster of --fake
+	currentWatcher       atomic.Pointer[watcher.Watcher] // the running watcher, replaced when the config file changes
 )
 
+// fakeEventInterval is the time between the canned events played with --fake
+const fakeEventInterval = 2 * time.Second
+
 // rootCmd defines the CLI command using Cobra
 var rootCmd = &cobra.Command{
 	Use:   "pod-watcher",
 	Short: "Watch Kubernetes pods and log changes when a marker string is present",
-	Long: `pod-watcher monitors all Kubernetes pods across all namespaces, filtering for a specified marker string in the pod's YAML.
+	Long: `pod-watcher monitors Kubernetes pods (across all namespaces by default), filtering for a specified marker string in the pod's YAML.
 It logs every change to any matching pod as a separate YAML document in a stream.
+Any other resource kind, including custom resources, can be watched instead with --resource.
 
 Examples:
   pod-watcher --marker "DEBUG_MODE"
   pod-watcher --marker "DEBUG_MODE" --stop-on-delete
+  pod-watcher --marker "DEBUG_MODE" --namespace team-a,team-b
+  pod-watcher --label-selector app=web --field-selector status.phase=Running
+  pod-watcher --marker "DEBUG_MODE" --output jsonl | jq .name
+  pod-watcher --marker "DEBUG_MODE" --resource deployments.apps
+  pod-watcher --marker "team-a" --marker-regex 'image: .*:canary' --marker-all
+  pod-watcher --filter-cel "pod.status.phase == 'Running' && pod.spec.nodeName.startsWith('gpu-')"
 `,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Read the config file, then configure the operational logs before anything is logged
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+		if err := validateFlags(cmd); err != nil {
+			return err
+		}
+		kubeconfig = expandPath(kubeconfig)
+		return setupLogging(logLevel, logFormat, logOutput)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Execute the watch logic
 		if err := runWatcher(cmd.Context()); err != nil {
-			log.Fatalf("Error: %v", err)
+			var exit *watcher.ExitError
+			if errors.As(err, &exit) {
+				slog.Error(exit.Message, "exitCode", exit.Code)
+				os.Exit(exit.Code)
+			}
+			slog.Error("Watcher failed", "error", err)
+			os.Exit(1)
 		}
 	},
 }
 
 func init() {
 	// Define CLI flags
-	rootCmd.Flags().StringVarP(&marker, "marker", "m", "", "Marker substring to filter pods (required)")
+	rootCmd.Flags().StringArrayVarP(&markers, "marker", "m", nil, "Marker substring to filter pods (repeatable; required unless another marker or selector is given)")
+	rootCmd.Flags().StringArrayVar(&markerRegexes, "marker-regex", nil, "Regular expression to filter pods (repeatable)")
+	rootCmd.Flags().StringArrayVar(&markerPaths, "marker-path", nil, "Only match markers against the values at this field path, e.g. metadata.annotations.debug or spec.containers[*].env[*].value (repeatable)")
+	rootCmd.Flags().BoolVar(&markerAll, "marker-all", false, "Require every --marker and --marker-regex to match instead of any one")
+	rootCmd.Flags().StringArrayVar(&filterCEL, "filter-cel", nil, "CEL expression the pod, available as pod, must satisfy in addition to the markers, e.g. \"pod.status.phase == 'Running'\" (repeatable; all must be true)")
+	rootCmd.Flags().StringSliceVar(&phases, "phase", nil, "Only match the pods in one of these phases, e.g. Pending,Failed, in addition to the markers (comma-separated)")
+	rootCmd.Flags().StringSliceVar(&conditions, "condition", nil, "Only match the pods satisfying all of these conditions, as TYPE=STATUS or TYPE!=STATUS, e.g. Ready=False, in addition to the markers (comma-separated)")
+	rootCmd.Flags().StringVar(&filterPlugin, "filter-plugin", "", "Go plugin (.so) or WebAssembly module (.wasm) deciding whether each pod that passed the other filters matches, and transforming it (see Filter Plugins)")
 	rootCmd.Flags().BoolVarP(&stopOnDelete, "stop-on-delete", "s", false, "Stop after first matching pod is deleted")
-	rootCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (defaults to in-cluster or default config)")
-	rootCmd.Flags().StringVar(&kubecontext, "context", "", "The context name to load (defaults to the default context)")
-	// Mark required flags
-	_ = rootCmd.MarkFlagRequired("marker")
+	rootCmd.Flags().BoolVar(&waitForDeleteAll, "wait-for-delete-all", false, "Track every matching pod and stop once all of them have been deleted")
+	rootCmd.Flags().StringVar(&waitFor, "wait-for", "", "Stop once a matching pod meets this condition: a condition type such as Ready, condition=Ready=False, phase=Succeeded, or jsonpath={.status.podIP}[=value]")
+	rootCmd.Flags().DurationVar(&runTimeout, "timeout", 0, "Stop the watcher after this long; with --wait-for, exit non-zero if the condition has not been met by then (0 disables)")
+	rootCmd.Flags().StringVar(&runUntil, "until", "", "Stop the watcher at this time, in RFC 3339 format (e.g. 2024-06-01T18:00:00Z), like --timeout")
+	rootCmd.Flags().IntVar(&maxEvents, "max-events", 0, "Stop the watcher after emitting this many events (0 disables)")
+	rootCmd.Flags().BoolVar(&printSummary, "summary", false, "On exit, print a JSON summary of the run to stderr: why the watcher stopped, its duration and exit code, the events emitted per type, and the final phase of each matched pod")
+	rootCmd.Flags().StringVar(&summaryFile, "summary-file", "", "Write the JSON summary of the run to this file on exit, instead of printing it with --summary")
+	rootCmd.Flags().IntVar(&exitCodeOnDelete, "exit-code-on-delete", 0, "Exit code used when the tracked pods were deleted without all of them having succeeded")
+	rootCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file, with ~ and environment variables expanded (defaults to in-cluster or default config)")
+	rootCmd.Flags().StringArrayVar(&kubecontexts, "context", nil, "The kubeconfig context to watch (defaults to the current context; repeatable to watch several clusters at once)")
+	rootCmd.Flags().BoolVar(&skipPermissionCheck, "skip-permission-check", false, "Watch without first checking, with SelfSubjectAccessReviews, that the RBAC grants every access the flags need")
+	rootCmd.Flags().BoolVar(&allContexts, "all-contexts", false, "Watch the clusters of every context in the kubeconfig at once")
+	rootCmd.Flags().StringSliceVarP(&namespaces, "namespace", "n", nil, "Namespace to watch (repeatable or comma-separated; defaults to all namespaces)")
+	rootCmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "Watch pods in all namespaces (the default when no --namespace is given)")
+	rootCmd.Flags().StringVar(&resourceArg, "resource", "", "Resource to watch instead of pods, e.g. deployments.apps or mycrds.example.com/v1 (alias --kind)")
+	rootCmd.Flags().StringVarP(&labelSelector, "label-selector", "l", "", "Label selector applied server-side to the pod list/watch (e.g. app=web,tier!=db)")
+	rootCmd.Flags().StringVar(&forWorkload, "for", "", "Only watch the pods of this workload, e.g. deployment/web or job/migrate-db, in the --namespace (defaults to the namespace of the kubeconfig context)")
+	rootCmd.Flags().StringSliceVar(&excludeNamespaces, "exclude-namespace", nil, "Drop the pods in this namespace even when they match (repeatable or comma-separated)")
+	rootCmd.Flags().StringArrayVar(&excludeMarkers, "exclude-marker", nil, "Drop the pods containing this substring, in the fields given by --marker-path if any, even when they match (repeatable)")
+	rootCmd.Flags().StringVar(&watchLabel, "watch-label", "", "Only watch the pods opting in with this label, as KEY=VALUE or KEY for any value, e.g. "+watcher.DefaultOptIn+" (applied server-side)")
+	rootCmd.Flags().StringVar(&watchAnnotation, "watch-annotation", "", "Only watch the pods opting in with this annotation, as KEY=VALUE or KEY for any value, e.g. "+watcher.DefaultOptIn)
+	rootCmd.Flags().StringVar(&excludeLabelSelector, "exclude-label-selector", "", "Drop the pods whose labels match this selector even when they match (e.g. tier=system)")
+	rootCmd.Flags().StringVar(&fieldSelector, "field-selector", "", "Field selector applied server-side to the pod list/watch (e.g. spec.nodeName=node-1)")
+	rootCmd.Flags().StringVar(&execCommand, "exec", "", "Run this shell command for each emitted event, with the object as JSON on stdin and POD_WATCHER_* environment variables")
+	rootCmd.Flags().IntVar(&execConcurrency, "exec-concurrency", 4, "Maximum number of --exec commands running at once")
+	rootCmd.Flags().DurationVar(&execTimeout, "exec-timeout", time.Minute, "Kill an --exec command that runs longer than this")
+	addSinkFlags(rootCmd)
+	rootCmd.Flags().StringVar(&checkpointFile, "checkpoint-file", "", "Save the state of the watch in this file, so that a restart reports the changes made while it was down instead of starting afresh")
+	rootCmd.Flags().StringVar(&checkpointConfigMap, "checkpoint-configmap", "", "Save the state of the watch in this ConfigMap, as [namespace/]name, like --checkpoint-file (the namespace defaults to the pod's in-cluster, default otherwise)")
+	rootCmd.Flags().DurationVar(&checkpointInterval, "checkpoint-interval", 10*time.Second, "How often the checkpoint is saved")
+	rootCmd.Flags().BoolVar(&leaderElect, "leader-elect", false, "Only watch while holding a coordination.k8s.io Lease, so that one of several replicas emits events at a time")
+	rootCmd.Flags().StringVar(&leaseName, "leader-elect-lease-name", "pod-watcher", "Name of the leader election Lease")
+	rootCmd.Flags().StringVar(&leaseNamespace, "leader-elect-namespace", "", "Namespace of the leader election Lease (defaults to the pod's namespace in-cluster, default otherwise)")
+	rootCmd.Flags().DurationVar(&leaseDuration, "leader-elect-lease-duration", 15*time.Second, "How long standby replicas wait after the leader's last renewal before taking over")
+	rootCmd.Flags().DurationVar(&renewDeadline, "leader-elect-renew-deadline", 10*time.Second, "How long the leader retries renewing the Lease before giving up leadership")
+	rootCmd.Flags().DurationVar(&retryPeriod, "leader-elect-retry-period", 2*time.Second, "Interval between attempts to acquire or renew the Lease")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Read flag values from this YAML file, keyed by flag name; flags given on the command line take precedence, and the watcher is restarted when the file changes")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Minimum level of the operational logs: debug, info, warn, or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Format of the operational logs: text, json, or journald (native journal entries with the attributes as fields)")
+	rootCmd.PersistentFlags().StringVar(&logOutput, "log-output", "stderr", "Write the operational logs to stderr or to this file (never stdout, which carries the event stream)")
+	rootCmd.PersistentFlags().StringVar(&storeSpec, "store", "", "Persist every emitted event to this event store, e.g. sqlite:/var/lib/pod-watcher/events.db (see the query command)")
+	rootCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9090 (disabled by default)")
+	rootCmd.Flags().StringVar(&serveAddr, "serve-addr", "", "Stream the events as JSON envelopes to HTTP clients on this address, e.g. localhost:8080, as Server-Sent Events on /events and over a WebSocket on /ws; any other than localhost requires --serve-token-file and --serve-tls-cert (disabled by default)")
+	rootCmd.Flags().StringSliceVar(&serveAllowOrigins, "serve-allow-origin", nil, "Origin of the web pages allowed to connect to --serve-addr besides its own, e.g. https://dashboard.example.com, or * for any (repeatable or comma-separated)")
+	rootCmd.Flags().StringVar(&serveTokenFile, "serve-token-file", "", "File holding the token the clients of --serve-addr must send as a bearer token, or in the access_token query parameter")
+	rootCmd.Flags().StringVar(&serveTLSCert, "serve-tls-cert", "", "PEM certificate --serve-addr serves over TLS (plain HTTP by default)")
+	rootCmd.Flags().StringVar(&serveTLSKey, "serve-tls-key", "", "PEM key of the --serve-tls-cert certificate")
+	rootCmd.Flags().IntVar(&streamMaxClients, "stream-max-clients", defaultStreamMaxClients, "Maximum number of clients connected to --serve-addr at once")
+	rootCmd.Flags().BoolVar(&daemonMode, "daemon", false, "Run as a systemd service of Type=notify: report readiness once every watch is established, the status, and the watchdog pings (see the systemd-unit command)")
+	rootCmd.Flags().BoolVar(&tuiMode, "tui", false, "Show the matched pods in an interactive terminal UI instead of writing the event stream to stdout: select a pod to see its latest YAML, diffs and events, p pauses, / filters, x exports its history")
+	rootCmd.Flags().StringVar(&otelEndpoint, "otel-endpoint", "", "Export OpenTelemetry traces of the event pipeline over OTLP/gRPC to this collector, e.g. http://otel-collector:4317 (disabled by default)")
+	rootCmd.Flags().StringVar(&healthAddr, "health-addr", "", "Serve the /healthz, /readyz, and /status endpoints on this address, e.g. :8081 (disabled by default)")
+	rootCmd.Flags().DurationVar(&drainTimeout, "drain-timeout", watcher.DefaultDrainTimeout, "On shutdown, keep delivering the events queued for the sinks for up to this long before dropping them (0 drops them at once)")
+	rootCmd.Flags().IntVar(&workers, "workers", watcher.DefaultWorkers, "Number of goroutines filtering and emitting the pod changes; the changes of one pod are always processed in order")
+	rootCmd.Flags().IntVar(&queueSize, "queue-size", watcher.DefaultQueueSize, "Number of pod changes queued for each worker before the watch waits for it")
+	rootCmd.Flags().IntVar(&maxTrackedPods, "max-tracked-pods", 0, "Keep the state of the diff format, --dedupe, --min-interval and the tracking modes for at most this many pods, dropping that of the least recently updated (0 for no limit)")
+	rootCmd.Flags().IntVar(&shards, "shards", 0, "Split the watch of each namespace into up to this many concurrent watches by the values of --shard-label (0 or 1 for a single watch)")
+	rootCmd.Flags().StringVar(&shardLabel, "shard-label", "app", "Label whose values, listed at startup, split the watch into --shards")
+	rootCmd.Flags().Int64Var(&pageSize, "page-size", 0, "List the pods in pages of this many, read from etcd, instead of in one response from the API server's watch cache (0 disables)")
+	rootCmd.Flags().BoolVar(&useWatchList, "use-watch-list", false, "Stream the initial pods with a watch (Kubernetes 1.27+ WatchList) instead of listing them all at once, falling back to a list on older clusters")
+	rootCmd.Flags().DurationVar(&watchTimeout, "watch-timeout", 30*time.Minute, "Ask the API server to close each watch after this long so it is routinely restarted (0 disables)")
+	rootCmd.Flags().DurationVar(&maxBackoff, "max-backoff", watcher.DefaultMaxBackoff, "Cap of the delay before retrying a failed list or watch, which doubles from 1s after each consecutive failure, with jitter")
+	rootCmd.Flags().IntVar(&breakerThreshold, "circuit-breaker-threshold", watcher.DefaultBreakerThreshold, "Pause a list and watch for --circuit-breaker-pause, logging an error, after this many consecutive failures (-1 disables)")
+	rootCmd.Flags().DurationVar(&breakerPause, "circuit-breaker-pause", watcher.DefaultBreakerPause, "How long a list and watch is paused once --circuit-breaker-threshold consecutive attempts failed")
+	rootCmd.Flags().StringSliceVar(&eventTypes, "event-types", nil, "Only emit these event types: ADDED, MODIFIED, DELETED, RESYNC, CONTAINER, TIMELINE, ALERT, METRICS, REFERENCE, VOLUME, TERMINATING, FINALIZED, INIT, IMAGE_CHANGED, SECURITY, BACKFILL (comma-separated; defaults to all)")
+	rootCmd.Flags().BoolVar(&emitInitial, "emit-initial", false, "Emit every pod matching at startup as an ADDED event (by default they are only reported once they change)")
+	rootCmd.Flags().DurationVar(&since, "since", 0, "Backfill the history of the pods matching at startup over this window, e.g. 30m, from their status and the Kubernetes Events about them, as BACKFILL events emitted before watching")
+	rootCmd.Flags().BoolVar(&skipInitial, "skip-initial", false, "Never emit the revisions of the pods that existed at startup, even when a relist re-delivers them")
+	rootCmd.Flags().StringSliceVar(&stripPaths, "strip", nil, "Remove these field paths before output, e.g. --strip=managedFields,status.conditions (--strip alone removes managedFields)")
+	rootCmd.Flags().Lookup("strip").NoOptDefVal = strings.Join(watcher.DefaultStripPaths, ",")
+	rootCmd.Flags().BoolVar(&auditFields, "include-managed-fields-summary", false, "Replace the managed fields with a summary of who changed each modified pod: the field manager, when, and the fields it owns")
+	rootCmd.Flags().BoolVar(&redact, "redact", false, "Replace the values of environment variables and annotations with sensitive names, such as *_TOKEN or *_PASSWORD, with *** before output")
+	rootCmd.Flags().StringVar(&redactEnv, "redact-env", watcher.DefaultRedactEnv, "Regular expression of the environment variable names redacted by --redact, matched case-insensitively")
+	rootCmd.Flags().StringVar(&redactAnnotations, "redact-annotations", watcher.DefaultRedactAnnotations, "Regular expression of the annotation keys redacted by --redact, matched case-insensitively")
+	rootCmd.Flags().StringSliceVar(&redactPaths, "redact-path", nil, "Replace the values at these field paths with *** before output, e.g. --redact-path=spec.containers[*].args")
+	rootCmd.Flags().BoolVar(&includeEvents, "include-events", false, "Interleave the Kubernetes Events about matched pods into the output as EVENT documents")
+	rootCmd.Flags().BoolVar(&followReferences, "follow-references", false, "Also watch the ConfigMaps and Secrets matched pods mount or take environment variables from, emitting a REFERENCE event naming the changed keys when one changes")
+	rootCmd.Flags().BoolVar(&includeSecretData, "include-secret-data", false, "With --follow-references, add the data of the changed ConfigMaps and Secrets to the REFERENCE events instead of only the names of the changed keys")
+	rootCmd.Flags().BoolVar(&trackVolumes, "track-volumes", false, "Also watch the PersistentVolumeClaims matched pods mount and the VolumeAttachments of their volumes, emitting a VOLUME event when a claim's phase or a volume's attachment changes")
+	rootCmd.Flags().BoolVar(&trackContainers, "track-containers", false, "Emit a compact CONTAINER notice whenever a container of a matched pod restarts, crashes, starts waiting, or becomes (not) ready")
+	rootCmd.Flags().BoolVar(&trackInit, "track-init", false, "Emit an INIT notice when each init container of a matched pod starts, completes, or fails, and when each native sidecar starts, becomes ready, fails, or restarts")
+	rootCmd.Flags().BoolVar(&securityWatch, "security-watch", false, "Emit a SECURITY notice when privileged containers, the host network, PID or IPC namespaces, added capabilities or containers running as root appear on a matched pod or disappear from it")
+	rootCmd.Flags().BoolVar(&trackImages, "track-images", false, "Emit an IMAGE_CHANGED notice when the image of a container of a matched pod, or the digest it resolved to, changes between revisions")
+	rootCmd.Flags().BoolVar(&timeline, "timeline", false, "Emit the lifecycle timeline of each matched pod once it is deleted: created, scheduled, images pulled, started, ready, restarts, deleted, with the time between them")
+	rootCmd.Flags().IntVar(&restartThreshold, "restart-threshold", 0, "Emit an ALERT event when the containers of a matched pod restart this many times within --flap-window (0 disables)")
+	rootCmd.Flags().IntVar(&flapThreshold, "flap-threshold", 0, "Emit an ALERT event when a matched pod stops being ready this many times within --flap-window (0 disables)")
+	rootCmd.Flags().DurationVar(&flapWindow, "flap-window", watcher.DefaultFlapWindow, "Sliding window of --restart-threshold and --flap-threshold")
+	rootCmd.Flags().BoolVar(&trackTermination, "track-termination", false, "Emit a TERMINATING event when the deletion of a matched pod is requested, and a FINALIZED event with the time it took to terminate, and whether it was forced, once it is removed")
+	rootCmd.Flags().DurationVar(&terminatingThreshold, "terminating-threshold", 0, "With --track-termination, emit an ALERT event for a pod still terminating this long after its deletion was requested (0 disables)")
+	rootCmd.Flags().BoolVar(&includeNode, "include-node", false, "Add the node of each matched pod, with its taints, conditions and allocatable resources, to the events")
+	rootCmd.Flags().BoolVar(&includeMetrics, "include-metrics", false, "Add the CPU and memory usage of each matched pod and its containers, polled from the metrics server, to the events")
+	rootCmd.Flags().DurationVar(&metricsInterval, "metrics-interval", watcher.DefaultMetricsInterval, "How often the metrics server is polled with --include-metrics")
+	rootCmd.Flags().DurationVar(&statsInterval, "stats-interval", 0, "Write statistics of the matched pods (by namespace, phase, owner and node, restarts and churn) into the output stream and the metrics at this interval (0 disables them)")
+	rootCmd.Flags().DurationVar(&heartbeatInterval, "heartbeat-interval", 0, "Write a heartbeat with the state of the watches, the time of the last event and the number of matched pods into the output stream, the log and the metrics at this interval (0 disables it)")
+	rootCmd.Flags().DurationVar(&authCheckInterval, "auth-check-interval", 0, "Check the credentials of every cluster at this interval, refreshing short-lived ones such as those of exec credential plugins before a watch needs them (0 disables it)")
+	rootCmd.Flags().BoolVar(&metricsEvents, "metrics-events", false, "With --include-metrics, also emit a METRICS event with the usage of every matched pod after each poll")
+	rootCmd.Flags().BoolVar(&captureOnFailure, "capture-on-failure", false, "Capture the YAML, container logs, Events and node of each matched pod that fails or enters CrashLoopBackOff")
+	rootCmd.Flags().StringVar(&captureDir, "capture-dir", "artifacts", "Directory receiving a sub-directory of artifacts per failure with --capture-on-failure")
+	rootCmd.Flags().StringVar(&debugImage, "inject-debug-container", "", "Attach an ephemeral debug container running this image, e.g. busybox, to each matched pod once it meets --inject-debug-on")
+	rootCmd.Flags().StringVar(&debugTrigger, "inject-debug-on", watcher.DebugOnFailing, "When to attach the debug container: failing (a container crash loops or exited with an error), not-ready, or match")
+	rootCmd.Flags().BoolVar(&resolveOwners, "resolve-owners", false, "Add the top-level owner of each object, e.g. the Deployment or CronJob of a pod, to the events")
+	rootCmd.Flags().BoolVar(&attributeDeletions, "attribute-deletions", false, "Add the reason of the deletion of each pod to its DELETED event, inferred from its status, Events, node and controller: Evicted, OOMKilled, ScaledDown, NodeDrained or Manual")
+	rootCmd.Flags().BoolVar(&tailLogs, "tail-logs", false, "Stream the container logs of matched pods into the output, prefixed by pod and container")
+	rootCmd.Flags().BoolVar(&onImageChange, "on-image-change", false, "Only emit MODIFIED events when a pod's container images change")
+	rootCmd.Flags().BoolVar(&specChangesOnly, "spec-changes-only", false, "Only emit MODIFIED events when the spec (or generation) of the object changes, suppressing status updates")
+	rootCmd.Flags().BoolVar(&statusChangesOnly, "status-changes-only", false, "Only emit MODIFIED events when the status of the object changes")
+	rootCmd.Flags().DurationVar(&minInterval, "min-interval", 0, "Emit at most one MODIFIED event per pod in this interval, holding back the rest and emitting only the latest (0 disables)")
+	rootCmd.Flags().BoolVar(&dedupe, "dedupe", false, "Suppress MODIFIED events that leave the pod, after --strip, unchanged since its last emitted revision")
+	rootCmd.Flags().DurationVar(&resyncPeriod, "resync-period", 0, "Periodically re-deliver every cached match as a RESYNC event (0 disables)")
+	rootCmd.Flags().DurationVar(&resyncInterval, "resync-interval", 0, "Periodically list the pods afresh and emit those the watch missed, or has not delivered yet, as RESYNC events (0 disables)")
+	rootCmd.Flags().BoolVar(&fakeEvents, "fake", false, "Watch an in-memory cluster playing the canned lifecycle of demo pods carrying the marker "+watcher.FakeMarker+", instead of a real cluster")
+	_ = rootCmd.Flags().MarkHidden("fake")
+	// --kind is accepted as an alias of --resource
+	rootCmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "kind" {
+			name = "resource"
+		}
+		return pflag.NormalizedName(name)
+	})
+	addConnectionFlags(rootCmd)
+	// The exclusive flags are checked by validateFlags, with reasons; marking them keeps them out of the completions too
+	for _, exclusive := range exclusiveFlags {
+		rootCmd.MarkFlagsMutuallyExclusive(exclusive.flags[0], exclusive.flags[1])
+	}
+	registerCompletions(rootCmd)
+}
+
+// pluginPrefix is the prefix of the names kubectl looks up its plugins by: kubectl-pod_watch is run as kubectl pod-watch
+const pluginPrefix = "kubectl-"
+
+// setupPlugin presents the commands as kubectl pod-watch when the binary is installed as a kubectl plugin
+func setupPlugin(executable string) {
+	name := strings.TrimSuffix(filepath.Base(executable), ".exe")
+	if !strings.HasPrefix(name, pluginPrefix) {
+		return
+	}
+	displayName := "kubectl " + strings.ReplaceAll(strings.TrimPrefix(name, pluginPrefix), "_", "-")
+	rootCmd.Annotations = map[string]string{cobra.CommandDisplayNameAnnotation: displayName}
+	for _, cmd := range append([]*cobra.Command{rootCmd}, rootCmd.Commands()...) {
+		cmd.Long = strings.ReplaceAll(cmd.Long, rootCmd.Name()+" ", displayName+" ")
+	}
 }
 
 func main() {
-	// Set up context that cancels on SIGINT/SIGTERM for graceful shutdown
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	setupPlugin(os.Args[0])
+	// Set up context that cancels on SIGINT/SIGTERM (Ctrl+C, Ctrl+Break or the closing of the console on Windows) for graceful shutdown
+	ctx, cancel := signal.NotifyContext(context.Background(), shutdownSignals...)
 	defer cancel()
+	// Restore the default handling once shutting down, so that a second signal exits at once instead of waiting for the drain
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
 	// Run the Cobra command
 	if err := rootCmd.ExecuteContext(ctx); err != nil {
-		log.Fatalf("Command execution failed: %v", err)
+		slog.Error("Command execution failed", "error", err)
+		os.Exit(1)
 	}
 }
 
+// defaultStreamMaxClients caps the clients of --serve-addr, each of which buffers the events it has not received yet
+const defaultStreamMaxClients = 64
+
+// newEventStream returns the event stream of --serve-addr, which is only served beyond localhost to the clients
+// authenticating over TLS
+func newEventStream() (*watcher.EventStream, error) {
+	if streamMaxClients < 1 {
+		return nil, fmt.Errorf("--stream-max-clients must be at least 1")
+	}
+	options := []watcher.StreamOption{watcher.WithAllowedOrigins(serveAllowOrigins...), watcher.WithStreamMaxClients(streamMaxClients)}
+	if serveTokenFile != "" {
+		token, err := readTokenFile(serveTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the event stream token: %w", err)
+		}
+		options = append(options, watcher.WithStreamToken(token))
+	}
+	if serveTLSCert != "" {
+		options = append(options, watcher.WithStreamTLS(serveTLSCert, serveTLSKey))
+	}
+	if !loopbackAddr(serveAddr) && (serveTokenFile == "" || serveTLSCert == "") {
+		return nil, fmt.Errorf("serving the event stream on %s, beyond localhost, requires --serve-token-file and --serve-tls-cert so that the clients authenticate over TLS", serveAddr)
+	}
+	return watcher.NewEventStream(options...), nil
+}
+
 // runWatcher connects to Kubernetes and starts watching pods for the marker.
 func runWatcher(ctx context.Context) error {
-	// Build Kubernetes REST client configuration
-	config, err := buildConfig(kubeconfig)
+	start := time.Now()
+	// The event stream outlives the watchers, which are replaced when the config file changes
+	if serveAddr != "" {
+		stream, err := newEventStream()
+		if err != nil {
+			return err
+		}
+		eventStream = stream
+	}
+	// The terminal UI outlives the watchers too
+	if tuiMode {
+		if !term.IsTerminal(int(os.Stdout.Fd())) {
+			return fmt.Errorf("--tui requires stdout to be a terminal")
+		}
+		tuiView = watcher.NewTUI(watcher.TUIOptions{})
+	}
+	// With --fake, watch an in-memory cluster playing canned events instead
+	if fakeEvents {
+		namespace := metav1.NamespaceDefault
+		if len(namespaces) > 0 {
+			namespace = namespaces[0]
+		}
+		cluster, clientset := watcher.NewFakeCluster("")
+		fakeClusters = []watcher.Cluster{cluster}
+		go func() {
+			if err := watcher.PlayFakeEvents(ctx, clientset, namespace, fakeEventInterval); err != nil {
+				slog.Error("Could not play the fake events", "error", err)
+			}
+		}()
+	}
+	w, err := newWatcher(start)
 	if err != nil {
-		return fmt.Errorf("could not load Kubernetes config: %w", err)
+		return err
+	}
+	currentWatcher.Store(w)
+	// Report to systemd, if requested, until the watcher returns
+	if daemonMode {
+		daemonCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go notifySystemd(daemonCtx)
+	}
+	// Summarize the run, if requested, once the watcher returns
+	if printSummary || summaryFile != "" {
+		defer writeSummary()
+	}
+	// Trace the events, if requested, flushing the spans once the watcher returns
+	if otelEndpoint != "" {
+		shutdown, err := watcher.SetupTracing(ctx, otelEndpoint)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(shutdownCtx); err != nil {
+				slog.Warn("Could not flush the traces", "error", err)
+			}
+		}()
+	}
+	// Stream the events to HTTP clients, if requested, until the watcher returns
+	if eventStream != nil {
+		streamCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		if err := watcher.ServeEventStream(streamCtx, serveAddr, eventStream); err != nil {
+			return fmt.Errorf("could not serve the event stream: %w", err)
+		}
+	}
+	// Serve metrics, if requested, until the watcher returns
+	if metricsAddr != "" {
+		metricsCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go watcher.ServeMetrics(metricsCtx, metricsAddr)
+	}
+	// With a config file, replace the watcher whenever the file changes
+	if configReader != nil {
+		return runTUI(ctx, func(ctx context.Context) error { return runReloading(ctx, start, w) })
+	}
+	// Serve the health endpoints, if requested, until the watcher returns
+	if healthAddr != "" {
+		healthCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go watcher.ServeHealth(healthCtx, healthAddr, w.HealthHandler())
+	}
+	return runTUI(ctx, w.Run)
+}
+
+// runTUI runs the watcher behind the terminal UI of --tui, which shows the operational logs written to stderr
+// in its status line. The UI stays up once the watcher stops, until it is quit, which stops the watcher.
+// Without --tui, it just runs the watcher.
+func runTUI(ctx context.Context, run func(context.Context) error) error {
+	if tuiView == nil {
+		return run(ctx)
+	}
+	if logOutput == "" || logOutput == "stderr" {
+		if err := setupLogger(logLevel, logFormat, tuiView.LogWriter()); err != nil {
+			return err
+		}
+		defer func() { _ = setupLogging(logLevel, logFormat, logOutput) }()
 	}
-	// Create a Kubernetes clientset from the config
-	clientset, err := kubernetes.NewForConfig(config)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		err := run(ctx)
+		tuiView.Stopped(err)
+		done <- err
+	}()
+	uiErr := tuiView.Run(ctx)
+	cancel()
+	err := <-done
+	if uiErr != nil {
+		return fmt.Errorf("could not run the terminal UI: %w", uiErr)
+	}
+	return err
+}
+
+// writeSummary writes the summary of the last run of the current watcher as JSON to --summary-file, or to stderr
+func writeSummary() {
+	data, err := json.MarshalIndent(currentWatcher.Load().Summary(), "", "  ")
 	if err != nil {
-		return fmt.Errorf("could not create Kubernetes client: %w", err)
+		slog.Error("Could not encode the summary", "error", err)
+		return
+	}
+	data = append(data, '\n')
+	if summaryFile == "" {
+		_, _ = os.Stderr.Write(data)
+		return
+	}
+	if err := os.WriteFile(summaryFile, data, 0o644); err != nil {
+		slog.Error("Could not write the summary", "summaryFile", summaryFile, "error", err)
 	}
-	log.Printf("Starting pod watcher (marker=%q, stopOnDelete=%v)", marker, stopOnDelete)
+}
 
-	// Variables for stop-on-delete mode
-	var targetPodKey string // "namespace/name" of the first matching pod
-	targetAcquired := false // whether we've locked onto a specific pod
-	done := false           // signals when to terminate the watch loop
+// newWatcher creates a watcher configured by the flags, started at the given time
+func newWatcher(start time.Time) (*watcher.Watcher, error) {
+	// Build the Kubernetes REST client configuration of every watched cluster
+	clusters, err := clusterConfigs()
+	if err != nil {
+		return nil, fmt.Errorf("could not load Kubernetes config: %w", err)
+	}
+	// Fail with the missing permissions up front, rather than with the errors of the watch
+	if fakeClusters == nil && !skipPermissionCheck {
+		if err := checkPermissions(clusters); err != nil {
+			return nil, err
+		}
+	}
+	options, err := watcherOptions(start)
+	if err != nil {
+		return nil, err
+	}
+	w, err := watcher.NewMultiCluster(clusters, options...)
+	if err != nil {
+		return nil, err
+	}
+	reloading = true
+	return w, nil
+}
 
-	// Outer loop: keep watching until done or error requiring restart
-	for !done {
-		// 1. List pods to get current resourceVersion&#8203;:contentReference[oaicite:9]{index=9}
-		list, err := clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
-		if err != nil {
-			log.Printf("Initial pod list error: %v. Retrying...", err)
-			time.Sleep(2 * time.Second)
-			continue // retry listing until successful
+// watcherOptions translates the command line flags into watcher options
+func watcherOptions(now time.Time) ([]watcher.Option, error) {
+	options := []watcher.Option{
+		watcher.WithResource(resourceArg),
+		watcher.WithMarkers(markers...),
+		watcher.WithMarkerRegexes(markerRegexes...),
+		watcher.WithMarkerPaths(markerPaths...),
+		watcher.WithCELFilters(filterCEL...),
+		watcher.WithPhases(phases...),
+		watcher.WithConditions(conditions...),
+		watcher.WithFilterPlugin(filterPlugin),
+		watcher.WithExcludeNamespaces(excludeNamespaces...),
+		watcher.WithExcludeMarkers(excludeMarkers...),
+		watcher.WithExcludeLabelSelector(excludeLabelSelector),
+		watcher.WithLabelSelector(labelSelector),
+		watcher.WithWatchLabel(watchLabel),
+		watcher.WithWatchAnnotation(watchAnnotation),
+		watcher.WithFieldSelector(fieldSelector),
+		watcher.WithEventTypes(eventTypes...),
+		watcher.WithResyncPeriod(resyncPeriod),
+		watcher.WithRelistInterval(resyncInterval),
+		watcher.WithWatchTimeout(watchTimeout),
+		watcher.WithBackoff(watcher.BackoffOptions{Max: maxBackoff, Threshold: breakerThreshold, Pause: breakerPause}),
+		watcher.WithPageSize(pageSize),
+		watcher.WithShards(shards, shardLabel),
+		watcher.WithWorkers(workers, queueSize),
+		watcher.WithMaxTrackedObjects(maxTrackedPods),
+		watcher.WithDrainTimeout(drainTimeout),
+		watcher.WithExitCodeOnDelete(exitCodeOnDelete),
+		watcher.WithWaitFor(waitFor),
+		watcher.WithMaxEvents(maxEvents),
+		watcher.WithStrip(stripPaths...),
+	}
+	if forWorkload != "" {
+		// Like kubectl, the workload is looked up in the namespace of the kubeconfig context by default
+		watched := namespaces
+		if len(watched) == 0 {
+			watched = []string{contextNamespace()}
 		}
-		resourceVersion := list.ResourceVersion
+		options = append(options, watcher.WithWorkload(forWorkload), watcher.WithNamespaces(watched...))
+	} else if !allNamespaces {
+		options = append(options, watcher.WithNamespaces(namespaces...))
+	}
+	if auditFields {
+		options = append(options, watcher.WithManagedFieldsSummary())
+	}
+	if redact || len(redactPaths) > 0 {
+		options = append(options, watcher.WithRedaction(watcher.RedactOptions{Builtin: redact, Env: redactEnv, Annotations: redactAnnotations, Paths: redactPaths}))
+	}
+	if markerAll {
+		options = append(options, watcher.WithMarkerAll())
+	}
+	if useWatchList {
+		options = append(options, watcher.WithWatchList())
+	}
+	if emitInitial {
+		options = append(options, watcher.WithEmitInitial())
+	}
+	if since > 0 {
+		options = append(options, watcher.WithBackfill(since))
+	}
+	if snapshotMode {
+		options = append(options, watcher.WithSnapshot())
+	}
+	if skipInitial {
+		options = append(options, watcher.WithSkipInitial())
+	}
+	if stopOnDelete {
+		options = append(options, watcher.WithStopOnDelete())
+	}
+	if waitForDeleteAll {
+		options = append(options, watcher.WithWaitForDeleteAll())
+	}
+	if onImageChange {
+		options = append(options, watcher.WithImageChange())
+	}
+	if specChangesOnly {
+		options = append(options, watcher.WithChangesOnly(watcher.SpecSection))
+	}
+	if statusChangesOnly {
+		options = append(options, watcher.WithChangesOnly(watcher.StatusSection))
+	}
+	if minInterval > 0 {
+		options = append(options, watcher.WithMinInterval(minInterval))
+	}
+	if dedupe {
+		options = append(options, watcher.WithDedupe())
+	}
+	if includeEvents {
+		options = append(options, watcher.WithKubernetesEvents())
+	}
+	if followReferences {
+		options = append(options, watcher.WithReferences(includeSecretData))
+	}
+	if trackVolumes {
+		options = append(options, watcher.WithVolumeTracking())
+	}
+	if tailLogs {
+		options = append(options, watcher.WithLogTail())
+	}
+	if trackContainers {
+		options = append(options, watcher.WithContainerTracking())
+	}
+	if trackInit {
+		options = append(options, watcher.WithInitTracking())
+	}
+	if trackImages {
+		options = append(options, watcher.WithImageTracking())
+	}
+	if securityWatch {
+		options = append(options, watcher.WithSecurityWatch())
+	}
+	if timeline {
+		options = append(options, watcher.WithTimeline())
+	}
+	if restartThreshold != 0 || flapThreshold != 0 {
+		options = append(options, watcher.WithAlerts(watcher.AlertOptions{RestartThreshold: restartThreshold, FlapThreshold: flapThreshold, Window: flapWindow}))
+	}
+	if trackTermination {
+		options = append(options, watcher.WithTerminationTracking(watcher.TerminationOptions{StuckThreshold: terminatingThreshold}))
+	}
+	if includeNode {
+		options = append(options, watcher.WithNodeInfo())
+	}
+	if includeMetrics {
+		options = append(options, watcher.WithResourceUsage(watcher.UsageOptions{Interval: metricsInterval, Events: metricsEvents}))
+	}
+	if statsInterval > 0 {
+		options = append(options, watcher.WithStatsInterval(statsInterval))
+	}
+	if heartbeatInterval > 0 {
+		options = append(options, watcher.WithHeartbeatInterval(heartbeatInterval))
+	}
+	if authCheckInterval > 0 {
+		options = append(options, watcher.WithAuthCheckInterval(authCheckInterval))
+	}
+	if source := cloudEventsSourceName(); source != "" {
+		options = append(options, watcher.WithCloudEventsSource(source))
+	}
+	if captureOnFailure {
+		options = append(options, watcher.WithFailureCapture(captureDir))
+	}
+	if debugImage != "" {
+		options = append(options, watcher.WithDebugContainer(watcher.DebugOptions{Image: debugImage, Trigger: debugTrigger}))
+	}
+	if resolveOwners {
+		options = append(options, watcher.WithOwnerResolution())
+	}
+	if attributeDeletions {
+		options = append(options, watcher.WithDeletionAttribution())
+	}
+	if checkpointFile != "" || checkpointConfigMap != "" {
+		options = append(options, watcher.WithCheckpoint(checkpointOptions()))
+	}
+	if leaderElect {
+		options = append(options, watcher.WithLeaderElection(watcher.LeaderElectionOptions{
+			LeaseName:      leaseName,
+			LeaseNamespace: leaseNamespace,
+			LeaseDuration:  leaseDuration,
+			RenewDeadline:  renewDeadline,
+			RetryPeriod:    retryPeriod,
+		}))
+	}
+	deadline, err := runDeadline(now)
+	if err != nil {
+		return nil, err
+	}
+	if !deadline.IsZero() {
+		options = append(options, watcher.WithDeadline(deadline))
+	}
 
-		// 2. Start watching from the obtained resourceVersion for new changes
-		watcher, err := clientset.CoreV1().Pods("").Watch(ctx, metav1.ListOptions{
-			ResourceVersion: resourceVersion,
-		})
-		if err != nil {
-			log.Printf("Watch start failed (resourceVersion=%s): %v. Retrying...", resourceVersion, err)
-			time.Sleep(2 * time.Second)
-			continue // retry starting the watch
-		}
-
-		// Inner loop: process events from the watch
-		for event := range watcher.ResultChan() {
-			// Exit if context was canceled (e.g., Ctrl+C)
-			if ctx.Err() != nil {
-				log.Println("Context canceled, stopping watcher.")
-				done = true
-				break
-			}
-			if event.Type == watch.Error {
-				// An error occurred in the watch stream (e.g., too old resourceVersion)
-				// Log details and break to restart the watch&#8203;:contentReference[oaicite:10]{index=10}
-				if status, ok := event.Object.(*metav1.Status); ok {
-					log.Printf("Watch error: %s (code %d)", status.Message, status.Code)
-				} else {
-					log.Printf("Watch error: received unknown error object")
-				}
-				break // break inner loop to re-establish watch
-			}
+	sinks, err := sinkOptions()
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, sinks...)
+	if eventStream != nil {
+		options = append(options, watcher.WithEventStream(eventStream))
+	}
+	if tuiView != nil {
+		options = append(options, watcher.WithSink(tuiView))
+	}
+	if execCommand != "" {
+		options = append(options, watcher.WithExec(execCommand, execConcurrency, execTimeout))
+	}
+	return options, nil
+}
 
-			// Convert to a Pod or skip
-			pod, ok := event.Object.(*corev1.Pod)
-			if !ok {
-				// If it's not a Pod, it might be a *metav1.Status
-				// or something else. Usually we skip it.
-				continue
-			}
+// checkpointOptions returns the checkpoint settings of --checkpoint-file or --checkpoint-configmap
+func checkpointOptions() watcher.CheckpointOptions {
+	options := watcher.CheckpointOptions{File: checkpointFile, Interval: checkpointInterval}
+	if checkpointConfigMap != "" {
+		options.ConfigMapName = checkpointConfigMap
+		if namespace, name, ok := strings.Cut(checkpointConfigMap, "/"); ok {
+			options.ConfigMapNamespace, options.ConfigMapName = namespace, name
+		}
+	}
+	return options
+}
 
-			// Serialize Pod to YAML
-			podYAML, err := yaml.Marshal(pod)
-			if err != nil {
-				log.Printf("Failed to marshal pod %s/%s to YAML: %v", pod.Namespace, pod.Name, err)
-				continue
-			}
-			yamlStr := string(podYAML)
-			// Check for marker substring
-			if !strings.Contains(yamlStr, marker) {
-				continue // ignore events that don't include the marker
-			}
+// selectorFlags select the watched pods; one of them is required
+var selectorFlags = []string{"marker", "marker-regex", "filter-cel", "filter-plugin", "label-selector", "field-selector", "for", "watch-label", "watch-annotation"}
 
-			// If stopOnDelete mode, select the first matching pod as target
-			currentKey := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
-			if stopOnDelete {
-				if !targetAcquired {
-					targetPodKey = currentKey
-					targetAcquired = true
-					log.Printf("Target pod found: %s (monitoring exclusively)", targetPodKey)
-				}
-				// Once a target is acquired, ignore other pods
-				if currentKey != targetPodKey {
-					continue
-				}
-			}
+// exclusiveFlags are the pairs of flags that cannot be combined, and why
+var exclusiveFlags = []struct {
+	flags  [2]string
+	reason string
+}{
+	{[2]string{"namespace", "all-namespaces"}, "--all-namespaces watches every namespace"},
+	{[2]string{"for", "all-namespaces"}, "--for watches the namespace of the workload"},
+	{[2]string{"context", "all-contexts"}, "--all-contexts watches every context"},
+	{[2]string{"stop-on-delete", "wait-for-delete-all"}, "the first stops once one pod is deleted, the second once all of them are"},
+	{[2]string{"stop-on-delete", "wait-for"}, "the first stops once a pod is deleted, the second once a pod meets the condition"},
+	{[2]string{"wait-for-delete-all", "wait-for"}, "the first stops once every pod is deleted, the second once a pod meets the condition"},
+	{[2]string{"checkpoint-file", "checkpoint-configmap"}, "the checkpoint is saved in one place"},
+	{[2]string{"emit-initial", "skip-initial"}, "the first emits the pods existing at startup, the second never does"},
+	{[2]string{"daemon", "tui"}, "a service has no terminal"},
+	{[2]string{"spec-changes-only", "status-changes-only"}, "the first suppresses the modifications of the status, the second those of the spec"},
+}
 
-			// Output the pod's YAML as one document in the stream
-			fmt.Printf("---\n## Event: %s\n\n%s\n", event.Type, yamlStr)
+// dependentFlags are the flags that only take effect along with one of others, and are rejected without them
+var dependentFlags = []struct {
+	flag     string
+	requires []string
+}{
+	{"exit-code-on-delete", []string{"stop-on-delete", "wait-for-delete-all"}},
+	{"include-secret-data", []string{"follow-references"}},
+	{"metrics-interval", []string{"include-metrics"}},
+	{"metrics-events", []string{"include-metrics"}},
+	{"inject-debug-on", []string{"inject-debug-container"}},
+	{"capture-dir", []string{"capture-on-failure"}},
+	{"redact-env", []string{"redact"}},
+	{"redact-annotations", []string{"redact"}},
+	{"flap-window", []string{"restart-threshold", "flap-threshold"}},
+	{"terminating-threshold", []string{"track-termination"}},
+	{"shard-label", []string{"shards"}},
+	{"exec-concurrency", []string{"exec"}},
+	{"exec-timeout", []string{"exec"}},
+	{"checkpoint-interval", []string{"checkpoint-file", "checkpoint-configmap"}},
+	{"leader-elect-lease-name", []string{"leader-elect"}},
+	{"leader-elect-namespace", []string{"leader-elect"}},
+	{"leader-elect-lease-duration", []string{"leader-elect"}},
+	{"leader-elect-renew-deadline", []string{"leader-elect"}},
+	{"leader-elect-retry-period", []string{"leader-elect"}},
+	{"serve-allow-origin", []string{"serve-addr"}},
+	{"serve-token-file", []string{"serve-addr"}},
+	{"serve-tls-cert", []string{"serve-addr"}},
+	{"serve-tls-cert", []string{"serve-tls-key"}},
+	{"serve-tls-key", []string{"serve-tls-cert"}},
+	{"stream-max-clients", []string{"serve-addr"}},
+	{"max-files", []string{"max-file-size"}},
+	{"compress-rotated", []string{"max-file-size"}},
+	{"route", []string{"route-key"}},
+	{"webhook-batch-interval", []string{"webhook-batch-size"}},
+	{"archive-chunk-size", []string{"archive"}},
+	{"archive-chunk-interval", []string{"archive"}},
+	{"archive-encryption", []string{"archive"}},
+	{"archive-kms-key", []string{"archive"}},
+	{"archive-region", []string{"archive"}},
+	{"archive-endpoint", []string{"archive"}},
+	{"sink-queue-retention", []string{"sink-queue-dir"}},
+	{"sink-queue-max-size", []string{"sink-queue-dir"}},
+}
 
-			// If this was a deletion of the target pod (stop-on-delete mode), we can finish
-			if stopOnDelete && targetAcquired && event.Type == watch.Deleted && currentKey == targetPodKey {
-				log.Printf("Target pod %s deleted, exiting watcher.", targetPodKey)
-				done = true
-				break
-			}
-		} // end inner for events
+// minimumFlags are the numeric flags with a lower bound, checked here so that the errors name the flags rather than the options
+var minimumFlags = []struct {
+	flag    string
+	minimum int64 // a count, or nanoseconds for a duration
+}{
+	{"workers", 1},
+	{"queue-size", 1},
+	{"max-tracked-pods", 0},
+	{"page-size", 0},
+	{"shards", 0},
+	{"since", 0},
+	{"stats-interval", 0},
+	{"heartbeat-interval", 0},
+	{"auth-check-interval", 0},
+	{"restart-threshold", 0},
+	{"flap-threshold", 0},
+	{"terminating-threshold", 0},
+	{"sink-queue-retention", 0},
+}
 
-		// Clean up watcher resources
-		watcher.Stop()
-		if done {
-			break // exit outer loop if done flag is set
+// validateFlags rejects the combinations of the flags of the command, given on the command line or in the config file,
+// that cannot work together, before anything starts
+func validateFlags(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	changed := func(name string) bool {
+		f := flags.Lookup(name)
+		return f != nil && f.Changed
+	}
+	for _, exclusive := range exclusiveFlags {
+		if changed(exclusive.flags[0]) && changed(exclusive.flags[1]) {
+			return fmt.Errorf("--%s cannot be combined with --%s: %s", exclusive.flags[0], exclusive.flags[1], exclusive.reason)
+		}
+	}
+	// The pods are selected by one of these flags, or by the profiles of the config file
+	if !slices.ContainsFunc(selectorFlags, func(name string) bool { return flags.Lookup(name) == nil }) &&
+		!slices.ContainsFunc(selectorFlags, changed) && len(watchProfiles) == 0 {
+		return fmt.Errorf("at least one of the flags in the group [%s] is required, unless the config file has profiles", strings.Join(selectorFlags, " "))
+	}
+	for _, dependent := range dependentFlags {
+		if !changed(dependent.flag) || slices.ContainsFunc(dependent.requires, changed) {
+			continue
+		}
+		return fmt.Errorf("--%s requires --%s", dependent.flag, strings.Join(dependent.requires, " or --"))
+	}
+	for _, bound := range minimumFlags {
+		f := flags.Lookup(bound.flag)
+		if f == nil {
+			continue
+		}
+		var value int64
+		var err error
+		if f.Value.Type() == "duration" {
+			var d time.Duration
+			d, err = time.ParseDuration(f.Value.String())
+			value = int64(d)
+		} else {
+			value, err = strconv.ParseInt(f.Value.String(), 10, 64)
+		}
+		switch {
+		case err != nil:
+			return fmt.Errorf("invalid --%s %q: %w", bound.flag, f.Value.String(), err)
+		case value < bound.minimum && bound.minimum == 0:
+			return fmt.Errorf("--%s must not be negative", bound.flag)
+		case value < bound.minimum:
+			return fmt.Errorf("--%s must be at least %d", bound.flag, bound.minimum)
 		}
-		// Otherwise, loop continues to restart the watch after a short pause
-		log.Println("Watch stream ended, restarting watch...")
-		time.Sleep(1 * time.Second)
 	}
 	return nil
 }
 
-// buildConfig creates a Kubernetes client config from a file path or in-cluster settings
-func buildConfig(kubeconfigPath string) (*rest.Config, error) {
-	if kubeconfigPath != "" {
-		// Use the provided kubeconfig file
-		return clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+// runDeadline returns the time at which --timeout or --until stops the watcher, whichever comes first,
+// or the zero time when neither is set.
+func runDeadline(now time.Time) (time.Time, error) {
+	var deadline time.Time
+	if runTimeout > 0 {
+		deadline = now.Add(runTimeout)
+	}
+	if runUntil != "" {
+		until, err := time.Parse(time.RFC3339, runUntil)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid --until %q (must be an RFC 3339 time such as 2024-06-01T18:00:00Z): %w", runUntil, err)
+		}
+		if !until.After(now) {
+			return time.Time{}, fmt.Errorf("--until %s is in the past", runUntil)
+		}
+		if deadline.IsZero() || until.Before(deadline) {
+			deadline = until
+		}
+	}
+	return deadline, nil
+}
+
+// connectionFlags are the kubectl connection flags (--server, --token, --as, --request-timeout, ...).
+// --kubeconfig and the repeatable --context are pod-watcher's own, and --namespace selects the watched namespaces.
+var connectionFlags = genericclioptions.NewConfigFlags(false)
+
+// addConnectionFlags defines the kubectl connection flags on the command.
+// Their short forms are dropped where they clash with pod-watcher's own, such as -s for --stop-on-delete.
+func addConnectionFlags(cmd *cobra.Command) {
+	connectionFlags.KubeConfig, connectionFlags.Context, connectionFlags.Namespace = nil, nil, nil
+	flags := pflag.NewFlagSet("connection", pflag.ContinueOnError)
+	connectionFlags.AddFlags(flags)
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Shorthand != "" && cmd.Flags().ShorthandLookup(f.Shorthand) != nil {
+			f.Shorthand = ""
+		}
+		cmd.Flags().AddFlag(f)
+	})
+	connectionFlags.KubeConfig = &kubeconfig
+	cmd.Flags().StringVar(&contentType, "content-type", "protobuf", "Encoding requested from the API server for built-in resources such as pods: protobuf, which is cheaper to decode, or json (other resources always use json)")
+	cmd.Flags().Float32Var(&clientQPS, "qps", 0, "Maximum requests per second to the API server, client-side (0 keeps the client-go default of 5)")
+	cmd.Flags().IntVar(&clientBurst, "burst", 0, "Maximum burst of requests to the API server above --qps (0 keeps the client-go default of 10)")
+	cmd.Flags().StringVar(&proxyURL, "proxy-url", "", "URL of the proxy the requests to the API server go through (http, https or socks5), instead of the proxy-url of the kubeconfig or the environment")
+}
+
+// clusterConfigs creates the client configs of the watched clusters: the kubeconfig contexts given with --context,
+// every context with --all-contexts, or the current one. When watching several contexts,
+// each cluster is named after its context, which tags its events. With --fake, it is the in-memory cluster instead.
+func clusterConfigs() ([]watcher.Cluster, error) {
+	if fakeClusters != nil {
+		return fakeClusters, nil
+	}
+	contexts := kubecontexts
+	if allContexts {
+		var err error
+		if contexts, err = kubeconfigContexts(); err != nil {
+			return nil, err
+		}
+	}
+	if len(contexts) <= 1 && !allContexts {
+		var contextName string
+		if len(contexts) == 1 {
+			contextName = contexts[0]
+		}
+		config, err := buildConfig(contextName)
+		if err != nil {
+			return nil, err
+		}
+		return []watcher.Cluster{{Config: config}}, nil
+	}
+	var clusters []watcher.Cluster
+	for _, contextName := range contexts {
+		config, err := buildConfig(contextName)
+		if err != nil {
+			return nil, fmt.Errorf("context %s: %w", contextName, err)
+		}
+		clusters = append(clusters, watcher.Cluster{Name: contextName, Config: config})
+	}
+	return clusters, nil
+}
+
+// contextNamespace returns the namespace of the kubeconfig context, or of the pod when running in-cluster
+func contextNamespace() string {
+	namespace, _, err := connectionFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil || namespace == "" {
+		return metav1.NamespaceDefault
+	}
+	return namespace
+}
+
+// cloudEventsSourceName returns --cloudevents-source, or the name of the watched kubeconfig context when watching one;
+// the events of several clusters have the context of their cluster as their source anyway
+func cloudEventsSourceName() string {
+	if cloudEventsSource != "" || len(kubecontexts) > 1 || allContexts || fakeClusters != nil {
+		return cloudEventsSource
 	}
-	// No kubeconfig specified: try default external config, then in-cluster
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	overrides := &clientcmd.ConfigOverrides{}
-	if kubecontext != "" {
-		overrides.CurrentContext = kubecontext
+	if len(kubecontexts) == 1 {
+		return kubecontexts[0]
 	}
-	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
-	restConfig, err := config.ClientConfig()
+	config, err := connectionFlags.ToRawKubeConfigLoader().RawConfig()
 	if err != nil {
+		return ""
+	}
+	return config.CurrentContext
+}
+
+// kubeconfigContexts returns the names of the contexts of the kubeconfig, sorted
+func kubeconfigContexts() ([]string, error) {
+	config, err := connectionFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	var contexts []string
+	for name := range config.Contexts {
+		contexts = append(contexts, name)
+	}
+	if len(contexts) == 0 {
+		return nil, fmt.Errorf("--all-contexts: the kubeconfig has no contexts")
+	}
+	sort.Strings(contexts)
+	return contexts, nil
+}
+
+// buildConfig creates a Kubernetes client config for a context of the kubeconfig, applying the connection flags.
+// Without a kubeconfig file or context, it falls back to the in-cluster settings.
+func buildConfig(contextName string) (*rest.Config, error) {
+	connectionFlags.Context = &contextName
+	restConfig, err := connectionFlags.ToRESTConfig()
+	if err != nil && kubeconfig == "" && contextName == "" {
 		// If not found in default locations, try in-cluster config
-		return rest.InClusterConfig()
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := applyNetworkFlags(restConfig); err != nil {
+		return nil, err
 	}
-	return restConfig, nil
+	watcher.RateLimit(restConfig, clientQPS, clientBurst)
+	return restConfig, setContentType(restConfig)
+}
+
+// applyNetworkFlags applies --proxy-url to the config, and --certificate-authority and --tls-server-name to the
+// in-cluster config, which unlike a kubeconfig does not take them from the connection flags
+func applyNetworkFlags(config *rest.Config) error {
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return fmt.Errorf("invalid --proxy-url %q (must be an http, https or socks5 URL, e.g. http://proxy.internal:3128)", proxyURL)
+		}
+		config.Proxy = http.ProxyURL(u)
+	}
+	if caFile := *connectionFlags.CAFile; caFile != "" && config.CAFile != caFile {
+		config.CAFile, config.CAData = caFile, nil
+	}
+	if serverName := *connectionFlags.TLSServerName; serverName != "" {
+		config.ServerName = serverName
+	}
+	return nil
+}
+
+// setContentType applies --content-type to the config; the dynamic client used for --resource always uses JSON
+func setContentType(config *rest.Config) error {
+	switch contentType {
+	case "protobuf":
+		// Resources without a protobuf encoding, like those of aggregated APIs, are still answered in JSON
+		config.ContentType = runtime.ContentTypeProtobuf
+		config.AcceptContentTypes = runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON
+	case "json":
+		config.ContentType = runtime.ContentTypeJSON
+		config.AcceptContentTypes = runtime.ContentTypeJSON
+	default:
+		return fmt.Errorf("unsupported --content-type %q (must be protobuf or json)", contentType)
+	}
+	return nil
 }
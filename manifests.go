@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	manifestsImage     string
+	manifestsNamespace string
+	manifestsName      string
+	manifestsReplicas  int
+)
+
+// manifestsDataDir is the writable directory of the generated Deployment, and its working directory
+const manifestsDataDir = "/var/lib/pod-watcher"
+
+// manifestsOwnFlags are the flags of the manifests command itself, which are not passed on to the watcher
+var manifestsOwnFlags = []string{"image", "install-namespace", "name", "replicas"}
+
+// manifestsSkippedFlags are the flags that do not apply in the cluster: those of the kubeconfig, which the in-cluster
+// config replaces, the config file, whose settings are passed as flags, and those of an interactive or host run
+var manifestsSkippedFlags = []string{
+	"kubeconfig", "context", "all-contexts", "cluster", "user", "server", "token", "as", "as-group", "as-uid",
+	"certificate-authority", "client-certificate", "client-key", "tls-server-name", "insecure-skip-tls-verify", "cache-dir",
+	"config", "tui", "daemon", "fake", "color",
+}
+
+// manifestsSecretFlags are the flags holding secrets, passed through the environment variables they default to,
+// from a Secret, rather than on the command line of the Deployment
+var manifestsSecretFlags = map[string]string{
+	"webhook-secret":      "POD_WATCHER_WEBHOOK_SECRET",
+	"kafka-sasl-password": "POD_WATCHER_KAFKA_PASSWORD",
+	"nats-token":          "POD_WATCHER_NATS_TOKEN",
+	"slack-webhook":       "POD_WATCHER_SLACK_WEBHOOK",
+	"teams-webhook":       "POD_WATCHER_TEAMS_WEBHOOK",
+}
+
+// manifestsCmd prints the manifests deploying the watcher, as configured by the flags, into a cluster
+var manifestsCmd = &cobra.Command{
+	Use:   "manifests --image IMAGE [WATCH FLAGS]",
+	Short: "Print the manifests deploying the watcher, as configured by the flags, into a cluster",
+	Long: `manifests prints ready-to-apply Kubernetes manifests running the watcher in a cluster with the given flags:
+a ServiceAccount, the RBAC granting it only the access these flags need (Roles in the watched namespaces, or ClusterRoles
+when watching every namespace or cluster-scoped resources), a Deployment, a Secret holding the webhook secrets and
+passwords given to it, and a Service for the metrics endpoint of --metrics-addr. The flags of the kubeconfig do not
+apply, as the watcher uses the in-cluster config, and the settings of a --config file are passed as flags.
+
+Examples:
+  pod-watcher manifests --image registry.example.com/pod-watcher:v1.2.3 --namespace team-a --marker DEBUG_MODE | kubectl apply -f -
+  pod-watcher manifests --image registry.example.com/pod-watcher:v1.2.3 --config config.yaml --metrics-addr :9090 > pod-watcher.yaml
+`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		objects, err := deploymentManifests(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			data, err := manifestYAML(obj)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("---\n%s", data)
+		}
+		return nil
+	},
+}
+
+func init() {
+	manifestsCmd.Flags().StringVar(&manifestsImage, "image", "", "Container image of pod-watcher run by the Deployment")
+	manifestsCmd.Flags().StringVar(&manifestsNamespace, "install-namespace", "pod-watcher", "Namespace the watcher is deployed to")
+	manifestsCmd.Flags().StringVar(&manifestsName, "name", "pod-watcher", "Name of the Deployment and the other objects")
+	manifestsCmd.Flags().IntVar(&manifestsReplicas, "replicas", 0, "Replicas of the Deployment (defaults to 2 with --leader-elect, 1 otherwise)")
+	_ = manifestsCmd.MarkFlagRequired("image")
+	manifestsCmd.Flags().AddFlagSet(rootCmd.Flags())
+	registerCompletions(manifestsCmd)
+	rootCmd.AddCommand(manifestsCmd)
+}
+
+// deploymentManifests returns the objects deploying the watcher with the flags set
+func deploymentManifests(flags *pflag.FlagSet) ([]runtime.Object, error) {
+	labels := map[string]string{"app.kubernetes.io/name": "pod-watcher", "app.kubernetes.io/instance": manifestsName}
+	meta := func(name, namespace string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}
+	}
+	objects := []runtime.Object{&corev1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: meta(manifestsName, manifestsNamespace),
+	}}
+	objects = append(objects, rbacManifests(meta)...)
+
+	args, secrets := manifestArgs(flags)
+	container := corev1.Container{
+		Name:       "pod-watcher",
+		Image:      manifestsImage,
+		Args:       args,
+		WorkingDir: manifestsDataDir,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m"), corev1.ResourceMemory: resource.MustParse("64Mi")},
+			Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: ptr(false),
+			ReadOnlyRootFilesystem:   ptr(true),
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		},
+		VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: manifestsDataDir}},
+	}
+	if len(secrets) > 0 {
+		secret := &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: meta(manifestsName, manifestsNamespace),
+			StringData: secrets,
+		}
+		objects = append(objects, secret)
+		names := make([]string, 0, len(secrets))
+		for name := range secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			container.Env = append(container.Env, corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: manifestsName}, Key: name},
+			}})
+		}
+	}
+	metricsPort, err := addrPort("--metrics-addr", metricsAddr)
+	if err != nil {
+		return nil, err
+	}
+	if metricsPort > 0 {
+		container.Ports = append(container.Ports, corev1.ContainerPort{Name: "metrics", ContainerPort: metricsPort})
+	}
+	healthPort, err := addrPort("--health-addr", healthAddr)
+	if err != nil {
+		return nil, err
+	}
+	if healthPort > 0 {
+		container.Ports = append(container.Ports, corev1.ContainerPort{Name: "health", ContainerPort: healthPort})
+		probe := func(path string) *corev1.Probe {
+			return &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromString("health")}}, PeriodSeconds: 10}
+		}
+		container.LivenessProbe, container.ReadinessProbe = probe("/healthz"), probe("/readyz")
+	}
+	replicas := int32(manifestsReplicas)
+	if replicas <= 0 {
+		replicas = 1
+		if leaderElect {
+			replicas = 2
+		}
+	}
+	objects = append(objects, &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: meta(manifestsName, manifestsNamespace),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: manifestsName,
+					SecurityContext: &corev1.PodSecurityContext{
+						RunAsNonRoot:   ptr(true),
+						RunAsUser:      ptr(int64(65532)),
+						SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+					},
+					Containers: []corev1.Container{container},
+					Volumes:    []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+				},
+			},
+		},
+	})
+	if metricsPort > 0 {
+		service := &corev1.Service{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+			ObjectMeta: meta(manifestsName+"-metrics", manifestsNamespace),
+			Spec: corev1.ServiceSpec{
+				Selector: labels,
+				Ports:    []corev1.ServicePort{{Name: "metrics", Port: metricsPort, TargetPort: intstr.FromString("metrics")}},
+			},
+		}
+		service.Annotations = map[string]string{"prometheus.io/scrape": "true", "prometheus.io/port": strconv.Itoa(int(metricsPort))}
+		objects = append(objects, service)
+	}
+	return objects, nil
+}
+
+// rbacManifests returns the Roles or ClusterRoles granting the service account the permissions the flags need, and
+// their bindings: a ClusterRole for the cluster-scoped resources and, when watching every namespace, for the others too;
+// otherwise a Role in each watched namespace, and in the namespace of the watcher for its Lease and checkpoint.
+func rbacManifests(meta func(name, namespace string) metav1.ObjectMeta) []runtime.Object {
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: manifestsName, Namespace: manifestsNamespace}}
+	var clusterRules []rbacv1.PolicyRule
+	namespaceRules := make(map[string][]rbacv1.PolicyRule)
+	watched := watchedNamespaces()
+	for _, p := range requiredPermissions() {
+		rule := rbacv1.PolicyRule{APIGroups: []string{p.group}, Resources: []string{p.resource}, Verbs: p.verbs}
+		switch {
+		case p.cluster || (len(watched) == 0 && p.namespace == "" && !p.home):
+			clusterRules = appendRule(clusterRules, rule)
+		case p.namespace != "" || p.home:
+			namespace := p.namespace
+			if p.home {
+				namespace = manifestsNamespace
+			}
+			namespaceRules[namespace] = appendRule(namespaceRules[namespace], rule)
+		default:
+			for _, namespace := range watched {
+				namespaceRules[namespace] = appendRule(namespaceRules[namespace], rule)
+			}
+		}
+	}
+	var objects []runtime.Object
+	if len(clusterRules) > 0 {
+		name := manifestsNamespace + ":" + manifestsName
+		objects = append(objects,
+			&rbacv1.ClusterRole{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+				ObjectMeta: meta(name, ""),
+				Rules:      clusterRules,
+			},
+			&rbacv1.ClusterRoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+				ObjectMeta: meta(name, ""),
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+				Subjects:   subjects,
+			})
+	}
+	namespaces := make([]string, 0, len(namespaceRules))
+	for namespace := range namespaceRules {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: meta(manifestsName, namespace),
+				Rules:      namespaceRules[namespace],
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+				ObjectMeta: meta(manifestsName, namespace),
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: manifestsName},
+				Subjects:   subjects,
+			})
+	}
+	return objects
+}
+
+// appendRule adds the verbs of a rule to the rule of the same resource, or adds the rule
+func appendRule(rules []rbacv1.PolicyRule, rule rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	for i, existing := range rules {
+		if slices.Equal(existing.APIGroups, rule.APIGroups) && slices.Equal(existing.Resources, rule.Resources) {
+			for _, verb := range rule.Verbs {
+				if !slices.Contains(existing.Verbs, verb) {
+					rules[i].Verbs = append(rules[i].Verbs, verb)
+				}
+			}
+			return rules
+		}
+	}
+	return append(rules, rule)
+}
+
+// manifestArgs returns the command line of the watcher with the flags set, other than those of the manifests command
+// and those not applying in the cluster, along with the values of the secrets to pass in environment variables
+func manifestArgs(flags *pflag.FlagSet) ([]string, map[string]string) {
+	var args []string
+	secrets := make(map[string]string)
+	for _, env := range manifestsSecretFlags {
+		if value := os.Getenv(env); value != "" {
+			secrets[env] = value
+		}
+	}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if !f.Changed || slices.Contains(manifestsOwnFlags, f.Name) || slices.Contains(manifestsSkippedFlags, f.Name) {
+			return
+		}
+		if env, ok := manifestsSecretFlags[f.Name]; ok {
+			secrets[env] = f.Value.String()
+			return
+		}
+		if values, ok := f.Value.(pflag.SliceValue); ok {
+			for _, value := range values.GetSlice() {
+				args = append(args, "--"+f.Name+"="+value)
+			}
+			return
+		}
+		if f.Value.Type() == "bool" && f.Value.String() == "true" {
+			args = append(args, "--"+f.Name)
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return args, secrets
+}
+
+// addrPort returns the port of a listen address flag, or 0 if it is not set
+func addrPort(flag, addr string) (int32, error) {
+	if addr == "" {
+		return 0, nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err == nil {
+		var n int
+		if n, err = strconv.Atoi(port); err == nil && n > 0 && n < 65536 {
+			return int32(n), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid %s %q (must be [host]:port)", flag, addr)
+}
+
+// manifestYAML serializes an object without the empty status and creation timestamp of a manifest
+func manifestYAML(obj runtime.Object) ([]byte, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	delete(content, "status")
+	if metadata, ok := content["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "creationTimestamp")
+	}
+	if spec, ok := content["spec"].(map[string]interface{}); ok {
+		if template, ok := spec["template"].(map[string]interface{}); ok {
+			if metadata, ok := template["metadata"].(map[string]interface{}); ok {
+				delete(metadata, "creationTimestamp")
+			}
+		}
+	}
+	return yaml.Marshal(content)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"k8s.io/client-go/util/homedir"
+)
+
+// windowsVariable is a reference to an environment variable in the syntax of cmd.exe, e.g. %USERPROFILE%
+var windowsVariable = regexp.MustCompile(`%([A-Za-z_][A-Za-z0-9_()]*)%`)
+
+// expandPath expands the paths given in the config file, or quoted on the command line, that no shell expanded:
+// a leading ~ is the home directory, as client-go resolves it (%USERPROFILE% on Windows unless $HOME is set and
+// has a .kube/config), and the references to environment variables, $NAME or ${NAME}, or %NAME% on Windows,
+// are replaced by their values. Unset variables are left alone on Windows, as cmd.exe does.
+func expandPath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		path = filepath.Join(homedir.HomeDir(), path[1:])
+	}
+	if runtime.GOOS == "windows" {
+		return windowsVariable.ReplaceAllStringFunc(path, func(reference string) string {
+			if value, ok := os.LookupEnv(strings.Trim(reference, "%")); ok {
+				return value
+			}
+			return reference
+		})
+	}
+	return os.ExpandEnv(path)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// permissionCheckTimeout bounds the access reviews of the permission check of a cluster
+const permissionCheckTimeout = 30 * time.Second
+
+// permission is an access to the Kubernetes API that the watcher needs with the flags given
+type permission struct {
+	group    string // API group of the resource; empty for the core group, * when --resource does not name it
+	resource string // e.g. pods, or pods/log for a subresource
+	verbs    []string
+	// namespace is the namespace the access is needed in; empty for the watched namespaces,
+	// or for the namespace of the watcher itself when home is set
+	namespace string
+	home      bool
+	cluster   bool   // whether the resource is cluster-scoped
+	flag      string // the flag needing the access; empty for the watch itself
+}
+
+// requiredPermissions lists the accesses the watcher needs with the flags given
+func requiredPermissions() []permission {
+	watchVerbs := []string{"list", "watch"}
+	permissions := []permission{{resource: "pods", verbs: watchVerbs}}
+	if resourceArg != "" {
+		// The version of mycrds.example.com/v1 does not matter, and a resource without its group may be in any
+		groupResource, _, _ := strings.Cut(resourceArg, "/")
+		resource := schema.ParseGroupResource(groupResource)
+		if !strings.Contains(groupResource, ".") {
+			resource.Group = "*"
+		}
+		permissions[0] = permission{group: resource.Group, resource: resource.Resource, verbs: watchVerbs}
+	}
+	if forWorkload != "" {
+		kind, _, _ := strings.Cut(forWorkload, "/")
+		group, resource := "apps", strings.ToLower(kind)
+		switch resource {
+		case "deploy", "deployment", "deployments.apps":
+			resource = "deployments"
+		case "sts", "statefulset", "statefulsets.apps":
+			resource = "statefulsets"
+		case "ds", "daemonset", "daemonsets.apps":
+			resource = "daemonsets"
+		case "rs", "replicaset", "replicasets.apps":
+			resource = "replicasets"
+		case "job", "jobs", "jobs.batch":
+			group, resource = "batch", "jobs"
+		}
+		permissions = append(permissions, permission{group: group, resource: resource, verbs: []string{"get"}, flag: "--for"})
+	}
+	if includeEvents {
+		permissions = append(permissions, permission{resource: "events", verbs: watchVerbs, flag: "--include-events"})
+	}
+	if since > 0 {
+		permissions = append(permissions, permission{resource: "events", verbs: []string{"list"}, flag: "--since"})
+	}
+	if tailLogs {
+		permissions = append(permissions, permission{resource: "pods/log", verbs: []string{"get"}, flag: "--tail-logs"})
+	}
+	if captureOnFailure {
+		permissions = append(permissions,
+			permission{resource: "pods/log", verbs: []string{"get"}, flag: "--capture-on-failure"},
+			permission{resource: "events", verbs: []string{"list"}, flag: "--capture-on-failure"},
+			permission{resource: "nodes", verbs: []string{"get"}, cluster: true, flag: "--capture-on-failure"})
+	}
+	if followReferences {
+		permissions = append(permissions,
+			permission{resource: "configmaps", verbs: watchVerbs, flag: "--follow-references"},
+			permission{resource: "secrets", verbs: watchVerbs, flag: "--follow-references"})
+	}
+	if trackVolumes {
+		permissions = append(permissions,
+			permission{resource: "persistentvolumeclaims", verbs: watchVerbs, flag: "--track-volumes"},
+			permission{group: "storage.k8s.io", resource: "volumeattachments", verbs: watchVerbs, cluster: true, flag: "--track-volumes"})
+	}
+	if includeNode {
+		permissions = append(permissions, permission{resource: "nodes", verbs: watchVerbs, cluster: true, flag: "--include-node"})
+	}
+	if includeMetrics {
+		permissions = append(permissions, permission{group: "metrics.k8s.io", resource: "pods", verbs: []string{"list"}, flag: "--include-metrics"})
+	}
+	if debugImage != "" {
+		permissions = append(permissions, permission{resource: "pods/ephemeralcontainers", verbs: []string{"update"}, flag: "--inject-debug-container"})
+	}
+	if resolveOwners {
+		permissions = append(permissions,
+			permission{group: "apps", resource: "replicasets", verbs: []string{"get"}, flag: "--resolve-owners"},
+			permission{group: "batch", resource: "jobs", verbs: []string{"get"}, flag: "--resolve-owners"})
+	}
+	if attributeDeletions {
+		permissions = append(permissions,
+			permission{resource: "events", verbs: []string{"list"}, flag: "--attribute-deletions"},
+			permission{resource: "nodes", verbs: []string{"get"}, cluster: true, flag: "--attribute-deletions"},
+			permission{group: "apps", resource: "replicasets", verbs: []string{"get"}, flag: "--attribute-deletions"},
+			permission{group: "apps", resource: "statefulsets", verbs: []string{"get"}, flag: "--attribute-deletions"})
+	}
+	if leaderElect {
+		permissions = append(permissions, permission{group: "coordination.k8s.io", resource: "leases", verbs: []string{"get", "create", "update"},
+			namespace: leaseNamespace, home: leaseNamespace == "", flag: "--leader-elect"})
+	}
+	if checkpointConfigMap != "" {
+		namespace, _, ok := strings.Cut(checkpointConfigMap, "/")
+		if !ok {
+			namespace = ""
+		}
+		permissions = append(permissions, permission{resource: "configmaps", verbs: []string{"get", "create", "update"},
+			namespace: namespace, home: namespace == "", flag: "--checkpoint-configmap"})
+	}
+	return permissions
+}
+
+// watchedNamespaces returns the namespaces of --namespace, or else of the profiles, or nil when watching every namespace
+func watchedNamespaces() []string {
+	if allNamespaces {
+		return nil
+	}
+	if len(namespaces) == 0 {
+		return profileNamespaces()
+	}
+	return namespaces
+}
+
+// checkPermissions verifies with SelfSubjectAccessReviews that the watcher has every access it needs in each cluster,
+// before watching, rather than failing to watch forever. The accesses it lacks are logged, along with the rules of
+// the Roles that would grant them. It only warns when the accesses cannot be reviewed.
+func checkPermissions(clusters []watcher.Cluster) error {
+	var errs error
+	for _, cluster := range clusters {
+		if err := checkClusterPermissions(cluster); err != nil {
+			if cluster.Name != "" {
+				err = fmt.Errorf("context %s: %w", cluster.Name, err)
+			}
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
+
+func checkClusterPermissions(cluster watcher.Cluster) error {
+	clientset, err := kubernetes.NewForConfig(cluster.Config)
+	if err != nil {
+		return fmt.Errorf("could not create Kubernetes client: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), permissionCheckTimeout)
+	defer cancel()
+	watched := watchedNamespaces()
+	if forWorkload != "" && len(watched) == 0 {
+		watched = []string{contextNamespace()}
+	}
+	if len(watched) == 0 {
+		watched = []string{metav1.NamespaceAll}
+	}
+	missing := make(map[string][]rbacv1.PolicyRule) // by namespace, empty for the cluster
+	var namespaces []string
+	for _, p := range requiredPermissions() {
+		if p.flag == "" && resourceArg != "" {
+			gvr, namespaced, err := watcher.ResolveResource(clientset.Discovery(), resourceArg)
+			if err != nil {
+				return err
+			}
+			p.group, p.resource, p.cluster = gvr.Group, gvr.Resource, !namespaced
+		}
+		checked := watched
+		switch {
+		case p.cluster:
+			checked = []string{metav1.NamespaceAll}
+		case p.home:
+			checked = []string{contextNamespace()}
+		case p.namespace != "":
+			checked = []string{p.namespace}
+		}
+		resource, subresource, _ := strings.Cut(p.resource, "/")
+		for _, namespace := range checked {
+			for _, verb := range p.verbs {
+				review := &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace: namespace, Verb: verb, Group: p.group, Resource: resource, Subresource: subresource,
+					},
+				}}
+				review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+				if err != nil {
+					slog.Warn("Could not check the permissions of the watcher, watching anyway", "cluster", cluster.Name, "error", err)
+					return nil
+				}
+				if review.Status.Allowed {
+					continue
+				}
+				where := "in namespace " + namespace
+				if namespace == metav1.NamespaceAll {
+					where = "cluster-wide"
+				}
+				args := []any{"verb", verb, "resource", schema.GroupResource{Group: p.group, Resource: p.resource}.String(), "scope", where}
+				if p.flag != "" {
+					args = append(args, "neededBy", p.flag)
+				}
+				if cluster.Name != "" {
+					args = append(args, "cluster", cluster.Name)
+				}
+				slog.Error("Missing permission", args...)
+				if _, ok := missing[namespace]; !ok {
+					namespaces = append(namespaces, namespace)
+				}
+				missing[namespace] = appendRule(missing[namespace],
+					rbacv1.PolicyRule{APIGroups: []string{p.group}, Resources: []string{p.resource}, Verbs: []string{verb}})
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	for _, namespace := range namespaces {
+		data, err := yaml.Marshal(map[string]any{"rules": missing[namespace]})
+		if err != nil {
+			return err
+		}
+		kind := "Role in namespace " + namespace
+		if namespace == metav1.NamespaceAll {
+			kind = "ClusterRole"
+		}
+		fmt.Fprintf(os.Stderr, "# Rules of a %s bound to the watcher granting the missing permissions:\n%s", kind, data)
+	}
+	return fmt.Errorf("the watcher lacks the permissions it needs (see the rules above, or deploy it with those of `pod-watcher manifests`; --skip-permission-check watches anyway)")
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+var (
+	benchTarget       string
+	benchRate         int
+	benchDuration     time.Duration
+	benchPods         int
+	benchUpdates      int
+	benchMatchRatio   float64
+	benchReportFormat string
+)
+
+// benchFlags are the flags of the watch that the bench command shares, those selecting the cluster and configuring
+// the pipeline under load
+var benchFlags = []string{
+	"kubeconfig", "context",
+	"marker", "marker-regex", "marker-path", "marker-all", "filter-cel", "filter-plugin", "phase", "condition", "namespace", "label-selector",
+	"exclude-marker", "exclude-label-selector", "watch-label", "watch-annotation", "event-types",
+	"strip", "redact", "redact-env", "redact-annotations", "redact-path", "include-managed-fields-summary",
+	"dedupe", "on-image-change", "spec-changes-only", "status-changes-only", "track-containers", "track-init", "track-images", "security-watch", "timeline",
+	"restart-threshold", "flap-threshold", "flap-window", "track-termination",
+	"workers", "queue-size", "max-tracked-pods", "exec", "exec-concurrency", "exec-timeout",
+}
+
+// benchCmd measures the throughput and latency of the pipeline under a synthetic load
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure the throughput, allocations and latency of the filters and sinks under a synthetic load",
+	Long: `bench generates pod changes at a steady rate, in an in-memory cluster or through a disposable API server such
+as that of a local kind cluster, drives them through the same filters, transformations and sinks as a watch, and reports the
+throughput, the allocations per change, and the latency of each stage of the pipeline, so that regressions are
+measurable. Each pod is created, modified --updates times, then deleted and replaced, over a pool of --pods pods;
+--match-ratio of them carry the marker ` + watcher.FakeMarker + `, the default marker of the bench. The stdout output is
+serialized, then discarded, so that the report can be read.
+
+Examples:
+  pod-watcher bench --rate 1000 --duration 30s
+  pod-watcher bench --rate 500 --filter-cel "pod.status.phase == 'Pending'" --redact --output json
+  pod-watcher bench --target kubeconfig --context kind-bench --rate 200 --webhook-url http://localhost:8080/pods
+`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runBench(cmd)
+	},
+}
+
+func init() {
+	benchCmd.Flags().StringVar(&benchTarget, "target", "fake", "Where the pods are generated: fake, an in-memory cluster, or kubeconfig, the API server of the current context or --context, such as that of a local kind cluster")
+	benchCmd.Flags().IntVar(&benchRate, "rate", watcher.DefaultBenchRate, "Pod changes generated per second")
+	benchCmd.Flags().DurationVar(&benchDuration, "duration", watcher.DefaultBenchDuration, "How long to generate the changes")
+	benchCmd.Flags().IntVar(&benchPods, "pods", watcher.DefaultBenchPods, "Number of pods the changes are spread over at any time")
+	benchCmd.Flags().IntVar(&benchUpdates, "updates", watcher.DefaultBenchUpdates, "Number of modifications of each pod between its creation and its deletion")
+	benchCmd.Flags().Float64Var(&benchMatchRatio, "match-ratio", 1, "Share of the pods carrying the marker, from 0 to 1")
+	benchCmd.Flags().StringVar(&benchReportFormat, "report-format", "text", "Format of the report: text or json")
+	for _, name := range benchFlags {
+		benchCmd.Flags().AddFlag(rootCmd.Flags().Lookup(name))
+	}
+	addSinkFlags(benchCmd)
+	addConnectionFlags(benchCmd)
+	registerCompletions(benchCmd)
+	_ = benchCmd.RegisterFlagCompletionFunc("target", cobra.FixedCompletions([]string{"fake", "kubeconfig"}, cobra.ShellCompDirectiveNoFileComp))
+	_ = benchCmd.RegisterFlagCompletionFunc("report-format", cobra.FixedCompletions([]string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp))
+	rootCmd.AddCommand(benchCmd)
+}
+
+// runBench generates the load, then prints the report
+func runBench(cmd *cobra.Command) error {
+	if benchReportFormat != "text" && benchReportFormat != "json" {
+		return fmt.Errorf("unsupported report format %q (must be text or json)", benchReportFormat)
+	}
+	bench := watcher.BenchOptions{
+		Namespace:  metav1.NamespaceDefault,
+		Rate:       benchRate,
+		Duration:   benchDuration,
+		Pods:       benchPods,
+		Updates:    benchUpdates,
+		MatchRatio: benchMatchRatio,
+	}
+	// The pods are generated in, and only watched in, the first namespace
+	if len(namespaces) > 0 {
+		bench.Namespace = namespaces[0]
+	}
+	namespaces = []string{bench.Namespace}
+	switch benchTarget {
+	case "fake":
+		bench.Cluster, _ = watcher.NewFakeCluster("")
+	case "kubeconfig":
+		clusters, err := clusterConfigs()
+		if err != nil {
+			return fmt.Errorf("could not load Kubernetes config: %w", err)
+		}
+		bench.Cluster = clusters[0]
+	default:
+		return fmt.Errorf("unsupported bench target %q (must be fake or kubeconfig)", benchTarget)
+	}
+	if len(markers) == 0 && len(markerRegexes) == 0 && len(markerPaths) == 0 {
+		markers = []string{watcher.FakeMarker}
+	}
+	eventOutput = io.Discard
+	options, err := watcherOptions(time.Now())
+	if err != nil {
+		return err
+	}
+	result, err := watcher.Bench(cmd.Context(), bench, options...)
+	if err != nil {
+		return err
+	}
+	if benchReportFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	return writeBenchReport(os.Stdout, result)
+}
+
+// writeBenchReport writes the throughput and allocations of the run, then the latencies of its stages as a table
+func writeBenchReport(out io.Writer, result *watcher.BenchResult) error {
+	seconds := (result.Duration + result.CatchUp).Seconds()
+	perChange := func(total uint64) uint64 {
+		if result.Received == 0 {
+			return 0
+		}
+		return total / uint64(result.Received)
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Generated\t%d changes in %s (%.1f/s), %d failed\n", result.Generated, result.Duration.Round(time.Millisecond),
+		float64(result.Generated)/result.Duration.Seconds(), result.Failed)
+	fmt.Fprintf(tw, "Received\t%d changes (%.1f/s), caught up %s later\n", result.Received, float64(result.Received)/seconds, result.CatchUp.Round(time.Millisecond))
+	fmt.Fprintf(tw, "Emitted\t%d events (%.1f/s)\n", result.Emitted, float64(result.Emitted)/seconds)
+	fmt.Fprintf(tw, "Allocated\t%s (%s/change), %d allocations (%d/change)\n", byteSize(result.AllocBytes), byteSize(perChange(result.AllocBytes)), result.Allocs, perChange(result.Allocs))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+	tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STAGE\tCOUNT\tMEAN\tP50\tP90\tP99\tMAX")
+	for _, stage := range result.Stages {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", stage.Name, stage.Count, roundLatency(stage.Mean), roundLatency(stage.P50),
+			roundLatency(stage.P90), roundLatency(stage.P99), roundLatency(stage.Max))
+	}
+	return tw.Flush()
+}
+
+// roundLatency keeps three significant digits or so of a latency, e.g. 1.23ms or 45.6µs
+func roundLatency(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	case d >= time.Microsecond:
+		return d.Round(10 * time.Nanosecond)
+	default:
+		return d
+	}
+}
+
+// byteSize formats a number of bytes in binary units, e.g. 63.2KiB
+func byteSize(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	value, suffix := float64(n)/unit, "KiB"
+	for _, next := range []string{"MiB", "GiB", "TiB"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f%s", value, suffix)
+}
@@ -0,0 +1,25 @@
+// Package podwatcherv1 is the gRPC API of pod-watcher serve, generated from podwatcher.proto by buf generate in pkg/api.
+// A client subscribes to the changes to the pods matching a FilterSpec:
+//
+//	conn, err := grpc.NewClient("pod-watcher:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	if err != nil {
+//		return err
+//	}
+//	defer conn.Close()
+//	stream, err := podwatcherv1.NewPodWatcherClient(conn).WatchPods(ctx, &podwatcherv1.FilterSpec{
+//		Namespaces: []string{"team-a"},
+//		Markers:    []string{"DEBUG_MODE"},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	for {
+//		event, err := stream.Recv()
+//		if err != nil {
+//			return err
+//		}
+//		log.Printf("%s %s", event.Type, event.Key)
+//	}
+package podwatcherv1
+
+//go:generate sh -c "cd ../.. && buf generate"
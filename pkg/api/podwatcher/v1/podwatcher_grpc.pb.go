@@ -0,0 +1,134 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: podwatcher/v1/podwatcher.proto
+
+// The API of pod-watcher serve, streaming the changes to the pods matching a filter
+
+package podwatcherv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PodWatcher_WatchPods_FullMethodName = "/podwatcher.v1.PodWatcher/WatchPods"
+)
+
+// PodWatcherClient is the client API for PodWatcher service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PodWatcher streams filtered pod changes from the clusters the server watches
+type PodWatcherClient interface {
+	// WatchPods streams the events of the pods matching the filter until the client cancels the call.
+	// Only changes made after the call started are streamed, as with the pod-watcher command.
+	WatchPods(ctx context.Context, in *FilterSpec, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PodEvent], error)
+}
+
+type podWatcherClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPodWatcherClient(cc grpc.ClientConnInterface) PodWatcherClient {
+	return &podWatcherClient{cc}
+}
+
+func (c *podWatcherClient) WatchPods(ctx context.Context, in *FilterSpec, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PodEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PodWatcher_ServiceDesc.Streams[0], PodWatcher_WatchPods_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[FilterSpec, PodEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PodWatcher_WatchPodsClient = grpc.ServerStreamingClient[PodEvent]
+
+// PodWatcherServer is the server API for PodWatcher service.
+// All implementations must embed UnimplementedPodWatcherServer
+// for forward compatibility.
+//
+// PodWatcher streams filtered pod changes from the clusters the server watches
+type PodWatcherServer interface {
+	// WatchPods streams the events of the pods matching the filter until the client cancels the call.
+	// Only changes made after the call started are streamed, as with the pod-watcher command.
+	WatchPods(*FilterSpec, grpc.ServerStreamingServer[PodEvent]) error
+	mustEmbedUnimplementedPodWatcherServer()
+}
+
+// UnimplementedPodWatcherServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPodWatcherServer struct{}
+
+func (UnimplementedPodWatcherServer) WatchPods(*FilterSpec, grpc.ServerStreamingServer[PodEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchPods not implemented")
+}
+func (UnimplementedPodWatcherServer) mustEmbedUnimplementedPodWatcherServer() {}
+func (UnimplementedPodWatcherServer) testEmbeddedByValue()                    {}
+
+// UnsafePodWatcherServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PodWatcherServer will
+// result in compilation errors.
+type UnsafePodWatcherServer interface {
+	mustEmbedUnimplementedPodWatcherServer()
+}
+
+func RegisterPodWatcherServer(s grpc.ServiceRegistrar, srv PodWatcherServer) {
+	// If the following call pancis, it indicates UnimplementedPodWatcherServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PodWatcher_ServiceDesc, srv)
+}
+
+func _PodWatcher_WatchPods_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FilterSpec)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PodWatcherServer).WatchPods(m, &grpc.GenericServerStream[FilterSpec, PodEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PodWatcher_WatchPodsServer = grpc.ServerStreamingServer[PodEvent]
+
+// PodWatcher_ServiceDesc is the grpc.ServiceDesc for PodWatcher service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PodWatcher_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "podwatcher.v1.PodWatcher",
+	HandlerType: (*PodWatcherServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchPods",
+			Handler:       _PodWatcher_WatchPods_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "podwatcher/v1/podwatcher.proto",
+}
@@ -0,0 +1,148 @@
+package watcher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Reasons of the ALERT events of WithAlerts
+const (
+	AlertRestartLoop = "RestartLoop"       // the containers of the pod restarted too often within the window
+	AlertFlapping    = "ReadinessFlapping" // the pod stopped being ready too often within the window
+)
+
+// DefaultFlapWindow is the sliding window of the alert thresholds unless AlertOptions gives one
+const DefaultFlapWindow = 10 * time.Minute
+
+// AlertOptions configures the detection of degraded pods of WithAlerts; a zero threshold disables its alert
+type AlertOptions struct {
+	RestartThreshold int           // alert once the containers of a pod restarted this many times within the window
+	FlapThreshold    int           // alert once a pod stopped being ready this many times within the window
+	Window           time.Duration // the sliding window of the thresholds; DefaultFlapWindow if zero
+}
+
+// Alert is the detection of a degraded pod, carried by ALERT events
+type Alert struct {
+	Reason  string `json:"reason"` // AlertRestartLoop or AlertFlapping
+	Count   int    `json:"count"`  // restarts or readiness losses within the window
+	Window  string `json:"window"`
+	Message string `json:"message"`
+}
+
+// String formats the alert compactly, e.g. "RestartLoop: 5 restarts within 10m0s"
+func (a *Alert) String() string {
+	return a.Reason + ": " + a.Message
+}
+
+// podHealthHistory is the recent history of one pod
+type podHealthHistory struct {
+	restartCount int32       // total of the restart counts of its containers in the last revision
+	ready        bool        // whether it was ready in the last revision
+	restarts     []time.Time // within the window
+	unready      []time.Time // the times it stopped being ready, within the window
+}
+
+// alertDetector tracks the restarts and readiness of the matched pods over a sliding window (--restart-threshold, --flap-threshold)
+type alertDetector struct {
+	options AlertOptions
+	mu      sync.Mutex
+	pods    map[string]*podHealthHistory // pod key, qualified by its cluster -> its history
+}
+
+func newAlertDetector(options AlertOptions) *alertDetector {
+	if options.Window <= 0 {
+		options.Window = DefaultFlapWindow
+	}
+	return &alertDetector{options: options, pods: make(map[string]*podHealthHistory)}
+}
+
+// update records a revision of the pod observed at the given time, returning the alerts whose threshold it reached.
+// A pod from the initial list only sets the baseline, and a deleted one is forgotten. Once an alert has been raised
+// its history is cleared, so that it is raised again only if the threshold is reached anew.
+func (d *alertDetector) update(eventType string, key string, pod *corev1.Pod, observed time.Time, baseline bool) []*Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if eventType == string(watch.Deleted) {
+		delete(d.pods, key)
+		return nil
+	}
+	var restartCount int32
+	for _, status := range pod.Status.InitContainerStatuses {
+		restartCount += status.RestartCount
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		restartCount += status.RestartCount
+	}
+	ready := podReady(pod)
+	history, seen := d.pods[key]
+	if !seen || baseline {
+		d.pods[key] = &podHealthHistory{restartCount: restartCount, ready: ready}
+		return nil
+	}
+	for i := history.restartCount; i < restartCount; i++ {
+		history.restarts = append(history.restarts, observed)
+	}
+	if history.ready && !ready {
+		history.unready = append(history.unready, observed)
+	}
+	history.restartCount, history.ready = restartCount, ready
+	since := observed.Add(-d.options.Window)
+	history.restarts, history.unready = within(history.restarts, since), within(history.unready, since)
+
+	var alerts []*Alert
+	if threshold := d.options.RestartThreshold; threshold > 0 && len(history.restarts) >= threshold {
+		alerts = append(alerts, &Alert{Reason: AlertRestartLoop, Count: len(history.restarts), Window: d.options.Window.String(),
+			Message: fmt.Sprintf("%d restarts within %s", len(history.restarts), d.options.Window)})
+		history.restarts = nil
+	}
+	if threshold := d.options.FlapThreshold; threshold > 0 && len(history.unready) >= threshold {
+		alerts = append(alerts, &Alert{Reason: AlertFlapping, Count: len(history.unready), Window: d.options.Window.String(),
+			Message: fmt.Sprintf("stopped being ready %d times within %s", len(history.unready), d.options.Window)})
+		history.unready = nil
+	}
+	return alerts
+}
+
+// evict forgets the pod and its history
+func (d *alertDetector) evict(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pods, key)
+}
+
+// podReady reports whether the Ready condition of the pod is true
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// within drops the times before since from the ordered times
+func within(times []time.Time, since time.Time) []time.Time {
+	for len(times) > 0 && times[0].Before(since) {
+		times = times[1:]
+	}
+	return times
+}
+
+// emitAlerts emits an ALERT event for each alert raised for the pod, unless their type is filtered out
+func (p *eventProcessor) emitAlerts(m *matchedObject, pod *corev1.Pod, alerts []*Alert) {
+	for _, alert := range alerts {
+		alertsRaised.WithLabelValues(alert.Reason).Inc()
+		if p.eventTypes != nil && !p.eventTypes[AlertEvent] {
+			continue
+		}
+		event := Event{Type: AlertEvent, Key: m.key, Object: pod, Timestamp: time.Now().UTC(), Cluster: m.cluster, Alert: alert}
+		p.sinks.write(event)
+		p.publish(event)
+		eventsEmitted.WithLabelValues(AlertEvent).Inc()
+		p.health.eventEmitted()
+	}
+}
@@ -0,0 +1,417 @@
+package watcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/oauth2/google"
+)
+
+// Chunking of the archive unless ArchiveOptions gives another
+const (
+	DefaultArchiveChunkSize     = 64 << 20 // bytes of events, before compression
+	DefaultArchiveChunkInterval = 5 * time.Minute
+)
+
+// archiveBuffer is the number of chunks kept while the object store is failing, beyond which the oldest are dropped
+const archiveBuffer = 10
+
+// archiveManifest is the name of the object listing the chunks of a run, next to them
+const archiveManifest = "manifest.json"
+
+// ArchiveOptions configures the object storage archive sink
+type ArchiveOptions struct {
+	URL           string        // s3://bucket/prefix or gs://bucket/prefix
+	Format        string        // of the chunks, as for WithOutputFile; jsonl if empty
+	ChunkSize     int64         // bytes of events, before compression, closing a chunk; DefaultArchiveChunkSize if zero
+	ChunkInterval time.Duration // maximum time a chunk stays open; DefaultArchiveChunkInterval if zero
+	// Encryption is the server-side encryption of the S3 objects: AES256, aws:kms or aws:kms:dsse;
+	// empty for the default encryption of the bucket. GCS always encrypts the objects.
+	Encryption string
+	KMSKey     string // the KMS key of S3 aws:kms encryption, or the Cloud KMS key name encrypting the GCS objects
+	Region     string // of the S3 bucket; from the AWS configuration if empty
+	Endpoint   string // of an S3-compatible store, e.g. MinIO, addressed path-style
+}
+
+// ArchiveManifest lists the chunks of a run of the archive sink, uploaded next to them as manifest.json
+// and updated after each chunk
+type ArchiveManifest struct {
+	Started time.Time      `json:"started"`
+	Updated time.Time      `json:"updated"`
+	Format  string         `json:"format"`
+	Chunks  []ArchiveChunk `json:"chunks"`
+	// Pods maps each object, as cluster/namespace/name or namespace/name, to the chunks holding its events, in order
+	Pods map[string][]string `json:"pods"`
+}
+
+// ArchiveChunk is an uploaded chunk of the archive
+type ArchiveChunk struct {
+	Name   string    `json:"name"` // of the object, relative to the manifest
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	Events int       `json:"events"`
+	Bytes  int       `json:"bytes"` // compressed
+}
+
+// objectStore uploads the objects of the archive
+type objectStore interface {
+	put(ctx context.Context, key, contentType string, body []byte) error
+	String() string
+}
+
+// archiveChunk is a chunk of the archive being written or waiting for its upload
+type archiveChunk struct {
+	ArchiveChunk
+	buf    bytes.Buffer
+	gz     *gzip.Writer
+	writer *eventWriter
+	size   int64           // bytes of events written, before compression
+	pods   map[string]bool // the objects with events in the chunk
+}
+
+// archiveSink is the Sink writing the events to gzip-compressed chunks uploaded to object storage, under a prefix of
+// their own for each run, along with a manifest listing the chunks and the objects whose events each holds.
+// A chunk is closed once it holds ChunkSize bytes of events or has been open for ChunkInterval; one that could not
+// be uploaded is retried at the next interval.
+type archiveSink struct {
+	ctx      context.Context // aborts the uploads in progress once canceled
+	store    objectStore
+	prefix   string // of the objects of the run, ending in a slash
+	format   string
+	size     int64
+	manifest ArchiveManifest
+
+	mu      sync.Mutex
+	chunk   *archiveChunk   // the chunk being written; nil until the next event
+	pending []*archiveChunk // the closed chunks waiting for their upload, in order
+	seq     int             // of the last chunk
+
+	uploading sync.Mutex // serializes the uploads, keeping the chunks and the manifest in order
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// NewArchiveSink returns a sink uploading the events to S3 or GCS in compressed chunks
+func NewArchiveSink(options ArchiveOptions) (Sink, error) {
+	u, err := url.Parse(options.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid archive URL %q (must be s3://BUCKET[/PREFIX] or gs://BUCKET[/PREFIX])", options.URL)
+	}
+	if options.Format == "" {
+		options.Format = OutputJSONL
+	}
+	if _, err := newEventWriter(nil, options.Format); err != nil {
+		return nil, err
+	}
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = DefaultArchiveChunkSize
+	}
+	if options.ChunkInterval <= 0 {
+		options.ChunkInterval = DefaultArchiveChunkInterval
+	}
+	var store objectStore
+	switch u.Scheme {
+	case "s3":
+		if store, err = newS3Store(u.Host, options); err != nil {
+			return nil, err
+		}
+	case "gs":
+		if options.Encryption != "" {
+			return nil, fmt.Errorf("--archive-encryption is only supported by S3; GCS objects are encrypted with --archive-kms-key or the bucket's key")
+		}
+		if store, err = newGCSStore(u.Host, options.KMSKey); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported archive URL %q (must be s3://BUCKET[/PREFIX] or gs://BUCKET[/PREFIX])", options.URL)
+	}
+	started := time.Now().UTC()
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	s := &archiveSink{
+		ctx:      context.Background(),
+		store:    store,
+		prefix:   prefix + started.Format("20060102T150405Z") + "/",
+		format:   options.Format,
+		size:     options.ChunkSize,
+		manifest: ArchiveManifest{Started: started, Format: options.Format, Chunks: []ArchiveChunk{}, Pods: make(map[string][]string)},
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.run(options.ChunkInterval)
+	return s, nil
+}
+
+// bind aborts the uploads once the context is canceled
+func (s *archiveSink) bind(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// String names the sink for logs and metrics
+func (s *archiveSink) String() string {
+	return "archive:" + s.store.String() + "/" + s.prefix
+}
+
+// run closes the open chunk and uploads the pending ones at every interval until the sink is closed
+func (s *archiveSink) run(interval time.Duration) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.closeChunk()
+			s.mu.Unlock()
+			_ = s.upload()
+		}
+	}
+}
+
+// Write adds the event to the open chunk, uploading the chunk once it is full
+func (s *archiveSink) Write(event Event) error {
+	s.mu.Lock()
+	if s.chunk == nil {
+		s.seq++
+		chunk := &archiveChunk{pods: make(map[string]bool)}
+		chunk.Name = fmt.Sprintf("chunk-%06d%s.gz", s.seq, formatExtension(s.format))
+		chunk.gz = gzip.NewWriter(&chunk.buf)
+		chunk.writer, _ = newEventWriter(countingWriter{w: chunk.gz, n: &chunk.size}, s.format) // validated by NewArchiveSink
+		s.chunk = chunk
+	}
+	chunk := s.chunk
+	if err := chunk.writer.Write(event); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if chunk.Events == 0 {
+		chunk.First = event.Timestamp
+	}
+	chunk.Last = event.Timestamp
+	chunk.Events++
+	cluster, namespace, name := eventSubject(event)
+	chunk.pods[clusterKey(cluster, namespace+"/"+name)] = true
+	full := chunk.size >= s.size
+	if full {
+		s.closeChunk()
+	}
+	s.mu.Unlock()
+	if full {
+		_ = s.upload() // a failed chunk is kept rather than lost
+	}
+	return nil
+}
+
+// closeChunk closes the open chunk, if any, queueing it for upload and dropping the oldest chunks beyond the buffer;
+// s.mu must be held
+func (s *archiveSink) closeChunk() {
+	if s.chunk == nil {
+		return
+	}
+	if err := s.chunk.gz.Close(); err != nil {
+		slog.Error("Failed to compress archive chunk", "sink", s.String(), "chunk", s.chunk.Name, "error", err)
+	}
+	s.chunk.Bytes = s.chunk.buf.Len()
+	s.pending = append(s.pending, s.chunk)
+	s.chunk = nil
+	if over := len(s.pending) - archiveBuffer; over > 0 {
+		for _, dropped := range s.pending[:over] {
+			archiveFailures.Add(float64(dropped.Events))
+		}
+		s.pending = s.pending[over:]
+		slog.Warn("Archive buffer is full, dropping the oldest chunks", "sink", s.String(), "dropped", over)
+	}
+}
+
+// upload uploads the pending chunks in order, updating the manifest after each, and stopping at the first that fails,
+// which is kept for the next attempt
+func (s *archiveSink) upload() error {
+	s.uploading.Lock()
+	defer s.uploading.Unlock()
+	for {
+		s.mu.Lock()
+		if len(s.pending) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		chunk := s.pending[0]
+		s.mu.Unlock()
+
+		err := s.store.put(s.ctx, s.prefix+chunk.Name, "application/gzip", chunk.buf.Bytes())
+		if err == nil {
+			err = s.putManifest(chunk)
+		}
+		if err != nil {
+			slog.Error("Failed to upload archive chunk, keeping it for the next attempt", "sink", s.String(), "chunk", chunk.Name, "error", err)
+			return err
+		}
+		s.mu.Lock()
+		if len(s.pending) > 0 && s.pending[0] == chunk { // unless dropped meanwhile
+			s.pending = s.pending[1:]
+		}
+		s.mu.Unlock()
+	}
+}
+
+// putManifest uploads the manifest with the chunk added; only upload changes the manifest
+func (s *archiveSink) putManifest(chunk *archiveChunk) error {
+	manifest := s.manifest
+	manifest.Updated = time.Now().UTC()
+	manifest.Chunks = append(manifest.Chunks[:len(manifest.Chunks):len(manifest.Chunks)], chunk.ArchiveChunk)
+	manifest.Pods = make(map[string][]string, len(s.manifest.Pods)+len(chunk.pods))
+	for pod, chunks := range s.manifest.Pods {
+		manifest.Pods[pod] = chunks
+	}
+	pods := make([]string, 0, len(chunk.pods))
+	for pod := range chunk.pods {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+	for _, pod := range pods {
+		manifest.Pods[pod] = append(manifest.Pods[pod][:len(manifest.Pods[pod]):len(manifest.Pods[pod])], chunk.Name)
+	}
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal archive manifest: %w", err)
+	}
+	if err := s.store.put(s.ctx, s.prefix+archiveManifest, "application/json", body); err != nil {
+		return fmt.Errorf("could not upload archive manifest: %w", err)
+	}
+	s.manifest = manifest
+	return nil
+}
+
+// Close uploads the open and pending chunks, counting the events of those that could not be uploaded as failed
+func (s *archiveSink) Close() error {
+	close(s.stop)
+	<-s.stopped
+	s.mu.Lock()
+	s.closeChunk()
+	s.mu.Unlock()
+	err := s.upload()
+	if err != nil {
+		s.mu.Lock()
+		for _, chunk := range s.pending {
+			archiveFailures.Add(float64(chunk.Events))
+		}
+		s.pending = nil
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// s3Store uploads the objects to an S3 bucket, with the credentials of the default AWS configuration
+type s3Store struct {
+	client     *s3.Client
+	bucket     string
+	encryption string
+	kmsKey     string
+}
+
+func newS3Store(bucket string, options ArchiveOptions) (*s3Store, error) {
+	switch options.Encryption {
+	case "", string(s3types.ServerSideEncryptionAes256), string(s3types.ServerSideEncryptionAwsKms), string(s3types.ServerSideEncryptionAwsKmsDsse):
+	default:
+		return nil, fmt.Errorf("invalid --archive-encryption %q (must be AES256, aws:kms, or aws:kms:dsse)", options.Encryption)
+	}
+	if options.KMSKey != "" && !strings.HasPrefix(options.Encryption, "aws:kms") {
+		return nil, fmt.Errorf("--archive-kms-key requires --archive-encryption aws:kms or aws:kms:dsse for S3")
+	}
+	var loadOptions []func(*awsconfig.LoadOptions) error
+	if options.Region != "" {
+		loadOptions = append(loadOptions, awsconfig.WithRegion(options.Region))
+	}
+	config, err := awsconfig.LoadDefaultConfig(context.Background(), loadOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the AWS configuration: %w", err)
+	}
+	client := s3.NewFromConfig(config, func(o *s3.Options) {
+		if options.Endpoint != "" {
+			o.BaseEndpoint = aws.String(options.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Store{client: client, bucket: bucket, encryption: options.Encryption, kmsKey: options.KMSKey}, nil
+}
+
+func (s *s3Store) put(ctx context.Context, key, contentType string, body []byte) error {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	}
+	if s.encryption != "" {
+		input.ServerSideEncryption = s3types.ServerSideEncryption(s.encryption)
+	}
+	if s.kmsKey != "" {
+		input.SSEKMSKeyId = aws.String(s.kmsKey)
+	}
+	_, err := s.client.PutObject(ctx, input)
+	return err
+}
+
+func (s *s3Store) String() string {
+	return "s3://" + s.bucket
+}
+
+// gcsStore uploads the objects to a GCS bucket through its JSON API, with the application default credentials
+type gcsStore struct {
+	client *http.Client
+	bucket string
+	kmsKey string
+}
+
+func newGCSStore(bucket, kmsKey string) (*gcsStore, error) {
+	client, err := google.DefaultClient(context.Background(), "https://www.googleapis.com/auth/devstorage.read_write")
+	if err != nil {
+		return nil, fmt.Errorf("could not find the Google Cloud credentials: %w", err)
+	}
+	return &gcsStore{client: client, bucket: bucket, kmsKey: kmsKey}, nil
+}
+
+func (s *gcsStore) put(ctx context.Context, key, contentType string, body []byte) error {
+	query := url.Values{"uploadType": {"media"}, "name": {key}}
+	if s.kmsKey != "" {
+		query.Set("kmsKeyName", s.kmsKey)
+	}
+	endpoint := "https://storage.googleapis.com/upload/storage/v1/b/" + url.PathEscape(s.bucket) + "/o?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("GCS returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (s *gcsStore) String() string {
+	return "gs://" + s.bucket
+}
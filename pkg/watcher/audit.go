@@ -0,0 +1,173 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// maxFieldDepth is the depth down to which the fields of a manager are summarized, e.g. spec.containers[name=app].image
+const maxFieldDepth = 4
+
+// Change is the write of a field manager to an object, as recorded by its managed fields, carried by MODIFIED events
+// with WithManagedFieldsSummary
+type Change struct {
+	Manager     string    `json:"manager"`               // e.g. kubectl-edit, kube-controller-manager or kubelet
+	Operation   string    `json:"operation"`             // Apply or Update
+	Subresource string    `json:"subresource,omitempty"` // e.g. status
+	Time        time.Time `json:"time"`
+	Fields      []string  `json:"fields"` // the fields the manager owns, summarized, e.g. metadata.labels.app
+}
+
+// String formats the change compactly, e.g. "kubectl-edit (Update) at 2024-06-01T14:03:00Z, fields: metadata.labels.app"
+func (c *Change) String() string {
+	manager := c.Manager
+	if c.Subresource != "" {
+		manager += " (" + c.Operation + " " + c.Subresource + ")"
+	} else {
+		manager += " (" + c.Operation + ")"
+	}
+	return fmt.Sprintf("%s at %s, fields: %s", manager, c.Time.Format(time.RFC3339), strings.Join(c.Fields, ", "))
+}
+
+// managedFieldsTracker compares the managed fields of each revision of an object with those of the previous one,
+// to attribute its modifications to the field managers that wrote it since (--include-managed-fields-summary)
+type managedFieldsTracker struct {
+	mu      sync.Mutex
+	entries map[string]map[string]time.Time // object key, qualified by its cluster -> manager entry -> time of its last write
+}
+
+func newManagedFieldsTracker() *managedFieldsTracker {
+	return &managedFieldsTracker{entries: make(map[string]map[string]time.Time)}
+}
+
+// evict forgets the object
+func (t *managedFieldsTracker) evict(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+// update records the managed fields of a revision of the object, returning the writes since the previous revision
+// in the order they happened. Without a previous revision, the latest write is returned.
+// A deleted object, or one that stopped matching, is forgotten.
+func (t *managedFieldsTracker) update(eventType string, key string, obj runtime.Object, matched bool) []*Change {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if eventType == string(watch.Deleted) || !matched {
+		delete(t.entries, key)
+		return nil
+	}
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return nil
+	}
+	previous, seen := t.entries[key]
+	current := make(map[string]time.Time)
+	var changes []*Change
+	var latest *Change
+	for _, entry := range objMeta.GetManagedFields() {
+		if entry.Time == nil {
+			continue
+		}
+		id := entry.Manager + "/" + string(entry.Operation) + "/" + entry.Subresource
+		current[id] = entry.Time.Time
+		if seen && previous[id].Equal(entry.Time.Time) {
+			continue
+		}
+		change := &Change{Manager: entry.Manager, Operation: string(entry.Operation), Subresource: entry.Subresource,
+			Time: entry.Time.UTC(), Fields: summarizeFields(entry.FieldsV1)}
+		changes = append(changes, change)
+		if latest == nil || change.Time.After(latest.Time) {
+			latest = change
+		}
+	}
+	t.entries[key] = current
+	if !seen {
+		if latest == nil {
+			return nil
+		}
+		return []*Change{latest}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Time.Before(changes[j].Time) })
+	return changes
+}
+
+// summarizeFields lists the fields of a managed fields entry down to maxFieldDepth, in order.
+// Fields are given as paths, with list items selected by key, e.g. spec.containers[name=app].image.
+func summarizeFields(fields *metav1.FieldsV1) []string {
+	if fields == nil {
+		return nil
+	}
+	var set map[string]interface{}
+	if err := json.Unmarshal(fields.Raw, &set); err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var paths []string
+	var walk func(prefix string, depth int, set map[string]interface{})
+	walk = func(prefix string, depth int, set map[string]interface{}) {
+		children := 0
+		for name, child := range set {
+			if name == "." {
+				continue
+			}
+			children++
+			path := prefix + fieldSegment(prefix, name)
+			childSet, _ := child.(map[string]interface{})
+			if depth+1 < maxFieldDepth && len(childSet) > 0 {
+				walk(path, depth+1, childSet)
+				continue
+			}
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+		if children == 0 && prefix != "" && !seen[prefix] {
+			seen[prefix] = true
+			paths = append(paths, prefix)
+		}
+	}
+	walk("", 0, set)
+	sort.Strings(paths)
+	return paths
+}
+
+// fieldSegment formats one element of a managed fields set as a path segment:
+// "f:name" is the field name, "k:{...}" a list item by its keys, "v:..." a set item by its value, and "i:N" one by index
+func fieldSegment(prefix string, name string) string {
+	kind, value, _ := strings.Cut(name, ":")
+	switch kind {
+	case "f":
+		if prefix == "" {
+			return value
+		}
+		return "." + value
+	case "k":
+		var keys map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &keys); err != nil {
+			return "[" + value + "]"
+		}
+		var selectors []string
+		for k, v := range keys {
+			selectors = append(selectors, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(selectors)
+		return "[" + strings.Join(selectors, ",") + "]"
+	case "v":
+		return "[" + strings.Trim(value, `"`) + "]"
+	case "i":
+		return "[" + value + "]"
+	default:
+		return "." + name
+	}
+}
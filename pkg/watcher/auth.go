@@ -0,0 +1,63 @@
+package watcher
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Results of the credential checks of WithAuthCheckInterval, as counted in pod_watcher_auth_checks_total
+const (
+	authCheckOK           = "ok"           // the credentials were accepted
+	authCheckRefreshed    = "refreshed"    // they were rejected, then accepted once refreshed
+	authCheckUnauthorized = "unauthorized" // they were still rejected once refreshed
+	authCheckError        = "error"        // the API server could not be reached
+)
+
+// WithAuthCheckInterval makes an authenticated request to the API server of every cluster each interval, so that
+// short-lived credentials are refreshed before a watch needs them rather than when it is restarted: an exec credential
+// plugin is run again once its credential expired, and the credential is refreshed at once when it is rejected.
+// The outcomes are logged when the credentials are rejected, and counted in pod_watcher_auth_checks_total.
+func WithAuthCheckInterval(interval time.Duration) Option {
+	return func(w *Watcher) { w.authCheckInterval = interval }
+}
+
+// runAuthChecks checks the credentials of every cluster each interval until the context is canceled
+func (w *Watcher) runAuthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, c := range w.clusters {
+			result := c.checkAuth()
+			authChecks.WithLabelValues(c.name, result).Inc()
+		}
+	}
+}
+
+// checkAuth asks the API server for its version, which requires valid credentials when any are given. client-go
+// refreshes the credentials of a request rejected as unauthorized, so such a request is tried a second time.
+func (c *cluster) checkAuth() string {
+	_, err := c.clientset.Discovery().ServerVersion()
+	if apierrors.IsUnauthorized(err) {
+		if _, err = c.clientset.Discovery().ServerVersion(); err == nil {
+			slog.Info("Refreshed the credentials rejected by the API server", "cluster", c.name)
+			return authCheckRefreshed
+		}
+		if apierrors.IsUnauthorized(err) {
+			slog.Error("The API server rejects the credentials, even refreshed", "cluster", c.name, "error", err)
+			return authCheckUnauthorized
+		}
+	}
+	if err != nil {
+		slog.Warn("Could not check the credentials", "cluster", c.name, "error", err)
+		return authCheckError
+	}
+	return authCheckOK
+}
@@ -0,0 +1,146 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// Sources of the history of BACKFILL events
+const (
+	BackfillSourceStatus = "status" // the timestamps of the status of the pod, such as the start of a container
+	BackfillSourceEvent  = "event"  // a Kubernetes Event about the pod
+)
+
+// Backfill is a past occurrence in the life of a pod, reconstructed at startup by WithBackfill and carried by a
+// BACKFILL event
+type Backfill struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`              // BackfillSourceStatus or BackfillSourceEvent
+	Reason    string    `json:"reason"`              // e.g. Created, Started, Terminated, Ready, or the reason of the Event
+	Container string    `json:"container,omitempty"` // the container it concerns, if any
+	Message   string    `json:"message,omitempty"`
+}
+
+// String formats the occurrence compactly, e.g. "2024-06-01T14:02:11Z app Terminated (exit code 137, OOMKilled)"
+func (b *Backfill) String() string {
+	s := b.Time.Format(time.RFC3339)
+	if b.Source == BackfillSourceEvent {
+		s += " Event"
+	}
+	if b.Container != "" {
+		s += " " + b.Container
+	}
+	s += " " + b.Reason
+	if b.Message != "" {
+		if b.Source == BackfillSourceEvent {
+			s += ": " + b.Message
+		} else {
+			s += " (" + b.Message + ")"
+		}
+	}
+	return s
+}
+
+// WithBackfill reconstructs the history of the matched pods over the given window before the watcher started: once
+// each pod is listed, a BACKFILL event is emitted for every occurrence within the window, oldest first, from the
+// timestamps of its status (its creation, the transitions of its conditions, the starts and terminations of its
+// containers) and from the Kubernetes Events about it, listed at startup. The API server only keeps Events for an
+// hour by default. This requires permission to list Events.
+func WithBackfill(window time.Duration) Option {
+	return func(w *Watcher) { w.backfill = window }
+}
+
+// backfiller holds the Kubernetes Events of the backfill window (--since), listed at startup, until the pods they
+// are about are listed
+type backfiller struct {
+	since  time.Time
+	events map[string][]*Backfill // pod UID, qualified by its cluster -> the Events about it
+}
+
+// newBackfiller lists the Kubernetes Events about pods in the watched namespaces of every cluster that occurred
+// within the window. The history of the pods is backfilled from their status alone when they cannot be listed.
+func newBackfiller(ctx context.Context, clusters []*cluster, window time.Duration) *backfiller {
+	b := &backfiller{since: time.Now().Add(-window), events: make(map[string][]*Backfill)}
+	selector := fields.OneTermEqualSelector("involvedObject.kind", "Pod").String()
+	for _, c := range clusters {
+		for _, namespace := range c.watched {
+			events, err := c.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+			if err != nil {
+				slog.Warn("Could not list the Events to backfill, the history of the pods will lack them", "cluster", c.name, "namespace", namespace, "error", err)
+				continue
+			}
+			for i := range events.Items {
+				event := &events.Items[i]
+				t := eventTime(event)
+				if t.Time.Before(b.since) {
+					continue
+				}
+				message := event.Message
+				if event.Count > 1 {
+					message += fmt.Sprintf(" (x%d)", event.Count)
+				}
+				key := clusterKey(c.name, string(event.InvolvedObject.UID))
+				b.events[key] = append(b.events[key], &Backfill{Time: t.Time.UTC(), Source: BackfillSourceEvent, Reason: event.Reason, Message: message})
+			}
+		}
+	}
+	return b
+}
+
+// history returns the occurrences in the life of the pod of the cluster within the window, oldest first
+func (b *backfiller) history(cluster string, pod *corev1.Pod) []*Backfill {
+	var history []*Backfill
+	add := func(t metav1.Time, reason, container, message string) {
+		if !t.IsZero() && !t.Time.Before(b.since) {
+			history = append(history, &Backfill{Time: t.Time.UTC(), Source: BackfillSourceStatus, Reason: reason, Container: container, Message: message})
+		}
+	}
+	add(pod.CreationTimestamp, "Created", "", "")
+	for _, condition := range pod.Status.Conditions {
+		message := string(condition.Status)
+		if condition.Reason != "" {
+			message += ", " + condition.Reason
+		}
+		add(condition.LastTransitionTime, string(condition.Type), "", message)
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		for _, state := range []corev1.ContainerState{status.LastTerminationState, status.State} {
+			if state.Running != nil {
+				add(state.Running.StartedAt, "Started", status.Name, "")
+			}
+			if terminated := state.Terminated; terminated != nil {
+				add(terminated.StartedAt, "Started", status.Name, "")
+				message := fmt.Sprintf("exit code %d", terminated.ExitCode)
+				if terminated.Reason != "" {
+					message += ", " + terminated.Reason
+				}
+				add(terminated.FinishedAt, "Terminated", status.Name, message)
+			}
+		}
+	}
+	history = append(history, b.events[clusterKey(cluster, string(pod.UID))]...)
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Time.Before(history[j].Time) })
+	return history
+}
+
+// emitBackfill emits a BACKFILL event for each occurrence in the history of the listed pod within the window
+func (p *eventProcessor) emitBackfill(m *matchedObject, pod *corev1.Pod) {
+	if p.eventTypes != nil && !p.eventTypes[BackfillEvent] {
+		return
+	}
+	for _, backfill := range p.backfill.history(m.cluster, pod) {
+		event := Event{Type: BackfillEvent, Key: m.key, Object: pod, Timestamp: backfill.Time, Cluster: m.cluster, Backfill: backfill}
+		p.sinks.write(event)
+		p.publish(event)
+		eventsEmitted.WithLabelValues(BackfillEvent).Inc()
+		p.health.eventEmitted()
+	}
+}
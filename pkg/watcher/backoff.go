@@ -0,0 +1,139 @@
+package watcher
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Retry policy of the failing lists and watches, unless BackoffOptions gives another
+const (
+	DefaultInitialBackoff   = time.Second
+	DefaultMaxBackoff       = 2 * time.Minute
+	DefaultBreakerThreshold = 10
+	DefaultBreakerPause     = 5 * time.Minute
+)
+
+// BackoffOptions configures how the lists and watches of a namespace are retried after they fail, with WithBackoff.
+// The delay before each retry doubles from Initial up to Max, with jitter, and once Threshold consecutive attempts
+// failed the circuit breaker opens: the watcher logs an error and pauses for Pause before trying again.
+type BackoffOptions struct {
+	Initial   time.Duration // delay before the first retry; DefaultInitialBackoff if zero
+	Max       time.Duration // cap of the delay; DefaultMaxBackoff if zero
+	Threshold int           // consecutive failures opening the circuit breaker; DefaultBreakerThreshold if zero, never if negative
+	Pause     time.Duration // how long the open circuit breaker pauses; DefaultBreakerPause if zero
+}
+
+// withDefaults fills in the unset options
+func (o BackoffOptions) withDefaults() BackoffOptions {
+	if o.Initial <= 0 {
+		o.Initial = DefaultInitialBackoff
+	}
+	if o.Max <= 0 {
+		o.Max = DefaultMaxBackoff
+	}
+	if o.Initial > o.Max {
+		o.Initial = o.Max
+	}
+	if o.Threshold == 0 {
+		o.Threshold = DefaultBreakerThreshold
+	}
+	if o.Pause <= 0 {
+		o.Pause = DefaultBreakerPause
+	}
+	return o
+}
+
+// retryBackoff delays the lists and watches of one informer after consecutive failures, on top of the reflector's own
+// backoff, which is capped at 30 seconds, so that a struggling API server is not hammered by every namespace and replica
+type retryBackoff struct {
+	options   BackoffOptions
+	cluster   string
+	namespace string
+
+	mu           sync.Mutex
+	failures     int           // consecutive failed attempts
+	delay        time.Duration // before the next attempt
+	unauthorized bool          // whether the last attempt was rejected as unauthorized, and retried at once
+}
+
+func newRetryBackoff(options BackoffOptions, cluster, namespace string) *retryBackoff {
+	return &retryBackoff{options: options.withDefaults(), cluster: cluster, namespace: namespace}
+}
+
+// wait waits out the delay due before the next attempt, returning the error of the context if it is canceled first
+func (b *retryBackoff) wait(ctx context.Context) error {
+	b.mu.Lock()
+	delay := b.delay
+	b.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// done records the outcome of an attempt, computing the delay before the next one.
+// An expired resourceVersion is not a failure: the reflector lists again at once, as it should.
+// Neither are expired credentials: client-go refreshes the credentials of a request rejected as unauthorized,
+// running the exec credential plugin again or rereading the token file, so the first such rejection is retried at once.
+func (b *retryBackoff) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if apierrors.IsUnauthorized(err) && !b.unauthorized {
+		b.unauthorized, b.delay = true, 0
+		reauthentications.WithLabelValues(b.cluster).Inc()
+		slog.Info("The credentials were rejected, retrying with refreshed ones", "cluster", b.cluster, "namespace", namespaceList([]string{b.namespace}))
+		return
+	}
+	b.unauthorized = apierrors.IsUnauthorized(err)
+	if err == nil || apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+		if b.failures >= b.options.Threshold && b.options.Threshold > 0 {
+			slog.Info("Circuit breaker closed, the API server answers again", "cluster", b.cluster, "namespace", namespaceList([]string{b.namespace}))
+		}
+		b.failures, b.delay = 0, 0
+		return
+	}
+	b.failures++
+	if b.options.Threshold > 0 && b.failures%b.options.Threshold == 0 {
+		b.delay = b.options.Pause
+		breakerTrips.Inc()
+		slog.Error("Circuit breaker open, pausing the list and watch after consecutive failures", "cluster", b.cluster,
+			"namespace", namespaceList([]string{b.namespace}), "failures", b.failures, "pause", b.delay, "error", err)
+		return
+	}
+	b.delay = jitter(exponentialDelay(b.options.Initial, b.options.Max, b.failures))
+	slog.Warn("List or watch failed, retrying", "cluster", b.cluster, "namespace", namespaceList([]string{b.namespace}),
+		"failures", b.failures, "retryIn", b.delay.Round(time.Millisecond), "error", err)
+}
+
+// exponentialDelay is the delay after the nth consecutive failure, doubling from initial up to max
+func exponentialDelay(initial, max time.Duration, n int) time.Duration {
+	delay := initial
+	for i := 1; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// jitter spreads the delay randomly over [delay/2, delay), so that the informers failing together do not retry together
+func jitter(delay time.Duration) time.Duration {
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + rand.N(delay-half)
+}
@@ -0,0 +1,125 @@
+package watcher
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// eventBatchBuffer is the number of batches a batching sink keeps while its destination is failing,
+// beyond which the oldest events are dropped
+const eventBatchBuffer = 10
+
+// eventBatcher accumulates the serialized events of a batching sink and delivers them as JSON arrays of up to size
+// events, once a batch is full or at every interval. A batch that could not be delivered is kept and retried at the
+// next interval, so that every event is delivered at least once as long as the buffer of eventBatchBuffer batches
+// does not overflow.
+type eventBatcher struct {
+	sink     string                   // the name of the sink, for the logs
+	size     int                      // the most events per batch
+	deliver  func(batch []byte) error // delivers a JSON array of events
+	failures prometheus.Counter       // counts the events dropped or not delivered
+
+	mu      sync.Mutex
+	pending []json.RawMessage // the events waiting for delivery, in order
+	failing bool              // whether the last delivery failed, which leaves the retries to the interval
+
+	sending sync.Mutex // serializes the deliveries, keeping the events in order
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newEventBatcher(sink string, size int, interval time.Duration, failures prometheus.Counter, deliver func(batch []byte) error) *eventBatcher {
+	b := &eventBatcher{sink: sink, size: size, deliver: deliver, failures: failures, stop: make(chan struct{}), stopped: make(chan struct{})}
+	go b.run(interval)
+	return b
+}
+
+// run delivers the pending events at every interval until the batcher is closed
+func (b *eventBatcher) run(interval time.Duration) {
+	defer close(b.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			_ = b.flush(true)
+		}
+	}
+}
+
+// add queues the serialized event, delivering a batch once one is full unless the destination is failing.
+// Once the buffer is full the oldest events are dropped and counted as failed.
+func (b *eventBatcher) add(body json.RawMessage) {
+	b.mu.Lock()
+	b.pending = append(b.pending, body)
+	b.trim()
+	full := len(b.pending) >= b.size && !b.failing
+	b.mu.Unlock()
+	if full {
+		_ = b.flush(false) // a failed batch is kept rather than lost
+	}
+}
+
+// trim drops the oldest pending events beyond the buffer; b.mu must be held
+func (b *eventBatcher) trim() {
+	if over := len(b.pending) - b.size*eventBatchBuffer; over > 0 {
+		b.pending = b.pending[over:]
+		b.failures.Add(float64(over))
+		slog.Warn("Batch buffer is full, dropping the oldest events", "sink", b.sink, "dropped", over)
+	}
+}
+
+// flush delivers the full batches pending, and the last partial one if all is set, stopping at the first that fails,
+// which is kept for the next attempt
+func (b *eventBatcher) flush(all bool) error {
+	b.sending.Lock()
+	defer b.sending.Unlock()
+	for {
+		b.mu.Lock()
+		n := min(len(b.pending), b.size)
+		if n == 0 || (n < b.size && !all) {
+			b.mu.Unlock()
+			return nil
+		}
+		batch := b.pending[:n:n]
+		b.pending = b.pending[n:]
+		b.mu.Unlock()
+
+		body, err := json.Marshal(batch)
+		if err == nil {
+			err = b.deliver(body)
+		}
+		b.mu.Lock()
+		b.failing = err != nil
+		if err != nil {
+			b.pending = append(batch, b.pending...)
+			b.trim()
+		}
+		b.mu.Unlock()
+		if err != nil {
+			slog.Error("Failed to deliver a batch of events, keeping it for the next attempt", "sink", b.sink, "events", n, "error", err)
+			return err
+		}
+	}
+}
+
+// close stops the deliveries at every interval and delivers the pending events, counting those that could not be
+// delivered as failed
+func (b *eventBatcher) close() error {
+	close(b.stop)
+	<-b.stopped
+	err := b.flush(true)
+	if err != nil {
+		b.mu.Lock()
+		b.failures.Add(float64(len(b.pending)))
+		b.pending = nil
+		b.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,379 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// Defaults of the synthetic load of Bench
+const (
+	DefaultBenchRate     = 200
+	DefaultBenchDuration = 10 * time.Second
+	DefaultBenchPods     = 50
+	DefaultBenchUpdates  = 8
+)
+
+// benchSentAnnotation stamps each generated revision with the time it was written, in nanoseconds since the epoch,
+// to time its way to the sinks
+const benchSentAnnotation = "bench.pod-watcher.io/sent"
+
+// benchDrainTimeout bounds the wait for the pipeline to catch up with the generated changes
+const benchDrainTimeout = 30 * time.Second
+
+// BenchOptions configures the synthetic load that Bench drives through the pipeline
+type BenchOptions struct {
+	// Cluster receives the generated pods: that of NewFakeCluster, or that of a disposable local cluster.
+	// A Config is used for the generator too, without client-side rate limiting.
+	Cluster   Cluster
+	Namespace string
+	Rate      int           // changes generated per second
+	Duration  time.Duration // how long to generate them
+	Pods      int           // pods alive at once, each created, modified Updates times, then deleted, and replaced
+	Updates   int
+	// MatchRatio is the share of the pods carrying FakeMarker, the others being rejected by a filter on it
+	MatchRatio float64
+}
+
+// BenchStage is the latency distribution of a stage of the pipeline over a Bench run
+type BenchStage struct {
+	Name  string        `json:"name"`
+	Count int           `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// BenchResult reports the throughput, allocations and latencies of a Bench run
+type BenchResult struct {
+	Duration  time.Duration `json:"duration"`  // spent generating the changes
+	CatchUp   time.Duration `json:"catchUp"`   // spent afterwards until the pipeline caught up, or gave up
+	Generated int           `json:"generated"` // changes written to the cluster
+	Failed    int           `json:"failed"`    // changes the cluster rejected
+	Received  int           `json:"received"`  // changes received from the watch
+	Emitted   int           `json:"emitted"`   // events delivered to the sinks, notices included
+	// AllocBytes and Allocs are allocated by the whole process over the run, the generator and the fake cluster included
+	AllocBytes uint64 `json:"allocBytes"`
+	Allocs     uint64 `json:"allocs"`
+	// Stages are the end-to-end latency, from the write of a change to its delivery to the sinks, then those of the
+	// spans of the pipeline (see SetupTracing): event, queue, serialize, filter, and sink.write for each sink
+	Stages []BenchStage `json:"stages"`
+}
+
+// Bench drives a synthetic load of pod changes through a watcher configured by the options, such as its filters and
+// sinks, and measures the pipeline: the changes are generated at the rate for the duration, spread over a pool of pods
+// that are created, modified and deleted in turn, then the watcher is given time to catch up. The stages are timed
+// from the spans of the pipeline, which are recorded in-process for the run, replacing any OpenTelemetry provider.
+// Canceling the context ends the run early, reporting on the changes generated so far.
+func Bench(ctx context.Context, bench BenchOptions, options ...Option) (*BenchResult, error) {
+	if bench.Rate <= 0 || bench.Duration <= 0 || bench.Pods <= 0 || bench.Updates < 0 {
+		return nil, fmt.Errorf("the rate, duration and pods of a bench must be positive")
+	}
+	if bench.MatchRatio < 0 || bench.MatchRatio > 1 {
+		return nil, fmt.Errorf("the match ratio of a bench must be between 0 and 1, not %g", bench.MatchRatio)
+	}
+	clientset := bench.Cluster.Clientset
+	if clientset == nil {
+		config := rest.CopyConfig(bench.Cluster.Config)
+		config.QPS, config.RateLimiter = -1, nil
+		var err error
+		if clientset, err = kubernetes.NewForConfig(config); err != nil {
+			return nil, fmt.Errorf("could not create the client of the generator: %w", err)
+		}
+	}
+
+	recorder := newStageRecorder()
+	previous := otel.GetTracerProvider()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	}()
+
+	w, err := NewMultiCluster([]Cluster{bench.Cluster}, append(options, WithSink(recorder))...)
+	if err != nil {
+		return nil, err
+	}
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	done := make(chan error, 1)
+	go func() { done <- w.Run(runCtx) }()
+	for !w.Ready() {
+		select {
+		case err := <-done:
+			return nil, fmt.Errorf("the watcher stopped before the bench started: %w", err)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	g := &benchGenerator{pods: clientset.CoreV1().Pods(bench.Namespace), options: bench, slots: make([]*corev1.Pod, bench.Pods), revisions: make([]int, bench.Pods)}
+	if bench.Cluster.Config == nil {
+		g.version = 1
+		// The watches of the fake clientset panic once their buffer is full, rather than wait: keep the changes
+		// not yet received well below it
+		g.backlog, g.received = int(watch.DefaultChanSize)/2, recorder.received.Load
+	}
+	g.run(runCtx, start)
+	generated := time.Since(start)
+	// Wait for the pipeline to catch up: every change received, and the deliveries settled
+	deadline := time.Now().Add(benchDrainTimeout)
+	emitted := recorder.emitted.Load()
+	for time.Now().Before(deadline) && runCtx.Err() == nil {
+		time.Sleep(200 * time.Millisecond)
+		current := recorder.emitted.Load()
+		if recorder.received.Load() >= int64(g.generated-g.failed) && current == emitted {
+			break
+		}
+		emitted = current
+	}
+	elapsed := time.Since(start)
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	stop()
+	if err := <-done; err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	g.cleanup()
+
+	result := &BenchResult{
+		Duration:   generated,
+		CatchUp:    elapsed - generated,
+		Generated:  g.generated,
+		Failed:     g.failed,
+		Received:   int(recorder.received.Load()),
+		Emitted:    int(recorder.emitted.Load()),
+		AllocBytes: after.TotalAlloc - before.TotalAlloc,
+		Allocs:     after.Mallocs - before.Mallocs,
+		Stages:     recorder.stages(),
+	}
+	return result, nil
+}
+
+// benchGenerator writes the changes of a bench: each slot of the pool holds a pod that is created, modified, then
+// deleted, and replaced by a new one, the slots taking turns
+type benchGenerator struct {
+	pods      typedcorev1.PodInterface
+	options   BenchOptions
+	slots     []*corev1.Pod // the current pod of each slot, nil between two
+	revisions []int         // the number of changes of the current pod of each slot
+	pod       int           // the number of pods created
+	version   int           // the last resource version, which the fake clientset leaves to its clients; 0 otherwise
+	backlog   int           // the most changes written but not yet received from the watch, 0 for no bound
+	received  func() int64  // the number of changes received from the watch, with a backlog
+	generated int
+	failed    int
+}
+
+// run generates the changes at the rate until the duration has elapsed since start
+func (g *benchGenerator) run(ctx context.Context, start time.Time) {
+	for n := 0; ctx.Err() == nil; n++ {
+		elapsed := time.Since(start)
+		if elapsed >= g.options.Duration {
+			return
+		}
+		if due := int(elapsed.Seconds() * float64(g.options.Rate)); n >= due {
+			time.Sleep(time.Duration(float64(n+1-due) * float64(time.Second) / float64(g.options.Rate)))
+		}
+		// Hold back while the watch lags, which lowers the rate achieved rather than overflow it
+		for g.backlog > 0 && int64(g.generated-g.failed)-g.received() >= int64(g.backlog) {
+			if ctx.Err() != nil || time.Since(start) >= g.options.Duration {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err := g.change(ctx, n%len(g.slots)); err != nil {
+			slog.Debug("Could not write the change of the bench", "error", err)
+			g.failed++
+		}
+		g.generated++
+	}
+}
+
+// change advances the pod of the slot by one change: its creation, a modification, or its deletion
+func (g *benchGenerator) change(ctx context.Context, slot int) error {
+	pod := g.slots[slot]
+	switch {
+	case pod == nil:
+		g.pod++
+		pod = fakePod(g.options.Namespace, fmt.Sprintf("bench-%d", g.pod))
+		// Spread the matching pods evenly over the run
+		if int(float64(g.pod)*g.options.MatchRatio) == int(float64(g.pod-1)*g.options.MatchRatio) {
+			pod.Spec.Containers[0].Env[0].Value = "BENCH"
+		}
+		g.stamp(pod)
+		created, err := g.pods.Create(ctx, pod, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		g.slots[slot], g.revisions[slot] = created, 0
+	case g.revisions[slot] < g.options.Updates:
+		pod = pod.DeepCopy()
+		g.stamp(pod)
+		g.revisions[slot]++
+		updated, err := g.pods.Update(ctx, pod, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		g.slots[slot] = updated
+	default:
+		g.slots[slot] = nil
+		return g.pods.Delete(ctx, pod.Name, metav1.DeleteOptions{GracePeriodSeconds: new(int64)})
+	}
+	return nil
+}
+
+// cleanup deletes the pods left in the pool
+func (g *benchGenerator) cleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, pod := range g.slots {
+		if pod != nil {
+			_ = g.pods.Delete(ctx, pod.Name, metav1.DeleteOptions{GracePeriodSeconds: new(int64)})
+		}
+	}
+}
+
+// stamp records the time the revision of the pod is written, and gives it a resource version of its own in the
+// fake clientset, lest it be taken for a resync
+func (g *benchGenerator) stamp(pod *corev1.Pod) {
+	if g.version > 0 {
+		g.version++
+		pod.ResourceVersion = strconv.Itoa(g.version)
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[benchSentAnnotation] = strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+// stageRecorder collects the durations of the stages of the pipeline during a bench: those of its spans, as a span
+// processor, and the end-to-end latency of the generated revisions, as a sink
+type stageRecorder struct {
+	mu        sync.Mutex
+	durations map[string][]time.Duration // stage -> its durations
+	received  atomic.Int64
+	emitted   atomic.Int64
+}
+
+func newStageRecorder() *stageRecorder {
+	return &stageRecorder{durations: make(map[string][]time.Duration)}
+}
+
+func (r *stageRecorder) record(stage string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.durations[stage] = append(r.durations[stage], d)
+}
+
+func (r *stageRecorder) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (r *stageRecorder) OnEnd(span sdktrace.ReadOnlySpan) {
+	name := span.Name()
+	switch name {
+	case "event":
+		r.received.Add(1)
+	case "sink.write":
+		for _, attribute := range span.Attributes() {
+			if attribute.Key == "sink" {
+				name += " " + attribute.Value.AsString()
+			}
+		}
+		if name == "sink.write bench" {
+			return
+		}
+	}
+	r.record(name, span.EndTime().Sub(span.StartTime()))
+}
+
+func (r *stageRecorder) Shutdown(context.Context) error   { return nil }
+func (r *stageRecorder) ForceFlush(context.Context) error { return nil }
+
+// Write times the delivery of the revisions stamped by the generator; deletions carry the stamp of their last
+// modification, and are only counted
+func (r *stageRecorder) Write(event Event) error {
+	r.emitted.Add(1)
+	if event.Type != string(watch.Added) && event.Type != string(watch.Modified) {
+		return nil
+	}
+	pod, ok := event.Object.(*corev1.Pod)
+	if !ok {
+		return nil
+	}
+	if sent, err := strconv.ParseInt(pod.Annotations[benchSentAnnotation], 10, 64); err == nil {
+		r.record("end-to-end", time.Since(time.Unix(0, sent)))
+	}
+	return nil
+}
+
+func (r *stageRecorder) Close() error   { return nil }
+func (r *stageRecorder) String() string { return "bench" }
+
+// stages summarizes the durations of each stage, end-to-end first, then in the order of the pipeline
+func (r *stageRecorder) stages() []BenchStage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order := map[string]int{"end-to-end": 0, "event": 1, "queue": 2, "serialize": 3, "filter": 4}
+	names := make([]string, 0, len(r.durations))
+	for name := range r.durations {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		oi, ok := order[names[i]]
+		if !ok {
+			oi = len(order)
+		}
+		oj, ok := order[names[j]]
+		if !ok {
+			oj = len(order)
+		}
+		if oi != oj {
+			return oi < oj
+		}
+		return names[i] < names[j]
+	})
+	stages := make([]BenchStage, 0, len(names))
+	for _, name := range names {
+		durations := r.durations[name]
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		var total time.Duration
+		for _, d := range durations {
+			total += d
+		}
+		percentile := func(p float64) time.Duration {
+			return durations[int(p*float64(len(durations)-1))]
+		}
+		stages = append(stages, BenchStage{
+			Name:  name,
+			Count: len(durations),
+			Mean:  total / time.Duration(len(durations)),
+			P50:   percentile(0.5),
+			P90:   percentile(0.9),
+			P99:   percentile(0.99),
+			Max:   durations[len(durations)-1],
+		})
+	}
+	return stages
+}
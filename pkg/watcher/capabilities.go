@@ -0,0 +1,186 @@
+package watcher
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Capabilities of the API servers probed at the start of each watch, as named in the warnings and the metrics
+const (
+	CapabilityWatchList           = "WatchList"           // streaming the initial state with a watch, for WithWatchList
+	CapabilityBookmarks           = "WatchBookmarks"      // the bookmarks the watches resume from
+	CapabilityEphemeralContainers = "EphemeralContainers" // the ephemeralcontainers subresource, for WithDebugContainer
+)
+
+// warningDocument is the type of the warning documents in the JSON output formats
+const warningDocument = "WARNING"
+
+// capabilityProbeTimeout bounds the probe of the WatchList feature, which waits for the end of the initial events
+const capabilityProbeTimeout = 5 * time.Second
+
+// capabilityProbeSelector matches no object, so that the probe of the WatchList feature streams nothing but its end
+const capabilityProbeSelector = "pod-watcher.io/capability-probe=none"
+
+// Capabilities are the version of the API server of a cluster and the optional features it was found to support.
+// A feature whose probe failed, e.g. for lack of permission, is assumed to be supported.
+type Capabilities struct {
+	ServerVersion       string // e.g. v1.30.2, empty if unknown
+	WatchList           bool   // only probed with WithWatchList
+	Bookmarks           bool
+	EphemeralContainers bool
+}
+
+// Warning reports a requested feature that the API server of a cluster does not support, and how the watcher
+// adapted to it, written at the start of the watch
+type Warning struct {
+	Type          string    `json:"type"` // always "WARNING"
+	Timestamp     time.Time `json:"timestamp"`
+	Cluster       string    `json:"cluster,omitempty"`
+	ServerVersion string    `json:"serverVersion,omitempty"`
+	Feature       string    `json:"feature"` // CapabilityWatchList, ...
+	Message       string    `json:"message"`
+}
+
+// String formats the warning as a comment line, e.g.
+// "## Warning: WatchList is not supported by the API server (v1.26.3): the initial state is listed instead"
+func (w *Warning) String() string {
+	text := "## Warning"
+	if w.Cluster != "" {
+		text += " [" + w.Cluster + "]"
+	}
+	text += ": " + w.Feature + " is not supported by the API server"
+	if w.ServerVersion != "" {
+		text += " (" + w.ServerVersion + ")"
+	}
+	return text + ": " + w.Message
+}
+
+// probeCapabilities probes the API server of every cluster, records its capabilities in
+// pod_watcher_server_capabilities, and adapts the watch to those lacking: the initial state is listed rather than
+// streamed unless an API server supports it, and no debug container is injected in the clusters lacking ephemeral
+// containers. A warning document is written for each requested feature that is not supported.
+func (w *Watcher) probeCapabilities(ctx context.Context) {
+	watchList := false
+	for _, c := range w.clusters {
+		c.capabilities = w.probe(ctx, c)
+		capabilities := c.capabilities
+		slog.Info("Probed the API server", "cluster", c.name, "version", capabilities.ServerVersion, "watchList", capabilities.WatchList,
+			"bookmarks", capabilities.Bookmarks, "ephemeralContainers", capabilities.EphemeralContainers)
+		for capability, supported := range map[string]bool{CapabilityWatchList: capabilities.WatchList, CapabilityBookmarks: capabilities.Bookmarks,
+			CapabilityEphemeralContainers: capabilities.EphemeralContainers} {
+			if capability == CapabilityWatchList && !w.watchList {
+				continue
+			}
+			value := 0.0
+			if supported {
+				value = 1
+			}
+			serverCapabilities.WithLabelValues(c.name, capabilities.ServerVersion, capability).Set(value)
+		}
+		if w.watchList {
+			if capabilities.WatchList {
+				watchList = true
+			} else {
+				w.warn(c, CapabilityWatchList, "the initial state is listed instead")
+			}
+		}
+		if w.debug != nil && !capabilities.EphemeralContainers {
+			w.warn(c, CapabilityEphemeralContainers, "no debug container is injected in its pods")
+		}
+	}
+	if w.watchList {
+		if err := setWatchList(watchList); err != nil {
+			slog.Warn("Could not adapt the streaming of the initial state", "error", err)
+		}
+	}
+}
+
+// probe asks the API server of the cluster for its version and its features
+func (w *Watcher) probe(ctx context.Context, c *cluster) Capabilities {
+	capabilities := Capabilities{WatchList: w.watchList, Bookmarks: true, EphemeralContainers: true}
+	var parsed *version.Version
+	if info, err := c.clientset.Discovery().ServerVersion(); err != nil {
+		slog.Debug("Could not get the version of the API server", "cluster", c.name, "error", err)
+	} else if parsed, err = version.ParseGeneric(info.GitVersion); err != nil {
+		slog.Debug("Could not parse the version of the API server", "cluster", c.name, "version", info.GitVersion, "error", err)
+	} else {
+		capabilities.ServerVersion = info.GitVersion
+		// Bookmarks are generally available since Kubernetes 1.17
+		capabilities.Bookmarks = parsed.AtLeast(version.MajorMinor(1, 17))
+	}
+	if resources, err := c.clientset.Discovery().ServerResourcesForGroupVersion("v1"); err != nil {
+		slog.Debug("Could not discover the core resources of the API server", "cluster", c.name, "error", err)
+	} else {
+		capabilities.EphemeralContainers = false
+		for _, resource := range resources.APIResources {
+			if resource.Name == "pods/ephemeralcontainers" {
+				capabilities.EphemeralContainers = true
+			}
+		}
+	}
+	if w.watchList {
+		if parsed != nil && !parsed.AtLeast(version.MajorMinor(1, 27)) {
+			capabilities.WatchList = false
+		} else {
+			capabilities.WatchList = probeWatchList(ctx, c)
+		}
+	}
+	return capabilities
+}
+
+// probeWatchList starts a watch streaming the initial state of no object, which the API servers supporting
+// the WatchList feature end with a bookmark, and the others reject
+func probeWatchList(ctx context.Context, c *cluster) bool {
+	ctx, cancel := context.WithTimeout(ctx, capabilityProbeTimeout)
+	defer cancel()
+	sendInitialEvents := true
+	timeout := int64(capabilityProbeTimeout.Seconds())
+	stream, err := c.client.Watch(ctx, c.watched[0], metav1.ListOptions{
+		LabelSelector:        capabilityProbeSelector,
+		SendInitialEvents:    &sendInitialEvents,
+		ResourceVersionMatch: metav1.ResourceVersionMatchNotOlderThan,
+		AllowWatchBookmarks:  true,
+		TimeoutSeconds:       &timeout,
+	})
+	if err != nil {
+		slog.Debug("Could not probe the streaming of the initial state", "cluster", c.name, "error", err)
+		// Only the API servers lacking the feature reject it as invalid
+		return !apierrors.IsInvalid(err) && !apierrors.IsBadRequest(err)
+	}
+	defer stream.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case event, ok := <-stream.ResultChan():
+			if !ok || event.Type == watch.Error {
+				return false
+			}
+			if event.Type != watch.Bookmark {
+				continue
+			}
+			if objMeta, err := meta.Accessor(event.Object); err == nil && objMeta.GetAnnotations()[metav1.InitialEventsAnnotationKey] == "true" {
+				return true
+			}
+		}
+	}
+}
+
+// warn logs the warning about the feature the API server of the cluster lacks, and writes it to the output stream
+// and files
+func (w *Watcher) warn(c *cluster, feature, message string) {
+	warning := &Warning{Type: warningDocument, Timestamp: time.Now().UTC(), Cluster: c.name, ServerVersion: c.capabilities.ServerVersion,
+		Feature: feature, Message: message}
+	slog.Warn("The API server does not support a requested feature", "cluster", c.name, "version", warning.ServerVersion, "feature", feature,
+		"fallback", message)
+	for _, out := range w.writers {
+		out.writeDocument(warningDocument, warning.String(), warning)
+	}
+}
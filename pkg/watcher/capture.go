@@ -0,0 +1,164 @@
+package watcher
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// captureLogLimit bounds the size of each container log captured in a failure bundle
+const captureLogLimit = 10 * 1024 * 1024
+
+// failureCapture writes a bundle of artifacts for each matched pod that starts failing (--capture-on-failure):
+// its final YAML, the logs of its terminated containers, including those of the previous instance of restarted ones,
+// its Kubernetes Events, and its node. A pod is captured again only after it has recovered and failed anew.
+// Captures in progress when the watch stops are completed, unless the context given to Run is canceled.
+type failureCapture struct {
+	ctx        context.Context
+	dir        string
+	clientsets map[string]kubernetes.Interface // cluster name -> its clientset
+
+	mu      sync.Mutex
+	failing map[string]bool // pod key, qualified by its cluster -> whether it is failing
+	running sync.WaitGroup  // captures in progress
+}
+
+func newFailureCapture(ctx context.Context, dir string, clusters []*cluster) *failureCapture {
+	c := &failureCapture{ctx: ctx, dir: dir, clientsets: make(map[string]kubernetes.Interface), failing: make(map[string]bool)}
+	for _, cluster := range clusters {
+		c.clientsets[cluster.name] = cluster.clientset
+	}
+	return c
+}
+
+// update captures the pod of the cluster in the background if it has just started failing, or forgets it once deleted
+func (c *failureCapture) update(eventType string, cluster string, key string, pod *corev1.Pod) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if eventType == string(watch.Deleted) {
+		delete(c.failing, key)
+		return
+	}
+	reason := failureReason(pod)
+	wasFailing := c.failing[key]
+	c.failing[key] = reason != ""
+	if reason == "" || wasFailing {
+		return
+	}
+	c.running.Add(1)
+	go func() {
+		defer c.running.Done()
+		c.capture(cluster, key, reason, pod)
+	}()
+}
+
+// observe records whether a pod from the initial list is already failing, which is not captured
+func (c *failureCapture) observe(key string, pod *corev1.Pod) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failing[key] = failureReason(pod) != ""
+}
+
+// evict forgets whether the pod is failing
+func (c *failureCapture) evict(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.failing, key)
+}
+
+// wait waits for the captures in progress
+func (c *failureCapture) wait() {
+	c.running.Wait()
+}
+
+// failureReason returns why the pod is failing: the Failed phase or a container in CrashLoopBackOff; empty if it is not
+func failureReason(pod *corev1.Pod) string {
+	if pod.Status.Phase == corev1.PodFailed {
+		return string(corev1.PodFailed)
+	}
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" {
+				return "CrashLoopBackOff"
+			}
+		}
+	}
+	return ""
+}
+
+// capture writes the bundle of the pod into a directory of its own, named after the pod and the time of the failure
+func (c *failureCapture) capture(cluster, key, reason string, pod *corev1.Pod) {
+	name := strings.ReplaceAll(key, "/", "_") + "_" + time.Now().UTC().Format("20060102T150405Z")
+	dir := filepath.Join(c.dir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		slog.Error("Could not create the failure capture directory", "key", key, "dir", dir, "error", err)
+		return
+	}
+	clientset := c.clientsets[cluster]
+	var failed []string
+	write := func(file string, data func() ([]byte, error)) {
+		content, err := data()
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, file), content, 0o644)
+		}
+		if err != nil {
+			slog.Warn("Could not capture failure artifact", "key", key, "artifact", file, "error", err)
+			failed = append(failed, file)
+		}
+	}
+	write("pod.yaml", func() ([]byte, error) { return yaml.Marshal(pod) })
+	for _, status := range append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...) {
+		if status.LastTerminationState.Terminated != nil {
+			write(status.Name+".previous.log", func() ([]byte, error) { return c.logs(clientset, pod, status.Name, true) })
+		}
+		if status.State.Terminated != nil {
+			write(status.Name+".log", func() ([]byte, error) { return c.logs(clientset, pod, status.Name, false) })
+		}
+	}
+	write("events.yaml", func() ([]byte, error) {
+		selector := fields.Set{"involvedObject.kind": "Pod", "involvedObject.name": pod.Name}.AsSelector().String()
+		events, err := clientset.CoreV1().Events(pod.Namespace).List(c.ctx, metav1.ListOptions{FieldSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(events)
+	})
+	if pod.Spec.NodeName != "" {
+		write("node.yaml", func() ([]byte, error) {
+			node, err := clientset.CoreV1().Nodes().Get(c.ctx, pod.Spec.NodeName, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			node.ManagedFields = nil
+			return yaml.Marshal(node)
+		})
+	}
+	slog.Info("Captured failure artifacts", "key", key, "reason", reason, "dir", dir, "failed", strings.Join(failed, ","))
+}
+
+// logs fetches the log of a container of the pod, or of its previous instance
+func (c *failureCapture) logs(clientset kubernetes.Interface, pod *corev1.Pod, container string, previous bool) ([]byte, error) {
+	limit := int64(captureLogLimit)
+	stream, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container:  container,
+		Previous:   previous,
+		LimitBytes: &limit,
+	}).Stream(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return io.ReadAll(stream)
+}
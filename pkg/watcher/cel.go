@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// celFilter matches objects against the --filter-cel expressions, all of which must evaluate to true.
+// The object is available to the expressions as pod (whatever the watched resource) and as object.
+// An expression that fails to evaluate, e.g. because it selects a field the object does not have, does not match;
+// has() guards against missing fields, as in has(pod.spec.nodeName) && pod.spec.nodeName.startsWith('gpu-').
+type celFilter struct {
+	programs    []cel.Program
+	expressions []string // as given, for logging
+}
+
+// newCELFilter compiles the expressions, which must be boolean
+func newCELFilter(expressions []string) (*celFilter, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("pod", cel.DynType),
+		cel.Variable("object", cel.DynType),
+		ext.Strings(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the CEL environment: %w", err)
+	}
+	f := &celFilter{}
+	for _, expression := range expressions {
+		ast, issues := env.Compile(expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("invalid --filter-cel %q: %w", expression, issues.Err())
+		}
+		if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+			return nil, fmt.Errorf("invalid --filter-cel %q: must evaluate to a bool, not %s", expression, ast.OutputType())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --filter-cel %q: %w", expression, err)
+		}
+		f.programs = append(f.programs, program)
+		f.expressions = append(f.expressions, expression)
+	}
+	return f, nil
+}
+
+// matches reports whether every expression evaluates to true for the object
+func (f *celFilter) matches(key string, obj runtime.Object) bool {
+	content, err := objectContent(obj)
+	if err != nil {
+		return false
+	}
+	vars := map[string]interface{}{"pod": content, "object": content}
+	for i, program := range f.programs {
+		result, _, err := program.Eval(vars)
+		if err != nil {
+			slog.Debug("CEL filter did not evaluate", "key", key, "filter", f.expressions[i], "error", err)
+			return false
+		}
+		if matched, ok := result.Value().(bool); !ok || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the filter for logging
+func (f *celFilter) String() string {
+	return strings.Join(f.expressions, " AND ")
+}
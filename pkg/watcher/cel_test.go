@@ -0,0 +1,77 @@
+package watcher
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNewCELFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		expression string
+		wantErr    string
+	}{
+		{name: "bool", expression: "pod.status.phase == 'Pending'"},
+		{name: "dynamic", expression: "pod.metadata.labels.app"},
+		{name: "syntax error", expression: "pod.status.phase ==", wantErr: "Syntax error"},
+		{name: "undeclared variable", expression: "node.name == 'a'", wantErr: "undeclared reference"},
+		{name: "int result", expression: "1 + 1", wantErr: "must evaluate to a bool, not int"},
+		{name: "string result", expression: "'Pending'", wantErr: "must evaluate to a bool, not string"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newCELFilter([]string{tc.expression})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) || !strings.Contains(err.Error(), "--filter-cel") {
+					t.Fatalf("got error %v, want a --filter-cel one about %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCELFilterMatches(t *testing.T) {
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+		"spec":       map[string]interface{}{"replicas": int64(2)},
+	}}
+	for _, tc := range []struct {
+		name        string
+		expressions []string
+		pod         bool // whether the expressions are evaluated against fakePod rather than the deployment
+		want        bool
+	}{
+		{name: "true", expressions: []string{"pod.status.phase == 'Pending'"}, pod: true, want: true},
+		{name: "false", expressions: []string{"pod.status.phase == 'Running'"}, pod: true},
+		{name: "every expression", expressions: []string{"pod.status.phase == 'Pending'", "object.metadata.name == 'api'"}, pod: true},
+		{name: "string functions", expressions: []string{"pod.spec.containers.exists(c, c.image.startsWith('busy'))"}, pod: true, want: true},
+		{name: "unstructured", expressions: []string{"object.spec.replicas > 1"}, want: true},
+		{name: "missing field", expressions: []string{"pod.spec.nodeName.startsWith('gpu-')"}, pod: true},
+		{name: "guarded missing field", expressions: []string{"!has(pod.spec.nodeName) || pod.spec.nodeName.startsWith('gpu-')"}, pod: true, want: true},
+		{name: "missing map key", expressions: []string{"object.metadata.labels.app == 'web'"}},
+		{name: "non-bool result", expressions: []string{"pod.metadata.name"}, pod: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newCELFilter(tc.expressions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var matched bool
+			if tc.pod {
+				matched = f.matches("default/web", fakePod("default", "web"))
+			} else {
+				matched = f.matches("default/web", deployment)
+			}
+			if matched != tc.want {
+				t.Errorf("%s matched: %v, want %v", f, matched, tc.want)
+			}
+		})
+	}
+}
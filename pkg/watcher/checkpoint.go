@@ -0,0 +1,350 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// checkpointKey is the key of the checkpoint in the data of its ConfigMap
+const checkpointKey = "checkpoint.json"
+
+// checkpointVersion is the version of the checkpoint format; checkpoints of other versions are ignored
+const checkpointVersion = 1
+
+// CheckpointOptions configures the checkpoint of WithCheckpoint, kept either in a file or in a ConfigMap
+type CheckpointOptions struct {
+	File               string
+	ConfigMapName      string        // kept in the first watched cluster
+	ConfigMapNamespace string        // defaults to the namespace of the pod when running in-cluster, "default" otherwise
+	Interval           time.Duration // between two saves; defaults to 10s
+}
+
+// checkpointState is the persisted state of a watch: the resource version last processed by each informer,
+// and the resource version of every object, along with the last revision of those that matched
+type checkpointState struct {
+	Version          int                          `json:"version"`
+	Resource         string                       `json:"resource"`
+	Saved            time.Time                    `json:"saved"`
+	ResourceVersions map[string]string            `json:"resourceVersions"` // namespace, qualified by its cluster -> resource version
+	Objects          map[string]*checkpointObject `json:"objects"`          // object key, qualified by its cluster -> its state
+}
+
+// checkpointObject is the state of one object in a checkpoint
+type checkpointObject struct {
+	Cluster         string          `json:"cluster,omitempty"`
+	Key             string          `json:"key"`
+	ResourceVersion string          `json:"resourceVersion"`
+	Kind            string          `json:"kind,omitempty"`   // of the Object, as recorded in the event store
+	Object          json.RawMessage `json:"object,omitempty"` // the last revision after --strip, if it matched
+}
+
+// checkpointStore loads and saves the checkpoint document
+type checkpointStore interface {
+	load(ctx context.Context) ([]byte, error) // nil without a checkpoint
+	save(ctx context.Context, data []byte) error
+	String() string
+}
+
+// fileCheckpoint keeps the checkpoint in a file, replaced atomically on each save
+type fileCheckpoint struct {
+	path string
+}
+
+func (f fileCheckpoint) load(context.Context) ([]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f fileCheckpoint) save(_ context.Context, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (f fileCheckpoint) String() string {
+	return f.path
+}
+
+// configMapCheckpoint keeps the checkpoint in a ConfigMap, e.g. for a Deployment without a persistent volume
+type configMapCheckpoint struct {
+	clientset       kubernetes.Interface
+	namespace, name string
+}
+
+func (c configMapCheckpoint) load(ctx context.Context) ([]byte, error) {
+	configMap, err := c.clientset.CoreV1().ConfigMaps(c.namespace).Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(configMap.Data[checkpointKey]), nil
+}
+
+func (c configMapCheckpoint) save(ctx context.Context, data []byte) error {
+	configMaps := c.clientset.CoreV1().ConfigMaps(c.namespace)
+	configMap, err := configMaps.Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: c.namespace}, Data: map[string]string{checkpointKey: string(data)}}
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[checkpointKey] = string(data)
+	_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+func (c configMapCheckpoint) String() string {
+	return "configmap:" + c.namespace + "/" + c.name
+}
+
+// checkpointer records the state of the watch (--checkpoint-file, --checkpoint-configmap) and saves it periodically,
+// so that the next run resumes where this one left off: the objects of its initial lists are compared with
+// the previous checkpoint, emitting those created or changed in between as ADDED or MODIFIED, and the matching
+// objects that are gone as DELETED, instead of silently taking the lists as the new baseline.
+type checkpointer struct {
+	store    checkpointStore
+	resource string
+	interval time.Duration
+	previous *checkpointState // of the previous run; nil without a usable checkpoint
+
+	mu               sync.Mutex
+	seen             map[string]bool // objects of the previous checkpoint found in the initial lists
+	resourceVersions map[string]string
+	objects          map[string]*checkpointObject
+	dirty            bool // whether the state changed since it was last saved
+}
+
+// newCheckpointer loads the previous checkpoint from the store; an unreadable one is ignored, starting afresh
+func newCheckpointer(ctx context.Context, store checkpointStore, resource string, interval time.Duration) *checkpointer {
+	c := &checkpointer{
+		store:            store,
+		resource:         resource,
+		interval:         interval,
+		seen:             make(map[string]bool),
+		resourceVersions: make(map[string]string),
+		objects:          make(map[string]*checkpointObject),
+	}
+	data, err := store.load(ctx)
+	if err != nil {
+		slog.Warn("Could not load the checkpoint, starting afresh", "checkpoint", store.String(), "error", err)
+		return c
+	}
+	if len(data) == 0 {
+		return c
+	}
+	var previous checkpointState
+	if err := json.Unmarshal(data, &previous); err != nil {
+		slog.Warn("Ignoring an invalid checkpoint", "checkpoint", store.String(), "error", err)
+		return c
+	}
+	if previous.Version != checkpointVersion || previous.Resource != resource {
+		slog.Warn("Ignoring the checkpoint of another version or resource", "checkpoint", store.String(), "resource", previous.Resource)
+		return c
+	}
+	slog.Info("Resuming from checkpoint", "checkpoint", store.String(), "saved", previous.Saved.Format(time.RFC3339),
+		"objects", len(previous.Objects), "resourceVersions", previous.ResourceVersions)
+	c.previous = &previous
+	// Until the initial lists are complete, the objects not listed yet keep their previous state
+	maps.Copy(c.objects, previous.Objects)
+	maps.Copy(c.resourceVersions, previous.ResourceVersions)
+	return c
+}
+
+// resumeType returns the event type an object of an initial list is emitted as: ADDED if it was created after
+// the previous checkpoint, MODIFIED if it has changed since, or empty if it is only observed
+func (c *checkpointer) resumeType(cluster string, obj runtime.Object) string {
+	if c.previous == nil {
+		return ""
+	}
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	id := clusterKey(cluster, objectKey(objMeta))
+	c.mu.Lock()
+	c.seen[id] = true
+	c.mu.Unlock()
+	previous, found := c.previous.Objects[id]
+	switch {
+	case !found && objMeta.GetCreationTimestamp().After(c.previous.Saved):
+		return string(watch.Added)
+	case found && previous.ResourceVersion != objMeta.GetResourceVersion():
+		return string(watch.Modified)
+	}
+	return ""
+}
+
+// gone forgets the objects of the previous checkpoint that are in the namespace of the cluster (every namespace
+// for metav1.NamespaceAll) but were not in its initial list, i.e. were deleted in between,
+// returning the last revision of those that matched
+func (c *checkpointer) gone(cluster, namespace string) []runtime.Object {
+	if c.previous == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var deleted []runtime.Object
+	for id, previous := range c.previous.Objects {
+		objNamespace, _, namespaced := strings.Cut(previous.Key, "/")
+		if !namespaced {
+			objNamespace = ""
+		}
+		if previous.Cluster != cluster || c.seen[id] || (namespace != metav1.NamespaceAll && objNamespace != namespace) {
+			continue
+		}
+		c.seen[id] = true
+		c.dirty = true
+		delete(c.objects, id)
+		if previous.Object == nil {
+			continue
+		}
+		obj, err := decodeObject(previous.Kind, previous.Object)
+		if err != nil {
+			slog.Warn("Could not decode checkpointed object", "key", id, "error", err)
+			continue
+		}
+		deleted = append(deleted, obj)
+	}
+	return deleted
+}
+
+// processed records the resource version an informer has reached
+func (c *checkpointer) processed(cluster, namespace, resourceVersion string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resourceVersions[clusterKey(cluster, namespace)] = resourceVersion
+	c.dirty = true
+}
+
+// update records the state of an object, keeping the last revision of matching ones; deleted objects are forgotten
+func (c *checkpointer) update(eventType string, m *matchedObject, resourceVersion string, matched bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirty = true
+	if eventType == string(watch.Deleted) {
+		delete(c.objects, m.id)
+		return
+	}
+	state := &checkpointObject{Cluster: m.cluster, Key: m.key, ResourceVersion: resourceVersion}
+	if matched {
+		data, err := json.Marshal(m.obj)
+		if err != nil {
+			slog.Warn("Could not checkpoint object", "key", m.id, "error", err)
+		} else {
+			state.Kind, state.Object = objectKind(m.obj), data
+		}
+	}
+	c.objects[m.id] = state
+}
+
+// run saves the checkpoint every interval until the context is canceled
+func (c *checkpointer) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.save(ctx)
+		}
+	}
+}
+
+// save writes the checkpoint if the state changed since it was last saved
+func (c *checkpointer) save(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return
+	}
+	data, err := json.Marshal(&checkpointState{
+		Version:          checkpointVersion,
+		Resource:         c.resource,
+		Saved:            time.Now().UTC(),
+		ResourceVersions: c.resourceVersions,
+		Objects:          c.objects,
+	})
+	if err != nil {
+		slog.Error("Could not marshal the checkpoint", "error", err)
+		return
+	}
+	if err := c.store.save(ctx, data); err != nil {
+		slog.Warn("Could not save the checkpoint", "checkpoint", c.store.String(), "error", err)
+		return
+	}
+	c.dirty = false
+}
+
+// newCheckpointStore returns the store of the checkpoint options, validating them
+func newCheckpointStore(options CheckpointOptions, clientset kubernetes.Interface) (checkpointStore, error) {
+	switch {
+	case options.File != "" && options.ConfigMapName != "":
+		return nil, fmt.Errorf("--checkpoint-file and --checkpoint-configmap cannot be combined")
+	case options.File != "":
+		return fileCheckpoint{path: options.File}, nil
+	case options.ConfigMapName != "":
+		namespace := options.ConfigMapNamespace
+		if namespace == "" {
+			namespace = podNamespace()
+		}
+		return configMapCheckpoint{clientset: clientset, namespace: namespace, name: options.ConfigMapName}, nil
+	}
+	return nil, fmt.Errorf("the checkpoint requires a file or a ConfigMap")
+}
+
+// resume handles an object of the initial list of an informer, emitting it if it was created or changed since
+// the previous checkpoint, and only observing it otherwise
+func (p *eventProcessor) resume(cluster string, obj runtime.Object) {
+	if eventType := p.checkpoint.resumeType(cluster, obj); eventType != "" {
+		p.handle(context.Background(), cluster, eventType, obj)
+		return
+	}
+	p.observe(cluster, obj)
+}
+
+// resumeDeletions emits the deletions of the matching objects of the previous checkpoint that are missing
+// from the initial list of the namespace, once the informer has synced
+func (p *eventProcessor) resumeDeletions(cluster, namespace string) {
+	for _, obj := range p.checkpoint.gone(cluster, namespace) {
+		p.handle(context.Background(), cluster, string(watch.Deleted), obj)
+	}
+}
@@ -0,0 +1,153 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// checkpointPod returns a pod of the cluster "prod" at the resource version, created at the time
+func checkpointPod(name, resourceVersion string, created time.Time) *corev1.Pod {
+	pod := fakePod("default", name)
+	pod.ResourceVersion = resourceVersion
+	pod.CreationTimestamp = metav1.NewTime(created)
+	return pod
+}
+
+// checkpointed records the pod in the checkpointer as seen by the watch
+func checkpointed(c *checkpointer, eventType string, pod *corev1.Pod, matched bool) {
+	key := objectKey(pod)
+	c.update(eventType, &matchedObject{key: key, cluster: "prod", id: clusterKey("prod", key), obj: pod}, pod.ResourceVersion, matched)
+}
+
+func TestCheckpointWithoutPrevious(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name    string
+		content string // of the checkpoint file; empty for none
+	}{
+		{name: "missing"},
+		{name: "corrupt", content: `{"version": 1, "resource":`},
+		{name: "other version", content: `{"version": 2, "resource": "pods"}`},
+		{name: "other resource", content: `{"version": 1, "resource": "deployments.apps"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name+".json")
+			if tc.content != "" {
+				if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := newCheckpointer(context.Background(), fileCheckpoint{path: path}, "pods", time.Second)
+			if c.previous != nil {
+				t.Fatal("resuming from an unusable checkpoint")
+			}
+			// Every listed object is only observed, and none is gone
+			if eventType := c.resumeType("prod", checkpointPod("web-1", "5", time.Now())); eventType != "" {
+				t.Errorf("listed object emitted as %s", eventType)
+			}
+			if deleted := c.gone("prod", metav1.NamespaceAll); len(deleted) != 0 {
+				t.Errorf("%d objects gone", len(deleted))
+			}
+		})
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	store := fileCheckpoint{path: filepath.Join(t.TempDir(), "checkpoint.json")}
+	before := time.Now().Add(-time.Hour)
+	c := newCheckpointer(context.Background(), store, "pods", time.Second)
+	c.processed("prod", metav1.NamespaceAll, "42")
+	checkpointed(c, string(watch.Added), checkpointPod("unchanged", "5", before), true)
+	checkpointed(c, string(watch.Added), checkpointPod("changed", "6", before), true)
+	checkpointed(c, string(watch.Added), checkpointPod("deleted", "7", before), true)
+	checkpointed(c, string(watch.Added), checkpointPod("unmatched", "8", before), false)
+	checkpointed(c, string(watch.Added), checkpointPod("forgotten", "9", before), true)
+	checkpointed(c, string(watch.Deleted), checkpointPod("forgotten", "10", before), true)
+	c.save(context.Background())
+
+	resumed := newCheckpointer(context.Background(), store, "pods", time.Second)
+	if resumed.previous == nil {
+		t.Fatal("not resuming from the checkpoint")
+	}
+	if want := map[string]string{clusterKey("prod", metav1.NamespaceAll): "42"}; !reflect.DeepEqual(resumed.previous.ResourceVersions, want) {
+		t.Errorf("resuming from the resource versions %v, want %v", resumed.previous.ResourceVersions, want)
+	}
+	for _, tc := range []struct {
+		pod  *corev1.Pod
+		want string
+	}{
+		{checkpointPod("unchanged", "5", before), ""},
+		{checkpointPod("changed", "11", before), string(watch.Modified)},
+		{checkpointPod("unmatched", "8", before), ""},
+		{checkpointPod("created", "12", time.Now().Add(time.Hour)), string(watch.Added)},
+		{checkpointPod("forgotten", "10", before), ""},
+	} {
+		if eventType := resumed.resumeType("prod", tc.pod); eventType != tc.want {
+			t.Errorf("%s resumed as %q, want %q", tc.pod.Name, eventType, tc.want)
+		}
+	}
+	// The matching object missing from the list is deleted with its last revision
+	deleted := resumed.gone("prod", metav1.NamespaceAll)
+	if len(deleted) != 1 {
+		t.Fatalf("%d objects gone, want 1", len(deleted))
+	}
+	if pod, ok := deleted[0].(*corev1.Pod); !ok || pod.Name != "deleted" || pod.ResourceVersion != "7" {
+		t.Errorf("gone %#v, want the last revision of deleted", deleted[0])
+	}
+}
+
+func TestFileCheckpointAtomicSave(t *testing.T) {
+	dir := t.TempDir()
+	store := fileCheckpoint{path: filepath.Join(dir, "checkpoint.json")}
+	if err := store.save(context.Background(), []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.Stat(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.save(context.Background(), []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is replaced by a complete one rather than rewritten in place, leaving no temporary file behind
+	second, err := os.Stat(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(first, second) {
+		t.Error("the checkpoint was rewritten in place")
+	}
+	if data, err := store.load(context.Background()); err != nil || string(data) != "second" {
+		t.Errorf("loaded %q, %v, want the second checkpoint", data, err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("the directory holds %d files, want the checkpoint only", len(files))
+	}
+}
+
+func TestConfigMapCheckpoint(t *testing.T) {
+	store := configMapCheckpoint{clientset: fake.NewClientset(), namespace: "default", name: "pod-watcher"}
+	ctx := context.Background()
+	if data, err := store.load(ctx); err != nil || data != nil {
+		t.Fatalf("loaded %q, %v without a ConfigMap", data, err)
+	}
+	// The first save creates the ConfigMap, the next update it
+	for _, checkpoint := range []string{"first", "second"} {
+		if err := store.save(ctx, []byte(checkpoint)); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := store.load(ctx); err != nil || string(data) != checkpoint {
+			t.Errorf("loaded %q, %v, want %q", data, err, checkpoint)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// OutputCloudEvents is the output format writing one CloudEvents 1.0 structured JSON event per line
+const OutputCloudEvents = "cloudevents"
+
+const (
+	// DefaultCloudEventsSource is the source of the CloudEvents of an unnamed cluster, unless WithCloudEventsSource gives another
+	DefaultCloudEventsSource = "pod-watcher"
+	// cloudEventTypePrefix prefixes the types of the CloudEvents, e.g. io.podwatcher.pod.added
+	cloudEventTypePrefix = "io.podwatcher."
+	// cloudEventsContentType is the media type of a CloudEvent in the structured JSON mode
+	cloudEventsContentType = "application/cloudevents+json"
+	// cloudEventsBatchContentType is the media type of a JSON array of CloudEvents
+	cloudEventsBatchContentType = "application/cloudevents-batch+json"
+)
+
+// WithCloudEventsSource sets the source of the CloudEvents of the events of an unnamed cluster, e.g. the name of its
+// kubeconfig context; the events of a cluster of NewMultiCluster have its name as their source
+func WithCloudEventsSource(source string) Option {
+	return func(w *Watcher) { w.cloudEventsSource = source }
+}
+
+// cloudEvent is a CloudEvents 1.0 event in the structured JSON mode.
+// The data of an event is its object; that of an event carrying a change instead, such as CONTAINER or ALERT,
+// is its JSON envelope.
+type cloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`              // io.podwatcher.<kind>.<event type>, in lower case
+	Subject         string    `json:"subject,omitempty"` // namespace/name of the object
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            any       `json:"data"`
+}
+
+// newCloudEvent wraps the event in a CloudEvent, sourced from its cluster, or from source when it has no name
+func newCloudEvent(event Event, source string) *cloudEvent {
+	_, namespace, name := eventSubject(event)
+	var data any = event.Object
+	if event.notice() {
+		data = newEnvelope(event)
+	}
+	return newCloudEventOf(cloudEventKind(event.Object)+"."+event.Type, cloudEventSource(event.Cluster, source),
+		joinKey(namespace, name), event.Timestamp, data)
+}
+
+// newCloudEventOf returns a CloudEvent of the given type, e.g. pod.ADDED or log
+func newCloudEventOf(eventType, source, subject string, t time.Time, data any) *cloudEvent {
+	return &cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              string(uuid.NewUUID()),
+		Source:          source,
+		Type:            cloudEventTypePrefix + strings.ToLower(eventType),
+		Subject:         subject,
+		Time:            t,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+// cloudEventSource is the name of the cluster, or the given source for an unnamed one
+func cloudEventSource(cluster, source string) string {
+	switch {
+	case cluster != "":
+		return cluster
+	case source != "":
+		return source
+	default:
+		return DefaultCloudEventsSource
+	}
+}
+
+// cloudEventKind is the kind of the object in lower case, pod for the pods and for the Kubernetes Events about them
+func cloudEventKind(obj runtime.Object) string {
+	switch obj.(type) {
+	case *corev1.Pod, *corev1.Event:
+		return "pod"
+	}
+	if obj != nil {
+		if kind := obj.GetObjectKind().GroupVersionKind().Kind; kind != "" {
+			return strings.ToLower(kind)
+		}
+	}
+	return "object"
+}
+
+// joinKey is namespace/name, or the name of a cluster-scoped object
+func joinKey(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// marshalEvent serializes the event for the webhooks and Kafka: as its JSON envelope, or as a CloudEvent
+func marshalEvent(event Event, cloudEvents bool) ([]byte, error) {
+	if cloudEvents {
+		return json.Marshal(newCloudEvent(event, event.source))
+	}
+	return json.Marshal(newEnvelope(event))
+}
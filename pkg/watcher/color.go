@@ -0,0 +1,137 @@
+package watcher
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// ANSI escape sequences of the colorized output
+const (
+	ansiReset       = "\x1b[0m"
+	ansiRed         = "\x1b[31m"
+	ansiGreen       = "\x1b[32m"
+	ansiYellow      = "\x1b[33m"
+	ansiBlue        = "\x1b[34m"
+	ansiCyan        = "\x1b[36m"
+	ansiGray        = "\x1b[90m"
+	ansiBold        = "\x1b[1m"
+	ansiHighlight   = "\x1b[1;4m"   // bold and underlined, keeping the color of the surrounding text
+	ansiUnhighlight = "\x1b[22;24m" // ends ansiHighlight only
+)
+
+// colorizer colors the output of an eventWriter for a terminal: the event documents and table rows by event type,
+// the lines of a diff by the kind of change, and the marker substrings and regular expressions wherever they appear.
+// A nil colorizer leaves the output alone.
+type colorizer struct {
+	markers []*regexp.Regexp
+}
+
+// newColorizer returns a colorizer highlighting the markers of the filter, which may be nil
+func newColorizer(filter *markerFilter) *colorizer {
+	c := &colorizer{}
+	if filter == nil {
+		return c
+	}
+	for _, substring := range filter.substrings {
+		if substring != "" {
+			c.markers = append(c.markers, regexp.MustCompile(regexp.QuoteMeta(substring)))
+		}
+	}
+	c.markers = append(c.markers, filter.regexes...)
+	return c
+}
+
+// eventColor returns the color of an event type
+func eventColor(eventType string) string {
+	switch eventType {
+	case string(watch.Added):
+		return ansiGreen
+	case string(watch.Modified):
+		return ansiYellow
+	case string(watch.Deleted), AlertEvent:
+		return ansiRed
+	case ResyncEvent:
+		return ansiCyan
+	default:
+		return ansiBlue
+	}
+}
+
+// paint colors the text, line by line so that a pager showing part of it keeps the colors
+func (c *colorizer) paint(color string, text string) string {
+	if c == nil || text == "" {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = color + line + ansiReset
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// event colors text belonging to an event of the given type
+func (c *colorizer) event(eventType string, text string) string {
+	return c.paint(eventColor(eventType), text)
+}
+
+// highlight marks the matches of the markers in the text
+func (c *colorizer) highlight(text string) string {
+	if c == nil || len(c.markers) == 0 {
+		return text
+	}
+	// Collect the matched byte ranges first, so that overlapping markers are highlighted once
+	highlighted := make([]bool, len(text)+1)
+	for _, re := range c.markers {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			for i := loc[0]; i < loc[1]; i++ {
+				highlighted[i] = true
+			}
+		}
+	}
+	var b strings.Builder
+	on := false
+	for i := 0; i < len(text); {
+		// Line breaks end the highlight, so that every line can be colored on its own
+		if highlighted[i] != on || (on && text[i] == '\n') {
+			on = highlighted[i] && text[i] != '\n'
+			if on {
+				b.WriteString(ansiHighlight)
+			} else {
+				b.WriteString(ansiUnhighlight)
+			}
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		b.WriteString(text[i : i+size])
+		i += size
+	}
+	if on {
+		b.WriteString(ansiUnhighlight)
+	}
+	return b.String()
+}
+
+// diff colors the lines of a unified diff: additions green, removals red and hunk headers cyan
+func (c *colorizer) diff(text string) string {
+	if c == nil {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			lines[i] = c.paint(ansiGreen, c.highlight(line))
+		case strings.HasPrefix(line, "-"):
+			lines[i] = c.paint(ansiRed, c.highlight(line))
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = c.paint(ansiCyan, line)
+		default:
+			lines[i] = c.highlight(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,265 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Changes of an object between two states compared by CompareStates
+const (
+	ObjectAdded   = "added"   // the object only exists in the second state
+	ObjectRemoved = "removed" // the object only exists in the first state
+	ObjectChanged = "changed" // the object exists in both states, with different fields
+)
+
+// DefaultCompareIgnore are the fields CompareStates always ignores, which change with every write to an object
+var DefaultCompareIgnore = []string{"metadata.resourceVersion", "metadata.managedFields"}
+
+// ObjectDiff is the difference of one object between two states of the watched objects
+type ObjectDiff struct {
+	Change    string        `json:"change"` // ObjectAdded, ObjectRemoved or ObjectChanged
+	Cluster   string        `json:"cluster,omitempty"`
+	Namespace string        `json:"namespace,omitempty"`
+	Name      string        `json:"name"`
+	Fields    []FieldChange `json:"fields,omitempty"` // ObjectChanged only, sorted by path
+}
+
+// FieldChange is the change of one field of an object. The path names the elements of the lists of objects
+// with a name or type by it, e.g. spec.containers[name=app].image, and the others by their index.
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"` // nil for an added field
+	After  interface{} `json:"after,omitempty"`  // nil for a removed field
+}
+
+// String formats the change compactly, e.g. "spec.containers[name=app].image: nginx:1.25 -> nginx:1.26"
+func (c FieldChange) String() string {
+	return c.Path + ": " + formatFieldValue(c.Before) + " -> " + formatFieldValue(c.After)
+}
+
+// formatFieldValue formats a field value for display: strings as they are, other values as JSON
+func formatFieldValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "<none>"
+	case string:
+		return v
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// CompareStates compares two states of the watched objects, each given as recorded events, such as a stream captured
+// by pod-watcher snapshot or the events of a store as of a time (StoreQuery.Latest). The state of an object is its last
+// change in the events, and the objects whose last change is a deletion are absent. It returns the objects added,
+// removed and changed between the states, sorted by cluster, namespace and name, ignoring the fields of
+// DefaultCompareIgnore and those under the given paths, e.g. "status" or "metadata.labels.version".
+func CompareStates(before, after []Event, ignore ...string) ([]ObjectDiff, error) {
+	beforeState, err := latestStates(before)
+	if err != nil {
+		return nil, err
+	}
+	afterState, err := latestStates(after)
+	if err != nil {
+		return nil, err
+	}
+	ignore = append(append([]string{}, DefaultCompareIgnore...), ignore...)
+	var diffs []ObjectDiff
+	for id, old := range beforeState {
+		current, ok := afterState[id]
+		if !ok {
+			diffs = append(diffs, old.diff(ObjectRemoved))
+			continue
+		}
+		var fields []FieldChange
+		compareFields("", old.content, current.content, ignore, &fields)
+		if len(fields) > 0 {
+			sort.Slice(fields, func(i, j int) bool { return fields[i].Path < fields[j].Path })
+			diff := current.diff(ObjectChanged)
+			diff.Fields = fields
+			diffs = append(diffs, diff)
+		}
+	}
+	for id, current := range afterState {
+		if _, ok := beforeState[id]; !ok {
+			diffs = append(diffs, current.diff(ObjectAdded))
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		a, b := diffs[i], diffs[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return diffs, nil
+}
+
+// objectRevision is the state of an object compared by CompareStates
+type objectRevision struct {
+	cluster   string
+	namespace string
+	name      string
+	content   map[string]interface{}
+}
+
+func (r *objectRevision) diff(change string) ObjectDiff {
+	return ObjectDiff{Change: change, Cluster: r.cluster, Namespace: r.namespace, Name: r.name}
+}
+
+// latestStates returns the last revision of every object of the events that was not deleted, by its key qualified
+// by its cluster
+func latestStates(events []Event) (map[string]*objectRevision, error) {
+	states := make(map[string]*objectRevision)
+	for _, event := range events {
+		if !isChange(event.Type) || event.Object == nil {
+			continue
+		}
+		id := clusterKey(event.Cluster, event.Key)
+		if event.Type == string(watch.Deleted) {
+			delete(states, id)
+			continue
+		}
+		objMeta, err := meta.Accessor(event.Object)
+		if err != nil {
+			return nil, fmt.Errorf("event of %s: %w", id, err)
+		}
+		content, err := unstructuredContent(event.Object)
+		if err != nil {
+			return nil, fmt.Errorf("event of %s: %w", id, err)
+		}
+		states[id] = &objectRevision{cluster: event.Cluster, namespace: objMeta.GetNamespace(), name: objMeta.GetName(), content: content}
+	}
+	return states, nil
+}
+
+// unstructuredContent returns the fields of an object as JSON values
+func unstructuredContent(obj runtime.Object) (map[string]interface{}, error) {
+	if u, ok := obj.(runtime.Unstructured); ok {
+		return u.UnstructuredContent(), nil
+	}
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// compareFields appends the changes between two values at path, descending into the maps and lists
+func compareFields(path string, before, after interface{}, ignore []string, fields *[]FieldChange) {
+	if ignoredPath(path, ignore) || reflect.DeepEqual(before, after) {
+		return
+	}
+	switch old := before.(type) {
+	case map[string]interface{}:
+		current, ok := after.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for key, value := range old {
+			compareFields(joinFieldPath(path, key), value, current[key], ignore, fields)
+		}
+		for key, value := range current {
+			if _, ok := old[key]; !ok {
+				compareFields(joinFieldPath(path, key), nil, value, ignore, fields)
+			}
+		}
+		return
+	case []interface{}:
+		current, ok := after.([]interface{})
+		if !ok {
+			break
+		}
+		if key := listKey(old, current); key != "" {
+			compareKeyedList(path, key, old, current, ignore, fields)
+			return
+		}
+		for i := 0; i < max(len(old), len(current)); i++ {
+			var oldItem, currentItem interface{}
+			if i < len(old) {
+				oldItem = old[i]
+			}
+			if i < len(current) {
+				currentItem = current[i]
+			}
+			compareFields(fmt.Sprintf("%s[%d]", path, i), oldItem, currentItem, ignore, fields)
+		}
+		return
+	}
+	*fields = append(*fields, FieldChange{Path: path, Before: before, After: after})
+}
+
+// compareKeyedList compares two lists of objects identified by the value of the given key, such as containers by name
+func compareKeyedList(path string, key string, before, after []interface{}, ignore []string, fields *[]FieldChange) {
+	current := make(map[string]interface{}, len(after))
+	for _, item := range after {
+		current[fmt.Sprint(item.(map[string]interface{})[key])] = item
+	}
+	seen := make(map[string]bool, len(before))
+	for _, item := range before {
+		value := fmt.Sprint(item.(map[string]interface{})[key])
+		seen[value] = true
+		compareFields(fmt.Sprintf("%s[%s=%s]", path, key, value), item, current[value], ignore, fields)
+	}
+	for _, item := range after {
+		if value := fmt.Sprint(item.(map[string]interface{})[key]); !seen[value] {
+			compareFields(fmt.Sprintf("%s[%s=%s]", path, key, value), nil, item, ignore, fields)
+		}
+	}
+}
+
+// listKey returns the field identifying the elements of two lists, "name" or "type", when every element of both is
+// an object with a distinct string value of it; "" when they must be compared by index
+func listKey(lists ...[]interface{}) string {
+	for _, key := range []string{"name", "type"} {
+		if listKeyedBy(key, lists...) {
+			return key
+		}
+	}
+	return ""
+}
+
+func listKeyedBy(key string, lists ...[]interface{}) bool {
+	for _, list := range lists {
+		seen := make(map[string]bool, len(list))
+		for _, item := range list {
+			object, ok := item.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			value, ok := object[key].(string)
+			if !ok || seen[value] {
+				return false
+			}
+			seen[value] = true
+		}
+	}
+	return true
+}
+
+// joinFieldPath appends a field name to a path
+func joinFieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// ignoredPath tells whether the path is, or is under, one of the ignored paths
+func ignoredPath(path string, ignore []string) bool {
+	for _, prefix := range ignore {
+		if path == prefix || strings.HasPrefix(path, prefix+".") || strings.HasPrefix(path, prefix+"[") {
+			return true
+		}
+	}
+	return false
+}
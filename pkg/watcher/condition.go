@@ -0,0 +1,113 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// waitCondition is the --wait-for condition that ends the watch once a matching object satisfies it.
+// It is one of:
+//   - a status condition such as "Ready", "condition=PodScheduled" or "condition=Ready=False"
+//     (the status defaults to True), which also covers conditions like "Available" on other resources;
+//   - a phase such as "phase=Succeeded";
+//   - a JSONPath expression such as "jsonpath={.status.podIP}" (any non-empty value)
+//     or "jsonpath={.status.containerStatuses[0].ready}=true".
+type waitCondition struct {
+	spec          string
+	conditionType string             // status condition type, when waiting for a condition
+	path          *jsonpath.JSONPath // otherwise, the field to compare
+	value         string             // the expected value of the field, empty for any non-empty value
+}
+
+// parseWaitCondition parses the --wait-for flag
+func parseWaitCondition(spec string) (*waitCondition, error) {
+	c := &waitCondition{spec: spec}
+	kind, rest, _ := strings.Cut(spec, "=")
+	switch strings.ToLower(kind) {
+	case "phase":
+		if rest == "" {
+			return nil, fmt.Errorf("invalid --wait-for %q: missing phase", spec)
+		}
+		c.path, c.value = jsonpath.New(spec).AllowMissingKeys(true), rest
+		if err := c.path.Parse("{.status.phase}"); err != nil {
+			return nil, err
+		}
+	case "jsonpath":
+		expression, value := rest, ""
+		// The expected value follows the closing brace of the template, e.g. {.status.phase}=Running
+		if end := strings.LastIndex(rest, "}"); end >= 0 && strings.HasPrefix(rest[end+1:], "=") {
+			expression, value = rest[:end+1], rest[end+2:]
+		}
+		c.path, c.value = jsonpath.New(spec).AllowMissingKeys(true), value
+		if err := c.path.Parse(jsonpathTemplate(expression)); err != nil {
+			return nil, fmt.Errorf("invalid --wait-for %q: %w", spec, err)
+		}
+	case "condition":
+		if rest == "" {
+			return nil, fmt.Errorf("invalid --wait-for %q: missing condition type", spec)
+		}
+		c.conditionType, c.value, _ = strings.Cut(rest, "=")
+	default:
+		// A bare condition type such as "Ready" or "ContainersReady=False"
+		if kind == "" {
+			return nil, fmt.Errorf("invalid --wait-for %q: missing condition type", spec)
+		}
+		c.conditionType, c.value = kind, rest
+	}
+	if c.conditionType != "" && c.value == "" {
+		c.value = "True"
+	}
+	return c, nil
+}
+
+// met reports whether the object satisfies the condition
+func (c *waitCondition) met(obj runtime.Object) bool {
+	content, err := objectContent(obj)
+	if err != nil {
+		return false
+	}
+	if c.conditionType != "" {
+		conditions, _, _ := unstructured.NestedSlice(content, "status", "conditions")
+		for _, condition := range conditions {
+			fields, ok := condition.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if conditionType, _ := fields["type"].(string); strings.EqualFold(conditionType, c.conditionType) {
+				status, _ := fields["status"].(string)
+				return strings.EqualFold(status, c.value)
+			}
+		}
+		return false
+	}
+	results, err := c.path.FindResults(content)
+	if err != nil {
+		return false
+	}
+	for _, result := range results {
+		for _, value := range result {
+			text, ok := value.Interface().(string)
+			if !ok {
+				data, err := json.Marshal(value.Interface())
+				if err != nil {
+					continue
+				}
+				text = string(data)
+			}
+			if c.value == "" && text != "" && text != "null" || c.value != "" && strings.EqualFold(text, c.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String describes the condition for logging
+func (c *waitCondition) String() string {
+	return c.spec
+}
@@ -0,0 +1,149 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Changes of the container status reported by the CONTAINER events of WithContainerTracking
+const (
+	ContainerRestarted = "Restarted" // the restart count increased; the last termination gives the exit code and reason
+	ContainerCrashed   = "Crashed"   // the container terminated with a non-zero exit code
+	ContainerCompleted = "Completed" // the container terminated with exit code 0
+	ContainerWaiting   = "Waiting"   // the container is waiting for a new reason, e.g. ImagePullBackOff or CrashLoopBackOff
+	ContainerReady     = "Ready"
+	ContainerNotReady  = "NotReady" // a ready container stopped being ready
+)
+
+// routineWaiting are the waiting reasons every container goes through when it starts, which are not reported
+var routineWaiting = map[string]bool{"ContainerCreating": true, "PodInitializing": true}
+
+// ContainerChange is a change of the status of one container of a pod, carried by CONTAINER events
+type ContainerChange struct {
+	Container    string `json:"container"`
+	Init         bool   `json:"init,omitempty"` // whether it is an init container
+	Change       string `json:"change"`         // ContainerRestarted, ContainerCrashed, ...
+	Reason       string `json:"reason,omitempty"`
+	Message      string `json:"message,omitempty"`
+	ExitCode     *int32 `json:"exitCode,omitempty"`
+	RestartCount int32  `json:"restartCount"`
+}
+
+// String formats the change compactly, e.g. "Restarted (exit code 137, OOMKilled, restarts: 3)"
+func (c *ContainerChange) String() string {
+	var details []string
+	if c.ExitCode != nil {
+		details = append(details, fmt.Sprintf("exit code %d", *c.ExitCode))
+	}
+	if c.Reason != "" {
+		details = append(details, c.Reason)
+	}
+	if c.RestartCount > 0 {
+		details = append(details, fmt.Sprintf("restarts: %d", c.RestartCount))
+	}
+	text := c.Change
+	if len(details) > 0 {
+		text += " (" + strings.Join(details, ", ") + ")"
+	}
+	if c.Message != "" {
+		text += ": " + strings.Join(strings.Fields(c.Message), " ")
+	}
+	return text
+}
+
+// containerTracker diffs the container statuses of each pod against those of its previous revision (--track-containers)
+type containerTracker struct {
+	mu       sync.Mutex
+	statuses map[string]map[string]corev1.ContainerStatus // pod key, qualified by its cluster -> container name -> last status
+}
+
+func newContainerTracker() *containerTracker {
+	return &containerTracker{statuses: make(map[string]map[string]corev1.ContainerStatus)}
+}
+
+// evict forgets the pod
+func (t *containerTracker) evict(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.statuses, key)
+}
+
+// update records the container statuses of the pod, returning their changes since the previous revision.
+// A pod from the initial list only sets the baseline, and a deleted one is forgotten.
+func (t *containerTracker) update(eventType string, key string, pod *corev1.Pod, baseline bool) []*ContainerChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if eventType == string(watch.Deleted) {
+		delete(t.statuses, key)
+		return nil
+	}
+	previous := t.statuses[key]
+	current := make(map[string]corev1.ContainerStatus)
+	var changes []*ContainerChange
+	track := func(statuses []corev1.ContainerStatus, init bool) {
+		for _, status := range statuses {
+			current[status.Name] = status
+			if !baseline {
+				prev, seen := previous[status.Name]
+				changes = append(changes, containerChanges(prev, seen, status, init)...)
+			}
+		}
+	}
+	track(pod.Status.InitContainerStatuses, true)
+	track(pod.Status.ContainerStatuses, false)
+	t.statuses[key] = current
+	return changes
+}
+
+// containerChanges compares the status of a container with its previous one, if it was seen before
+func containerChanges(prev corev1.ContainerStatus, seen bool, status corev1.ContainerStatus, init bool) []*ContainerChange {
+	var changes []*ContainerChange
+	change := func(kind string) *ContainerChange {
+		c := &ContainerChange{Container: status.Name, Init: init, Change: kind, RestartCount: status.RestartCount}
+		changes = append(changes, c)
+		return c
+	}
+	if seen && status.RestartCount > prev.RestartCount {
+		c := change(ContainerRestarted)
+		if last := status.LastTerminationState.Terminated; last != nil {
+			c.ExitCode, c.Reason, c.Message = &last.ExitCode, last.Reason, last.Message
+		}
+	} else if terminated := status.State.Terminated; terminated != nil && (!seen || prev.State.Terminated == nil) {
+		kind := ContainerCompleted
+		if terminated.ExitCode != 0 {
+			kind = ContainerCrashed
+		}
+		c := change(kind)
+		c.ExitCode, c.Reason, c.Message = &terminated.ExitCode, terminated.Reason, terminated.Message
+	}
+	if waiting := status.State.Waiting; waiting != nil && !routineWaiting[waiting.Reason] &&
+		(!seen || prev.State.Waiting == nil || prev.State.Waiting.Reason != waiting.Reason) {
+		c := change(ContainerWaiting)
+		c.Reason, c.Message = waiting.Reason, waiting.Message
+	}
+	if status.Ready && (!seen || !prev.Ready) {
+		change(ContainerReady)
+	} else if !status.Ready && seen && prev.Ready {
+		change(ContainerNotReady)
+	}
+	return changes
+}
+
+// emitContainerChanges emits a CONTAINER event for each change of the containers of the pod, unless their type is filtered out
+func (p *eventProcessor) emitContainerChanges(m *matchedObject, pod *corev1.Pod, changes []*ContainerChange) {
+	if p.eventTypes != nil && !p.eventTypes[ContainerEvent] {
+		return
+	}
+	for _, change := range changes {
+		event := Event{Type: ContainerEvent, Key: m.key, Object: pod, Timestamp: time.Now().UTC(), Cluster: m.cluster, Container: change}
+		p.sinks.write(event)
+		p.publish(event)
+		eventsEmitted.WithLabelValues(ContainerEvent).Inc()
+		p.health.eventEmitted()
+	}
+}
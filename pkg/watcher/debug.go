@@ -0,0 +1,151 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Triggers of the debug container injection of WithDebugContainer
+const (
+	DebugOnFailing  = "failing"   // a container is crash looping or terminated with an error while the pod still runs
+	DebugOnNotReady = "not-ready" // the pod runs but is not ready
+	DebugOnMatch    = "match"     // the pod matches, as soon as it runs
+)
+
+// DebugOptions configures the injection of ephemeral debug containers of WithDebugContainer
+type DebugOptions struct {
+	Image   string // the image of the debug container, e.g. busybox
+	Trigger string // DebugOnFailing, DebugOnNotReady or DebugOnMatch; DebugOnFailing if empty
+}
+
+// debugInjector attaches an ephemeral debug container to the matched pods meeting the trigger (--inject-debug-container),
+// at most once per pod, through the ephemeralcontainers subresource, like kubectl debug. The container targets
+// the failing container, sharing its process namespace, and keeps a TTY open so that it can be attached to.
+type debugInjector struct {
+	ctx        context.Context
+	options    DebugOptions
+	clientsets map[string]kubernetes.Interface // cluster name -> its clientset
+
+	mu       sync.Mutex
+	injected map[string]bool // pod key, qualified by its cluster -> whether a debug container was injected
+	running  sync.WaitGroup  // injections in progress
+}
+
+func newDebugInjector(ctx context.Context, options DebugOptions, clusters []*cluster) *debugInjector {
+	d := &debugInjector{ctx: ctx, options: options, clientsets: make(map[string]kubernetes.Interface), injected: make(map[string]bool)}
+	for _, cluster := range clusters {
+		// Older API servers lack the ephemeralcontainers subresource
+		if cluster.capabilities.EphemeralContainers {
+			d.clientsets[cluster.name] = cluster.clientset
+		}
+	}
+	return d
+}
+
+// parseDebugTrigger validates the trigger of the injection, defaulting to DebugOnFailing
+func parseDebugTrigger(trigger string) (string, error) {
+	switch trigger = strings.ToLower(strings.TrimSpace(trigger)); trigger {
+	case "":
+		return DebugOnFailing, nil
+	case DebugOnFailing, DebugOnNotReady, DebugOnMatch:
+		return trigger, nil
+	default:
+		return "", fmt.Errorf("invalid --inject-debug-on %q (must be %s, %s or %s)", trigger, DebugOnFailing, DebugOnNotReady, DebugOnMatch)
+	}
+}
+
+// update injects the debug container into the pod of the cluster in the background once it meets the trigger,
+// or forgets the pod once deleted
+func (d *debugInjector) update(eventType string, cluster string, key string, pod *corev1.Pod) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if eventType == string(watch.Deleted) {
+		delete(d.injected, key)
+		return
+	}
+	if _, ok := d.clientsets[cluster]; !ok {
+		return
+	}
+	if d.injected[key] || pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return // ephemeral containers can only run in a running pod
+	}
+	target, triggered := d.triggered(pod)
+	if !triggered {
+		return
+	}
+	d.injected[key] = true
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		d.inject(cluster, key, target, pod)
+	}()
+}
+
+// evict forgets whether a debug container was injected into the pod
+func (d *debugInjector) evict(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.injected, key)
+}
+
+// wait waits for the injections in progress
+func (d *debugInjector) wait() {
+	d.running.Wait()
+}
+
+// triggered reports whether the running pod meets the trigger, returning the container the debug container targets
+func (d *debugInjector) triggered(pod *corev1.Pod) (string, bool) {
+	target := ""
+	if len(pod.Spec.Containers) > 0 {
+		target = pod.Spec.Containers[0].Name
+	}
+	switch d.options.Trigger {
+	case DebugOnNotReady:
+		return target, !podReady(pod)
+	case DebugOnMatch:
+		return target, true
+	default:
+		for _, status := range pod.Status.ContainerStatuses {
+			waiting, terminated := status.State.Waiting, status.State.Terminated
+			if waiting != nil && waiting.Reason == "CrashLoopBackOff" || terminated != nil && terminated.ExitCode != 0 {
+				return status.Name, true
+			}
+		}
+		return "", false
+	}
+}
+
+// inject adds the debug container to the ephemeral containers of the pod, logging the result
+func (d *debugInjector) inject(cluster, key, target string, pod *corev1.Pod) {
+	name := "debugger-" + utilrand.String(5)
+	updated := pod.DeepCopy()
+	updated.Spec.EphemeralContainers = append(updated.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     name,
+			Image:                    d.options.Image,
+			ImagePullPolicy:          corev1.PullIfNotPresent,
+			Stdin:                    true,
+			TTY:                      true,
+			TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+		},
+		TargetContainerName: target,
+	})
+	_, err := d.clientsets[cluster].CoreV1().Pods(pod.Namespace).UpdateEphemeralContainers(d.ctx, pod.Name, updated, metav1.UpdateOptions{})
+	if err != nil {
+		debugInjections.WithLabelValues("failed").Inc()
+		slog.Error("Could not inject the debug container", "key", key, "image", d.options.Image, "target", target, "error", err)
+		return
+	}
+	debugInjections.WithLabelValues("injected").Inc()
+	slog.Info("Injected the debug container", "key", key, "container", name, "image", d.options.Image, "target", target,
+		"attach", fmt.Sprintf("kubectl attach -it -n %s %s -c %s", pod.Namespace, pod.Name, name))
+}
@@ -0,0 +1,246 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Reasons of the deletions attributed by WithDeletionAttribution
+const (
+	DeletionEvicted     = "Evicted"     // evicted through the Eviction API or by the kubelet, or preempted by the scheduler
+	DeletionOOMKilled   = "OOMKilled"   // a container of the pod ran out of memory
+	DeletionScaledDown  = "ScaledDown"  // its controller removed it while scaling down, or was deleted itself
+	DeletionNodeDrained = "NodeDrained" // its node was drained, cordoned, lost or removed
+	DeletionManual      = "Manual"      // nothing else explains it, as when the pod is deleted by hand
+)
+
+// Deletion is the reason of the deletion of a pod, inferred on a best-effort basis with WithDeletionAttribution
+type Deletion struct {
+	Reason  string `json:"reason"`            // DeletionEvicted, DeletionOOMKilled, ...
+	Message string `json:"message,omitempty"` // the evidence of the reason, such as the Kubernetes Event it was inferred from
+}
+
+// String formats the deletion compactly, e.g. "ScaledDown: ReplicaSet web-7d9f8: Deleted pod: web-7d9f8-x2k4q"
+func (d *Deletion) String() string {
+	if d.Message == "" {
+		return d.Reason
+	}
+	return d.Reason + ": " + d.Message
+}
+
+// WithDeletionAttribution adds the reason of the deletion of each pod to its DELETED event, inferred from the last
+// revision of the pod, from the Kubernetes Events about it and its controller, from its node, and from the scale of
+// its controller. This requires permission to list Events and to get nodes, ReplicaSets and StatefulSets.
+func WithDeletionAttribution() Option {
+	return func(w *Watcher) { w.attributeDeletions = true }
+}
+
+// deletionAttributor infers why the matched pods were deleted (--attribute-deletions), looking up the evidence
+// that their last revision lacks in their cluster
+type deletionAttributor struct {
+	ctx        context.Context
+	clientsets map[string]kubernetes.Interface // cluster name -> its clientset
+}
+
+func newDeletionAttributor(ctx context.Context, clusters []*cluster) *deletionAttributor {
+	a := &deletionAttributor{ctx: ctx, clientsets: make(map[string]kubernetes.Interface)}
+	for _, c := range clusters {
+		a.clientsets[c.name] = c.clientset
+	}
+	return a
+}
+
+// attribute returns the reason of the deletion of the pod of the cluster, trying the most specific evidence first,
+// and counts it in pod_watcher_deletions_attributed_total
+func (a *deletionAttributor) attribute(cluster string, pod *corev1.Pod) *Deletion {
+	deletion := podDeletion(pod)
+	if clientset, ok := a.clientsets[cluster]; ok {
+		if deletion == nil {
+			deletion = a.fromEvents(clientset, pod)
+		}
+		if deletion == nil {
+			deletion = a.fromOwner(clientset, pod)
+		}
+		if deletion == nil || deletion.Reason == DeletionEvicted {
+			// An eviction from a cordoned node is part of its drain
+			if drained := a.fromNode(clientset, pod); drained != nil {
+				deletion = drained
+			}
+		}
+	}
+	if deletion == nil {
+		deletion = &Deletion{Reason: DeletionManual}
+	}
+	deletionsAttributed.WithLabelValues(deletion.Reason).Inc()
+	return deletion
+}
+
+// podDeletion infers the reason from the last revision of the pod: its DisruptionTarget condition, set since
+// Kubernetes 1.26 on the pods being evicted or preempted, an eviction by the kubelet, or a container killed
+// for running out of memory
+func podDeletion(pod *corev1.Pod) *Deletion {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type != corev1.DisruptionTarget || condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Reason {
+		case "DeletionByTaintManager", "DeletionByPodGC":
+			// The node became unreachable, or was removed
+			return &Deletion{Reason: DeletionNodeDrained, Message: condition.Message}
+		default:
+			return &Deletion{Reason: DeletionEvicted, Message: condition.Message}
+		}
+	}
+	if pod.Status.Reason == "Evicted" {
+		return &Deletion{Reason: DeletionEvicted, Message: pod.Status.Message}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if terminated := status.State.Terminated; terminated != nil && terminated.Reason == "OOMKilled" {
+			return &Deletion{Reason: DeletionOOMKilled, Message: fmt.Sprintf("container %s (exit code %d)", status.Name, terminated.ExitCode)}
+		}
+	}
+	return nil
+}
+
+// fromEvents infers the reason from the latest Kubernetes Event about the pod telling of its eviction, preemption,
+// or of the loss of its node
+func (a *deletionAttributor) fromEvents(clientset kubernetes.Interface, pod *corev1.Pod) *Deletion {
+	selector := fields.Set{"involvedObject.kind": "Pod", "involvedObject.uid": string(pod.UID)}.AsSelector().String()
+	events, err := clientset.CoreV1().Events(pod.Namespace).List(a.ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		slog.Debug("Could not list the Events of the deleted pod", "pod", pod.Namespace+"/"+pod.Name, "error", err)
+		return nil
+	}
+	var deletion *Deletion
+	var latest metav1.Time
+	for _, event := range events.Items {
+		var reason string
+		switch event.Reason {
+		case "Evicted", "Preempted":
+			reason = DeletionEvicted
+		case "TaintManagerEviction", "NodeNotReady":
+			reason = DeletionNodeDrained
+		default:
+			continue
+		}
+		if t := eventTime(&event); deletion == nil || latest.Before(&t) {
+			deletion, latest = &Deletion{Reason: reason, Message: event.Reason + ": " + event.Message}, t
+		}
+	}
+	return deletion
+}
+
+// eventTime is the last time the Event occurred
+func eventTime(event *corev1.Event) metav1.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp
+	case !event.EventTime.IsZero():
+		return metav1.NewTime(event.EventTime.Time)
+	default:
+		return event.CreationTimestamp
+	}
+}
+
+// fromOwner infers a scale-down from the controller of the pod: the Event it records for each pod it deletes,
+// its own deletion, or, failing those, a scale below the pod, such as the ordinal of a StatefulSet pod beyond its replicas
+func (a *deletionAttributor) fromOwner(clientset kubernetes.Interface, pod *corev1.Pod) *Deletion {
+	ref := ownerReference(pod.OwnerReferences)
+	if ref == nil {
+		return nil
+	}
+	owner := ref.Kind + " " + ref.Name
+	selector := fields.Set{"involvedObject.kind": ref.Kind, "involvedObject.name": ref.Name, "reason": "SuccessfulDelete"}.AsSelector().String()
+	events, err := clientset.CoreV1().Events(pod.Namespace).List(a.ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		slog.Debug("Could not list the Events of the owner of the deleted pod", "pod", pod.Namespace+"/"+pod.Name, "owner", owner, "error", err)
+	} else {
+		for _, event := range events.Items {
+			// e.g. "Deleted pod: web-7d9f8-x2k4q", or "delete Pod web-2 in StatefulSet web successful"
+			if containsWord(event.Message, pod.Name) {
+				return &Deletion{Reason: DeletionScaledDown, Message: owner + ": " + event.Message}
+			}
+		}
+	}
+	group, _, _ := strings.Cut(ref.APIVersion, "/")
+	if group != "apps" {
+		return nil
+	}
+	var replicas *int32
+	var current int32
+	var deleting bool
+	switch ref.Kind {
+	case "ReplicaSet":
+		replicaSet, err := clientset.AppsV1().ReplicaSets(pod.Namespace).Get(a.ctx, ref.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return &Deletion{Reason: DeletionScaledDown, Message: owner + " was deleted"}
+		} else if err != nil {
+			return nil
+		}
+		replicas, current, deleting = replicaSet.Spec.Replicas, replicaSet.Status.Replicas, replicaSet.DeletionTimestamp != nil
+	case "StatefulSet":
+		statefulSet, err := clientset.AppsV1().StatefulSets(pod.Namespace).Get(a.ctx, ref.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return &Deletion{Reason: DeletionScaledDown, Message: owner + " was deleted"}
+		} else if err != nil {
+			return nil
+		}
+		replicas, current, deleting = statefulSet.Spec.Replicas, statefulSet.Status.Replicas, statefulSet.DeletionTimestamp != nil
+		ordinal, err := strconv.Atoi(strings.TrimPrefix(pod.Name, ref.Name+"-"))
+		if err == nil && replicas != nil && int32(ordinal) >= *replicas {
+			return &Deletion{Reason: DeletionScaledDown, Message: fmt.Sprintf("%s scaled to %d replicas", owner, *replicas)}
+		}
+	default:
+		return nil
+	}
+	switch {
+	case deleting:
+		return &Deletion{Reason: DeletionScaledDown, Message: owner + " is being deleted"}
+	case replicas != nil && (*replicas == 0 || *replicas < current):
+		return &Deletion{Reason: DeletionScaledDown, Message: fmt.Sprintf("%s scaled to %d replicas", owner, *replicas)}
+	}
+	return nil
+}
+
+// containsWord reports whether the word appears in the text, delimited by spaces or the ends of the text
+func containsWord(text, word string) bool {
+	for _, field := range strings.Fields(text) {
+		if field == word {
+			return true
+		}
+	}
+	return false
+}
+
+// fromNode infers a drain from the node of the pod: cordoned, not ready, or removed
+func (a *deletionAttributor) fromNode(clientset kubernetes.Interface, pod *corev1.Pod) *Deletion {
+	if pod.Spec.NodeName == "" {
+		return nil
+	}
+	node, err := clientset.CoreV1().Nodes().Get(a.ctx, pod.Spec.NodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &Deletion{Reason: DeletionNodeDrained, Message: "node " + pod.Spec.NodeName + " was removed"}
+	} else if err != nil {
+		slog.Debug("Could not get the node of the deleted pod", "pod", pod.Namespace+"/"+pod.Name, "node", pod.Spec.NodeName, "error", err)
+		return nil
+	}
+	if node.Spec.Unschedulable {
+		return &Deletion{Reason: DeletionNodeDrained, Message: "node " + node.Name + " is cordoned"}
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status != corev1.ConditionTrue {
+			return &Deletion{Reason: DeletionNodeDrained, Message: "node " + node.Name + " is NotReady"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package watcher
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// expectGolden compares got to testdata/name, rewriting the file instead with -update
+func expectGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s does not match, got:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		before, after string
+		want          string
+	}{{
+		name:   "identical",
+		before: "a: 1\nb: 2\n",
+		after:  "a: 1\nb: 2\n",
+		want:   "",
+	}, {
+		name:   "added",
+		before: "a: 1\nc: 3\n",
+		after:  "a: 1\nb: 2\nc: 3\n",
+		want:   "@@ -1,2 +1,3 @@\n a: 1\n+b: 2\n c: 3\n",
+	}, {
+		name:   "removed",
+		before: "a: 1\nb: 2\nc: 3\n",
+		after:  "a: 1\nc: 3\n",
+		want:   "@@ -1,3 +1,2 @@\n a: 1\n-b: 2\n c: 3\n",
+	}, {
+		name:   "changed",
+		before: "a: 1\nb: 2\nc: 3\n",
+		after:  "a: 1\nb: 4\nc: 3\n",
+		want:   "@@ -1,3 +1,3 @@\n a: 1\n-b: 2\n+b: 4\n c: 3\n",
+	}, {
+		name:   "from nothing",
+		before: "",
+		after:  "a: 1\n",
+		want:   "@@ -1,0 +1,1 @@\n+a: 1\n",
+	}, {
+		name:   "distant changes",
+		before: "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+		after:  "A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+		want:   "@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+	}, {
+		name:   "close changes",
+		before: "a\n1\n2\n3\n4\n5\nb\n",
+		after:  "A\n1\n2\n3\n4\n5\nB\n",
+		want:   "@@ -1,7 +1,7 @@\n-a\n+A\n 1\n 2\n 3\n 4\n 5\n-b\n+B\n",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := unifiedDiff(tc.before, tc.after); got != tc.want {
+				t.Errorf("got diff:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(pod *corev1.Pod)
+	}{
+		{name: "added", modify: func(pod *corev1.Pod) { pod.Labels = map[string]string{"app": "web", "tier": "frontend"} }},
+		{name: "removed", modify: func(pod *corev1.Pod) { pod.Labels = nil }},
+		{name: "changed", modify: func(pod *corev1.Pod) { pod.Status.Phase = corev1.PodRunning }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			e, err := newEventWriter(&out, OutputDiff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The first revision is written in full, the next as a diff against it, and an unchanged one not at all
+			event := testPodEvent("ADDED", "web-1", corev1.PodPending)
+			pod := event.Object.(*corev1.Pod)
+			pod.Labels = map[string]string{"app": "web"}
+			if err := e.Write(event); err != nil {
+				t.Fatal(err)
+			}
+			pod = pod.DeepCopy()
+			tc.modify(pod)
+			event.Type, event.Object = "MODIFIED", pod
+			for range 2 {
+				if err := e.Write(event); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expectGolden(t, filepath.Join("diff", tc.name+".golden"), out.Bytes())
+		})
+	}
+}
@@ -0,0 +1,207 @@
+//go:build envtest
+
+package watcher
+
+// The envtest suite runs the watcher against a real API server: the etcd and kube-apiserver binaries of
+// controller-runtime's envtest, installed with setup-envtest, without a kubelet or controllers:
+//
+//	KUBEBUILDER_ASSETS=$(setup-envtest use -p path) go test -tags envtest ./pkg/watcher
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// envtestToken authenticates the tests as a member of system:masters
+const envtestToken = "envtest-token"
+
+// startControlPlane starts etcd and kube-apiserver from $KUBEBUILDER_ASSETS, skipping the test without it,
+// and returns the cluster served once the API server is ready; both are stopped when the test ends
+func startControlPlane(t *testing.T) (Cluster, kubernetes.Interface) {
+	t.Helper()
+	assets := os.Getenv("KUBEBUILDER_ASSETS")
+	if assets == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set; install the envtest binaries with setup-envtest")
+	}
+	dir := t.TempDir()
+	etcdURL := "http://127.0.0.1:" + freePort(t)
+	startProcess(t, filepath.Join(assets, "etcd"),
+		"--data-dir", filepath.Join(dir, "etcd"),
+		"--listen-client-urls", etcdURL,
+		"--advertise-client-urls", etcdURL,
+		"--listen-peer-urls", "http://127.0.0.1:"+freePort(t),
+		"--unsafe-no-fsync")
+
+	serviceAccountKey := filepath.Join(dir, "sa.key")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(serviceAccountKey, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens := filepath.Join(dir, "tokens.csv")
+	if err := os.WriteFile(tokens, []byte(envtestToken+`,admin,admin,"system:masters"`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	port := freePort(t)
+	startProcess(t, filepath.Join(assets, "kube-apiserver"),
+		"--etcd-servers", etcdURL,
+		"--bind-address", "127.0.0.1",
+		"--secure-port", port,
+		"--cert-dir", filepath.Join(dir, "certs"),
+		"--token-auth-file", tokens,
+		"--authorization-mode", "RBAC",
+		"--service-cluster-ip-range", "10.0.0.0/24",
+		"--service-account-issuer", "https://kubernetes.default.svc",
+		"--service-account-key-file", serviceAccountKey,
+		"--service-account-signing-key-file", serviceAccountKey,
+		// Without the controllers, the namespaces have no default service account for the pods
+		"--disable-admission-plugins", "ServiceAccount")
+
+	config := &rest.Config{Host: "https://127.0.0.1:" + port, BearerToken: envtestToken, TLSClientConfig: rest.TLSClientConfig{Insecure: true}}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The API server is ready once it serves /readyz and has created the default namespace
+	deadline := time.Now().Add(time.Minute)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := clientset.Discovery().RESTClient().Get().AbsPath("/readyz").DoRaw(ctx)
+		if err == nil {
+			_, err = clientset.CoreV1().Namespaces().Get(ctx, metav1.NamespaceDefault, metav1.GetOptions{})
+		}
+		cancel()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the API server is not ready: %v", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return Cluster{Config: config}, clientset
+}
+
+// startProcess runs the binary until the test ends, failing it if the binary cannot start
+func startProcess(t *testing.T, path string, args ...string) {
+	t.Helper()
+	cmd := exec.Command(path, args...)
+	logs, err := os.Create(filepath.Join(t.TempDir(), filepath.Base(path)+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Stdout, cmd.Stderr = logs, logs
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("could not start %s: %v", path, err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = logs.Close()
+		if t.Failed() {
+			if data, err := os.ReadFile(logs.Name()); err == nil {
+				t.Logf("%s:\n%s", filepath.Base(path), data)
+			}
+		}
+	})
+}
+
+// freePort returns a port of the loopback interface that was free a moment ago
+func freePort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// createPod creates an unscheduled pod carrying the env variable, if any, which the API server deletes at once
+func createPod(t *testing.T, clientset kubernetes.Interface, namespace, name, env string) *corev1.Pod {
+	t.Helper()
+	pod := fakePod(namespace, name)
+	pod.Status = corev1.PodStatus{}
+	pod.Spec.Containers[0].Env = nil
+	if env != "" {
+		pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: env, Value: "true"}}
+	}
+	created, err := clientset.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+// createNamespace creates the namespace
+func createNamespace(t *testing.T, clientset kubernetes.Interface, name string) {
+	t.Helper()
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if _, err := clientset.CoreV1().Namespaces().Create(context.Background(), namespace, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvtestWatchLifecycle(t *testing.T) {
+	cluster, clientset := startControlPlane(t)
+	events := fakeWatch(t, cluster, WithMarkers(testMarker))
+
+	pod := createPod(t, clientset, metav1.NamespaceDefault, "web", testMarker)
+	expectEvent(t, events, "ADDED", "default/web")
+	pod.Status.Phase = corev1.PodRunning
+	if _, err := clientset.CoreV1().Pods(pod.Namespace).UpdateStatus(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	event := expectEvent(t, events, "MODIFIED", "default/web")
+	if phase := event.Object.(*corev1.Pod).Status.Phase; phase != corev1.PodRunning {
+		t.Errorf("MODIFIED event carries phase %s, want %s", phase, corev1.PodRunning)
+	}
+	if err := clientset.CoreV1().Pods(pod.Namespace).Delete(context.Background(), pod.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, "DELETED", "default/web")
+}
+
+func TestEnvtestWatchFilters(t *testing.T) {
+	cluster, clientset := startControlPlane(t)
+	for _, namespace := range []string{"team-a", "team-b"} {
+		createNamespace(t, clientset, namespace)
+	}
+	events := fakeWatch(t, cluster, WithMarkers(testMarker), WithNamespaces("team-a"))
+
+	// Neither the pod without the marker nor that of another namespace is emitted, before the matching one
+	createPod(t, clientset, "team-a", "unmarked", "")
+	createPod(t, clientset, "team-b", "elsewhere", testMarker)
+	createPod(t, clientset, "team-a", "marked", testMarker)
+	expectEvent(t, events, "ADDED", "team-a/marked")
+}
+
+func TestEnvtestWatchFieldSelector(t *testing.T) {
+	cluster, clientset := startControlPlane(t)
+	// The field selectors are evaluated by the API server, which the fake clientset does not emulate
+	events := fakeWatch(t, cluster, WithMarkers(testMarker), WithFieldSelector("metadata.name!=ignored"))
+
+	createPod(t, clientset, metav1.NamespaceDefault, "ignored", testMarker)
+	for i := range 3 {
+		createPod(t, clientset, metav1.NamespaceDefault, fmt.Sprintf("web-%d", i), testMarker)
+		expectEvent(t, events, "ADDED", fmt.Sprintf("default/web-%d", i))
+	}
+}
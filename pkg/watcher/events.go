@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// runEventInformer watches the Kubernetes Events about pods in one namespace (metav1.NamespaceAll for every namespace)
+// of a cluster, handing each new or updated Event to the processor until the context is canceled.
+// Events that already existed when the watcher started are not reported, like the pods themselves.
+func (w *Watcher) runEventInformer(ctx context.Context, c *cluster, namespace string, processor *eventProcessor) error {
+	events := c.clientset.CoreV1().Events(namespace)
+	selector := fields.OneTermEqualSelector("involvedObject.kind", "Pod").String()
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return events.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			if timeout := watchTimeoutSeconds(w.watchTimeout); timeout != nil {
+				options.TimeoutSeconds = timeout
+			}
+			return events.Watch(ctx, options)
+		},
+	}
+	informer := cache.NewSharedIndexInformer(listWatch, &corev1.Event{}, 0, cache.Indexers{})
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if event, ok := obj.(*corev1.Event); ok && !isInInitialList {
+				processor.handleKubeEvent(c.name, event)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// A repeated Event is updated in place with a higher count
+			if event, ok := newObj.(*corev1.Event); ok && !sameResourceVersion(oldObj, newObj) {
+				processor.handleKubeEvent(c.name, event)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not register Kubernetes Event handler: %w", err)
+	}
+	informer.Run(ctx.Done())
+	return nil
+}
+
+// handleKubeEvent emits a Kubernetes Event of the cluster to the sinks if it is about a matched pod
+func (p *eventProcessor) handleKubeEvent(cluster string, event *corev1.Event) {
+	pod := event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name
+	if !p.matched.contains(clusterKey(cluster, pod)) {
+		return
+	}
+	if p.target != nil && !p.target.is(clusterKey(cluster, pod)) {
+		return
+	}
+	var obj runtime.Object = event
+	if p.strip != nil {
+		var err error
+		if obj, err = p.strip.strip(event); err != nil {
+			slog.Error("Failed to strip fields from Event", "event", event.Name, "pod", pod, "error", err)
+			marshalErrors.Inc()
+			return
+		}
+	}
+	// The annotations and --redact-path apply to the Events too, whose messages may quote the values of the pod
+	if p.redact != nil {
+		var err error
+		if obj, err = p.redact.redact(obj); err != nil {
+			slog.Error("Failed to redact fields of Event", "event", event.Name, "pod", pod, "error", err)
+			marshalErrors.Inc()
+			return
+		}
+	}
+	objYAML, err := yaml.Marshal(obj)
+	if err != nil {
+		slog.Error("Failed to marshal Event to YAML", "event", event.Name, "pod", pod, "error", err)
+		marshalErrors.Inc()
+		return
+	}
+	emitted := Event{Type: KubeEvent, Key: objectKey(event), Object: obj, Timestamp: time.Now().UTC(), Cluster: cluster, yaml: string(objYAML)}
+	p.sinks.write(emitted)
+	p.publish(emitted)
+	eventsEmitted.WithLabelValues(KubeEvent).Inc()
+	p.health.eventEmitted()
+}
@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// exclusionFilter drops objects that match the positive criteria but are in an excluded namespace,
+// contain an excluded marker, or match the excluded label selector. Any one of them excludes the object.
+type exclusionFilter struct {
+	namespaces map[string]bool
+	markers    *markerFilter // nil without --exclude-marker; matched against the same fields as the markers
+	selector   labels.Selector
+	names      []string // the exclusions as given, for logging
+}
+
+// newExclusionFilter compiles the exclusion flags, returning nil when nothing is excluded
+func newExclusionFilter(namespaces []string, markers []string, labelSelector string, markerPaths []string) (*exclusionFilter, error) {
+	f := &exclusionFilter{namespaces: make(map[string]bool)}
+	for _, namespace := range namespaces {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			f.namespaces[namespace] = true
+			f.names = append(f.names, "namespace="+namespace)
+		}
+	}
+	if len(markers) > 0 {
+		var err error
+		if f.markers, err = newMarkerFilter(markers, nil, false, markerPaths); err != nil {
+			return nil, err
+		}
+		f.names = append(f.names, "markers="+f.markers.String())
+	}
+	if labelSelector != "" {
+		selector, err := labels.Parse(labelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --exclude-label-selector %q: %w", labelSelector, err)
+		}
+		f.selector = selector
+		f.names = append(f.names, "labels="+labelSelector)
+	}
+	if len(f.names) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// excludes reports whether the object, given its YAML serialization, is excluded
+func (f *exclusionFilter) excludes(obj runtime.Object, objYAML string) bool {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	if f.namespaces[objMeta.GetNamespace()] {
+		return true
+	}
+	if f.selector != nil && f.selector.Matches(labels.Set(objMeta.GetLabels())) {
+		return true
+	}
+	return f.markers != nil && f.markers.matchesObject(obj, objYAML)
+}
+
+// String describes the exclusions for logging; nil excludes nothing
+func (f *exclusionFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.names, " OR ")
+}
@@ -0,0 +1,135 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeServerVersion is the version of the API server of NewFakeCluster, matching client-go v0.32
+const fakeServerVersion = "v1.32.2"
+
+// FakeMarker is found in the pods played by PlayFakeEvents, so that they match with --marker
+const FakeMarker = "DEBUG_MODE"
+
+// NewFakeCluster returns a cluster served by the fake clientset of client-go, which keeps its objects in memory,
+// to run the watcher without a cluster: the pods created, updated and deleted through the clientset,
+// e.g. by PlayFakeEvents, are watched like those of a real cluster. It has a single ready node, named node-1,
+// and reports the version of Kubernetes of the client-go it is built with.
+func NewFakeCluster(name string) (Cluster, kubernetes.Interface) {
+	clientset := fake.NewClientset(fakeNode("node-1"))
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{Major: "1", Minor: "32", GitVersion: fakeServerVersion}
+	return Cluster{Name: name, Clientset: clientset}, clientset
+}
+
+// PlayFakeEvents plays the canned lifecycle of a pod through the clientset, typically that of NewFakeCluster, in the
+// namespace, over and over until the context is canceled: it is created, scheduled, started, becomes ready, has its
+// container crash and restart, and is deleted, one step per interval. The pods carry FakeMarker in an environment variable.
+func PlayFakeEvents(ctx context.Context, clientset kubernetes.Interface, namespace string, interval time.Duration) error {
+	pods := clientset.CoreV1().Pods(namespace)
+	version := 0
+	for n := 1; ; n++ {
+		pod := fakePod(namespace, fmt.Sprintf("demo-%d", n))
+		version++
+		pod.ResourceVersion = strconv.Itoa(version)
+		steps := []func(pod *corev1.Pod){
+			func(pod *corev1.Pod) {
+				pod.Spec.NodeName = "node-1"
+				setFakeCondition(pod, corev1.PodScheduled, corev1.ConditionTrue)
+			},
+			func(pod *corev1.Pod) {
+				pod.Status.Phase = corev1.PodRunning
+				pod.Status.PodIP = "10.0.0.10"
+				pod.Status.ContainerStatuses[0].ImageID = "docker.io/library/busybox@sha256:0000"
+				pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()}}
+			},
+			func(pod *corev1.Pod) {
+				pod.Status.ContainerStatuses[0].Ready = true
+				setFakeCondition(pod, corev1.PodReady, corev1.ConditionTrue)
+			},
+			func(pod *corev1.Pod) {
+				status := &pod.Status.ContainerStatuses[0]
+				status.Ready = false
+				status.RestartCount++
+				status.LastTerminationState = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled", FinishedAt: metav1.Now()}}
+				status.State = corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off 10s restarting failed container"}}
+				setFakeCondition(pod, corev1.PodReady, corev1.ConditionFalse)
+			},
+			func(pod *corev1.Pod) {
+				status := &pod.Status.ContainerStatuses[0]
+				status.Ready = true
+				status.State = corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()}}
+				setFakeCondition(pod, corev1.PodReady, corev1.ConditionTrue)
+			},
+		}
+		created, err := pods.Create(ctx, pod, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("could not create fake pod: %w", err)
+		}
+		slog.Debug("Created fake pod", "namespace", namespace, "name", created.Name)
+		for _, step := range append(steps, nil) {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+			if step == nil {
+				break
+			}
+			step(created)
+			// The fake clientset keeps resource versions as they are given, while the informers need them to change
+			version++
+			created.ResourceVersion = strconv.Itoa(version)
+			if created, err = pods.UpdateStatus(ctx, created, metav1.UpdateOptions{}); err != nil {
+				return fmt.Errorf("could not update fake pod: %w", err)
+			}
+		}
+		if err := pods.Delete(ctx, created.Name, metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("could not delete fake pod: %w", err)
+		}
+	}
+}
+
+// fakePod returns a pending pod with one container
+func fakePod(namespace, name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": "demo"}, CreationTimestamp: metav1.Now()},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "app",
+			Image: "busybox",
+			Env:   []corev1.EnvVar{{Name: "MODE", Value: FakeMarker}},
+		}}},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodPending,
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "app", Image: "busybox", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}}},
+		},
+	}
+}
+
+// fakeNode returns a ready node, on which the pods of PlayFakeEvents are scheduled
+func fakeNode(name string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.Now()},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue, LastTransitionTime: metav1.Now()}}},
+	}
+}
+
+// setFakeCondition sets the status of a condition of the pod, adding it if needed
+func setFakeCondition(pod *corev1.Pod, conditionType corev1.PodConditionType, status corev1.ConditionStatus) {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == conditionType {
+			pod.Status.Conditions[i].Status, pod.Status.Conditions[i].LastTransitionTime = status, metav1.Now()
+			return
+		}
+	}
+	pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{Type: conditionType, Status: status, LastTransitionTime: metav1.Now()})
+}
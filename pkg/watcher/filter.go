@@ -0,0 +1,139 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// markerFilter matches the serialized object against the --marker substrings and --marker-regex expressions.
+// By default any one of them matching is enough; with --marker-all every one of them must match.
+// A filter without markers matches everything, leaving the selection to the server-side selectors.
+// When --marker-path expressions are given the markers are only matched against the values they select.
+type markerFilter struct {
+	substrings []string
+	regexes    []*regexp.Regexp
+	all        bool
+	paths      []*jsonpath.JSONPath
+	pathNames  []string // the --marker-path expressions as given, for logging
+}
+
+// newMarkerFilter compiles the marker flags into a filter
+func newMarkerFilter(substrings []string, expressions []string, all bool, paths []string) (*markerFilter, error) {
+	f := &markerFilter{substrings: substrings, all: all}
+	for _, expression := range expressions {
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --marker-regex %q: %w", expression, err)
+		}
+		f.regexes = append(f.regexes, re)
+	}
+	for _, path := range paths {
+		jp := jsonpath.New(path).AllowMissingKeys(true)
+		if err := jp.Parse(jsonpathTemplate(path)); err != nil {
+			return nil, fmt.Errorf("invalid --marker-path %q: %w", path, err)
+		}
+		f.paths = append(f.paths, jp)
+		f.pathNames = append(f.pathNames, path)
+	}
+	return f, nil
+}
+
+// jsonpathTemplate turns a kubectl-style field path such as "spec.containers[*].image"
+// into a JSONPath template; paths already written as templates ("{.spec.nodeName}") are kept as-is.
+func jsonpathTemplate(path string) string {
+	if strings.HasPrefix(path, "{") {
+		return path
+	}
+	if !strings.HasPrefix(path, ".") {
+		path = "." + path
+	}
+	return "{" + path + "}"
+}
+
+// matchesObject reports whether the object satisfies the markers, given its YAML serialization
+func (f *markerFilter) matchesObject(obj runtime.Object, objYAML string) bool {
+	if len(f.paths) == 0 {
+		return f.matches(objYAML)
+	}
+	values, err := f.pathValues(obj)
+	if err != nil {
+		return false
+	}
+	return f.matches(strings.Join(values, "\n"))
+}
+
+// pathValues returns the values selected by the --marker-path expressions, rendered as text
+func (f *markerFilter) pathValues(obj runtime.Object) ([]string, error) {
+	content, err := objectContent(obj)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, jp := range f.paths {
+		results, err := jp.FindResults(content)
+		if err != nil {
+			continue // e.g. indexing into a value of the wrong type; treat as no match
+		}
+		for _, result := range results {
+			for _, value := range result {
+				if s, ok := value.Interface().(string); ok {
+					values = append(values, s)
+				} else if data, err := json.Marshal(value.Interface()); err == nil {
+					values = append(values, string(data))
+				}
+			}
+		}
+	}
+	return values, nil
+}
+
+// objectContent returns the object as the generic map JSONPath expressions are evaluated against
+func objectContent(obj runtime.Object) (map[string]interface{}, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u.Object, nil
+	}
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// matches reports whether the text satisfies the markers
+func (f *markerFilter) matches(text string) bool {
+	if len(f.substrings) == 0 && len(f.regexes) == 0 {
+		return true
+	}
+	for _, substring := range f.substrings {
+		if strings.Contains(text, substring) != f.all {
+			return !f.all // a hit in OR mode, a miss in AND mode
+		}
+	}
+	for _, re := range f.regexes {
+		if re.MatchString(text) != f.all {
+			return !f.all
+		}
+	}
+	return f.all
+}
+
+// String describes the filter for logging
+func (f *markerFilter) String() string {
+	var parts []string
+	for _, substring := range f.substrings {
+		parts = append(parts, fmt.Sprintf("%q", substring))
+	}
+	for _, re := range f.regexes {
+		parts = append(parts, fmt.Sprintf("/%s/", re))
+	}
+	joined := strings.Join(parts, " OR ")
+	if f.all {
+		joined = strings.Join(parts, " AND ")
+	}
+	if len(f.paths) == 0 {
+		return joined
+	}
+	return fmt.Sprintf("%s in %s", joined, strings.Join(f.pathNames, ","))
+}
@@ -0,0 +1,69 @@
+package watcher
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestJSONPathTemplate(t *testing.T) {
+	for path, want := range map[string]string{
+		"spec.nodeName":            "{.spec.nodeName}",
+		".spec.nodeName":           "{.spec.nodeName}",
+		"spec.containers[*].image": "{.spec.containers[*].image}",
+		"{.spec.nodeName}":         "{.spec.nodeName}",
+	} {
+		if got := jsonpathTemplate(path); got != want {
+			t.Errorf("jsonpathTemplate(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestMarkerPaths(t *testing.T) {
+	pod := fakePod("default", "web")
+	pod.Spec.NodeName = "gpu-1"
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "sidecar", Image: "envoy", Args: []string{"--level", "trace"}})
+	pod.Annotations = map[string]string{"example.com/owner": "payments"}
+	for _, tc := range []struct {
+		name    string
+		paths   []string
+		markers []string
+		all     bool
+		want    bool
+	}{
+		{name: "top level", paths: []string{"spec.nodeName"}, markers: []string{"gpu-"}, want: true},
+		{name: "nested", paths: []string{"status.containerStatuses[0].state.waiting.reason"}, markers: []string{"ContainerCreating"}, want: true},
+		{name: "nested elsewhere", paths: []string{"spec.nodeName"}, markers: []string{FakeMarker}},
+		{name: "every element", paths: []string{"spec.containers[*].image"}, markers: []string{"envoy"}, want: true},
+		{name: "nested in every element", paths: []string{"spec.containers[*].env[*].value"}, markers: []string{FakeMarker}, want: true},
+		{name: "array index", paths: []string{"spec.containers[1].image"}, markers: []string{"busybox"}},
+		{name: "array element", paths: []string{"spec.containers[1].args"}, markers: []string{`"trace"`}, want: true},
+		{name: "array out of range", paths: []string{"spec.containers[5].image"}, markers: []string{"busybox"}},
+		{name: "filter expression", paths: []string{`{.spec.containers[?(@.name=="sidecar")].image}`}, markers: []string{"envoy"}, want: true},
+		{name: "escaped map key", paths: []string{`metadata.annotations.example\.com/owner`}, markers: []string{"payments"}, want: true},
+		{name: "missing field", paths: []string{"spec.priorityClassName"}, markers: []string{"web"}},
+		{name: "indexing a string", paths: []string{"spec.nodeName[0]"}, markers: []string{"gpu-"}},
+		{name: "any path", paths: []string{"spec.nodeName", "spec.containers[*].image"}, markers: []string{"envoy"}, want: true},
+		{name: "all markers across paths", paths: []string{"spec.nodeName", "spec.containers[*].image"}, markers: []string{"gpu-", "envoy"}, all: true, want: true},
+		{name: "all markers missing one", paths: []string{"spec.nodeName"}, markers: []string{"gpu-", "envoy"}, all: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newMarkerFilter(tc.markers, nil, tc.all, tc.paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The markers only apply to the selected values, never to the rest of the object
+			if matched := f.matchesObject(pod, "name: web\nenvoy gpu- "+FakeMarker); matched != tc.want {
+				t.Errorf("%s matched: %v, want %v", f, matched, tc.want)
+			}
+		})
+	}
+}
+
+func TestMarkerPathInvalid(t *testing.T) {
+	_, err := newMarkerFilter([]string{"x"}, nil, false, []string{"spec.containers[*"})
+	if err == nil || !strings.Contains(err.Error(), "--marker-path") {
+		t.Fatalf("got error %v, want an invalid --marker-path", err)
+	}
+}
@@ -0,0 +1,57 @@
+package watcher
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+
+	flowcontrolv1 "k8s.io/api/flowcontrol/v1"
+	"k8s.io/client-go/rest"
+)
+
+// RateLimit configures the client-side rate limiter of a REST config, and reports how the API server's
+// API Priority and Fairness classifies and throttles the requests made with it: the flow schema and priority level
+// it answers with are logged once, and each request it rejects as too many, which client-go retries after the delay
+// it asks for, is counted in pod_watcher_api_throttled_total by priority level.
+// A zero qps or burst keeps the client-go default, 5 requests per second with bursts of 10.
+func RateLimit(config *rest.Config, qps float32, burst int) {
+	if qps > 0 {
+		config.QPS = qps
+	}
+	if burst > 0 {
+		config.Burst = burst
+	}
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &flowControlTransport{next: rt, host: config.Host}
+	})
+}
+
+// flowControlTransport notes the API Priority and Fairness headers of the responses
+type flowControlTransport struct {
+	next      http.RoundTripper
+	host      string
+	once      sync.Once // logs the classification of the requests
+	throttled sync.Once // warns of the first throttled request, the rest are counted
+}
+
+func (t *flowControlTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	flowSchema := resp.Header.Get(flowcontrolv1.ResponseHeaderMatchedFlowSchemaUID)
+	priorityLevel := resp.Header.Get(flowcontrolv1.ResponseHeaderMatchedPriorityLevelConfigurationUID)
+	if priorityLevel != "" {
+		t.once.Do(func() {
+			slog.Debug("Requests are classified by API Priority and Fairness", "host", t.host, "flowSchemaUID", flowSchema, "priorityLevelUID", priorityLevel)
+		})
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		apiThrottled.WithLabelValues(priorityLevel).Inc()
+		t.throttled.Do(func() {
+			slog.Warn("The API server is throttling the requests, consider lowering --qps or raising the priority of pod-watcher",
+				"host", t.host, "path", req.URL.Path, "retryAfter", resp.Header.Get("Retry-After"), "priorityLevelUID", priorityLevel)
+		})
+	}
+	return resp, nil
+}
@@ -0,0 +1,108 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Framings of the records of the output streams and files, as set by WithFraming.
+// A record is an event document, a notice, a log line, or a document about the watch such as a heartbeat.
+const (
+	FramingYAML           = "yaml"            // a standard YAML stream: each document starts with --- and ends with ...
+	FramingJSONSeq        = "json-seq"        // a JSON text sequence (RFC 7464): each record starts with RS (0x1E) and ends with LF
+	FramingLengthPrefixed = "length-prefixed" // each record is preceded by its length in bytes, as a 4-byte big-endian unsigned integer
+	FramingNUL            = "nul"             // each record ends with a NUL byte
+)
+
+// recordSeparator starts each record of a JSON text sequence
+const recordSeparator = '\x1e'
+
+// WithFraming frames the records of the output streams and files of WithOutput and WithOutputFile for stream processors:
+// FramingYAML, FramingJSONSeq, FramingLengthPrefixed or FramingNUL. Without it each output format uses its own
+// separators, such as the --- and blank line of the YAML format. Framed output is never colorized.
+func WithFraming(framing string) Option {
+	return func(w *Watcher) { w.framing = framing }
+}
+
+// checkFraming verifies that the records of the output format can be framed as requested: only the JSON formats make
+// a JSON text sequence, and only the YAML and JSON formats a YAML stream
+func checkFraming(framing, format string) error {
+	switch framing {
+	case "", FramingLengthPrefixed, FramingNUL:
+		return nil
+	case FramingJSONSeq:
+		switch format {
+		case OutputJSON, OutputJSONL, OutputCloudEvents:
+			return nil
+		}
+		return fmt.Errorf("the %s framing requires the %s, %s or %s output format, not %q", framing, OutputJSON, OutputJSONL, OutputCloudEvents, format)
+	case FramingYAML:
+		switch format {
+		case OutputYAML, OutputJSON, OutputJSONL, OutputCloudEvents:
+			return nil
+		}
+		return fmt.Errorf("the %s framing requires the %s, %s, %s or %s output format, not %q", framing, OutputYAML, OutputJSON, OutputJSONL, OutputCloudEvents, format)
+	default:
+		return fmt.Errorf("unsupported framing %q (must be one of %s, %s, %s or %s)", framing, FramingYAML, FramingJSONSeq, FramingLengthPrefixed, FramingNUL)
+	}
+}
+
+// frame makes the writer buffer each record and write it framed once complete, in a framing checked by checkFraming
+func (e *eventWriter) frame(framing string) {
+	if framing == "" {
+		return
+	}
+	e.framer = &framer{w: e.w, framing: framing}
+	e.w, e.colors = e.framer, nil
+}
+
+// framer collects the writes of a record, then writes it framed to w
+type framer struct {
+	w       io.Writer
+	framing string
+	record  bytes.Buffer
+}
+
+func (f *framer) Write(p []byte) (int, error) {
+	return f.record.Write(p)
+}
+
+// flush writes the record collected since the previous flush, if any, without the separators of the output format
+func (f *framer) flush() error {
+	if f.record.Len() == 0 {
+		return nil
+	}
+	record := strings.TrimRight(strings.TrimPrefix(f.record.String(), "---\n"), "\n")
+	f.record.Reset()
+	var framed []byte
+	switch f.framing {
+	case FramingYAML:
+		if commentsOnly(record) {
+			// Notices and log lines are comments, which are valid between the documents
+			framed = []byte(record + "\n")
+		} else {
+			framed = []byte("---\n" + record + "\n...\n")
+		}
+	case FramingJSONSeq:
+		framed = []byte(string(recordSeparator) + record + "\n")
+	case FramingLengthPrefixed:
+		framed = append(binary.BigEndian.AppendUint32(nil, uint32(len(record))), record...)
+	case FramingNUL:
+		framed = []byte(record + "\x00")
+	}
+	_, err := f.w.Write(framed)
+	return err
+}
+
+// commentsOnly reports whether every line of the record is a comment
+func commentsOnly(record string) bool {
+	for _, line := range strings.Split(record, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,274 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Roles of a watcher, as reported by /status
+const (
+	roleStarting = "starting" // Run has not been called yet
+	roleWatching = "watching"
+	roleStandby  = "standby" // waiting for the leader election lease
+	roleStopped  = "stopped" // Run has returned
+)
+
+// healthState tracks the state of the watches for the health endpoints
+type healthState struct {
+	mu          sync.Mutex
+	role        string
+	started     time.Time
+	informers   map[string]*informerHealth // namespace, qualified by its cluster and shard -> state of its informer
+	expected    int                        // informers of the current watch, known once its shards are planned
+	matched     *matchSet                  // of the current watch; nil while not watching
+	received    int64
+	emitted     int64
+	lastEvent   time.Time // last event received from an informer
+	lastEmitted time.Time
+}
+
+// informerHealth is the state of the informer of one namespace, or of one shard of it
+type informerHealth struct {
+	cluster       string
+	namespace     string
+	shard         string      // e.g. "2/4" with --shards, empty otherwise
+	synced        func() bool // whether the initial list has been delivered
+	connected     bool        // whether the latest list and watch succeeded
+	lastError     string
+	lastErrorTime time.Time
+}
+
+func newHealthState() *healthState {
+	return &healthState{role: roleStarting, informers: make(map[string]*informerHealth)}
+}
+
+// setRole records what the watcher is doing
+func (h *healthState) setRole(role string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.role = role
+	if role == roleWatching && h.started.IsZero() {
+		h.started = time.Now().UTC()
+	}
+}
+
+// startWatch records the start of a watch matching into the set
+func (h *healthState) startWatch(matched *matchSet) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.matched = matched
+}
+
+// endWatch forgets the informers and matches of a watch that ended
+func (h *healthState) endWatch() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.matched = nil
+	h.expected = 0
+	clear(h.informers)
+}
+
+// planInformers records the number of informers of the current watch, which it is not ready without
+func (h *healthState) planInformers(informers int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expected = informers
+}
+
+// addInformer registers the informer of a namespace (or shard of it) of a cluster, reporting it as synced once synced returns true
+func (h *healthState) addInformer(cluster, namespace, shard string, synced func() bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.informers[informerKey(cluster, namespace, shard)] = &informerHealth{cluster: cluster, namespace: namespace, shard: shard, synced: synced}
+}
+
+// informerKey identifies the informer of a namespace, or of a shard of it
+func informerKey(cluster, namespace, shard string) string {
+	key := clusterKey(cluster, namespace)
+	if shard != "" {
+		key += "#" + shard
+	}
+	return key
+}
+
+// connected records that the informer of a namespace has established its watch
+func (h *healthState) connected(cluster, namespace, shard string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if informer := h.informers[informerKey(cluster, namespace, shard)]; informer != nil {
+		informer.connected = true
+	}
+}
+
+// failed records that the list or watch of a namespace failed; the informer retries with a backoff
+func (h *healthState) failed(cluster, namespace, shard string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if informer := h.informers[informerKey(cluster, namespace, shard)]; informer != nil {
+		informer.connected = false
+		informer.lastError, informer.lastErrorTime = err.Error(), time.Now().UTC()
+	}
+}
+
+// eventReceived records an event received from an informer
+func (h *healthState) eventReceived() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.received++
+	h.lastEvent = time.Now().UTC()
+}
+
+// eventEmitted records an event emitted to the sinks
+func (h *healthState) eventEmitted() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.emitted++
+	h.lastEmitted = time.Now().UTC()
+}
+
+// ready reports whether the watcher is watching with every informer synced and connected, or standing by for the lease
+func (h *healthState) ready() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.readyLocked()
+}
+
+func (h *healthState) readyLocked() bool {
+	switch h.role {
+	case roleStandby:
+		return true
+	case roleWatching:
+	default:
+		return false
+	}
+	if h.expected == 0 || len(h.informers) < h.expected {
+		return false
+	}
+	for _, informer := range h.informers {
+		if !informer.connected || !informer.synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// watcherStatus is the document served by /status
+type watcherStatus struct {
+	Role            string            `json:"role"`
+	Ready           bool              `json:"ready"`
+	Since           *time.Time        `json:"since,omitempty"` // when the watcher first started watching
+	MatchedObjects  int               `json:"matchedObjects"`
+	EventsReceived  int64             `json:"eventsReceived"`
+	EventsEmitted   int64             `json:"eventsEmitted"`
+	LastEventTime   *time.Time        `json:"lastEventTime,omitempty"`
+	LastEmittedTime *time.Time        `json:"lastEmittedTime,omitempty"`
+	Namespaces      []namespaceStatus `json:"namespaces"`
+}
+
+// namespaceStatus is the state of the informer of one namespace in /status
+type namespaceStatus struct {
+	Cluster       string     `json:"cluster,omitempty"`
+	Namespace     string     `json:"namespace"`
+	Shard         string     `json:"shard,omitempty"` // e.g. "2/4" with --shards
+	Synced        bool       `json:"synced"`
+	Connected     bool       `json:"connected"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// status returns the current status
+func (h *healthState) status() watcherStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := watcherStatus{
+		Role:            h.role,
+		Ready:           h.readyLocked(),
+		Since:           timeOrNil(h.started),
+		EventsReceived:  h.received,
+		EventsEmitted:   h.emitted,
+		LastEventTime:   timeOrNil(h.lastEvent),
+		LastEmittedTime: timeOrNil(h.lastEmitted),
+		Namespaces:      []namespaceStatus{},
+	}
+	if h.matched != nil {
+		status.MatchedObjects = h.matched.size()
+	}
+	for _, informer := range h.informers {
+		status.Namespaces = append(status.Namespaces, namespaceStatus{
+			Cluster:       informer.cluster,
+			Namespace:     namespaceList([]string{informer.namespace}),
+			Shard:         informer.shard,
+			Synced:        informer.synced(),
+			Connected:     informer.connected,
+			LastError:     informer.lastError,
+			LastErrorTime: timeOrNil(informer.lastErrorTime),
+		})
+	}
+	sort.Slice(status.Namespaces, func(i, j int) bool {
+		a, b := status.Namespaces[i], status.Namespaces[j]
+		if a.Cluster != b.Cluster || a.Namespace != b.Namespace {
+			return a.Cluster < b.Cluster || (a.Cluster == b.Cluster && a.Namespace < b.Namespace)
+		}
+		return a.Shard < b.Shard
+	})
+	return status
+}
+
+// timeOrNil omits zero times from the status
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// HealthHandler returns the health endpoints of the watcher:
+// /healthz answers while the process is up, /readyz only once every watch is established (or while standing by
+// for the leader election lease), and /status reports the state of the watches as JSON.
+func (w *Watcher) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(rw http.ResponseWriter, _ *http.Request) {
+		if !w.Ready() {
+			http.Error(rw, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/status", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(rw)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(w.health.status())
+	})
+	return mux
+}
+
+// Ready reports whether the watcher is watching with every watch established and its initial list delivered,
+// or standing by for the leader election lease, as /readyz does
+func (w *Watcher) Ready() bool {
+	return w.health.ready()
+}
+
+// ServeHealth serves the health endpoints of HealthHandler on addr until the context is canceled
+func ServeHealth(ctx context.Context, addr string, handler http.Handler) {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	slog.Info("Serving health endpoints", "address", addr, "paths", "/healthz, /readyz, /status")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Health server failed", "error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// heartbeatDocument is the type of the heartbeat documents in the JSON output formats
+const heartbeatDocument = "HEARTBEAT"
+
+// WithHeartbeatInterval periodically writes a heartbeat document into the output stream and files, logs it, and counts
+// it in pod_watcher_heartbeats_total, so that a quiet watch can be told from a broken one: whether every watch is
+// connected, when the last event was received and emitted, and the number of matched objects.
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(w *Watcher) { w.heartbeatInterval = interval }
+}
+
+// Heartbeat is the state of the watch, as written every WithHeartbeatInterval
+type Heartbeat struct {
+	Type      string    `json:"type"` // always "HEARTBEAT"
+	Timestamp time.Time `json:"timestamp"`
+	Role      string    `json:"role"`      // watching, or standby while waiting for the leader election lease
+	Ready     bool      `json:"ready"`     // whether every watch is connected with its initial list delivered
+	Watches   int       `json:"watches"`   // the watches of the namespaces, or of the shards of them
+	Connected int       `json:"connected"` // the watches whose latest list and watch succeeded
+	// Disconnected names the namespaces, qualified by their cluster, whose watch is not connected, with its last error
+	Disconnected    []string   `json:"disconnected,omitempty"`
+	Pods            int        `json:"pods"` // the matched objects
+	EventsReceived  int64      `json:"eventsReceived"`
+	EventsEmitted   int64      `json:"eventsEmitted"`
+	LastEventTime   *time.Time `json:"lastEventTime,omitempty"`
+	LastEmittedTime *time.Time `json:"lastEmittedTime,omitempty"`
+}
+
+// String formats the heartbeat as a comment line, e.g.
+// "## Heartbeat: watching, ready, 3/3 watches connected, 42 pods, last event 12m0s ago, last emitted 3h0m0s ago"
+func (h *Heartbeat) String() string {
+	state := "not ready"
+	if h.Ready {
+		state = "ready"
+	}
+	text := fmt.Sprintf("## Heartbeat: %s, %s, %d/%d watches connected, %d pods, last event %s, last emitted %s",
+		h.Role, state, h.Connected, h.Watches, h.Pods, sinceText(h.Timestamp, h.LastEventTime), sinceText(h.Timestamp, h.LastEmittedTime))
+	if len(h.Disconnected) > 0 {
+		text += " (disconnected: " + strings.Join(h.Disconnected, ", ") + ")"
+	}
+	return text
+}
+
+// sinceText formats how long before now the time was, "never" for none
+func sinceText(now time.Time, t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return max(now.Sub(*t), 0).Round(time.Second).String() + " ago"
+}
+
+// runHeartbeats writes a heartbeat every interval until the context is canceled
+func (w *Watcher) runHeartbeats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		heartbeat := w.heartbeat(time.Now())
+		heartbeats.Inc()
+		slog.Info("Heartbeat", "role", heartbeat.Role, "ready", heartbeat.Ready, "watches", heartbeat.Watches, "connected", heartbeat.Connected,
+			"pods", heartbeat.Pods, "eventsReceived", heartbeat.EventsReceived, "eventsEmitted", heartbeat.EventsEmitted,
+			"lastEventTime", heartbeat.LastEventTime, "lastEmittedTime", heartbeat.LastEmittedTime)
+		for _, out := range w.writers {
+			out.writeDocument(heartbeatDocument, heartbeat.String(), heartbeat)
+		}
+	}
+}
+
+// heartbeat reads the state of the watch from its health
+func (w *Watcher) heartbeat(now time.Time) *Heartbeat {
+	status := w.health.status()
+	heartbeat := &Heartbeat{Type: heartbeatDocument, Timestamp: now.UTC(), Role: status.Role, Ready: status.Ready, Watches: len(status.Namespaces),
+		Pods: status.MatchedObjects, EventsReceived: status.EventsReceived, EventsEmitted: status.EventsEmitted,
+		LastEventTime: status.LastEventTime, LastEmittedTime: status.LastEmittedTime}
+	for _, namespace := range status.Namespaces {
+		if namespace.Connected {
+			heartbeat.Connected++
+			continue
+		}
+		name := clusterKey(namespace.Cluster, namespace.Namespace)
+		if namespace.Shard != "" {
+			name += "#" + namespace.Shard
+		}
+		if namespace.LastError != "" {
+			name += ": " + namespace.LastError
+		}
+		heartbeat.Disconnected = append(heartbeat.Disconnected, name)
+	}
+	return heartbeat
+}
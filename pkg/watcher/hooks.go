@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// execHook runs a user command for each emitted event. The event is described by the
+// POD_WATCHER_EVENT, POD_WATCHER_NAMESPACE, POD_WATCHER_NAME and POD_WATCHER_KEY environment variables
+// and the full object is written to the command's stdin as JSON. At most concurrency commands run at once;
+// further events wait for a free slot, and each command is killed once it exceeds the timeout.
+type execHook struct {
+	command string
+	timeout time.Duration
+	slots   chan struct{}
+	running sync.WaitGroup
+}
+
+func newExecHook(command string, concurrency int, timeout time.Duration) *execHook {
+	return &execHook{
+		command: command,
+		timeout: timeout,
+		slots:   make(chan struct{}, max(concurrency, 1)),
+	}
+}
+
+// run starts the command for the event, blocking only while every slot is busy
+func (h *execHook) run(eventType string, cluster string, key string, obj runtime.Object) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		slog.Error("Failed to marshal object for --exec", "key", key, "error", err)
+		marshalErrors.Inc()
+		return
+	}
+	env := append(os.Environ(), "POD_WATCHER_EVENT="+eventType, "POD_WATCHER_KEY="+key)
+	if cluster != "" {
+		env = append(env, "POD_WATCHER_CLUSTER="+cluster)
+	}
+	if objMeta, err := meta.Accessor(obj); err == nil {
+		env = append(env, "POD_WATCHER_NAMESPACE="+objMeta.GetNamespace(), "POD_WATCHER_NAME="+objMeta.GetName())
+	}
+	h.slots <- struct{}{}
+	h.running.Add(1)
+	go func() {
+		defer h.running.Done()
+		defer func() { <-h.slots }()
+		// Hooks are not tied to the watcher's context so that they can finish during shutdown
+		ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+		defer cancel()
+		cmd := shellCommand(ctx, h.command)
+		cmd.Env = env
+		cmd.Stdin = bytes.NewReader(data)
+		// Hook output goes to stderr so it never mixes with the event stream
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			slog.Warn("--exec hook failed", "type", eventType, "key", key, "error", err)
+			hookFailures.Inc()
+		}
+	}()
+}
+
+// wait blocks until every running command has finished
+func (h *execHook) wait() {
+	h.running.Wait()
+}
+
+// shellCommand runs the command line through the shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
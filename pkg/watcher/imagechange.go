@@ -0,0 +1,194 @@
+package watcher
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// imageTracker remembers the container images last seen for each pod so that
+// MODIFIED events can be suppressed unless the images actually changed.
+type imageTracker struct {
+	mu     sync.Mutex
+	images map[string][]string // "namespace/name" -> sorted container images
+}
+
+func newImageTracker() *imageTracker {
+	return &imageTracker{images: make(map[string][]string)}
+}
+
+// shouldEmit reports whether an event for the pod should be emitted, updating the cache as it goes.
+// ADDED events always emit and seed the cache, DELETED events always emit and evict the pod,
+// and MODIFIED events only emit when the sorted list of container images differs from the last one seen.
+func (t *imageTracker) shouldEmit(eventType watch.EventType, key string, pod *corev1.Pod) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch eventType {
+	case watch.Deleted:
+		delete(t.images, key)
+		return true
+	case watch.Modified:
+		current := containerImages(pod)
+		previous, seen := t.images[key]
+		t.images[key] = current
+		// A pod we have not seen before (e.g. it existed before we started) counts as a change
+		return !seen || !slices.Equal(previous, current)
+	default:
+		t.images[key] = containerImages(pod)
+		return true
+	}
+}
+
+// evict forgets the pod
+func (t *imageTracker) evict(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.images, key)
+}
+
+// containerImages returns the sorted images of the pod's spec.containers
+func containerImages(pod *corev1.Pod) []string {
+	images := make([]string, 0, len(pod.Spec.Containers))
+	for _, c := range pod.Spec.Containers {
+		images = append(images, c.Image)
+	}
+	slices.Sort(images)
+	return images
+}
+
+// ImageChange is a change of the image of one container of a pod between two revisions, carried by IMAGE_CHANGED events:
+// the image of its spec, or the digest it resolved to, as reported by the imageID of its status
+type ImageChange struct {
+	Container  string `json:"container"`
+	Init       bool   `json:"init,omitempty"` // whether it is an init container
+	OldImage   string `json:"oldImage"`
+	NewImage   string `json:"newImage"`
+	OldImageID string `json:"oldImageID,omitempty"`
+	NewImageID string `json:"newImageID,omitempty"`
+}
+
+// String formats the change compactly, e.g. "nginx:1.25 → nginx:1.26" or "nginx:1.25 (sha256:3f2a91c4e0b1 → sha256:8d1e07a2c5f3)"
+func (c *ImageChange) String() string {
+	text := c.OldImage
+	if c.NewImage != c.OldImage {
+		text += " → " + c.NewImage
+	}
+	switch {
+	case c.NewImageID == "":
+		// the digest of the new image is not known until its container runs
+	case c.NewImageID != c.OldImageID:
+		text += " (" + shortDigest(c.OldImageID) + " → " + shortDigest(c.NewImageID) + ")"
+	default:
+		text += " (" + shortDigest(c.NewImageID) + ")"
+	}
+	return text
+}
+
+// shortDigest abbreviates the digest of an image ID, e.g. "docker.io/library/nginx@sha256:3f2a...", to "sha256:3f2a91c4e0b1"
+func shortDigest(imageID string) string {
+	if imageID == "" {
+		return "<none>"
+	}
+	if i := strings.LastIndex(imageID, "@"); i >= 0 {
+		imageID = imageID[i+1:]
+	}
+	algorithm, digest, ok := strings.Cut(imageID, ":")
+	if !ok || len(digest) <= 12 {
+		return imageID
+	}
+	return algorithm + ":" + digest[:12]
+}
+
+// containerImage is the image of a container and the digest it resolved to, "" until it is pulled
+type containerImage struct {
+	image   string
+	imageID string
+	init    bool
+}
+
+// imageChangeTracker records the images and digests of the containers of each pod (--track-images) to report
+// their changes between revisions
+type imageChangeTracker struct {
+	mu     sync.Mutex
+	images map[string]map[string]containerImage // pod key, qualified by its cluster -> container name -> its image
+}
+
+func newImageChangeTracker() *imageChangeTracker {
+	return &imageChangeTracker{images: make(map[string]map[string]containerImage)}
+}
+
+// evict forgets the pod
+func (t *imageChangeTracker) evict(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.images, key)
+}
+
+// update records the images of the containers of the pod, returning their changes since the previous revision.
+// The digest of a container that is pulled for the first time is not a change. A pod seen for the first time only
+// sets the baseline, and a deleted one is forgotten.
+func (t *imageChangeTracker) update(eventType string, key string, pod *corev1.Pod) []*ImageChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if eventType == string(watch.Deleted) {
+		delete(t.images, key)
+		return nil
+	}
+	previous, seen := t.images[key]
+	current := make(map[string]containerImage)
+	var names []string
+	record := func(containers []corev1.Container, statuses []corev1.ContainerStatus, init bool) {
+		imageIDs := make(map[string]string)
+		for _, status := range statuses {
+			imageIDs[status.Name] = status.ImageID
+		}
+		for _, container := range containers {
+			current[container.Name] = containerImage{image: container.Image, imageID: imageIDs[container.Name], init: init}
+			names = append(names, container.Name)
+		}
+	}
+	record(pod.Spec.InitContainers, pod.Status.InitContainerStatuses, true)
+	record(pod.Spec.Containers, pod.Status.ContainerStatuses, false)
+	t.images[key] = current
+	if !seen {
+		return nil
+	}
+	var changes []*ImageChange
+	for _, name := range names {
+		old, ok := previous[name]
+		now := current[name]
+		if !ok {
+			continue
+		}
+		imageID := now.imageID
+		// Keep the last known digest while the container is not running, e.g. restarting with its new image,
+		// to report the digest it resolves to once it runs
+		if imageID == "" {
+			now.imageID = old.imageID
+			current[name] = now
+		}
+		if old.image != now.image || (old.imageID != "" && imageID != "" && old.imageID != imageID) {
+			changes = append(changes, &ImageChange{Container: name, Init: now.init, OldImage: old.image, NewImage: now.image,
+				OldImageID: old.imageID, NewImageID: imageID})
+		}
+	}
+	return changes
+}
+
+// emitImageChanges emits an IMAGE_CHANGED event for each changed image of the pod, unless their type is filtered out
+func (p *eventProcessor) emitImageChanges(m *matchedObject, pod *corev1.Pod, changes []*ImageChange) {
+	if p.eventTypes != nil && !p.eventTypes[ImageEvent] {
+		return
+	}
+	for _, change := range changes {
+		event := Event{Type: ImageEvent, Key: m.key, Object: pod, Timestamp: time.Now().UTC(), Cluster: m.cluster, Image: change}
+		p.sinks.write(event)
+		p.publish(event)
+		eventsEmitted.WithLabelValues(ImageEvent).Inc()
+		p.health.eventEmitted()
+	}
+}
@@ -0,0 +1,295 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	clientfeatures "k8s.io/client-go/features"
+	"k8s.io/client-go/tools/cache"
+)
+
+// runInformer runs a shared informer over the watched resources of one namespace (metav1.NamespaceAll for every namespace)
+// of a cluster, or one shard of it, handing each event to the processor until the context is canceled.
+// The informer takes care of re-listing and re-watching after errors, resuming from the last seen
+// resourceVersion and de-duplicating against its cache, so no events are lost across restarts.
+func (w *Watcher) runInformer(ctx context.Context, c *cluster, namespace string, shard *watchShard, processor *eventProcessor) error {
+	informer := cache.NewSharedIndexInformer(w.newListWatch(ctx, c, namespace, shard), c.client.ExampleObject(), w.resyncPeriod, cache.Indexers{})
+	// With a checkpoint, record how far the informer got
+	processed := func(obj interface{}) {
+		if objMeta, err := meta.Accessor(obj); err == nil && processor.checkpoint != nil {
+			processor.checkpoint.processed(c.name, namespace, objMeta.GetResourceVersion())
+		}
+	}
+	// Hand the notifications to the worker of their object, keeping those of one object in order.
+	// The changes received from the watch, given their event type, are traced from their receipt to the end of the job.
+	submit := func(obj interface{}, eventType string, job func(ctx context.Context)) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			job(context.Background())
+			return
+		}
+		id := clusterKey(c.name, key)
+		if eventType == "" {
+			processor.workers.submit(id, func() { job(context.Background()) })
+			return
+		}
+		attributes := []attribute.KeyValue{attribute.String("event.type", eventType), attribute.String("key", key)}
+		if c.name != "" {
+			attributes = append(attributes, attribute.String("cluster", c.name))
+		}
+		eventCtx, span := tracer().Start(context.Background(), "event", trace.WithAttributes(attributes...))
+		_, queued := startSpan(eventCtx, "queue")
+		processor.workers.submit(id, func() {
+			queued.End()
+			defer span.End()
+			job(eventCtx)
+		})
+	}
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			// A snapshot only emits the objects of the initial list
+			if w.snapshot && !isInInitialList {
+				return
+			}
+			eventType := string(watch.Added)
+			if isInInitialList {
+				eventType = ""
+			}
+			submit(obj, eventType, func(ctx context.Context) {
+				defer processed(obj)
+				// Objects that existed before we started are only reported once they change, unless --emit-initial,
+				// or, with a checkpoint, if they were created or changed since it was saved
+				if isInInitialList && processor.checkpoint != nil {
+					processor.resume(c.name, obj.(runtime.Object))
+					return
+				}
+				if isInInitialList {
+					processor.list(c.name, obj.(runtime.Object))
+					return
+				}
+				processor.handle(ctx, c.name, string(watch.Added), obj.(runtime.Object))
+			})
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if w.snapshot {
+				return
+			}
+			// A periodic resync re-delivers the cached object unchanged
+			eventType := string(watch.Modified)
+			if sameResourceVersion(oldObj, newObj) {
+				eventType = ResyncEvent
+			}
+			submit(newObj, eventType, func(ctx context.Context) {
+				defer processed(newObj)
+				processor.handle(ctx, c.name, eventType, newObj.(runtime.Object))
+			})
+		},
+		DeleteFunc: func(obj interface{}) {
+			if w.snapshot {
+				return
+			}
+			submit(obj, string(watch.Deleted), func(ctx context.Context) {
+				// If the watch missed the deletion we get the last known state wrapped in a tombstone
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if obj, ok := obj.(runtime.Object); ok {
+					processor.handle(ctx, c.name, string(watch.Deleted), obj)
+				}
+			})
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not register event handler: %w", err)
+	}
+	if processor.snapshot != nil {
+		processor.snapshot <- registration.HasSynced
+	}
+	// Drop the managed fields before caching when they are stripped anyway, which keeps the cache much smaller,
+	// unless they are summarized
+	if w.strip != nil && w.strip.managedFields && !w.auditFields {
+		if err := informer.SetTransform(dropManagedFields); err != nil {
+			return fmt.Errorf("could not register transform: %w", err)
+		}
+	}
+	cachedStores.Store(informer.GetStore(), true)
+	defer cachedStores.Delete(informer.GetStore())
+	// Report failing lists and watches in the health endpoints while the informer retries them
+	w.health.addInformer(c.name, namespace, shard.String(), informer.HasSynced)
+	err = informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		w.health.failed(c.name, namespace, shard.String(), err)
+		cache.DefaultWatchErrorHandler(r, err)
+	})
+	if err != nil {
+		return fmt.Errorf("could not register watch error handler: %w", err)
+	}
+	// With a checkpoint, the objects gone since it was saved are reported once the initial list has been processed
+	if processor.checkpoint != nil {
+		resumed := make(chan struct{})
+		defer func() { <-resumed }()
+		go func() {
+			defer close(resumed)
+			synced := cache.WaitForCacheSync(ctx.Done(), registration.HasSynced)
+			if shard.synced() && synced && ctx.Err() == nil {
+				processor.workers.wait()
+				processor.resumeDeletions(c.name, namespace)
+			}
+		}()
+	}
+	// With a relist interval, the objects the watch missed are found by listing them afresh
+	if w.relistInterval > 0 && !w.snapshot {
+		relisted := make(chan struct{})
+		defer func() { <-relisted }()
+		go func() {
+			defer close(relisted)
+			w.runRelists(ctx, c, namespace, shard, informer.GetStore(), registration.HasSynced, func(obj runtime.Object) {
+				submit(obj, ResyncEvent, func(ctx context.Context) {
+					processor.handle(ctx, c.name, ResyncEvent, obj)
+				})
+			})
+		}()
+	}
+	informer.Run(ctx.Done())
+	attributes := []any{"cluster", c.name, "namespace", namespaceList([]string{namespace})}
+	if shard != nil {
+		attributes = append(attributes, "shard", shard.String())
+	}
+	slog.Info("Context canceled, stopping watcher", attributes...)
+	return nil
+}
+
+// newListWatch adapts the resourceClient of the cluster to the informer, applying the server-side selectors and watch timeout.
+// The watches ask for bookmarks, which keep the informer's resourceVersion current while nothing matching changes,
+// so that a restarted watch resumes from the last bookmark instead of failing as too old and listing everything again.
+// After failures, the lists and watches are delayed by the backoff policy.
+func (w *Watcher) newListWatch(ctx context.Context, c *cluster, namespace string, shard *watchShard) *cache.ListWatch {
+	backoff := newRetryBackoff(w.backoff, c.name, namespace)
+	var (
+		listed   bool   // whether the initial list has been made, making the next one a relist
+		watching bool   // whether a watch has been started before, making the next one a restart
+		bookmark string // resourceVersion of the last bookmark received
+	)
+	// relisted counts the lists made after the initial one, which with --use-watch-list may fall back to a list
+	relisted := func() {
+		if listed {
+			relists.Inc()
+			slog.Info("Listed again, the watch could not be resumed", "cluster", c.name, "namespace", namespaceList([]string{namespace}))
+		}
+		listed = true
+	}
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			if err := backoff.wait(ctx); err != nil {
+				return nil, err
+			}
+			w.applySelectors(c, shard, &options)
+			// A snapshot is a consistent read of the API server's storage, rather than of its watch cache
+			if w.snapshot && options.ResourceVersion == "0" {
+				options.ResourceVersion = ""
+			}
+			if w.pageSize > 0 {
+				options.Limit = w.pageSize
+				// The API server answers lists at resourceVersion 0 from its watch cache in one response, whatever the limit
+				if options.ResourceVersion == "0" {
+					options.ResourceVersion = ""
+				}
+			}
+			list, err := c.client.List(ctx, namespace, options)
+			backoff.done(err)
+			if err == nil && options.Continue == "" {
+				relisted()
+			}
+			return list, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			if err := backoff.wait(ctx); err != nil {
+				return nil, err
+			}
+			w.applySelectors(c, shard, &options)
+			options.AllowWatchBookmarks = c.capabilities.Bookmarks
+			if timeout := watchTimeoutSeconds(w.watchTimeout); timeout != nil {
+				options.TimeoutSeconds = timeout
+			}
+			// With --use-watch-list, the initial state is streamed by a watch taking the place of the list
+			streaming := options.SendInitialEvents != nil && *options.SendInitialEvents
+			if watching && !streaming {
+				watchRestarts.Inc()
+				slog.Debug("Restarting watch", "cluster", c.name, "namespace", namespaceList([]string{namespace}),
+					"resourceVersion", options.ResourceVersion, "fromBookmark", options.ResourceVersion != "" && options.ResourceVersion == bookmark)
+			}
+			watching = true
+			watcher, err := c.client.Watch(ctx, namespace, options)
+			backoff.done(err)
+			if err != nil {
+				return nil, err
+			}
+			w.health.connected(c.name, namespace, shard.String())
+			// Note the bookmarks on their way to the informer, which resumes from them;
+			// a streamed list is complete once the bookmark marking the end of the initial events arrives
+			return watch.Filter(watcher, func(event watch.Event) (watch.Event, bool) {
+				if event.Type == watch.Bookmark {
+					watchBookmarks.Inc()
+					if objMeta, err := meta.Accessor(event.Object); err == nil {
+						bookmark = objMeta.GetResourceVersion()
+						if streaming && objMeta.GetAnnotations()[metav1.InitialEventsAnnotationKey] == "true" {
+							relisted()
+						}
+					}
+				}
+				return event, true
+			}), nil
+		},
+	}
+}
+
+// setWatchList makes the informers' reflectors stream their initial state with a watch sending the initial events,
+// which they fall back from to a list when the API server rejects it, or list it again
+func setWatchList(enabled bool) error {
+	gates, ok := clientfeatures.FeatureGates().(interface {
+		Set(feature clientfeatures.Feature, enabled bool) error
+	})
+	if !ok {
+		return fmt.Errorf("could not enable --use-watch-list: the client-go feature gates have been replaced")
+	}
+	return gates.Set(clientfeatures.WatchListClient, enabled)
+}
+
+// applySelectors adds the server-side selectors of the cluster to list/watch options
+func (w *Watcher) applySelectors(c *cluster, shard *watchShard, options *metav1.ListOptions) {
+	options.LabelSelector = shard.labelSelector(c.labelSelector)
+	options.FieldSelector = w.fieldSelector
+}
+
+// sameResourceVersion reports whether two revisions of an object are identical, as on a resync
+func sameResourceVersion(oldObj, newObj interface{}) bool {
+	oldMeta, err := meta.Accessor(oldObj)
+	if err != nil {
+		return false
+	}
+	newMeta, err := meta.Accessor(newObj)
+	if err != nil {
+		return false
+	}
+	return oldMeta.GetResourceVersion() == newMeta.GetResourceVersion()
+}
+
+// watchTimeoutSeconds converts the watch timeout into the TimeoutSeconds watch option.
+// A zero (or negative) timeout leaves the option unset so the informer's own timeout applies.
+func watchTimeoutSeconds(timeout time.Duration) *int64 {
+	if timeout <= 0 {
+		return nil
+	}
+	seconds := int64(timeout.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &seconds
+}
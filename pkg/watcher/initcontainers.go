@@ -0,0 +1,177 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Milestones of the init containers reported by the INIT events of WithInitTracking
+const (
+	InitStarted   = "Started"   // the container started running
+	InitCompleted = "Completed" // the container terminated with exit code 0
+	InitFailed    = "Failed"    // the container terminated with a non-zero exit code, or was restarted after one
+	InitReady     = "Ready"     // the sidecar passed its readiness probe; sidecars only
+	InitRestarted = "Restarted" // the sidecar was restarted; sidecars only
+)
+
+// InitChange is a milestone of one init container of a pod, carried by INIT events
+type InitChange struct {
+	Container string `json:"container"`
+	// Sidecar tells whether it is a native sidecar, an init container with the restart policy Always, which keeps
+	// running alongside the containers of the pod rather than completing before they start
+	Sidecar   bool   `json:"sidecar,omitempty"`
+	Milestone string `json:"milestone"` // InitStarted, InitCompleted, ...
+	Index     int    `json:"index"`     // the position of the container among the init containers of the pod, from 1
+	Count     int    `json:"count"`     // the number of init containers of the pod
+	Reason    string `json:"reason,omitempty"`
+	Message   string `json:"message,omitempty"`
+	ExitCode  *int32 `json:"exitCode,omitempty"`
+	// Duration is the time the container ran, e.g. "4.2s"; Completed and Failed only
+	Duration     string `json:"duration,omitempty"`
+	RestartCount int32  `json:"restartCount"`
+}
+
+// String formats the milestone compactly, e.g. "init container 2/3 migrate Failed (exit code 1, Error, ran 4.2s)"
+func (c *InitChange) String() string {
+	kind := "init container"
+	if c.Sidecar {
+		kind = "sidecar"
+	}
+	var details []string
+	if c.ExitCode != nil {
+		details = append(details, fmt.Sprintf("exit code %d", *c.ExitCode))
+	}
+	if c.Reason != "" {
+		details = append(details, c.Reason)
+	}
+	if c.Duration != "" {
+		details = append(details, "ran "+c.Duration)
+	}
+	if c.RestartCount > 0 {
+		details = append(details, fmt.Sprintf("restarts: %d", c.RestartCount))
+	}
+	text := fmt.Sprintf("%s %d/%d %s %s", kind, c.Index, c.Count, c.Container, c.Milestone)
+	if len(details) > 0 {
+		text += " (" + strings.Join(details, ", ") + ")"
+	}
+	if c.Message != "" {
+		text += ": " + strings.Join(strings.Fields(c.Message), " ")
+	}
+	return text
+}
+
+// initTracker diffs the init container statuses of each pod against those of its previous revision (--track-init)
+type initTracker struct {
+	mu       sync.Mutex
+	statuses map[string]map[string]corev1.ContainerStatus // pod key, qualified by its cluster -> init container name -> last status
+}
+
+func newInitTracker() *initTracker {
+	return &initTracker{statuses: make(map[string]map[string]corev1.ContainerStatus)}
+}
+
+// evict forgets the pod
+func (t *initTracker) evict(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.statuses, key)
+}
+
+// update records the init container statuses of the pod, returning their milestones since the previous revision,
+// in the order of the init containers. A pod from the initial list only sets the baseline, and a deleted one is forgotten.
+func (t *initTracker) update(eventType string, key string, pod *corev1.Pod, baseline bool) []*InitChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if eventType == string(watch.Deleted) || len(pod.Spec.InitContainers) == 0 {
+		delete(t.statuses, key)
+		return nil
+	}
+	previous := t.statuses[key]
+	current := make(map[string]corev1.ContainerStatus)
+	statuses := make(map[string]corev1.ContainerStatus)
+	for _, status := range pod.Status.InitContainerStatuses {
+		statuses[status.Name] = status
+	}
+	var changes []*InitChange
+	for i, container := range pod.Spec.InitContainers {
+		status, ok := statuses[container.Name]
+		if !ok {
+			continue
+		}
+		current[container.Name] = status
+		if baseline {
+			continue
+		}
+		prev, seen := previous[container.Name]
+		sidecar := container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+		for _, change := range initChanges(prev, seen, status, sidecar) {
+			change.Container, change.Sidecar, change.Index, change.Count = container.Name, sidecar, i+1, len(pod.Spec.InitContainers)
+			change.RestartCount = status.RestartCount
+			changes = append(changes, change)
+		}
+	}
+	t.statuses[key] = current
+	return changes
+}
+
+// initChanges compares the status of an init container with its previous one, if it was seen before
+func initChanges(prev corev1.ContainerStatus, seen bool, status corev1.ContainerStatus, sidecar bool) []*InitChange {
+	var changes []*InitChange
+	// A container restarted since the previous revision: its previous instance terminated, unless already reported
+	if seen && status.RestartCount > prev.RestartCount {
+		if last := status.LastTerminationState.Terminated; last != nil && prev.State.Terminated == nil {
+			changes = append(changes, terminatedChange(last))
+		}
+		if sidecar {
+			changes = append(changes, &InitChange{Milestone: InitRestarted})
+		}
+	}
+	running := status.State.Running
+	terminated := status.State.Terminated
+	newInstance := !seen || status.RestartCount != prev.RestartCount
+	switch {
+	case running != nil && (newInstance || prev.State.Running == nil || !prev.State.Running.StartedAt.Equal(&running.StartedAt)):
+		changes = append(changes, &InitChange{Milestone: InitStarted})
+	case terminated != nil && (newInstance || prev.State.Terminated == nil):
+		// An instance that ran between two revisions was never seen running
+		if newInstance || prev.State.Running == nil {
+			changes = append(changes, &InitChange{Milestone: InitStarted})
+		}
+		changes = append(changes, terminatedChange(terminated))
+	}
+	if sidecar && status.Ready && (!seen || !prev.Ready) {
+		changes = append(changes, &InitChange{Milestone: InitReady})
+	}
+	return changes
+}
+
+// terminatedChange is the Completed or Failed milestone of a terminated init container
+func terminatedChange(terminated *corev1.ContainerStateTerminated) *InitChange {
+	change := &InitChange{Milestone: InitCompleted, Reason: terminated.Reason, Message: terminated.Message, ExitCode: &terminated.ExitCode}
+	if terminated.ExitCode != 0 {
+		change.Milestone = InitFailed
+	}
+	if !terminated.StartedAt.IsZero() && !terminated.FinishedAt.IsZero() {
+		change.Duration = max(terminated.FinishedAt.Sub(terminated.StartedAt.Time), 0).Round(100 * time.Millisecond).String()
+	}
+	return change
+}
+
+// emitInitChanges emits an INIT event for each milestone of the init containers of the pod, unless their type is filtered out
+func (p *eventProcessor) emitInitChanges(m *matchedObject, pod *corev1.Pod, changes []*InitChange) {
+	if p.eventTypes != nil && !p.eventTypes[InitEvent] {
+		return
+	}
+	for _, change := range changes {
+		event := Event{Type: InitEvent, Key: m.key, Object: pod, Timestamp: time.Now().UTC(), Cluster: m.cluster, Init: change}
+		p.sinks.write(event)
+		p.publish(event)
+		eventsEmitted.WithLabelValues(InitEvent).Inc()
+		p.health.eventEmitted()
+	}
+}
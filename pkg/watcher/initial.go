@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// listedVersions remembers the resourceVersions of the objects of the informers' initial lists (--skip-initial),
+// so that the revisions that existed before the watcher started are never emitted, not even when a relist after
+// an expired watch or a periodic resync re-delivers them. An object is forgotten once it changes or is deleted.
+type listedVersions struct {
+	mu       sync.Mutex
+	versions map[string]string // object key, qualified by its cluster -> its resourceVersion when listed
+}
+
+func newListedVersions() *listedVersions {
+	return &listedVersions{versions: make(map[string]string)}
+}
+
+// record notes the listed revision of an object
+func (l *listedVersions) record(key string, resourceVersion string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.versions[key] = resourceVersion
+}
+
+// listed reports whether the event carries the revision of the object that was listed at startup;
+// deletions never do
+func (l *listedVersions) listed(eventType string, key string, resourceVersion string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	version, ok := l.versions[key]
+	if !ok {
+		return false
+	}
+	if eventType != string(watch.Deleted) && version == resourceVersion {
+		return true
+	}
+	delete(l.versions, key)
+	return false
+}
+
+// list records an object from the informer's initial list like observe and, with --emit-initial, emits it as ADDED
+func (p *eventProcessor) list(cluster string, obj runtime.Object) {
+	m, ok := p.observe(cluster, obj)
+	if !ok {
+		return
+	}
+	if p.listed != nil {
+		if objMeta, err := meta.Accessor(m.obj); err == nil {
+			p.listed.record(m.id, objMeta.GetResourceVersion())
+		}
+	}
+	// If backfill mode, reconstruct the history of the pod before it is emitted or watched
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.backfill != nil && (p.target == nil || p.target.accept(m.id)) {
+		p.emitBackfill(m, pod)
+	}
+	if !p.emitInitial || (p.target != nil && !p.target.accept(m.id)) {
+		return
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.logs != nil {
+		p.logs.update(string(watch.Added), m.cluster, m.id, pod)
+	}
+	if p.eventTypes != nil && !p.eventTypes[string(watch.Added)] {
+		return
+	}
+	p.emit(string(watch.Added), m)
+}
@@ -0,0 +1,213 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// KafkaOptions configures the Kafka sink
+type KafkaOptions struct {
+	Brokers []string // host:port of the bootstrap brokers
+	Topic   string
+	TLS     TLSOptions
+	// SASLMechanism is one of plain, scram-sha-256 or scram-sha-512; empty disables SASL authentication
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+	BatchSize     int           // maximum number of messages per produce request
+	BatchTimeout  time.Duration // maximum time a message waits for its batch to fill up
+	// CloudEvents publishes the events as CloudEvents 1.0 in the structured JSON mode rather than as envelopes,
+	// with the content-type header of the mode
+	CloudEvents bool
+	// ArraySize greater than 1 publishes the events as JSON arrays of up to this many events, one per message,
+	// once an array is full or every ArrayInterval, rather than one message per event
+	ArraySize     int
+	ArrayInterval time.Duration
+}
+
+// kafkaSink is the Sink publishing each event as a JSON envelope to a Kafka topic, keyed by the object's key
+// so that the events of one object stay in order on one partition.
+// Messages are batched and produced asynchronously; failed deliveries are logged and counted.
+type kafkaSink struct {
+	writer *kafka.Writer
+	topic  string
+	// sync publishes the events of the queue of WithSinkQueue, and the arrays of events, one at a time, waiting for
+	// their acknowledgement
+	sync        *kafka.Writer
+	cloudEvents bool
+	contentType string // the content-type header of the messages, only set for CloudEvents
+}
+
+// NewKafkaSink returns a sink publishing each event to a Kafka topic, or with an ArraySize greater than 1,
+// publishing JSON arrays of events. An array that could not be published is retried with the next, keeping up to
+// 10 arrays of events before dropping the oldest; the pending events are published when the sink is closed.
+func NewKafkaSink(options KafkaOptions) (Sink, error) {
+	if len(options.Brokers) == 0 || options.Topic == "" {
+		return nil, fmt.Errorf("the Kafka sink requires brokers and a topic")
+	}
+	tlsConfig, err := options.TLS.config()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Kafka TLS settings: %w", err)
+	}
+	mechanism, err := saslMechanism(options.SASLMechanism, options.SASLUsername, options.SASLPassword)
+	if err != nil {
+		return nil, err
+	}
+	topic := options.Topic
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(options.Brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    options.BatchSize,
+		BatchTimeout: options.BatchTimeout,
+		Async:        true,
+		Transport:    &kafka.Transport{TLS: tlsConfig, SASL: mechanism},
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				slog.Error("Failed to publish events to Kafka", "topic", topic, "messages", len(messages), "error", err)
+				kafkaFailures.Add(float64(len(messages)))
+			}
+		},
+	}
+	sync := &kafka.Writer{
+		Addr:         writer.Addr,
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    1,
+		Transport:    writer.Transport,
+	}
+	sink := &kafkaSink{writer: writer, topic: topic, sync: sync, cloudEvents: options.CloudEvents}
+	if options.CloudEvents {
+		sink.contentType = cloudEventsContentType
+	}
+	if options.ArraySize <= 1 {
+		return sink, nil
+	}
+	if options.CloudEvents {
+		sink.contentType = cloudEventsBatchContentType
+	}
+	return newBatchedKafkaSink(sink, options.ArraySize, options.ArrayInterval)
+}
+
+// saslMechanism returns the SASL mechanism with the given name, or nil for no authentication
+func saslMechanism(name, username, password string) (sasl.Mechanism, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("unsupported Kafka SASL mechanism %q (must be plain, scram-sha-256 or scram-sha-512)", name)
+	}
+}
+
+// Write queues the event for the next batch
+func (s *kafkaSink) Write(event Event) error {
+	value, err := marshalEvent(event, s.cloudEvents)
+	if err != nil {
+		marshalErrors.Inc()
+		return fmt.Errorf("could not marshal event: %w", err)
+	}
+	// With Async set this only fails when the topic's partitions cannot be looked up or the writer is closed;
+	// errors producing the batch go to the Completion callback
+	err = s.writer.WriteMessages(context.Background(), s.message(event.Key, value, event.Timestamp))
+	if err != nil {
+		kafkaFailures.Inc()
+	}
+	return err
+}
+
+// queueID identifies the topic and its brokers for WithSinkQueue
+func (s *kafkaSink) queueID() string {
+	return s.writer.Addr.String() + "/" + s.topic
+}
+
+// encodeEvent serializes the event for WithSinkQueue
+func (s *kafkaSink) encodeEvent(event Event) (queuedEvent, error) {
+	value, err := marshalEvent(event, s.cloudEvents)
+	if err != nil {
+		marshalErrors.Inc()
+		return queuedEvent{}, fmt.Errorf("could not marshal event: %w", err)
+	}
+	return queuedEvent{Time: event.Timestamp, Key: event.Key, Body: value}, nil
+}
+
+// deliverQueued publishes an event of the queue of WithSinkQueue, waiting for its acknowledgement by the brokers,
+// since the batches of the asynchronous writer report their failures too late to keep the event queued
+func (s *kafkaSink) deliverQueued(event queuedEvent) error {
+	return s.sync.WriteMessages(context.Background(), s.message(event.Key, event.Body, event.Time))
+}
+
+// message is the message of an event, keyed by the key of its object, with the content-type header of CloudEvents
+func (s *kafkaSink) message(key string, value []byte, t time.Time) kafka.Message {
+	message := kafka.Message{Key: []byte(key), Value: value, Time: t}
+	if s.contentType != "" {
+		message.Headers = []kafka.Header{{Key: "content-type", Value: []byte(s.contentType)}}
+	}
+	return message
+}
+
+// Close delivers the pending batches and closes the connections
+func (s *kafkaSink) Close() error {
+	_ = s.sync.Close()
+	return s.writer.Close()
+}
+
+// String names the sink for logs and metrics
+func (s *kafkaSink) String() string {
+	return "kafka:" + s.topic
+}
+
+// batchedKafkaSink is the kafkaSink publishing the events as JSON arrays of up to size events, one per message,
+// once an array is full or at every interval, retrying the arrays that could not be published as an eventBatcher does.
+// As an array holds the events of several objects, the messages are keyed by the topic, which keeps them in order
+// on one partition.
+type batchedKafkaSink struct {
+	*kafkaSink
+	batcher *eventBatcher
+}
+
+func newBatchedKafkaSink(sink *kafkaSink, size int, interval time.Duration) (*batchedKafkaSink, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("the interval of the Kafka arrays of events must be positive")
+	}
+	s := &batchedKafkaSink{kafkaSink: sink}
+	s.batcher = newEventBatcher(sink.String(), size, interval, kafkaFailures, func(batch []byte) error {
+		return s.sync.WriteMessages(context.Background(), s.message(s.topic, batch, time.Now()))
+	})
+	return s, nil
+}
+
+// Write queues the event for the next array
+func (s *batchedKafkaSink) Write(event Event) error {
+	value, err := marshalEvent(event, s.cloudEvents)
+	if err != nil {
+		marshalErrors.Inc()
+		return fmt.Errorf("could not marshal event: %w", err)
+	}
+	s.batcher.add(value)
+	return nil
+}
+
+// Close publishes the pending events, counting those that could not be published as failed, and closes the connections
+func (s *batchedKafkaSink) Close() error {
+	err := s.batcher.close()
+	if closeErr := s.kafkaSink.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// serviceAccountNamespace holds the namespace of the pod when running in-cluster
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// LeaderElectionOptions configures the election of the one replica that watches and emits events.
+// Zero durations default to those of the Kubernetes controllers.
+type LeaderElectionOptions struct {
+	LeaseName      string
+	LeaseNamespace string // defaults to the namespace of the pod when running in-cluster, "default" otherwise
+	Identity       string // unique per replica; defaults to the host name with a random suffix
+	// LeaseDuration is how long the other replicas wait after the last renewal before taking over
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps retrying to renew the lease before giving up leadership
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval between the attempts to acquire or renew the lease
+	RetryPeriod time.Duration
+}
+
+// defaulted returns the options with their defaults filled in
+func (o LeaderElectionOptions) defaulted() (LeaderElectionOptions, error) {
+	if o.LeaseName == "" {
+		return o, fmt.Errorf("leader election requires a lease name")
+	}
+	if o.LeaseNamespace == "" {
+		o.LeaseNamespace = podNamespace()
+	}
+	if o.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return o, fmt.Errorf("could not determine the leader election identity: %w", err)
+		}
+		o.Identity = hostname + "_" + string(uuid.NewUUID())
+	}
+	if o.LeaseDuration == 0 {
+		o.LeaseDuration = 15 * time.Second
+	}
+	if o.RenewDeadline == 0 {
+		o.RenewDeadline = 10 * time.Second
+	}
+	if o.RetryPeriod == 0 {
+		o.RetryPeriod = 2 * time.Second
+	}
+	return o, nil
+}
+
+// podNamespace returns the namespace of the pod when running in-cluster, "default" otherwise
+func podNamespace() string {
+	if data, err := os.ReadFile(serviceAccountNamespace); err == nil && strings.TrimSpace(string(data)) != "" {
+		return strings.TrimSpace(string(data))
+	}
+	return metav1.NamespaceDefault
+}
+
+// runElected stands by until this replica holds the lease, then watches until it loses it, and stands by again.
+// The lease is released as soon as the watch ends, so that another replica can take over without waiting for it to expire.
+func (w *Watcher) runElected(ctx context.Context, sinks *fanOut) error {
+	options := *w.leaderElection
+	if !w.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, w.deadline)
+		defer cancel()
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: options.LeaseName, Namespace: options.LeaseNamespace},
+		Client:     w.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: options.Identity},
+	}
+	slog.Info("Waiting for leadership", "lease", options.LeaseNamespace+"/"+options.LeaseName, "identity", options.Identity)
+	for {
+		// Each term runs an election, watching from this goroutine while leading
+		w.health.setRole(roleStandby)
+		termCtx, endTerm := context.WithCancel(ctx)
+		leading := make(chan context.Context, 1)
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   options.LeaseDuration,
+			RenewDeadline:   options.RenewDeadline,
+			RetryPeriod:     options.RetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            "pod-watcher",
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) { leading <- leaderCtx },
+				OnStoppedLeading: func() {},
+				OnNewLeader: func(identity string) {
+					if identity != options.Identity {
+						slog.Info("Another replica is the leader, standing by", "leader", identity)
+					}
+				},
+			},
+		})
+		if err != nil {
+			endTerm()
+			return fmt.Errorf("invalid leader election settings: %w", err)
+		}
+		elected := make(chan struct{})
+		go func() {
+			defer close(elected)
+			elector.Run(termCtx)
+		}()
+		select {
+		case <-elected:
+			endTerm()
+			if ctx.Err() == nil {
+				continue // leadership was lost as soon as it was acquired
+			}
+			// Stopped while standing by
+			if w.condition != nil {
+				return &ExitError{Code: 1, Message: fmt.Sprintf("Watcher stopped before condition %s was met.", w.condition)}
+			}
+			return nil
+		case leaderCtx := <-leading:
+			slog.Info("Became the leader, watching", "identity", options.Identity)
+			isLeader.Set(1)
+			err := w.watch(leaderCtx, sinks)
+			isLeader.Set(0)
+			lost := leaderCtx.Err() != nil && ctx.Err() == nil
+			// Ending the term releases the lease
+			endTerm()
+			<-elected
+			if !lost {
+				return err
+			}
+			slog.Warn("Lost leadership, standing by", "identity", options.Identity)
+		}
+	}
+}
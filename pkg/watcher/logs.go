@@ -0,0 +1,93 @@
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// logTailer streams the logs of the containers of matched pods into the output stream.
+// Each running container instance is followed once, from its start time, until it exits;
+// all of a pod's streams are stopped when the pod is deleted.
+type logTailer struct {
+	ctx        context.Context
+	clientsets map[string]kubernetes.Interface // cluster name -> its clientset
+	outs       []*eventWriter                  // the sinks writing to an output stream or file
+
+	mu      sync.Mutex
+	streams map[string]map[string]context.CancelFunc // pod key, qualified by its cluster -> container ID -> cancel of its stream
+}
+
+func newLogTailer(ctx context.Context, clusters []*cluster, outs []*eventWriter) *logTailer {
+	t := &logTailer{
+		ctx:        ctx,
+		clientsets: make(map[string]kubernetes.Interface),
+		outs:       outs,
+		streams:    make(map[string]map[string]context.CancelFunc),
+	}
+	for _, c := range clusters {
+		t.clientsets[c.name] = c.clientset
+	}
+	return t
+}
+
+// update starts streams for newly running containers of the pod of the cluster, or stops all of them once it is deleted
+func (t *logTailer) update(eventType string, cluster string, key string, pod *corev1.Pod) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if eventType == string(watch.Deleted) {
+		for _, cancel := range t.streams[key] {
+			cancel()
+		}
+		delete(t.streams, key)
+		return
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Running == nil || status.ContainerID == "" {
+			continue
+		}
+		if _, streaming := t.streams[key][status.ContainerID]; streaming {
+			continue
+		}
+		if t.streams[key] == nil {
+			t.streams[key] = make(map[string]context.CancelFunc)
+		}
+		ctx, cancel := context.WithCancel(t.ctx)
+		t.streams[key][status.ContainerID] = cancel
+		go t.follow(ctx, cluster, pod.Namespace, pod.Name, status.Name, status.State.Running.StartedAt)
+	}
+}
+
+// follow copies the log of one container into the output stream until it ends or is canceled
+func (t *logTailer) follow(ctx context.Context, cluster, namespace, name, container string, since metav1.Time) {
+	req := t.clientsets[cluster].CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{
+		Container: container,
+		Follow:    true,
+		SinceTime: &since,
+	})
+	stream, err := req.Stream(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("Could not stream container logs", "cluster", cluster, "namespace", namespace, "pod", name, "container", container, "error", err)
+		}
+		return
+	}
+	defer stream.Close()
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		for _, out := range t.outs {
+			out.writeLog(cluster, namespace, name, container, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		slog.Warn("Container log stream failed", "cluster", cluster, "namespace", namespace, "pod", name, "container", container, "error", err)
+	}
+}
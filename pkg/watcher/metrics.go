@@ -0,0 +1,272 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/tools/cache"
+)
+
+// cachedStores are the caches of the running informers, counted by pod_watcher_cached_objects
+var cachedStores sync.Map // cache.Store -> true
+
+// Prometheus metrics, always recorded and served when --metrics-addr is set
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	eventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_events_received_total",
+		Help: "Events received from the informers, by event type.",
+	}, []string{"type"})
+	eventsEmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_events_emitted_total",
+		Help: "Events emitted to the sinks, by event type.",
+	}, []string{"type"})
+	eventsSuppressed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_events_suppressed_total",
+		Help: "Events not emitted because of --dedupe or --min-interval, by reason.",
+	}, []string{"reason"})
+	matchedObjects = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pod_watcher_matched_objects",
+		Help: "Number of currently known objects matching the filters.",
+	})
+	watchRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_watch_restarts_total",
+		Help: "Watches re-established after the previous watch ended or failed.",
+	})
+	watchBookmarks = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_watch_bookmarks_total",
+		Help: "Bookmarks received, from which a restarted watch resumes.",
+	})
+	relists = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_relists_total",
+		Help: "Full lists made after the initial one because a watch could not be resumed.",
+	})
+	relistMissed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_relist_missed_total",
+		Help: "Objects found by the periodic relists of --resync-interval that the watch had not delivered.",
+	})
+	breakerTrips = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_circuit_breaker_trips_total",
+		Help: "Times a list and watch was paused after consecutive failures.",
+	})
+	reauthentications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_reauthentications_total",
+		Help: "Lists and watches rejected as unauthorized and retried at once with refreshed credentials, by cluster.",
+	}, []string{"cluster"})
+	authChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_auth_checks_total",
+		Help: "Checks of the credentials of each cluster made every --auth-check-interval, by result (ok, refreshed, unauthorized, error).",
+	}, []string{"cluster", "result"})
+	apiThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_api_throttled_total",
+		Help: "Requests rejected by the API server as too many, by the UID of their API Priority and Fairness priority level.",
+	}, []string{"priority_level"})
+	cachedObjects = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pod_watcher_cached_objects",
+		Help: "Number of objects held in the informer caches, matching or not.",
+	}, func() float64 {
+		n := 0
+		cachedStores.Range(func(store, _ any) bool {
+			n += len(store.(cache.Store).ListKeys())
+			return true
+		})
+		return float64(n)
+	})
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pod_watcher_queue_depth",
+		Help: "Informer notifications waiting for a worker.",
+	})
+	marshalErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_marshal_errors_total",
+		Help: "Objects that could not be serialized.",
+	})
+	webhookFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_webhook_failures_total",
+		Help: "Events that could not be delivered to the webhook after all retries.",
+	})
+	sinkErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_sink_errors_total",
+		Help: "Events that a sink failed to write, by sink.",
+	}, []string{"sink"})
+	kafkaFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_kafka_failures_total",
+		Help: "Events that could not be published to Kafka.",
+	})
+	natsFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_nats_failures_total",
+		Help: "Events that could not be published to NATS.",
+	})
+	archiveFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_archive_failures_total",
+		Help: "Events whose archive chunk could not be uploaded to object storage.",
+	})
+	filterPluginFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_filter_plugin_failures_total",
+		Help: "Objects dropped because the filter plugin failed to evaluate them.",
+	})
+	notifyFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_notification_failures_total",
+		Help: "Slack or Teams notifications that could not be posted after all retries.",
+	})
+	isLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pod_watcher_leader",
+		Help: "1 while this replica holds the leader election lease and watches, 0 otherwise.",
+	})
+	hookFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_exec_failures_total",
+		Help: "--exec hook commands that failed or timed out.",
+	})
+	alertsRaised = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_alerts_total",
+		Help: "Alerts raised for degraded pods with --restart-threshold, --flap-threshold or --terminating-threshold, by reason.",
+	}, []string{"reason"})
+	securityFindingsAdded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_security_findings_total",
+		Help: "Security-relevant settings appearing on matched pods with --security-watch, by setting.",
+	}, []string{"setting"})
+	debugInjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_debug_injections_total",
+		Help: "Ephemeral debug containers injected with --inject-debug-container, by result.",
+	}, []string{"result"})
+	terminationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pod_watcher_pod_termination_seconds",
+		Help:    "Time from the request of the deletion of a matched pod to its removal, with --track-termination.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+	})
+	forcedDeletions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_forced_deletions_total",
+		Help: "Matched pods removed while containers of them were still running, with --track-termination.",
+	})
+	deletionsAttributed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_deletions_attributed_total",
+		Help: "Deletions of matched pods by the reason attributed to them with --attribute-deletions.",
+	}, []string{"reason"})
+	trackedObjectsCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pod_watcher_tracked_objects",
+		Help: "Objects whose state the watcher keeps for the diff format, --dedupe, --min-interval and the tracking modes.",
+	})
+	stateEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_state_evictions_total",
+		Help: "Objects whose state was dropped, by reason: deleted, unmatched (stopped matching the filters) or capacity (--max-tracked-pods).",
+	}, []string{"reason"})
+	statsPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pod_watcher_stats_pods",
+		Help: "Matched objects by cluster, namespace and phase, as of the last --stats-interval statistics.",
+	}, []string{"cluster", "namespace", "phase"})
+	statsRestarts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pod_watcher_stats_restarts",
+		Help: "Restarts of the containers of the matched pods by cluster and namespace, as of the last --stats-interval statistics.",
+	}, []string{"cluster", "namespace"})
+	statsOwnerPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pod_watcher_stats_owner_pods",
+		Help: "Matched objects by cluster, namespace and owner workload, as of the last --stats-interval statistics.",
+	}, []string{"cluster", "namespace", "owner"})
+	statsNodePods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pod_watcher_stats_node_pods",
+		Help: "Matched pods by cluster and node, as of the last --stats-interval statistics.",
+	}, []string{"cluster", "node"})
+	statsChurn = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pod_watcher_stats_churn",
+		Help: "Matched objects created and deleted, and containers restarted, over the last --stats-interval, by change.",
+	}, []string{"change"})
+	serverCapabilities = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pod_watcher_server_capabilities",
+		Help: "Whether the API server of each cluster supports a capability (1) or not (0), as probed at the start of the watch, by cluster, version and capability.",
+	}, []string{"cluster", "version", "capability"})
+	sinkQueueEvents = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pod_watcher_sink_queue_events",
+		Help: "Events waiting in the --sink-queue-dir queue of a sink for redelivery, by sink.",
+	}, []string{"sink"})
+	sinkQueueRedelivered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_sink_queue_redelivered_total",
+		Help: "Queued events delivered once their sink recovered, by sink.",
+	}, []string{"sink"})
+	sinkQueueDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_watcher_sink_queue_dropped_total",
+		Help: "Queued events dropped without being delivered, by sink and reason: expired (beyond the retention), size (beyond the maximum size) or unreadable.",
+	}, []string{"sink", "reason"})
+	heartbeats = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_watcher_heartbeats_total",
+		Help: "Heartbeats written every --heartbeat-interval.",
+	})
+	eventLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pod_watcher_event_processing_seconds",
+		Help:    "Time taken to filter and emit each received event, by event type.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"type"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		eventsReceived,
+		eventsEmitted,
+		eventsSuppressed,
+		matchedObjects,
+		watchRestarts,
+		watchBookmarks,
+		relists,
+		relistMissed,
+		breakerTrips,
+		reauthentications,
+		authChecks,
+		apiThrottled,
+		cachedObjects,
+		queueDepth,
+		marshalErrors,
+		webhookFailures,
+		sinkErrors,
+		kafkaFailures,
+		natsFailures,
+		archiveFailures,
+		filterPluginFailures,
+		notifyFailures,
+		isLeader,
+		hookFailures,
+		alertsRaised,
+		securityFindingsAdded,
+		debugInjections,
+		terminationDuration,
+		forcedDeletions,
+		deletionsAttributed,
+		trackedObjectsCount,
+		stateEvictions,
+		statsPods,
+		statsRestarts,
+		statsOwnerPods,
+		statsNodePods,
+		statsChurn,
+		heartbeats,
+		serverCapabilities,
+		sinkQueueEvents,
+		sinkQueueRedelivered,
+		sinkQueueDropped,
+		eventLatency,
+	)
+}
+
+// ServeMetrics exposes the watcher metrics on addr at /metrics until the context is canceled
+func ServeMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	slog.Info("Serving metrics", "address", addr, "path", "/metrics")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Metrics server failed", "error", err)
+	}
+}
@@ -0,0 +1,136 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Headers of the NATS messages, identifying the event without decoding the payload
+const (
+	natsEventHeader   = "Pod-Watcher-Event"
+	natsKeyHeader     = "Pod-Watcher-Key"
+	natsClusterHeader = "Pod-Watcher-Cluster"
+)
+
+// NATSOptions configures the NATS sink
+type NATSOptions struct {
+	URL         string // one or more comma-separated server URLs
+	Subject     string
+	Credentials string // user credentials file (JWT and NKey seed)
+	Token       string
+	TLS         TLSOptions
+	// JetStream publishes to a stream and waits for its acknowledgement of each event
+	JetStream  bool
+	AckTimeout time.Duration
+}
+
+// natsSink is the Sink publishing each event as a JSON envelope to a NATS subject.
+// The connection is re-established indefinitely; events published while disconnected are buffered by the client.
+type natsSink struct {
+	ctx        context.Context // aborts JetStream publishes waiting for an acknowledgement once canceled
+	conn       *nats.Conn
+	js         jetstream.JetStream // nil unless publishing to JetStream
+	subject    string
+	ackTimeout time.Duration
+}
+
+// NewNATSSink connects to NATS and returns a sink publishing each event to a subject
+func NewNATSSink(options NATSOptions) (Sink, error) {
+	if options.URL == "" || options.Subject == "" {
+		return nil, fmt.Errorf("the NATS sink requires a server URL and a subject")
+	}
+	natsOptions := []nats.Option{
+		nats.Name("pod-watcher"),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2 * time.Second),
+		nats.RetryOnFailedConnect(true),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				slog.Warn("Disconnected from NATS, reconnecting", "error", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			slog.Info("Reconnected to NATS", "server", conn.ConnectedUrl())
+		}),
+	}
+	if options.Credentials != "" {
+		natsOptions = append(natsOptions, nats.UserCredentials(options.Credentials))
+	}
+	if options.Token != "" {
+		natsOptions = append(natsOptions, nats.Token(options.Token))
+	}
+	tlsConfig, err := options.TLS.config()
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS TLS settings: %w", err)
+	}
+	if tlsConfig != nil {
+		natsOptions = append(natsOptions, nats.Secure(tlsConfig))
+	}
+	conn, err := nats.Connect(options.URL, natsOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to NATS: %w", err)
+	}
+	s := &natsSink{ctx: context.Background(), conn: conn, subject: options.Subject, ackTimeout: options.AckTimeout}
+	if options.JetStream {
+		if s.js, err = jetstream.New(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not use JetStream: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// bind aborts the publishes waiting for an acknowledgement once the context is canceled
+func (s *natsSink) bind(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// Write publishes the event, waiting for the stream's acknowledgement with JetStream
+func (s *natsSink) Write(event Event) error {
+	data, err := json.Marshal(newEnvelope(event))
+	if err != nil {
+		marshalErrors.Inc()
+		return fmt.Errorf("could not marshal event: %w", err)
+	}
+	msg := nats.NewMsg(s.subject)
+	msg.Data = data
+	// Headers can only be sent once the server is known to support them, so not while still connecting
+	if s.conn.HeadersSupported() {
+		msg.Header.Set(natsEventHeader, event.Type)
+		msg.Header.Set(natsKeyHeader, event.Key)
+		if event.Cluster != "" {
+			msg.Header.Set(natsClusterHeader, event.Cluster)
+		}
+	}
+	if s.js == nil {
+		err = s.conn.PublishMsg(msg)
+	} else {
+		ctx, cancel := context.WithTimeout(s.ctx, s.ackTimeout)
+		defer cancel()
+		_, err = s.js.PublishMsg(ctx, msg)
+	}
+	if err != nil {
+		natsFailures.Inc()
+	}
+	return err
+}
+
+// Close flushes the buffered messages and closes the connection
+func (s *natsSink) Close() error {
+	defer s.conn.Close()
+	if s.conn.IsConnected() {
+		return s.conn.FlushTimeout(5 * time.Second)
+	}
+	return nil
+}
+
+// String names the sink for logs and metrics
+func (s *natsSink) String() string {
+	return "nats:" + s.subject
+}
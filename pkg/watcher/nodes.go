@@ -0,0 +1,169 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// nodeSyncTimeout bounds the wait for the node caches before the pods are watched, e.g. without permission to list nodes
+const nodeSyncTimeout = 30 * time.Second
+
+// NodeInfo is a summary of the node a pod runs on, added to its events with WithNodeInfo
+type NodeInfo struct {
+	Name          string            `json:"name"`
+	Unschedulable bool              `json:"unschedulable,omitempty"` // the node is cordoned
+	Taints        []string          `json:"taints,omitempty"`        // as key=value:effect
+	Conditions    map[string]string `json:"conditions,omitempty"`    // condition type -> status, e.g. Ready -> True
+	Allocatable   map[string]string `json:"allocatable,omitempty"`   // cpu, memory, ephemeral-storage and pods
+}
+
+// String formats the node compactly, e.g. "node-3 (Ready, MemoryPressure, taints: dedicated=gpu:NoSchedule, allocatable: cpu=3920m memory=14Gi pods=110)"
+func (n *NodeInfo) String() string {
+	var parts []string
+	if n.Conditions[string(corev1.NodeReady)] == string(corev1.ConditionTrue) {
+		parts = append(parts, "Ready")
+	} else {
+		parts = append(parts, "NotReady")
+	}
+	// The other conditions, such as MemoryPressure, report problems when true
+	for _, condition := range []corev1.NodeConditionType{corev1.NodeMemoryPressure, corev1.NodeDiskPressure, corev1.NodePIDPressure, corev1.NodeNetworkUnavailable} {
+		if n.Conditions[string(condition)] == string(corev1.ConditionTrue) {
+			parts = append(parts, string(condition))
+		}
+	}
+	if n.Unschedulable {
+		parts = append(parts, "Unschedulable")
+	}
+	if len(n.Taints) > 0 {
+		parts = append(parts, "taints: "+strings.Join(n.Taints, ","))
+	}
+	var allocatable []string
+	for _, resource := range allocatableResources {
+		if quantity, ok := n.Allocatable[string(resource)]; ok {
+			allocatable = append(allocatable, string(resource)+"="+quantity)
+		}
+	}
+	if len(allocatable) > 0 {
+		parts = append(parts, "allocatable: "+strings.Join(allocatable, " "))
+	}
+	return n.Name + " (" + strings.Join(parts, ", ") + ")"
+}
+
+// allocatableResources are the allocatable resources of the node summarized in NodeInfo
+var allocatableResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage, corev1.ResourcePods}
+
+// nodeInfo summarizes a node
+func nodeInfo(node *corev1.Node) *NodeInfo {
+	info := &NodeInfo{Name: node.Name, Unschedulable: node.Spec.Unschedulable}
+	for _, taint := range node.Spec.Taints {
+		text := taint.Key
+		if taint.Value != "" {
+			text += "=" + taint.Value
+		}
+		info.Taints = append(info.Taints, text+":"+string(taint.Effect))
+	}
+	for _, condition := range node.Status.Conditions {
+		if info.Conditions == nil {
+			info.Conditions = make(map[string]string)
+		}
+		info.Conditions[string(condition.Type)] = string(condition.Status)
+	}
+	for _, resource := range allocatableResources {
+		if quantity, ok := node.Status.Allocatable[resource]; ok {
+			if info.Allocatable == nil {
+				info.Allocatable = make(map[string]string)
+			}
+			info.Allocatable[string(resource)] = quantity.String()
+		}
+	}
+	return info
+}
+
+// trimNode keeps only the fields of a node summarized in NodeInfo, which keeps the cache small
+func trimNode(obj interface{}) (interface{}, error) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return obj, nil // a tombstone
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: node.Name, ResourceVersion: node.ResourceVersion},
+		Spec:       corev1.NodeSpec{Unschedulable: node.Spec.Unschedulable, Taints: node.Spec.Taints},
+		Status:     corev1.NodeStatus{Conditions: node.Status.Conditions, Allocatable: node.Status.Allocatable},
+	}, nil
+}
+
+// nodeCache caches the nodes of every watched cluster through an informer per cluster (--include-node),
+// so that the node of each emitted pod is looked up without a request
+type nodeCache struct {
+	informers map[string]cache.SharedIndexInformer // cluster name -> the informer of its nodes
+}
+
+func newNodeCache(ctx context.Context, clusters []*cluster, watchTimeout time.Duration) (*nodeCache, error) {
+	n := &nodeCache{informers: make(map[string]cache.SharedIndexInformer)}
+	for _, c := range clusters {
+		nodes := c.clientset.CoreV1().Nodes()
+		listWatch := &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return nodes.List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				if timeout := watchTimeoutSeconds(watchTimeout); timeout != nil {
+					options.TimeoutSeconds = timeout
+				}
+				return nodes.Watch(ctx, options)
+			},
+		}
+		informer := cache.NewSharedIndexInformer(listWatch, &corev1.Node{}, 0, cache.Indexers{})
+		if err := informer.SetTransform(trimNode); err != nil {
+			return nil, fmt.Errorf("could not register node transform: %w", err)
+		}
+		n.informers[c.name] = informer
+	}
+	return n, nil
+}
+
+// run runs the informers until the context is canceled, and waits for their initial lists for up to nodeSyncTimeout,
+// so that the first pod events already have their node
+func (n *nodeCache) run(ctx context.Context, wg *sync.WaitGroup) {
+	var synced []cache.InformerSynced
+	for _, informer := range n.informers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			informer.Run(ctx.Done())
+		}()
+		synced = append(synced, informer.HasSynced)
+	}
+	syncCtx, cancel := context.WithTimeout(ctx, nodeSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), synced...) && ctx.Err() == nil {
+		slog.Warn("Could not list the nodes, the events will lack them until they are", "timeout", nodeSyncTimeout)
+	}
+}
+
+// get returns the summary of the node of the cluster, or nil if it is not known
+func (n *nodeCache) get(cluster string, name string) *NodeInfo {
+	informer, ok := n.informers[cluster]
+	if !ok || name == "" {
+		return nil
+	}
+	obj, exists, err := informer.GetStore().GetByKey(name)
+	if err != nil || !exists {
+		return nil
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return nil
+	}
+	return nodeInfo(node)
+}
@@ -0,0 +1,358 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Triggers of the notification sinks
+const (
+	TriggerDeleted   = "deleted"   // the object was deleted
+	TriggerFailed    = "failed"    // the pod entered the Failed phase
+	TriggerRestarted = "restarted" // the restart count of a container of the pod increased
+	TriggerAlert     = "alert"     // an ALERT event was raised for the pod, with WithAlerts or WithTerminationTracking
+)
+
+// Services the notification sinks post to
+const (
+	NotifySlack = "slack"
+	NotifyTeams = "teams"
+)
+
+// maxSnippetLines bounds the status snippet of a notification
+const maxSnippetLines = 10
+
+// NotifyOptions configures a notification sink
+type NotifyOptions struct {
+	Service  string // NotifySlack or NotifyTeams
+	URL      string // the incoming webhook of the channel
+	Triggers []string
+	// Interval is the minimum time between two notifications of the same trigger for the same object; 0 disables throttling
+	Interval time.Duration
+	Timeout  time.Duration
+	Retries  int           // additional attempts after the first failure
+	Backoff  time.Duration // delay before the first retry, doubling after each attempt
+}
+
+// notifyState is what a notification sink remembers of an object to detect its triggers
+type notifyState struct {
+	phase    corev1.PodPhase
+	restarts map[string]int32 // container name -> restart count
+}
+
+// notifySink is the Sink posting a formatted message to a Slack or Teams channel when an event matches one of its
+// triggers, rather than every event. Each trigger is throttled per object, so that a crash-looping pod does not
+// flood the channel. Objects first seen in a modification only set the baseline of the restart counts.
+type notifySink struct {
+	service  string
+	delivery *webhookSink
+	triggers map[string]bool
+	interval time.Duration
+
+	states map[string]*notifyState // object key, qualified by its cluster -> its last state
+	sent   map[string]time.Time    // trigger and qualified object key -> time of its last notification
+}
+
+// NewNotifySink returns a sink posting notifications to a Slack or Teams incoming webhook
+func NewNotifySink(options NotifyOptions) (Sink, error) {
+	switch options.Service {
+	case NotifySlack, NotifyTeams:
+	default:
+		return nil, fmt.Errorf("unsupported notification service %q (must be %s or %s)", options.Service, NotifySlack, NotifyTeams)
+	}
+	if options.URL == "" {
+		return nil, fmt.Errorf("the %s notification sink requires a webhook URL", options.Service)
+	}
+	triggers, err := parseTriggers(options.Triggers)
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := newWebhookSink(options.URL, nil, options.Timeout, options.Retries, options.Backoff, "")
+	if err != nil {
+		return nil, err
+	}
+	return &notifySink{
+		service:  options.Service,
+		delivery: delivery,
+		triggers: triggers,
+		interval: options.Interval,
+		states:   make(map[string]*notifyState),
+		sent:     make(map[string]time.Time),
+	}, nil
+}
+
+// parseTriggers validates the triggers, defaulting to all of them
+func parseTriggers(triggers []string) (map[string]bool, error) {
+	if len(triggers) == 0 {
+		triggers = []string{TriggerDeleted, TriggerFailed, TriggerRestarted}
+	}
+	parsed := make(map[string]bool)
+	for _, trigger := range triggers {
+		trigger = strings.ToLower(strings.TrimSpace(trigger))
+		switch trigger {
+		case TriggerDeleted, TriggerFailed, TriggerRestarted, TriggerAlert:
+			parsed[trigger] = true
+		default:
+			return nil, fmt.Errorf("invalid notification trigger %q (must be %s, %s, %s or %s)", trigger, TriggerDeleted, TriggerFailed, TriggerRestarted, TriggerAlert)
+		}
+	}
+	return parsed, nil
+}
+
+// bind aborts the deliveries once the context is canceled
+func (s *notifySink) bind(ctx context.Context) {
+	s.delivery.bind(ctx)
+}
+
+// Write posts a notification for each trigger the event matches that is not throttled
+func (s *notifySink) Write(event Event) error {
+	var notifications []notification
+	switch event.Type {
+	case string(watch.Added), string(watch.Modified), string(watch.Deleted), ResyncEvent:
+		notifications = s.detect(event)
+	case AlertEvent:
+		if s.triggers[TriggerAlert] && event.Alert != nil {
+			n := notification{trigger: TriggerAlert, event: event, title: "is degraded", reason: event.Alert.String()}
+			if pod, ok := event.Object.(*corev1.Pod); ok {
+				n.snippet = podSnippet(pod)
+			}
+			notifications = append(notifications, n)
+		}
+	default:
+		return nil
+	}
+	var errs []string
+	for _, n := range notifications {
+		id := n.trigger + "\x00" + clusterKey(event.Cluster, event.Key)
+		if last, ok := s.sent[id]; ok && s.interval > 0 && time.Since(last) < s.interval {
+			slog.Debug("Notification throttled", "sink", s.String(), "trigger", n.trigger, "key", event.Key)
+			continue
+		}
+		s.sent[id] = time.Now()
+		if err := s.delivery.deliver(s.delivery.ctx, s.payload(n)); err != nil {
+			notifyFailures.Inc()
+			errs = append(errs, err.Error())
+		}
+	}
+	if event.Type == string(watch.Deleted) {
+		s.forget(clusterKey(event.Cluster, event.Key))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not post notification: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Close releases the idle connections to the webhook
+func (s *notifySink) Close() error {
+	return s.delivery.Close()
+}
+
+// String names the sink for logs and metrics
+func (s *notifySink) String() string {
+	return s.service
+}
+
+// forget drops the state and throttling of a deleted object
+func (s *notifySink) forget(id string) {
+	delete(s.states, id)
+	for _, trigger := range []string{TriggerDeleted, TriggerFailed, TriggerRestarted, TriggerAlert} {
+		delete(s.sent, trigger+"\x00"+id)
+	}
+}
+
+// evict forgets the state and throttling of the object
+func (s *notifySink) evict(id string) {
+	s.forget(id)
+}
+
+// notification is a matched trigger of an event, ready to be formatted
+type notification struct {
+	trigger string
+	event   Event
+	title   string
+	reason  string
+	snippet []string // lines of the relevant status
+}
+
+// detect records the state of the object, returning the notifications of the triggers the event matches
+func (s *notifySink) detect(event Event) []notification {
+	id := clusterKey(event.Cluster, event.Key)
+	pod, isPod := event.Object.(*corev1.Pod)
+	var notifications []notification
+	if event.Type == string(watch.Deleted) {
+		if s.triggers[TriggerDeleted] {
+			n := notification{trigger: TriggerDeleted, event: event, title: "deleted", reason: "Deleted"}
+			if isPod {
+				n.reason = "Deleted in phase " + string(pod.Status.Phase)
+				n.snippet = podSnippet(pod)
+			}
+			notifications = append(notifications, n)
+		}
+		return notifications
+	}
+	if !isPod {
+		return nil
+	}
+	previous, seen := s.states[id]
+	current := &notifyState{phase: pod.Status.Phase, restarts: make(map[string]int32)}
+	s.states[id] = current
+	var restarted []corev1.ContainerStatus
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			current.restarts[status.Name] = status.RestartCount
+			if seen && status.RestartCount > previous.restarts[status.Name] {
+				restarted = append(restarted, status)
+			}
+		}
+	}
+	if s.triggers[TriggerFailed] && pod.Status.Phase == corev1.PodFailed && (!seen || previous.phase != corev1.PodFailed) {
+		reason := pod.Status.Reason
+		if reason == "" {
+			reason = string(corev1.PodFailed)
+		}
+		if pod.Status.Message != "" {
+			reason += ": " + pod.Status.Message
+		}
+		notifications = append(notifications, notification{trigger: TriggerFailed, event: event, title: "failed", reason: reason, snippet: podSnippet(pod)})
+	}
+	if s.triggers[TriggerRestarted] && len(restarted) > 0 {
+		var reasons []string
+		for _, status := range restarted {
+			reason := status.Name + " restarted"
+			if last := status.LastTerminationState.Terminated; last != nil {
+				reason += fmt.Sprintf(" (exit code %d", last.ExitCode)
+				if last.Reason != "" {
+					reason += ", " + last.Reason
+				}
+				reason += ")"
+			}
+			reasons = append(reasons, reason)
+		}
+		notifications = append(notifications, notification{trigger: TriggerRestarted, event: event, title: "restarted", reason: strings.Join(reasons, "; "), snippet: podSnippet(pod)})
+	}
+	return notifications
+}
+
+// podSnippet summarizes the status of the containers of the pod, one line each, e.g.
+// "app: waiting (CrashLoopBackOff), restarts: 3, last terminated: OOMKilled, exit code 137"
+func podSnippet(pod *corev1.Pod) []string {
+	var lines []string
+	for _, status := range append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...) {
+		line := status.Name + ": "
+		switch state := status.State; {
+		case state.Running != nil:
+			line += "running"
+		case state.Waiting != nil:
+			line += "waiting (" + state.Waiting.Reason + ")"
+		case state.Terminated != nil:
+			line += fmt.Sprintf("terminated (%s, exit code %d)", state.Terminated.Reason, state.Terminated.ExitCode)
+		default:
+			line += "unknown"
+		}
+		line += fmt.Sprintf(", restarts: %d", status.RestartCount)
+		if last := status.LastTerminationState.Terminated; last != nil {
+			line += fmt.Sprintf(", last terminated: %s, exit code %d", last.Reason, last.ExitCode)
+			if last.Message != "" {
+				line += ": " + strings.Join(strings.Fields(last.Message), " ")
+			}
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > maxSnippetLines {
+		lines = append(lines[:maxSnippetLines], fmt.Sprintf("... and %d more containers", len(lines)-maxSnippetLines))
+	}
+	return lines
+}
+
+// facts lists the identity of the object of the notification, as name/value pairs
+func (n notification) facts() [][2]string {
+	namespace, name := "", n.event.Key
+	if objMeta, ok := n.event.Object.(metav1.Object); ok {
+		namespace, name = objMeta.GetNamespace(), objMeta.GetName()
+	}
+	facts := [][2]string{{"Name", name}}
+	if namespace != "" {
+		facts = append(facts, [2]string{"Namespace", namespace})
+	}
+	if n.event.Cluster != "" {
+		facts = append(facts, [2]string{"Cluster", n.event.Cluster})
+	}
+	if pod, ok := n.event.Object.(*corev1.Pod); ok && pod.Spec.NodeName != "" {
+		facts = append(facts, [2]string{"Node", pod.Spec.NodeName})
+	}
+	if n.event.Owner != nil {
+		facts = append(facts, [2]string{"Owner", n.event.Owner.String()})
+	}
+	return append(facts, [2]string{"Reason", n.reason})
+}
+
+// heading is the first line of the notification, e.g. "Pod default/web-0 restarted"
+func (n notification) heading() string {
+	kind := n.event.Object.GetObjectKind().GroupVersionKind().Kind
+	if _, ok := n.event.Object.(*corev1.Pod); ok {
+		kind = "Pod" // typed objects from the informers carry no kind
+	} else if kind == "" {
+		kind = "Object"
+	}
+	return fmt.Sprintf("%s %s %s", kind, n.event.Key, n.title)
+}
+
+// payload formats the notification as the message of the service
+func (s *notifySink) payload(n notification) []byte {
+	var message any
+	if s.service == NotifyTeams {
+		message = teamsMessage(n)
+	} else {
+		message = slackMessage(n)
+	}
+	body, _ := json.Marshal(message)
+	return body
+}
+
+// slackMessage formats the notification as a Slack message in mrkdwn
+func slackMessage(n notification) map[string]any {
+	lines := []string{"*" + n.heading() + "*"}
+	for _, fact := range n.facts() {
+		lines = append(lines, fmt.Sprintf("*%s:* %s", fact[0], fact[1]))
+	}
+	if len(n.snippet) > 0 {
+		lines = append(lines, "```"+strings.Join(n.snippet, "\n")+"```")
+	}
+	return map[string]any{"text": strings.Join(lines, "\n")}
+}
+
+// teamsMessage formats the notification as an Adaptive Card, as accepted by Teams workflow webhooks
+func teamsMessage(n notification) map[string]any {
+	var facts []map[string]string
+	for _, fact := range n.facts() {
+		facts = append(facts, map[string]string{"title": fact[0], "value": fact[1]})
+	}
+	body := []map[string]any{
+		{"type": "TextBlock", "text": n.heading(), "weight": "Bolder", "size": "Medium", "wrap": true},
+		{"type": "FactSet", "facts": facts},
+	}
+	if len(n.snippet) > 0 {
+		body = append(body, map[string]any{"type": "TextBlock", "text": strings.Join(n.snippet, "\n\n"), "fontType": "Monospace", "wrap": true})
+	}
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+			},
+		}},
+	}
+}
@@ -0,0 +1,54 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultOptIn is the conventional annotation or label of the pods opting in to be watched,
+// for --watch-annotation and --watch-label
+const DefaultOptIn = "pod-watcher.io/watch=true"
+
+// optIn is the annotation or label, given as KEY=VALUE or just KEY, that the watched objects must carry.
+// Without a value, carrying the key with any value is enough.
+type optIn struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// parseOptIn parses the KEY[=VALUE] of the flag, checking that it is a valid annotation or label
+func parseOptIn(flag, spec string, label bool) (*optIn, error) {
+	key, value, hasValue := strings.Cut(strings.TrimSpace(spec), "=")
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid --%s %q: %s", flag, spec, strings.Join(errs, "; "))
+	}
+	if label && hasValue {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid --%s %q: %s", flag, spec, strings.Join(errs, "; "))
+		}
+	}
+	return &optIn{key: key, value: value, hasValue: hasValue}, nil
+}
+
+// selector returns the opt-in as a label selector
+func (o *optIn) selector() string {
+	if !o.hasValue {
+		return o.key
+	}
+	return o.key + "=" + o.value
+}
+
+// annotated reports whether the object carries the opt-in as an annotation
+func (o *optIn) annotated(obj runtime.Object) bool {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	value, ok := objMeta.GetAnnotations()[o.key]
+	return ok && (!o.hasValue || value == o.value)
+}
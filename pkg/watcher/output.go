@@ -0,0 +1,577 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/yaml"
+)
+
+// Supported values of the --output flag
+const (
+	OutputYAML  = "yaml"  // a YAML stream with one document per event (the default)
+	OutputJSON  = "json"  // one indented JSON envelope per event
+	OutputJSONL = "jsonl" // one single-line JSON envelope per event
+	OutputDiff  = "diff"  // a YAML stream where modifications are shown as a unified diff against the previous revision
+	OutputTable = "table" // one line per event with the status of the pod, like kubectl get pods --watch
+	OutputWide  = "wide"  // the table with the IP and the conditions of the pod
+)
+
+// eventEnvelope wraps an object with the details of the event for the JSON output formats.
+// Pods are carried in the pod field; any other resource watched with --resource in the object field.
+type eventEnvelope struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	Owner     *Owner    `json:"owner,omitempty"`
+	// Container is the change of a CONTAINER event, which carries it instead of the pod
+	Container *ContainerChange `json:"container,omitempty"`
+	// Timeline is the lifecycle of the pod of a TIMELINE event, which carries it instead of the pod
+	Timeline *Timeline `json:"timeline,omitempty"`
+	// Alert is the detection of an ALERT event, which carries it instead of the pod
+	Alert *Alert `json:"alert,omitempty"`
+	// Changes are the writes of the field managers since the previous revision with --include-managed-fields-summary
+	Changes []*Change `json:"changes,omitempty"`
+	// Node is the summary of the node of the pod with --include-node
+	Node *NodeInfo `json:"node,omitempty"`
+	// Deletion is the reason of the deletion of the pod of a DELETED event with --attribute-deletions
+	Deletion *Deletion `json:"deletion,omitempty"`
+	// Usage is the resource usage of the pod with --include-metrics, which METRICS events carry instead of the pod
+	Usage *PodUsage `json:"usage,omitempty"`
+	// Reference is the change of a REFERENCE event, which carries it instead of the pod
+	Reference *ReferenceChange `json:"reference,omitempty"`
+	// Volume is the change of a VOLUME event, which carries it instead of the pod
+	Volume *VolumeChange `json:"volume,omitempty"`
+	// Termination is the deletion of a TERMINATING or FINALIZED event, which carries it instead of the pod
+	Termination *Termination `json:"termination,omitempty"`
+	// Init is the milestone of an init container of an INIT event, which carries it instead of the pod
+	Init *InitChange `json:"init,omitempty"`
+	// Image is the change of an IMAGE_CHANGED event, which carries it instead of the pod
+	Image *ImageChange `json:"image,omitempty"`
+	// Security is the change of a SECURITY event, which carries it instead of the pod
+	Security *SecurityChange `json:"security,omitempty"`
+	// Backfill is the past occurrence of a BACKFILL event, which carries it instead of the pod
+	Backfill *Backfill      `json:"backfill,omitempty"`
+	Pod      *corev1.Pod    `json:"pod,omitempty"`
+	Object   runtime.Object `json:"object,omitempty"`
+}
+
+// logLine is a container log line in the JSON output formats
+type logLine struct {
+	Type      string    `json:"type"` // always "LOG"
+	Timestamp time.Time `json:"timestamp"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Container string    `json:"container"`
+	Line      string    `json:"line"`
+}
+
+// logEvent is the type of log lines in the JSON output formats
+const logEvent = "LOG"
+
+// newEnvelope wraps the object of an event in an envelope
+func newEnvelope(event Event) *eventEnvelope {
+	obj := event.Object
+	envelope := &eventEnvelope{
+		Type:        event.Type,
+		Timestamp:   event.Timestamp,
+		Cluster:     event.Cluster,
+		Owner:       event.Owner,
+		Container:   event.Container,
+		Timeline:    event.Timeline,
+		Alert:       event.Alert,
+		Changes:     event.Changes,
+		Node:        event.Node,
+		Deletion:    event.Deletion,
+		Usage:       event.Usage,
+		Reference:   event.Reference,
+		Volume:      event.Volume,
+		Termination: event.Termination,
+		Init:        event.Init,
+		Image:       event.Image,
+		Security:    event.Security,
+		Backfill:    event.Backfill,
+	}
+	if objMeta, err := meta.Accessor(obj); err == nil {
+		envelope.Namespace, envelope.Name = objMeta.GetNamespace(), objMeta.GetName()
+	}
+	if event.notice() {
+		return envelope
+	}
+	if pod, ok := obj.(*corev1.Pod); ok {
+		envelope.Pod = pod
+	} else {
+		envelope.Object = obj
+	}
+	return envelope
+}
+
+// eventWriter is the Sink serializing event documents onto an output stream or file.
+// Container log lines are written through it as well, so writes are guarded by a mutex.
+type eventWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	name      string // "stream", or "file:" and the path
+	format    string
+	file      *rotatingFile     // nil unless writing to a file
+	previous  map[string]string // diff format only: object key, qualified by its cluster -> last emitted YAML
+	widths    []int             // table formats only: widths of the columns, nil until the header is written
+	clustered bool              // table formats only: whether the table has a CLUSTER column
+	owners    bool              // table formats only: whether the table has an OWNER column, with --resolve-owners
+	colors    *colorizer        // nil unless writing colorized output to a terminal
+	template  *outputTemplate   // template formats only
+	source    string            // cloudevents format only: the source of the log lines and documents of an unnamed cluster
+	framer    *framer           // nil unless framing the records (WithFraming)
+}
+
+// newEventWriter returns an eventWriter in the given format for w
+func newEventWriter(w io.Writer, format string) (*eventWriter, error) {
+	e := &eventWriter{w: w, name: "stream", format: format}
+	switch format {
+	case OutputYAML, OutputJSON, OutputJSONL, OutputCloudEvents, OutputDiff, OutputTable, OutputWide:
+	default:
+		tmpl, ok, err := parseOutputTemplate(format)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("unsupported output format %q (must be one of %s, %s, %s, %s, %s, %s, %s, %s=TEMPLATE, or %s=TEMPLATE)",
+				format, OutputYAML, OutputJSON, OutputJSONL, OutputCloudEvents, OutputDiff, OutputTable, OutputWide, OutputGoTemplate, OutputJSONPath)
+		}
+		e.template = tmpl
+	}
+	if format == OutputDiff {
+		e.previous = make(map[string]string)
+	}
+	return e, nil
+}
+
+// eventSubject returns the object an event is about: the pod of a Kubernetes Event, otherwise the object of the event
+func eventSubject(event Event) (cluster, namespace, name string) {
+	namespace, name = "", event.Key
+	if kubeEvent, ok := event.Object.(*corev1.Event); ok {
+		namespace, name = kubeEvent.InvolvedObject.Namespace, kubeEvent.InvolvedObject.Name
+	} else if objMeta, err := meta.Accessor(event.Object); err == nil {
+		namespace, name = objMeta.GetNamespace(), objMeta.GetName()
+	}
+	return event.Cluster, namespace, name
+}
+
+// openOutputFile returns an eventWriter in the given format for the file at path.
+// The file is gzip-compressed when compress is true or the path ends in ".gz", and rotated as configured.
+func openOutputFile(path string, compress bool, format string, rotation FileRotation) (*eventWriter, error) {
+	e, err := newEventWriter(nil, format)
+	if err != nil {
+		return nil, err
+	}
+	if rotation.MaxSize > 0 && rotation.MaxFiles < 1 {
+		return nil, fmt.Errorf("at least one rotated output file must be kept when rotating output files")
+	}
+	f, err := openRotatingFile(path, compress || strings.HasSuffix(path, ".gz"), rotation)
+	if err != nil {
+		return nil, err
+	}
+	e.w, e.file, e.name = f, f, "file:"+path
+	return e, nil
+}
+
+// Write outputs the event as one document in the stream
+func (e *eventWriter) Write(event Event) error {
+	objYAML := event.yaml
+	if objYAML == "" && !event.notice() && (e.format == OutputYAML || e.format == OutputDiff) {
+		data, err := yaml.Marshal(event.Object)
+		if err != nil {
+			marshalErrors.Inc()
+			return fmt.Errorf("could not marshal %s to YAML: %w", event.Key, err)
+		}
+		objYAML = string(data)
+	}
+	return e.writeEvent(event, objYAML)
+}
+
+// String names the sink for logs and metrics
+func (e *eventWriter) String() string {
+	return e.name
+}
+
+// evict forgets the revision of the object last written in the diff format
+func (e *eventWriter) evict(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.previous, id)
+}
+
+// writeEvent outputs the event as one document in the stream.
+// objYAML is the serialized object, used as-is by the YAML formats.
+func (e *eventWriter) writeEvent(event Event, objYAML string) (err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer func() {
+		if endErr := e.endDocument(); err == nil {
+			err = endErr
+		}
+	}()
+	switch e.format {
+	case OutputYAML, OutputDiff, OutputTable, OutputWide:
+		if event.notice() {
+			// Container changes, timelines, alerts, usage, references, volumes and terminations are compact notices, written as comments like the log lines
+			if e.format == OutputTable || e.format == OutputWide {
+				if err := e.writeTableHeader(event); err != nil {
+					return err
+				}
+			}
+			_, err = fmt.Fprintln(e.w, e.colors.event(event.Type, noticeText(event)))
+			return err
+		}
+	}
+	switch e.format {
+	case OutputYAML:
+		_, err = fmt.Fprintf(e.w, "---\n%s\n\n%s\n", e.colors.event(event.Type, eventHeader(event)), e.colors.highlight(objYAML))
+		return err
+	case OutputDiff:
+		return e.writeDiff(event, objYAML)
+	case OutputTable, OutputWide:
+		return e.writeTable(event)
+	}
+	if e.format == OutputCloudEvents {
+		return e.writeJSON(event.Type, newCloudEvent(event, event.source))
+	}
+	envelope := newEnvelope(event)
+	if e.template != nil {
+		out, err := e.template.render(envelope)
+		if err != nil {
+			return fmt.Errorf("could not render %s through the template: %w", event.Key, err)
+		}
+		_, err = fmt.Fprintln(e.w, e.colors.event(event.Type, out))
+		return err
+	}
+	var data []byte
+	if e.format == OutputJSON {
+		data, err = json.MarshalIndent(envelope, "", "  ")
+	} else {
+		data, err = json.Marshal(envelope)
+	}
+	if err != nil {
+		marshalErrors.Inc()
+		return fmt.Errorf("could not marshal %s to JSON: %w", event.Key, err)
+	}
+	_, err = fmt.Fprintf(e.w, "%s\n", e.colors.event(event.Type, e.colors.highlight(string(data))))
+	return err
+}
+
+// eventHeader returns the comment lines introducing an event document in the YAML formats
+func eventHeader(event Event) string {
+	header := "## Event: " + event.Type
+	if event.Cluster != "" {
+		header += "\n## Cluster: " + event.Cluster
+	}
+	if event.Owner != nil {
+		header += "\n## Owner: " + event.Owner.String()
+	}
+	for _, change := range event.Changes {
+		header += "\n## Changed by: " + change.String()
+	}
+	if event.Node != nil {
+		header += "\n## Node: " + event.Node.String()
+	}
+	if event.Deletion != nil {
+		header += "\n## Deletion: " + event.Deletion.String()
+	}
+	if event.Usage != nil {
+		header += "\n## Usage: " + event.Usage.String()
+	}
+	return header
+}
+
+// notice reports whether the event is a notice about its pod, a CONTAINER, TIMELINE, ALERT, METRICS, REFERENCE, VOLUME,
+// TERMINATING, FINALIZED, INIT, IMAGE_CHANGED, SECURITY or BACKFILL event, rather than a revision
+func (event Event) notice() bool {
+	return event.Container != nil || event.Timeline != nil || event.Alert != nil || event.Type == MetricsEvent ||
+		event.Reference != nil || event.Volume != nil || event.Termination != nil || event.Init != nil ||
+		event.Image != nil || event.Security != nil || event.Backfill != nil
+}
+
+// noticeText formats a notice as comment lines
+func noticeText(event Event) string {
+	switch {
+	case event.Timeline != nil:
+		return timelineNotice(event)
+	case event.Alert != nil:
+		return alertNotice(event)
+	case event.Type == MetricsEvent:
+		return usageNotice(event)
+	case event.Reference != nil:
+		return referenceNotice(event)
+	case event.Volume != nil:
+		return volumeNotice(event)
+	case event.Termination != nil:
+		return terminationNotice(event)
+	case event.Init != nil:
+		return initNotice(event)
+	case event.Image != nil:
+		return imageNotice(event)
+	case event.Security != nil:
+		return securityNotice(event)
+	case event.Backfill != nil:
+		return backfillNotice(event)
+	default:
+		return containerNotice(event)
+	}
+}
+
+// noticePrefix starts the comment line of a notice with its kind and the pod it is about, along with the container
+// of the notices about one, e.g. "## Container [default/web/app]"
+func noticePrefix(event Event) string {
+	namespace, name := "", event.Key
+	if objMeta, err := meta.Accessor(event.Object); err == nil {
+		namespace, name = objMeta.GetNamespace(), objMeta.GetName()
+	}
+	subject := clusterKey(event.Cluster, namespace) + "/" + name
+	var kind string
+	switch {
+	case event.Timeline != nil:
+		kind = "Timeline"
+	case event.Alert != nil:
+		kind = "Alert"
+	case event.Type == MetricsEvent:
+		kind = "Usage"
+	case event.Reference != nil:
+		kind = "Reference"
+	case event.Volume != nil:
+		kind = "Volume"
+	case event.Termination != nil && event.Type == FinalizedEvent:
+		kind = "Finalized"
+	case event.Termination != nil:
+		kind = "Terminating"
+	case event.Init != nil:
+		kind = "Init"
+	case event.Image != nil:
+		kind, subject = "Image changed", subject+"/"+event.Image.Container
+	case event.Security != nil:
+		kind = "Security"
+	case event.Backfill != nil:
+		kind = "Backfill"
+	default:
+		kind, subject = "Container", subject+"/"+event.Container.Container
+	}
+	return fmt.Sprintf("## %s [%s]", kind, subject)
+}
+
+// containerNotice formats the change of a CONTAINER event as a comment line
+func containerNotice(event Event) string {
+	return fmt.Sprintf("%s: %s", noticePrefix(event), event.Container)
+}
+
+// alertNotice formats the alert of an ALERT event as a comment line
+func alertNotice(event Event) string {
+	return fmt.Sprintf("%s: %s", noticePrefix(event), event.Alert)
+}
+
+// usageNotice formats the resource usage of a METRICS event as a comment line
+func usageNotice(event Event) string {
+	return fmt.Sprintf("%s: %s", noticePrefix(event), event.Usage)
+}
+
+// referenceNotice formats the change of a REFERENCE event as a comment line
+func referenceNotice(event Event) string {
+	return fmt.Sprintf("%s: %s", noticePrefix(event), event.Reference)
+}
+
+// volumeNotice formats the change of a VOLUME event as a comment line
+func volumeNotice(event Event) string {
+	return fmt.Sprintf("%s: %s", noticePrefix(event), event.Volume)
+}
+
+// terminationNotice formats the deletion of a TERMINATING or FINALIZED event as a comment line
+func terminationNotice(event Event) string {
+	return fmt.Sprintf("%s: %s", noticePrefix(event), event.Termination)
+}
+
+// initNotice formats the milestone of an INIT event as a comment line
+func initNotice(event Event) string {
+	return fmt.Sprintf("%s: %s", noticePrefix(event), event.Init)
+}
+
+// imageNotice formats the change of an IMAGE_CHANGED event as a comment line
+func imageNotice(event Event) string {
+	return fmt.Sprintf("%s: %s", noticePrefix(event), event.Image)
+}
+
+// securityNotice formats the change of a SECURITY event as a comment line
+func securityNotice(event Event) string {
+	return fmt.Sprintf("%s: %s", noticePrefix(event), event.Security)
+}
+
+// backfillNotice formats the past occurrence of a BACKFILL event as a comment line
+func backfillNotice(event Event) string {
+	return fmt.Sprintf("%s: %s", noticePrefix(event), event.Backfill)
+}
+
+// timelineNotice formats the timeline of a TIMELINE event as comment lines, one per milestone
+func timelineNotice(event Event) string {
+	notice := noticePrefix(event)
+	if event.Timeline.Total != "" {
+		notice += ": " + event.Timeline.Total
+	}
+	for _, line := range strings.Split(event.Timeline.String(), "\n") {
+		if line != "" {
+			notice += "\n##   " + line
+		}
+	}
+	return notice
+}
+
+// writeLog outputs one container log line, prefixed with its origin.
+// In the YAML formats the line is written as a comment so the stream remains valid YAML, and the table formats do the same.
+func (e *eventWriter) writeLog(cluster, namespace, name, container, line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer func() { _ = e.endDocument() }()
+	switch e.format {
+	case OutputYAML, OutputDiff, OutputTable, OutputWide:
+		fmt.Fprintln(e.w, e.colors.paint(ansiGray, fmt.Sprintf("## Log [%s/%s/%s]: %s", clusterKey(cluster, namespace), name, container, line)))
+		return
+	}
+	entry := logLine{
+		Type:      logEvent,
+		Timestamp: time.Now().UTC(),
+		Cluster:   cluster,
+		Namespace: namespace,
+		Name:      name,
+		Container: container,
+		Line:      line,
+	}
+	if e.format == OutputCloudEvents {
+		_ = e.writeJSON(logEvent, newCloudEventOf("log", cloudEventSource(cluster, e.source), joinKey(namespace, name), entry.Timestamp, entry))
+		return
+	}
+	if e.template != nil {
+		out, err := e.template.render(entry)
+		if err != nil {
+			slog.Error("Could not render a log line through the template", "pod", clusterKey(cluster, namespace+"/"+name), "error", err)
+			return
+		}
+		fmt.Fprintln(e.w, e.colors.paint(ansiGray, out))
+		return
+	}
+	var data []byte
+	var err error
+	if e.format == OutputJSON {
+		data, err = json.MarshalIndent(entry, "", "  ")
+	} else {
+		data, err = json.Marshal(entry)
+	}
+	if err != nil {
+		marshalErrors.Inc()
+		return
+	}
+	fmt.Fprintln(e.w, e.colors.paint(ansiGray, string(data)))
+}
+
+// writeDocument outputs a document about the watch rather than an object, such as the statistics: as comment lines
+// in the YAML and table formats, like the log lines, and as a document of its own in the JSON and template formats
+func (e *eventWriter) writeDocument(documentType string, comment string, document any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer func() { _ = e.endDocument() }()
+	switch e.format {
+	case OutputYAML, OutputDiff, OutputTable, OutputWide:
+		fmt.Fprintln(e.w, e.colors.event(documentType, comment))
+		return
+	case OutputCloudEvents:
+		_ = e.writeJSON(documentType, newCloudEventOf(documentType, cloudEventSource("", e.source), "", time.Now().UTC(), document))
+		return
+	}
+	if e.template != nil {
+		out, err := e.template.render(document)
+		if err != nil {
+			slog.Error("Could not render the document through the template", "type", documentType, "error", err)
+			return
+		}
+		fmt.Fprintln(e.w, e.colors.event(documentType, out))
+		return
+	}
+	var data []byte
+	var err error
+	if e.format == OutputJSON {
+		data, err = json.MarshalIndent(document, "", "  ")
+	} else {
+		data, err = json.Marshal(document)
+	}
+	if err != nil {
+		marshalErrors.Inc()
+		return
+	}
+	fmt.Fprintln(e.w, e.colors.event(documentType, string(data)))
+}
+
+// writeJSON outputs the document on a single line, as a CloudEvent for instance
+func (e *eventWriter) writeJSON(documentType string, document any) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		marshalErrors.Inc()
+		return fmt.Errorf("could not marshal the %s document to JSON: %w", documentType, err)
+	}
+	_, err = fmt.Fprintln(e.w, e.colors.event(documentType, string(data)))
+	return err
+}
+
+// writeDiff outputs the change since the previously emitted revision of the object as a unified diff.
+// ADDED events, and objects seen for the first time, fall back to the full YAML document.
+func (e *eventWriter) writeDiff(event Event, objYAML string) error {
+	key := clusterKey(event.Cluster, event.Key)
+	previous, seen := e.previous[key]
+	if event.Type == string(watch.Deleted) {
+		delete(e.previous, key)
+	} else {
+		e.previous[key] = objYAML
+	}
+	if !seen || event.Type == string(watch.Added) {
+		_, err := fmt.Fprintf(e.w, "---\n%s\n\n%s\n", e.colors.event(event.Type, eventHeader(event)), e.colors.highlight(objYAML))
+		return err
+	}
+	diff := unifiedDiff(previous, objYAML)
+	if diff == "" {
+		return nil // nothing changed since the last emitted revision (e.g. a resync)
+	}
+	_, err := fmt.Fprintf(e.w, "---\n%s\n\n%s\n", e.colors.event(event.Type, eventHeader(event)+"\n## Diff: "+event.Key), e.colors.diff(diff))
+	return err
+}
+
+// endDocument writes the framed record once a complete document has been written, and lets the output file rotate
+func (e *eventWriter) endDocument() error {
+	if e.framer != nil {
+		if err := e.framer.flush(); err != nil {
+			return err
+		}
+	}
+	if e.file == nil {
+		return nil
+	}
+	if err := e.file.endDocument(); err != nil {
+		slog.Error("Failed to rotate output file", "error", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the output file so that a compressed stream is finalized.
+func (e *eventWriter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.file == nil {
+		return nil
+	}
+	return e.file.Close()
+}
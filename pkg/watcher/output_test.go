@@ -0,0 +1,174 @@
+package watcher
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPodEvent(eventType, name string, phase corev1.PodPhase) Event {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+	return Event{Type: eventType, Key: "default/" + name, Object: pod, Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// readGzip decompresses the whole file, failing unless the gzip stream was finalized
+func readGzip(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("not a gzip stream: %v", err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("truncated gzip stream: %v", err)
+	}
+	return string(data)
+}
+
+func TestOutputFileCompressedJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	out, err := openOutputFile(path, true, OutputJSONL, FileRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []Event{
+		testPodEvent("ADDED", "web-1", corev1.PodPending),
+		testPodEvent("MODIFIED", "web-1", corev1.PodRunning),
+		testPodEvent("DELETED", "web-1", corev1.PodSucceeded),
+	}
+	for _, event := range events {
+		if err := out.Write(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.writeDocument(heartbeatDocument, "## Heartbeat", map[string]string{"type": heartbeatDocument})
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(readGzip(t, path)))
+	var envelopes []eventEnvelope
+	for scanner.Scan() {
+		var envelope eventEnvelope
+		if err := json.Unmarshal(scanner.Bytes(), &envelope); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	if len(envelopes) != len(events)+1 {
+		t.Fatalf("got %d documents, want %d", len(envelopes), len(events)+1)
+	}
+	for i, event := range events {
+		envelope := envelopes[i]
+		if envelope.Type != event.Type || envelope.Namespace != "default" || envelope.Name != "web-1" {
+			t.Errorf("document %d is %s %s/%s, want %s default/web-1", i, envelope.Type, envelope.Namespace, envelope.Name, event.Type)
+		}
+		if want := event.Object.(*corev1.Pod).Status.Phase; envelope.Pod == nil || envelope.Pod.Status.Phase != want {
+			t.Errorf("document %d does not carry the pod in phase %s", i, want)
+		}
+	}
+	// The last document is only in the file once Close flushed the gzip stream
+	if last := envelopes[len(envelopes)-1]; last.Type != heartbeatDocument {
+		t.Errorf("last document is %s, want %s", last.Type, heartbeatDocument)
+	}
+}
+
+func TestOutputFileCompressedBySuffix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.yaml.gz")
+	out, err := openOutputFile(path, false, OutputYAML, FileRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Write(testPodEvent("ADDED", "db-0", corev1.PodRunning)); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Write(testPodEvent("DELETED", "db-0", corev1.PodRunning)); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content := readGzip(t, path)
+	if documents := strings.Count(content, "name: db-0"); documents != 2 {
+		t.Errorf("got %d documents about db-0, want 2:\n%s", documents, content)
+	}
+	if !strings.Contains(content, "DELETED") {
+		t.Errorf("the last document was not flushed on Close:\n%s", content)
+	}
+}
+
+func TestOutputFileRotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	out, err := openOutputFile(path, false, OutputJSONL, FileRotation{MaxSize: 1, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	renameFile = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+	}
+	defer func() { renameFile = os.Rename }()
+
+	// The file that could not be moved aside is continued rather than left closed, and rotated once it can be
+	if err := out.Write(testPodEvent("ADDED", "web-1", corev1.PodPending)); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Write(testPodEvent("MODIFIED", "web-1", corev1.PodRunning)); err != nil {
+		t.Fatal(err)
+	}
+	renameFile = os.Rename
+	if err := out.Write(testPodEvent("DELETED", "web-1", corev1.PodSucceeded)); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if documents := strings.Count(string(rotated), "\n"); documents != 3 {
+		t.Errorf("the rotated file holds %d documents, want 3:\n%s", documents, rotated)
+	}
+}
+
+func TestNoticeText(t *testing.T) {
+	withPod := func(event Event) Event {
+		event.Key, event.Object = "default/web-1", testPodEvent("MODIFIED", "web-1", corev1.PodRunning).Object
+		return event
+	}
+	for _, tc := range []struct {
+		name  string
+		event Event
+		want  string // the start of the notice
+	}{
+		{name: "container", event: withPod(Event{Type: ContainerEvent, Container: &ContainerChange{Container: "app"}}), want: "## Container [default/web-1/app]: "},
+		{name: "image", event: withPod(Event{Type: ImageEvent, Image: &ImageChange{Container: "app"}}), want: "## Image changed [default/web-1/app]: "},
+		{name: "alert", event: withPod(Event{Type: AlertEvent, Alert: &Alert{Reason: AlertFlapping}}), want: "## Alert [default/web-1]: "},
+		{name: "terminating", event: withPod(Event{Type: TerminatingEvent, Termination: &Termination{}}), want: "## Terminating [default/web-1]: "},
+		{name: "finalized", event: withPod(Event{Type: FinalizedEvent, Termination: &Termination{}}), want: "## Finalized [default/web-1]: "},
+		{name: "cluster", event: withPod(Event{Type: AlertEvent, Cluster: "prod", Alert: &Alert{}}), want: "## Alert [" + clusterKey("prod", "default") + "/web-1]: "},
+		{name: "without object", event: Event{Type: AlertEvent, Key: "web-1", Alert: &Alert{}}, want: "## Alert [/web-1]: "},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if notice := noticeText(tc.event); !strings.HasPrefix(notice, tc.want) {
+				t.Errorf("got notice %q, want it to start with %q", notice, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package watcher
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// dirSink is the Sink writing the events of each object to a file of its own in a directory,
+// named "<namespace>__<name>.yaml" (or ".json", ".jsonl" or ".txt" depending on the format), under a sub-directory per cluster
+// when watching named clusters. The Kubernetes Events about a pod go to the file of the pod.
+// Each event is appended to its file with one write, so the files are never left open.
+type dirSink struct {
+	dir     string
+	format  string
+	append  bool                    // continue the files already in the directory instead of truncating them
+	writers map[string]*eventWriter // per object, qualified by its cluster, keeping the state of the diff and table formats; dropped once the object is deleted
+	started map[string]bool         // the files written to so far, which are appended to from then on
+	buf     bytes.Buffer
+}
+
+// NewDirSink returns a sink writing the events of each object to a file of its own in dir, in the given format.
+// The directory is created if needed. The files already in it are truncated when first written to, unless appending.
+func NewDirSink(dir string, format string, appendFiles bool) (Sink, error) {
+	if _, err := newEventWriter(nil, format); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, fmt.Errorf("could not create output directory: %w", err)
+	}
+	return &dirSink{dir: dir, format: format, append: appendFiles, writers: make(map[string]*eventWriter), started: make(map[string]bool)}, nil
+}
+
+// Write appends the event to the file of its object
+func (d *dirSink) Write(event Event) error {
+	namespace, name := d.object(event)
+	path, id := d.file(event.Cluster, namespace, name), clusterKey(event.Cluster, name)
+	if namespace != "" {
+		id = clusterKey(event.Cluster, namespace+"/"+name)
+	}
+	writer, ok := d.writers[id]
+	if !ok {
+		var err error
+		if writer, err = newEventWriter(&d.buf, d.format); err != nil {
+			return err
+		}
+		d.writers[id] = writer
+	}
+	d.buf.Reset()
+	if err := writer.Write(event); err != nil {
+		return err
+	}
+	if event.Type == string(watch.Deleted) {
+		delete(d.writers, id)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !d.started[path] && !d.append {
+		flags |= os.O_TRUNC
+	}
+	d.started[path] = true
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return fmt.Errorf("could not create output directory: %w", err)
+	}
+	f, err := os.OpenFile(path, flags, 0o666)
+	if err != nil {
+		return fmt.Errorf("could not open output file: %w", err)
+	}
+	_, err = f.Write(d.buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// evict forgets the state of the diff and table formats of the object
+func (d *dirSink) evict(id string) {
+	delete(d.writers, id)
+}
+
+// object returns the namespace and name of the object whose file receives the event
+func (d *dirSink) object(event Event) (string, string) {
+	namespace, name := "", event.Key
+	if kubeEvent, ok := event.Object.(*corev1.Event); ok {
+		namespace, name = kubeEvent.InvolvedObject.Namespace, kubeEvent.InvolvedObject.Name
+	} else if objMeta, err := meta.Accessor(event.Object); err == nil {
+		namespace, name = objMeta.GetNamespace(), objMeta.GetName()
+	}
+	return namespace, name
+}
+
+// path returns the file receiving the event
+func (d *dirSink) path(event Event) string {
+	namespace, name := d.object(event)
+	return d.file(event.Cluster, namespace, name)
+}
+
+// file returns the file receiving the events of the object of the cluster
+func (d *dirSink) file(cluster string, namespace string, name string) string {
+	file := fileNameSafe(name)
+	if namespace != "" {
+		file = fileNameSafe(namespace) + "__" + file
+	}
+	dir := d.dir
+	if cluster != "" {
+		dir = filepath.Join(dir, fileNameSafe(cluster))
+	}
+	return filepath.Join(dir, file+d.extension())
+}
+
+// extension returns the extension of the files in the format of the sink
+func (d *dirSink) extension() string {
+	return formatExtension(d.format)
+}
+
+// formatExtension returns the extension of the files holding events in the given format
+func formatExtension(format string) string {
+	switch format {
+	case OutputYAML, OutputDiff:
+		return ".yaml"
+	case OutputJSON:
+		return ".json"
+	case OutputJSONL, OutputCloudEvents:
+		return ".jsonl"
+	default:
+		return ".txt"
+	}
+}
+
+// String names the sink for logs and metrics
+func (d *dirSink) String() string {
+	return "dir:" + d.dir
+}
+
+// Close releases the state kept per file; the files themselves are closed after each write
+func (d *dirSink) Close() error {
+	clear(d.writers)
+	return nil
+}
+
+// fileNameSafe replaces the characters of a name that cannot appear in a file name, such as those of context names
+func fileNameSafe(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(name)
+}
@@ -0,0 +1,119 @@
+package watcher
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Owner is the top-level owner of an object, found by following its controller owner references,
+// e.g. the Deployment of a pod created through a ReplicaSet
+type Owner struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// String formats the owner as kind/name
+func (o *Owner) String() string {
+	return o.Kind + "/" + o.Name
+}
+
+// maxOwnerDepth bounds the owner chains that are followed, in case of a reference cycle
+const maxOwnerDepth = 5
+
+// ownerResolver follows the owner references of objects up to their top-level owner (--resolve-owners).
+// Only ReplicaSets and Jobs are looked up, as the intermediate owners of pods created by Deployments and CronJobs;
+// any other owner is taken to be the top-level one. The owner found for each intermediate owner is cached.
+type ownerResolver struct {
+	ctx        context.Context
+	clientsets map[string]kubernetes.Interface // cluster name -> its clientset
+
+	mu     sync.Mutex
+	owners map[types.UID]*Owner // intermediate owner UID -> its top-level owner
+}
+
+func newOwnerResolver(ctx context.Context, clusters []*cluster) *ownerResolver {
+	r := &ownerResolver{ctx: ctx, clientsets: make(map[string]kubernetes.Interface), owners: make(map[types.UID]*Owner)}
+	for _, c := range clusters {
+		r.clientsets[c.name] = c.clientset
+	}
+	return r
+}
+
+// resolve returns the top-level owner of the object of the cluster, or nil if it has no owner
+func (r *ownerResolver) resolve(cluster string, obj runtime.Object) *Owner {
+	objMeta, ok := obj.(metav1.Object)
+	if !ok {
+		return nil
+	}
+	ref := ownerReference(objMeta.GetOwnerReferences())
+	if ref == nil {
+		return nil
+	}
+	var chain []types.UID
+	var owner *Owner
+	for depth := 0; ref != nil && depth < maxOwnerDepth; depth++ {
+		owner = &Owner{Kind: ref.Kind, Name: ref.Name}
+		r.mu.Lock()
+		cached, found := r.owners[ref.UID]
+		r.mu.Unlock()
+		if found {
+			owner = cached
+			break
+		}
+		chain = append(chain, ref.UID)
+		next, err := r.parent(cluster, objMeta.GetNamespace(), ref)
+		if err != nil {
+			// The owner may already be gone, e.g. with the pods of a deleted Deployment
+			slog.Debug("Could not look up owner", "owner", owner.String(), "namespace", objMeta.GetNamespace(), "error", err)
+			return owner // not cached, so that it is looked up again
+		}
+		ref = next
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, uid := range chain {
+		r.owners[uid] = owner
+	}
+	return owner
+}
+
+// parent returns the owner reference of an intermediate owner, or nil for a top-level one
+func (r *ownerResolver) parent(cluster, namespace string, ref *metav1.OwnerReference) (*metav1.OwnerReference, error) {
+	group, _, _ := strings.Cut(ref.APIVersion, "/")
+	clientset := r.clientsets[cluster]
+	switch {
+	case ref.Kind == "ReplicaSet" && group == "apps":
+		replicaSet, err := clientset.AppsV1().ReplicaSets(namespace).Get(r.ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return ownerReference(replicaSet.OwnerReferences), nil
+	case ref.Kind == "Job" && group == "batch":
+		job, err := clientset.BatchV1().Jobs(namespace).Get(r.ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return ownerReference(job.OwnerReferences), nil
+	}
+	return nil, nil
+}
+
+// ownerReference returns the controller among the owner references, or the first one if none is the controller
+func ownerReference(refs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			return &refs[i]
+		}
+	}
+	if len(refs) > 0 {
+		return &refs[0]
+	}
+	return nil
+}
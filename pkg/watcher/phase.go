@@ -0,0 +1,133 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// statusFilter matches objects by the phase and the conditions of their status (--phase, --condition).
+// The object must be in one of the phases, if any, and satisfy every condition.
+type statusFilter struct {
+	phases     []string // any of them matches; none for every phase
+	conditions []conditionRequirement
+	names      []string // the requirements as given, for logging
+}
+
+// conditionRequirement is a condition of --condition: TYPE=STATUS, or TYPE!=STATUS
+type conditionRequirement struct {
+	conditionType string // lower-cased
+	status        string // True, False or Unknown
+	negated       bool
+}
+
+// newStatusFilter parses the phases and the conditions, returning nil when there are none
+func newStatusFilter(phases []string, conditions []string) (*statusFilter, error) {
+	f := &statusFilter{}
+	for _, phase := range phases {
+		if phase = strings.TrimSpace(phase); phase != "" {
+			f.phases = append(f.phases, phase)
+		}
+	}
+	if len(f.phases) > 0 {
+		f.names = append(f.names, "phase="+strings.Join(f.phases, "|"))
+	}
+	for _, condition := range conditions {
+		if condition = strings.TrimSpace(condition); condition == "" {
+			continue
+		}
+		requirement, err := parseConditionRequirement(condition)
+		if err != nil {
+			return nil, err
+		}
+		f.conditions = append(f.conditions, requirement)
+		f.names = append(f.names, condition)
+	}
+	if len(f.names) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// parseConditionRequirement parses TYPE=STATUS or TYPE!=STATUS, the status being True when omitted
+func parseConditionRequirement(condition string) (conditionRequirement, error) {
+	requirement := conditionRequirement{conditionType: condition, status: string(corev1.ConditionTrue)}
+	if conditionType, status, ok := strings.Cut(condition, "!="); ok {
+		requirement = conditionRequirement{conditionType: conditionType, status: status, negated: true}
+	} else if conditionType, status, ok := strings.Cut(condition, "="); ok {
+		requirement = conditionRequirement{conditionType: conditionType, status: status}
+	}
+	requirement.conditionType = strings.ToLower(strings.TrimSpace(requirement.conditionType))
+	if requirement.conditionType == "" {
+		return requirement, fmt.Errorf("invalid --condition %q: must be TYPE=STATUS or TYPE!=STATUS, e.g. Ready=False", condition)
+	}
+	for _, status := range []corev1.ConditionStatus{corev1.ConditionTrue, corev1.ConditionFalse, corev1.ConditionUnknown} {
+		if strings.EqualFold(strings.TrimSpace(requirement.status), string(status)) {
+			requirement.status = string(status)
+			return requirement, nil
+		}
+	}
+	return requirement, fmt.Errorf("invalid --condition %q: the status must be True, False or Unknown", condition)
+}
+
+// matches reports whether the object is in one of the phases and satisfies every condition, both compared
+// case-insensitively. A condition the object does not report only satisfies the negated requirements, e.g. Ready!=True.
+func (f *statusFilter) matches(obj runtime.Object) bool {
+	phase, conditions := objectStatus(obj)
+	if len(f.phases) > 0 {
+		matched := false
+		for _, p := range f.phases {
+			if strings.EqualFold(p, phase) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, requirement := range f.conditions {
+		status, ok := conditions[requirement.conditionType]
+		if (ok && status == requirement.status) == requirement.negated {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the requirements for logging; nil requires nothing
+func (f *statusFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.names, " AND ")
+}
+
+// objectStatus returns the phase of the status of the object, and the status of each of its conditions by lower-cased type
+func objectStatus(obj runtime.Object) (string, map[string]string) {
+	conditions := make(map[string]string)
+	if pod, ok := obj.(*corev1.Pod); ok {
+		for _, condition := range pod.Status.Conditions {
+			conditions[strings.ToLower(string(condition.Type))] = string(condition.Status)
+		}
+		return string(pod.Status.Phase), conditions
+	}
+	u, ok := obj.(runtime.Unstructured)
+	if !ok {
+		return "", conditions
+	}
+	content := u.UnstructuredContent()
+	phase, _, _ := unstructured.NestedString(content, "status", "phase")
+	items, _, _ := unstructured.NestedSlice(content, "status", "conditions")
+	for _, item := range items {
+		if condition, ok := item.(map[string]interface{}); ok {
+			conditionType, _ := condition["type"].(string)
+			status, _ := condition["status"].(string)
+			conditions[strings.ToLower(conditionType)] = status
+		}
+	}
+	return phase, conditions
+}
@@ -0,0 +1,164 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"plugin"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// pluginTimeout bounds the evaluation of an object by a WebAssembly filter plugin
+const pluginTimeout = time.Second
+
+// FilterFunc is custom filtering and transformation logic, given each object that passed the other filters, after
+// --strip and --redact, as the map of its JSON representation. It returns whether the object matches and, to transform
+// it, the object to emit instead; nil keeps it as it is. It must be safe for concurrent use.
+type FilterFunc func(obj map[string]interface{}) (bool, map[string]interface{})
+
+// WithFilterFunc evaluates every object that passed the other filters with the function, which decides whether
+// it matches and may transform it
+func WithFilterFunc(filter FilterFunc) Option {
+	return func(w *Watcher) { w.filterFunc = filter }
+}
+
+// WithFilterPlugin evaluates every object that passed the other filters with a plugin, which decides whether
+// it matches and may transform it: a Go plugin (.so), built with go build -buildmode=plugin against the same versions of
+// Go and the packages it shares with pod-watcher, exporting a FilterFunc named Filter; or a WebAssembly module (.wasm).
+//
+// The WebAssembly module may import WASI, and exports its memory along with the functions
+// alloc(size i32) i32, returning a buffer of size bytes, and filter(ptr i32, len i32) i64, given the JSON object
+// in the buffer and returning the length of its JSON result in the lower 32 bits and its address in the upper 32 bits.
+// The result is {"match": bool, "object": {...}}, where object is the transformed object, if any.
+// The objects are evaluated one at a time, within a second each.
+func WithFilterPlugin(path string) Option {
+	return func(w *Watcher) { w.filterPlugin = path }
+}
+
+// loadFilterPlugin loads the Go or WebAssembly plugin of WithFilterPlugin
+func loadFilterPlugin(path string) (FilterFunc, func(), error) {
+	if strings.EqualFold(filepath.Ext(path), ".wasm") {
+		return loadWASMFilter(path)
+	}
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load --filter-plugin %s: %w", path, err)
+	}
+	symbol, err := p.Lookup("Filter")
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid --filter-plugin %s: %w", path, err)
+	}
+	switch filter := symbol.(type) {
+	case func(map[string]interface{}) (bool, map[string]interface{}):
+		return filter, func() {}, nil
+	case *FilterFunc:
+		return *filter, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("invalid --filter-plugin %s: Filter is a %T, not a func(map[string]interface{}) (bool, map[string]interface{})", path, symbol)
+	}
+}
+
+// wasmFilter evaluates objects with the filter function of a WebAssembly module, one at a time
+type wasmFilter struct {
+	path   string
+	module api.Module
+	alloc  api.Function
+	filter api.Function
+	mu     sync.Mutex
+}
+
+func loadWASMFilter(path string) (FilterFunc, func(), error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read --filter-plugin %s: %w", path, err)
+	}
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+	// The module is a reactor: its _initialize, if any, is called, but not its _start
+	module, err := r.InstantiateWithConfig(ctx, code, wazero.NewModuleConfig().WithName("filter").WithStartFunctions("_initialize").WithStderr(os.Stderr))
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, nil, fmt.Errorf("could not instantiate --filter-plugin %s: %w", path, err)
+	}
+	f := &wasmFilter{path: path, module: module, alloc: module.ExportedFunction("alloc"), filter: module.ExportedFunction("filter")}
+	if f.alloc == nil || f.filter == nil || module.Memory() == nil {
+		_ = r.Close(ctx)
+		return nil, nil, fmt.Errorf("invalid --filter-plugin %s: the module must export its memory and the functions alloc and filter", path)
+	}
+	return f.evaluate, func() { _ = r.Close(context.Background()) }, nil
+}
+
+// evaluate passes the object to the filter function of the module, logging the failures, which drop the object
+func (f *wasmFilter) evaluate(obj map[string]interface{}) (bool, map[string]interface{}) {
+	match, transformed, err := f.call(obj)
+	if err != nil {
+		filterPluginFailures.Inc()
+		slog.Warn("Filter plugin failed, dropping the object", "plugin", f.path, "error", err)
+		return false, nil
+	}
+	return match, transformed
+}
+
+func (f *wasmFilter) call(obj map[string]interface{}) (bool, map[string]interface{}, error) {
+	input, err := json.Marshal(obj)
+	if err != nil {
+		return false, nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	results, err := f.alloc.Call(ctx, uint64(len(input)))
+	if err != nil {
+		return false, nil, fmt.Errorf("alloc: %w", err)
+	}
+	ptr := uint32(results[0])
+	if !f.module.Memory().Write(ptr, input) {
+		return false, nil, fmt.Errorf("alloc returned a buffer out of the memory of the module")
+	}
+	if results, err = f.filter.Call(ctx, uint64(ptr), uint64(len(input))); err != nil {
+		return false, nil, fmt.Errorf("filter: %w", err)
+	}
+	output, ok := f.module.Memory().Read(uint32(results[0]>>32), uint32(results[0]))
+	if !ok {
+		return false, nil, fmt.Errorf("filter returned a result out of the memory of the module")
+	}
+	var result struct {
+		Match  bool                   `json:"match"`
+		Object map[string]interface{} `json:"object"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return false, nil, fmt.Errorf("invalid result of filter: %w", err)
+	}
+	return result.Match, result.Object, nil
+}
+
+// applyFilterFunc evaluates the object with the filter function, returning whether it matches and the object to emit
+func applyFilterFunc(filter FilterFunc, obj runtime.Object) (bool, runtime.Object, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return false, nil, err
+	}
+	match, transformed := filter(content)
+	if !match || transformed == nil {
+		return match, obj, nil
+	}
+	// Decode the transformed object into an object of the same type
+	result := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(transformed, result); err != nil {
+		return false, nil, fmt.Errorf("invalid object returned by the filter plugin: %w", err)
+	}
+	return true, result, nil
+}
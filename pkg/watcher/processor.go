@@ -0,0 +1,589 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/yaml"
+)
+
+// eventProcessor filters events and emits the matching ones to the sinks.
+// It is shared by the informers of every namespace, so it must be safe for concurrent use.
+type eventProcessor struct {
+	watchCtx context.Context // canceled when the watcher stops
+	sinks    *fanOut
+	workers  *workerPool     // runs the informer notifications
+	events   chan Event      // nil unless Events was called
+	logs     *logTailer      // nil unless --tail-logs
+	strip    *fieldStripper  // nil unless --strip
+	redact   *redactor       // nil unless --redact or --redact-path
+	hook     *execHook       // nil unless --exec
+	owners   *ownerResolver  // nil unless --resolve-owners
+	capture  *failureCapture // nil unless --capture-on-failure
+	debug    *debugInjector  // nil unless --inject-debug-container
+	filter   *markerFilter
+	optIn    *optIn           // nil unless --watch-annotation
+	status   *statusFilter    // nil unless --phase or --condition
+	cel      *celFilter       // nil unless --filter-cel
+	plugin   FilterFunc       // nil unless --filter-plugin
+	exclude  *exclusionFilter // nil unless --exclude-*
+	target   *podTarget       // nil unless stop-on-delete
+	waiter   *deleteWaiter    // nil unless --wait-for-delete-all
+	images   *imageTracker    // nil unless --on-image-change
+	sections *sectionTracker  // nil unless --spec-changes-only or --status-changes-only
+	// audit attributes the modifications to their field managers; nil unless --include-managed-fields-summary
+	audit *managedFieldsTracker
+	// references reports the changes to the ConfigMaps and Secrets of the pods; nil unless --follow-references
+	references *referenceTracker
+	// volumes reports the changes to the state of the volumes of the pods; nil unless --track-volumes
+	volumes *volumeTracker
+	// terminations follows the deletions of the pods; nil unless --track-termination
+	terminations *terminationTracker
+	// deletions infers why the pods of the DELETED events were deleted; nil unless --attribute-deletions
+	deletions *deletionAttributor
+	// backfill reconstructs the history of the listed pods; nil unless --since
+	backfill *backfiller
+	// containers reports the changes of the container statuses; nil unless --track-containers
+	containers *containerTracker
+	inits      *initTracker // nil unless --track-init
+	// imageChanges reports the changes of the images of the containers; nil unless --track-images
+	imageChanges *imageChangeTracker
+	security     *securityTracker // nil unless --security-watch
+	timelines    *timelineTracker // nil unless --timeline
+	alerts       *alertDetector   // nil unless --restart-threshold or --flap-threshold
+	usage        *usageTracker    // nil unless --include-metrics
+	stats        *statsTracker    // nil unless --stats-interval
+	nodes        *nodeCache       // nil unless --include-node
+	throttle     *eventThrottle   // nil unless --min-interval or --dedupe
+	checkpoint   *checkpointer    // nil unless --checkpoint-file or --checkpoint-configmap
+	listed       *listedVersions  // nil unless --skip-initial
+	snapshot     chan func() bool // receives the HasSynced of each informer; nil unless WithSnapshot
+	health       *healthState
+	summary      *summaryRecorder // nil when simulating
+	// condition ends the watch once a matching object satisfies it; nil unless --wait-for
+	condition *waitCondition
+	maxEvents int64  // stop after emitting this many events; 0 for no limit
+	stop      func() // called once the tracked objects have been deleted
+	// eventTypes restricts the emitted event types; nil emits every type.
+	// Filtered events still drive target selection, image tracking and stop-on-delete.
+	eventTypes  map[string]bool
+	emitInitial bool            // emit the objects of the initial lists as ADDED
+	matched     matchSet        // keys of the objects currently matching the filters
+	tracked     *trackedObjects // the objects with state, evicted once deleted, unmatched, or beyond --max-tracked-pods
+
+	deleted      atomic.Bool  // whether the watcher stopped because the tracked objects were deleted
+	unsuccessful atomic.Bool  // whether any tracked object was deleted without having succeeded
+	satisfied    atomic.Bool  // whether a matching object met the --wait-for condition
+	snapshotted  atomic.Bool  // whether the objects of the snapshot have all been emitted
+	emitted      atomic.Int64 // number of events emitted, for --max-events
+}
+
+// matchedObject is an object that passed the filters, ready to be emitted
+type matchedObject struct {
+	key     string // "namespace/name" of the object
+	cluster string
+	id      string         // the key qualified by the cluster, identifying the object across clusters
+	obj     runtime.Object // the object after --strip and --redact
+	yaml    string
+	ctx     context.Context // carries the span of the event, if traced
+	changes []*Change       // the writes of the field managers since the previous revision; MODIFIED only, with --include-managed-fields-summary
+}
+
+// match strips, redacts and serializes the object and applies the markers, phases, conditions, CEL filters and exclusions, reporting whether it matched.
+// The context carries the span of the event, if traced.
+func (p *eventProcessor) match(ctx context.Context, cluster string, eventType string, obj runtime.Object) (*matchedObject, bool) {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		slog.Warn("Skipping event for an object without metadata", "type", eventType, "error", err)
+		return nil, false
+	}
+	key := objectKey(objMeta)
+	m := &matchedObject{key: key, cluster: cluster, id: clusterKey(cluster, key), obj: obj, ctx: ctx}
+	// Remove the noisy fields before anything looks at the object, and serialize it to YAML
+	_, span := startSpan(ctx, "serialize")
+	if p.strip != nil {
+		if m.obj, err = p.strip.strip(obj); err != nil {
+			slog.Error("Failed to strip fields", "key", m.id, "error", err)
+			marshalErrors.Inc()
+			endSpan(span, err)
+			return nil, false
+		}
+	}
+	if p.redact != nil {
+		if m.obj, err = p.redact.redact(m.obj); err != nil {
+			slog.Error("Failed to redact fields", "key", m.id, "error", err)
+			marshalErrors.Inc()
+			endSpan(span, err)
+			return nil, false
+		}
+	}
+	objYAML, err := yaml.Marshal(m.obj)
+	if err != nil {
+		slog.Error("Failed to marshal object to YAML", "key", m.id, "error", err)
+		marshalErrors.Inc()
+		endSpan(span, err)
+		return nil, false
+	}
+	endSpan(span, nil)
+	m.yaml = string(objYAML)
+	// Check for the opt-in annotation and the markers (no markers matches every object the selectors let through),
+	// then the phase and conditions, the CEL filters and exclusions
+	_, span = startSpan(ctx, "filter")
+	matched := (p.optIn == nil || p.optIn.annotated(m.obj)) && p.filter.matchesObject(m.obj, m.yaml) &&
+		(p.status == nil || p.status.matches(m.obj)) &&
+		(p.cel == nil || p.cel.matches(m.id, m.obj)) &&
+		(p.exclude == nil || !p.exclude.excludes(m.obj, m.yaml))
+	// Then the filter plugin, which may transform the object
+	if matched && p.plugin != nil {
+		transformed := m.obj
+		if matched, transformed, err = applyFilterFunc(p.plugin, m.obj); err != nil {
+			slog.Error("Filter plugin failed", "key", m.id, "error", err)
+			filterPluginFailures.Inc()
+		} else if matched && transformed != m.obj {
+			m.obj = transformed
+			if objYAML, err = yaml.Marshal(m.obj); err != nil {
+				slog.Error("Failed to marshal object to YAML", "key", m.id, "error", err)
+				marshalErrors.Inc()
+				matched = false
+			}
+			m.yaml = string(objYAML)
+		}
+	}
+	span.SetAttributes(attribute.Bool("matched", matched))
+	endSpan(span, nil)
+	// If auditing, attribute the modification to the field managers from the managed fields, which --strip may have removed
+	if p.audit != nil {
+		if changes := p.audit.update(eventType, m.id, obj, matched); eventType == string(watch.Modified) {
+			m.changes = changes
+		}
+	}
+	if !matched {
+		p.matched.update(m.id, false)
+		if p.checkpoint != nil {
+			p.checkpoint.update(eventType, m, objMeta.GetResourceVersion(), false)
+		}
+		return m, false
+	}
+	p.matched.update(m.id, eventType != string(watch.Deleted))
+	if p.checkpoint != nil {
+		p.checkpoint.update(eventType, m, objMeta.GetResourceVersion(), true)
+	}
+	return m, true
+}
+
+// observe records an object from the informer's initial list without emitting it,
+// so that trackers know about matching objects that existed before the watcher started.
+func (p *eventProcessor) observe(cluster string, obj runtime.Object) (*matchedObject, bool) {
+	m, ok := p.match(context.Background(), cluster, string(watch.Added), obj)
+	if !ok {
+		return m, false
+	}
+	p.track(m)
+	if p.waiter != nil {
+		p.waiter.add(m.id)
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.images != nil {
+		p.images.shouldEmit(watch.Added, m.id, pod)
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.containers != nil {
+		p.containers.update(string(watch.Added), m.id, pod, true)
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.inits != nil {
+		p.inits.update(string(watch.Added), m.id, pod, true)
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.imageChanges != nil {
+		p.imageChanges.update(string(watch.Added), m.id, pod)
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.security != nil {
+		p.security.update(string(watch.Added), m.id, pod, true)
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.capture != nil {
+		p.capture.observe(m.id, pod)
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.timelines != nil {
+		p.timelines.update(string(watch.Added), m.id, pod, time.Now().UTC())
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.alerts != nil {
+		p.alerts.update(string(watch.Added), m.id, pod, time.Now().UTC(), true)
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.terminations != nil {
+		p.terminations.update(string(watch.Added), m, pod, time.Now().UTC(), true)
+	}
+	if p.usage != nil {
+		p.usage.update(string(watch.Added), m)
+	}
+	if p.references != nil {
+		p.references.update(string(watch.Added), m)
+	}
+	if p.summary != nil {
+		p.summary.update(string(watch.Added), m)
+	}
+	if p.stats != nil {
+		p.stats.update(string(watch.Added), m, true)
+	}
+	if p.volumes != nil {
+		p.volumes.update(string(watch.Added), m)
+	}
+	if p.condition != nil && p.condition.met(m.obj) {
+		p.satisfy("Condition already met, exiting watcher", "condition", p.condition.String(), "key", m.id)
+	}
+	return m, true
+}
+
+// handle serializes the object, applies the marker and mode filters, and emits it as an event document.
+// The context carries the span of the event, if traced.
+func (p *eventProcessor) handle(ctx context.Context, cluster string, eventType string, obj runtime.Object) {
+	eventsReceived.WithLabelValues(eventType).Inc()
+	p.health.eventReceived()
+	defer prometheus.NewTimer(eventLatency.WithLabelValues(eventType)).ObserveDuration()
+	m, ok := p.match(ctx, cluster, eventType, obj)
+	if m != nil {
+		slog.Debug("Event received", "type", eventType, "key", m.id, "matched", ok)
+	}
+	// If waitForDeleteAll mode, a tracked object counts as deleted even if it no longer matches
+	if m != nil && p.waiter != nil {
+		if eventType == string(watch.Deleted) {
+			if tracked, last := p.waiter.remove(m.id); tracked {
+				p.recordDeletion(m.obj)
+				if last {
+					defer p.finish("All tracked objects deleted, exiting watcher", "last", m.id)
+				}
+			}
+		} else if ok {
+			p.waiter.add(m.id)
+		}
+	}
+	if !ok {
+		// Drop the state of an object that stopped matching, or was deleted since
+		if m != nil {
+			p.untrack(eventType, m)
+		}
+		return // ignore events that don't include the marker
+	}
+	// Drop the state of a deleted object once its events have been handled, after those of the trackers
+	if eventType == string(watch.Deleted) {
+		defer p.untrack(eventType, m)
+	} else {
+		p.track(m)
+	}
+	if p.summary != nil {
+		p.summary.update(eventType, m)
+	}
+	if p.stats != nil {
+		p.stats.update(eventType, m, false)
+	}
+	// If skipInitial mode, ignore the revisions that existed before the watcher started
+	if p.listed != nil {
+		if objMeta, err := meta.Accessor(m.obj); err == nil && p.listed.listed(eventType, m.id, objMeta.GetResourceVersion()) {
+			return
+		}
+	}
+
+	// If stopOnDelete mode, select the first matching object as target
+	if p.target != nil && !p.target.accept(m.id) {
+		return // once a target is acquired, ignore other objects
+	}
+	// If tailLogs mode, follow the logs of the matched pod's running containers
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.logs != nil {
+		p.logs.update(eventType, m.cluster, m.id, pod)
+	}
+	// If waitFor mode, the first object meeting the condition ends the watch once it has been emitted
+	if p.condition != nil && eventType != string(watch.Deleted) && p.condition.met(m.obj) {
+		defer p.satisfy("Condition met, exiting watcher", "condition", p.condition.String(), "key", m.id)
+	}
+	// If stopOnDelete mode, the deletion of the target ends the watch once it has been emitted
+	if p.target != nil && eventType == string(watch.Deleted) {
+		p.recordDeletion(m.obj)
+		defer p.finish("Target deleted, exiting watcher", "key", m.id)
+	}
+	// If captureOnFailure mode, capture the artifacts of the pod once it starts failing
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.capture != nil {
+		p.capture.update(eventType, m.cluster, m.id, pod)
+	}
+	// If injecting debug containers, attach one to the pod once it meets the trigger
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.debug != nil {
+		p.debug.update(eventType, m.cluster, m.id, pod)
+	}
+	// If trackContainers mode, report the changes of the containers ahead of the pod event
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.containers != nil {
+		p.emitContainerChanges(m, pod, p.containers.update(eventType, m.id, pod, false))
+	}
+	// If trackInit mode, report the milestones of the init containers ahead of the pod event
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.inits != nil {
+		p.emitInitChanges(m, pod, p.inits.update(eventType, m.id, pod, false))
+	}
+	// If trackImages mode, report the changes of the images of the containers ahead of the pod event
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.imageChanges != nil {
+		p.emitImageChanges(m, pod, p.imageChanges.update(eventType, m.id, pod))
+	}
+	// If securityWatch mode, report the changes of the security-relevant settings ahead of the pod event
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.security != nil {
+		p.emitSecurityChange(m, pod, p.security.update(eventType, m.id, pod, false))
+	}
+	// If alerting, raise the alerts of a degraded pod ahead of the pod event
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.alerts != nil {
+		p.emitAlerts(m, pod, p.alerts.update(eventType, m.id, pod, time.Now().UTC(), false))
+	}
+	// If trackTermination mode, report the request of the deletion of the pod ahead of the pod event,
+	// and its removal once its deletion has been emitted
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.terminations != nil {
+		terminating, finalized := p.terminations.update(eventType, m, pod, time.Now().UTC(), false)
+		p.emitTermination(TerminatingEvent, m, pod, terminating)
+		if finalized != nil {
+			defer p.emitTermination(FinalizedEvent, m, pod, finalized)
+		}
+	}
+	// If timeline mode, report the lifecycle of the pod once its deletion has been emitted
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.timelines != nil {
+		if timeline := p.timelines.update(eventType, m.id, pod, time.Now().UTC()); timeline != nil {
+			defer p.emitTimeline(m, pod, timeline)
+		}
+	}
+	// If including the resource usage, track the pod for the METRICS events
+	if p.usage != nil {
+		p.usage.update(eventType, m)
+	}
+	// If followReferences mode, track the ConfigMaps and Secrets of the pod
+	if p.references != nil {
+		p.references.update(eventType, m)
+	}
+	// If trackVolumes mode, track the claims of the pod
+	if p.volumes != nil {
+		p.volumes.update(eventType, m)
+	}
+	// If onImageChange mode, skip pod modifications that leave the container images untouched
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.images != nil && !p.images.shouldEmit(watch.EventType(eventType), m.id, pod) {
+		return
+	}
+	// If specChangesOnly or statusChangesOnly mode, skip modifications that leave the section untouched
+	if p.sections != nil && !p.sections.shouldEmit(watch.EventType(eventType), m.id, m.obj) {
+		return
+	}
+
+	// Output the object as one document in the stream, unless its event type is filtered out
+	if p.eventTypes != nil && !p.eventTypes[eventType] {
+		return
+	}
+	// If minInterval or dedupe mode, skip repeated and too frequent modifications
+	if p.throttle != nil && !p.throttle.shouldEmit(eventType, m) {
+		return
+	}
+	p.emit(eventType, m)
+}
+
+// emit hands the matched object to the sinks, the Events channel and the exec hook
+func (p *eventProcessor) emit(eventType string, m *matchedObject) {
+	// If maxEvents mode, stop once the last allowed event has been delivered to every sink
+	if p.maxEvents > 0 {
+		n := p.emitted.Add(1)
+		if n > p.maxEvents {
+			return // concurrent events racing the stop
+		}
+		if n == p.maxEvents {
+			defer p.stopAfter("Maximum number of events emitted, exiting watcher", "events", n)
+		}
+	}
+	event := Event{Type: eventType, Key: m.key, Object: m.obj, Timestamp: time.Now().UTC(), Cluster: m.cluster, yaml: m.yaml,
+		trace: trace.SpanContextFromContext(m.ctx)}
+	if p.owners != nil {
+		event.Owner = p.owners.resolve(m.cluster, m.obj)
+	}
+	event.Changes = m.changes
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.nodes != nil {
+		event.Node = p.nodes.get(m.cluster, pod.Spec.NodeName)
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok && p.deletions != nil && eventType == string(watch.Deleted) {
+		event.Deletion = p.deletions.attribute(m.cluster, pod)
+	}
+	if p.usage != nil {
+		event.Usage = p.usage.get(m.id)
+	}
+	p.sinks.write(event)
+	p.publish(event)
+	if p.hook != nil {
+		p.hook.run(eventType, m.cluster, m.key, m.obj)
+	}
+	eventsEmitted.WithLabelValues(eventType).Inc()
+	p.health.eventEmitted()
+}
+
+// publish sends the event to the Events channel, unless the watcher is stopping
+func (p *eventProcessor) publish(event Event) {
+	if p.events == nil {
+		return
+	}
+	select {
+	case p.events <- event:
+	case <-p.watchCtx.Done():
+	}
+}
+
+// recordDeletion notes whether a tracked object was deleted without having succeeded.
+// Only pods have a notion of success, so the deletion of any other object counts as unsuccessful.
+func (p *eventProcessor) recordDeletion(obj runtime.Object) {
+	if pod, ok := obj.(*corev1.Pod); !ok || pod.Status.Phase != corev1.PodSucceeded {
+		p.unsuccessful.Store(true)
+	}
+}
+
+// finish stops the watcher because the tracked objects have been deleted
+func (p *eventProcessor) finish(message string, args ...any) {
+	slog.Info(message, args...)
+	p.deleted.Store(true)
+	p.stop()
+}
+
+// satisfy stops the watcher because an object met the --wait-for condition
+func (p *eventProcessor) satisfy(message string, args ...any) {
+	if p.satisfied.CompareAndSwap(false, true) {
+		slog.Info(message, args...)
+	}
+	p.stop()
+}
+
+// stopAfter stops the watcher because --max-events has been reached
+func (p *eventProcessor) stopAfter(message string, args ...any) {
+	slog.Info(message, args...)
+	p.stop()
+}
+
+// exitCode returns the process exit code for a watcher that stopped because the tracked objects were deleted:
+// 0 when every deleted pod had succeeded, and the given code otherwise.
+func (p *eventProcessor) exitCode(onUnsuccessfulDelete int) int {
+	if p.deleted.Load() && p.unsuccessful.Load() {
+		return onUnsuccessfulDelete
+	}
+	return 0
+}
+
+// matchSet tracks the keys of the objects currently matching the filters, qualified by their cluster,
+// for the matched objects gauge
+type matchSet struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// update records whether the object with the given key currently matches
+func (m *matchSet) update(key string, matches bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if matches {
+		if m.keys == nil {
+			m.keys = make(map[string]bool)
+		}
+		m.keys[key] = true
+	} else {
+		delete(m.keys, key)
+	}
+	matchedObjects.Set(float64(len(m.keys)))
+}
+
+// size returns the number of objects currently matching
+func (m *matchSet) size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.keys)
+}
+
+// contains reports whether the object with the given key currently matches
+func (m *matchSet) contains(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keys[key]
+}
+
+// clusterKey qualifies the key of an object with the name of its cluster, if it has one
+func clusterKey(cluster string, key string) string {
+	if cluster == "" {
+		return key
+	}
+	return cluster + "/" + key
+}
+
+// parseEventTypes validates the --event-types flag, returning nil when every type should be emitted
+func parseEventTypes(values []string) (map[string]bool, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	types := make(map[string]bool)
+	for _, value := range values {
+		eventType := strings.ToUpper(strings.TrimSpace(value))
+		switch eventType {
+		case string(watch.Added), string(watch.Modified), string(watch.Deleted), ResyncEvent, ContainerEvent, TimelineEvent, AlertEvent, MetricsEvent, ReferenceEvent, VolumeEvent,
+			TerminatingEvent, FinalizedEvent, InitEvent, ImageEvent, SecurityEvent, BackfillEvent:
+			types[eventType] = true
+		default:
+			return nil, fmt.Errorf("unsupported event type %q (must be one of %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s)",
+				value, watch.Added, watch.Modified, watch.Deleted, ResyncEvent, ContainerEvent, TimelineEvent, AlertEvent, MetricsEvent, ReferenceEvent, VolumeEvent,
+				TerminatingEvent, FinalizedEvent, InitEvent, ImageEvent, SecurityEvent, BackfillEvent)
+		}
+	}
+	return types, nil
+}
+
+// deleteWaiter tracks every matching object in wait-for-delete-all mode.
+type deleteWaiter struct {
+	mu   sync.Mutex
+	live map[string]bool // keys of the matching objects not yet deleted
+}
+
+func newDeleteWaiter() *deleteWaiter {
+	return &deleteWaiter{live: make(map[string]bool)}
+}
+
+// add starts tracking the object with the given key
+func (w *deleteWaiter) add(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.live[key] {
+		w.live[key] = true
+		slog.Info("Tracking object until it is deleted", "key", key, "tracked", len(w.live))
+	}
+}
+
+// remove stops tracking the deleted object, reporting whether it was tracked and whether it was the last one
+func (w *deleteWaiter) remove(key string) (tracked, last bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.live[key] {
+		return false, false
+	}
+	delete(w.live, key)
+	return true, len(w.live) == 0
+}
+
+// podTarget tracks the single pod (or other object) monitored in stop-on-delete mode.
+type podTarget struct {
+	mu  sync.Mutex
+	key string // of the first matching object, qualified by its cluster; empty until acquired
+}
+
+// accept locks onto the first key it is given and reports whether key is the target.
+func (t *podTarget) accept(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.key == "" {
+		t.key = key
+		slog.Info("Target found, monitoring exclusively", "key", key)
+	}
+	return key == t.key
+}
+
+// is reports whether key is the acquired target
+func (t *podTarget) is(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.key != "" && key == t.key
+}
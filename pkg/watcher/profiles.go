@@ -0,0 +1,247 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/yaml"
+)
+
+// WithProfile adds a watch profile, an independent set of filters and sinks sharing the informers of the watcher,
+// so that several teams can be served by a single watch of the cluster. The events of the objects matching the filters
+// of the profile, among those of the watcher, go only to the sinks added by its options, e.g.
+// WithProfile("team-a", WithNamespaces("team-a"), WithMarkers("DEBUG_MODE"), WithWebhook(options)).
+// Its options may select namespaces (WithNamespaces), match markers (WithMarkers, WithMarkerRegexes, WithMarkerPaths,
+// WithMarkerAll), labels (WithLabelSelector, applied client-side), phases and conditions (WithPhases, WithConditions)
+// and CEL filters (WithCELFilters), exclude objects (WithExcludeNamespaces, WithExcludeMarkers, WithExcludeLabelSelector),
+// restrict the event types (WithEventTypes), and add sinks; any other option fails the watch. Without namespaces of its own, the watcher watches those of every profile.
+func WithProfile(name string, options ...Option) Option {
+	return func(w *Watcher) {
+		p := &profile{name: name, config: &Watcher{}, matched: make(map[string]bool)}
+		for _, option := range options {
+			if unsupported := unsupportedProfileOption(option); unsupported != "" {
+				p.unsupported = append(p.unsupported, unsupported)
+				continue
+			}
+			option(p.config)
+		}
+		w.profiles = append(w.profiles, p)
+	}
+}
+
+// profileFields are the fields of the Watcher that the options of a profile may set
+var profileFields = map[string]bool{
+	"namespaces": true, "markers": true, "markerRegexes": true, "markerPaths": true, "markerAll": true, "celFilters": true,
+	"phases": true, "conditions": true, "labelSelector": true, "excludeNamespaces": true, "excludeMarkers": true,
+	"excludeLabelSelector": true, "eventTypes": true, "newSinks": true,
+}
+
+// unsupportedProfileOption applies the option to an empty Watcher and, if it sets any field that a profile does not
+// support, returns its name, e.g. WithDedupe
+func unsupportedProfileOption(option Option) string {
+	probe := &Watcher{}
+	option(probe)
+	fields := reflect.ValueOf(probe).Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		if profileFields[fields.Type().Field(i).Name] || fields.Field(i).IsZero() {
+			continue
+		}
+		// e.g. github.com/stephenc/pod-watcher/pkg/watcher.WithDedupe.func1
+		name := runtime.FuncForPC(reflect.ValueOf(option).Pointer()).Name()
+		name = name[strings.LastIndex(name, "/")+1:]
+		name = strings.TrimPrefix(name, "watcher.")
+		if before, _, found := strings.Cut(name, ".func"); found {
+			name = before
+		}
+		return name
+	}
+	return ""
+}
+
+// profile is a watch profile of WithProfile, deciding which events go to its sinks
+type profile struct {
+	name        string
+	config      *Watcher        // the options of the profile
+	unsupported []string        // the names of the options given to the profile that it does not support
+	namespaces  map[string]bool // nil for every namespace of the watcher
+	selector    labels.Selector // nil without a label selector
+
+	mu      sync.Mutex
+	matched map[string]bool // object key, qualified by its cluster -> whether its last revision matched
+}
+
+// compileProfiles parses the filters of the profiles and, unless the watcher selects namespaces itself, watches
+// the namespaces of every profile, or every namespace when one of them does not select any
+func (w *Watcher) compileProfiles() error {
+	var namespaces []string
+	everyNamespace := false
+	names := make(map[string]bool)
+	for _, p := range w.profiles {
+		if p.name == "" {
+			return fmt.Errorf("profiles must have a name")
+		}
+		if names[p.name] {
+			return fmt.Errorf("profile %s is given more than once", p.name)
+		}
+		names[p.name] = true
+		if len(p.unsupported) > 0 {
+			return fmt.Errorf("profile %s does not support %s", p.name, strings.Join(p.unsupported, ", "))
+		}
+		if err := p.config.compileFilters(); err != nil {
+			return fmt.Errorf("profile %s: %w", p.name, err)
+		}
+		if p.config.labelSelector != "" {
+			selector, err := labels.Parse(p.config.labelSelector)
+			if err != nil {
+				return fmt.Errorf("profile %s: invalid label selector %q: %w", p.name, p.config.labelSelector, err)
+			}
+			p.selector = selector
+		}
+		watched := uniqueNamespaces(p.config.namespaces)
+		if watched[0] == "" {
+			everyNamespace = true
+			continue
+		}
+		p.namespaces = make(map[string]bool)
+		for _, namespace := range watched {
+			p.namespaces[namespace] = true
+			if len(w.namespaces) > 0 && !slices.Contains(uniqueNamespaces(w.namespaces), namespace) {
+				return fmt.Errorf("profile %s selects namespace %s, which is not watched", p.name, namespace)
+			}
+			if !slices.Contains(namespaces, namespace) {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+	}
+	if len(w.profiles) > 0 && len(w.namespaces) == 0 && !everyNamespace {
+		w.namespaces = namespaces
+	}
+	return nil
+}
+
+// openProfileSinks creates the sinks of the profiles, which only receive the events their profile accepts
+func (w *Watcher) openProfileSinks() error {
+	for _, p := range w.profiles {
+		if len(p.config.newSinks) == 0 {
+			return fmt.Errorf("profile %s has no sink", p.name)
+		}
+		for _, newSink := range p.config.newSinks {
+			sink, err := newSink()
+			if err == nil && w.sinkQueue != nil {
+				sink, err = w.queueSink(sink)
+				if err != nil {
+					_ = sink.Close()
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("profile %s: %w", p.name, err)
+			}
+			w.sinks = append(w.sinks, &profileSink{Sink: sink, profile: p})
+		}
+	}
+	return nil
+}
+
+// profileSink is a sink only receiving the events its profile accepts
+type profileSink struct {
+	Sink
+	profile *profile
+}
+
+func (s *profileSink) String() string {
+	return sinkName(s.Sink) + " (profile " + s.profile.name + ")"
+}
+
+func (s *profileSink) bind(ctx context.Context) {
+	if sink, ok := s.Sink.(bindable); ok {
+		sink.bind(ctx)
+	}
+}
+
+// accepts tells whether the event goes to the sinks of the profile. The changes to the watched objects are matched
+// against its filters; the other events, about an object, go to them if the last revision of that object matched.
+func (p *profile) accepts(event Event) bool {
+	cluster, namespace, name := eventSubject(event)
+	key := name
+	if namespace != "" {
+		key = namespace + "/" + name
+	}
+	key = clusterKey(cluster, key)
+	if p.config.emitted != nil && !p.config.emitted[event.Type] {
+		// Still follow whether the object matches for the events of the other types
+		if isChange(event.Type) {
+			p.update(key, event)
+		}
+		return false
+	}
+	if !isChange(event.Type) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.matched[key]
+	}
+	return p.update(key, event)
+}
+
+// update matches a change of an object against the filters of the profile, remembering the outcome
+func (p *profile) update(key string, event Event) bool {
+	matched := p.matches(event)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if event.Type == string(watch.Deleted) || !matched {
+		delete(p.matched, key)
+	} else {
+		p.matched[key] = true
+	}
+	return matched
+}
+
+// evict forgets whether the last revision of the object matched
+func (p *profile) evict(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.matched, key)
+}
+
+// matches applies the filters of the profile to the object of a change
+func (p *profile) matches(event Event) bool {
+	objMeta, err := meta.Accessor(event.Object)
+	if err != nil {
+		return false
+	}
+	if p.namespaces != nil && !p.namespaces[objMeta.GetNamespace()] {
+		return false
+	}
+	if p.selector != nil && !p.selector.Matches(labels.Set(objMeta.GetLabels())) {
+		return false
+	}
+	// Replayed events are not serialized yet
+	objYAML := event.yaml
+	if objYAML == "" {
+		data, err := yaml.Marshal(event.Object)
+		if err != nil {
+			return false
+		}
+		objYAML = string(data)
+	}
+	return p.config.filter.matchesObject(event.Object, objYAML) &&
+		(p.config.status == nil || p.config.status.matches(event.Object)) &&
+		(p.config.cel == nil || p.config.cel.matches(clusterKey(event.Cluster, event.Key), event.Object)) &&
+		(p.config.exclude == nil || !p.config.exclude.excludes(event.Object, objYAML))
+}
+
+// isChange tells whether an event type is that of a change to a watched object, rather than an event about one
+func isChange(eventType string) bool {
+	switch eventType {
+	case string(watch.Added), string(watch.Modified), string(watch.Deleted), ResyncEvent:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package watcher
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Redacted replaces the redacted values
+const Redacted = "***"
+
+// Built-in redaction rules of WithRedaction, matched case-insensitively
+const (
+	// DefaultRedactEnv matches the names of the environment variables whose values are redacted
+	DefaultRedactEnv = `SECRET|TOKEN|PASSWORD|PASSWD|API_?KEY|CREDENTIAL|PRIVATE_?KEY`
+	// DefaultRedactAnnotations matches the keys of the annotations whose values are redacted, including the last applied
+	// configuration of kubectl apply, which holds the environment variables of the manifest
+	DefaultRedactAnnotations = `secret|token|password|credential|kubectl\.kubernetes\.io/last-applied-configuration`
+)
+
+// RedactOptions configures the redaction of sensitive values of WithRedaction
+type RedactOptions struct {
+	Builtin     bool     // apply the built-in rules, redacting environment variables and annotations
+	Env         string   // regular expression of the redacted environment variable names; DefaultRedactEnv if empty
+	Annotations string   // regular expression of the redacted annotation keys; DefaultRedactAnnotations if empty
+	Paths       []string // field paths whose values are redacted, e.g. spec.containers[*].args, as with WithStrip
+}
+
+// redactor replaces sensitive values with Redacted before objects are matched and serialized (--redact, --redact-path).
+// The environment variables are those of the containers of pods, and of any pod template of other resources.
+type redactor struct {
+	env         *regexp.Regexp // nil without the built-in rules
+	annotations *regexp.Regexp // nil without the built-in rules
+	paths       [][]string
+}
+
+// newRedactor compiles the redaction rules, returning nil when nothing is redacted
+func newRedactor(options *RedactOptions) (*redactor, error) {
+	if options == nil || !options.Builtin && len(options.Paths) == 0 {
+		return nil, nil
+	}
+	r := &redactor{}
+	if options.Builtin {
+		env, annotations := options.Env, options.Annotations
+		if env == "" {
+			env = DefaultRedactEnv
+		}
+		if annotations == "" {
+			annotations = DefaultRedactAnnotations
+		}
+		var err error
+		if r.env, err = regexp.Compile("(?i)" + env); err != nil {
+			return nil, fmt.Errorf("invalid --redact-env %q: %w", env, err)
+		}
+		if r.annotations, err = regexp.Compile("(?i)" + annotations); err != nil {
+			return nil, fmt.Errorf("invalid --redact-annotations %q: %w", annotations, err)
+		}
+	}
+	for _, path := range options.Paths {
+		segments, err := splitFieldPath(strings.TrimSpace(path))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --redact-path %q: %w", path, err)
+		}
+		r.paths = append(r.paths, segments)
+	}
+	return r, nil
+}
+
+// redact returns a copy of the object with its sensitive values replaced; the original, which may be
+// shared with the informer cache, is never modified
+func (r *redactor) redact(obj runtime.Object) (runtime.Object, error) {
+	obj = obj.DeepCopyObject()
+	if r.annotations != nil {
+		if objMeta, err := meta.Accessor(obj); err == nil {
+			annotations := objMeta.GetAnnotations()
+			for key, value := range annotations {
+				if value != "" && r.annotations.MatchString(key) {
+					annotations[key] = Redacted
+				}
+			}
+			objMeta.SetAnnotations(annotations)
+		}
+	}
+	if pod, ok := obj.(*corev1.Pod); ok && r.env != nil {
+		for i := range pod.Spec.InitContainers {
+			r.redactEnv(pod.Spec.InitContainers[i].Env)
+		}
+		for i := range pod.Spec.Containers {
+			r.redactEnv(pod.Spec.Containers[i].Env)
+		}
+		for i := range pod.Spec.EphemeralContainers {
+			r.redactEnv(pod.Spec.EphemeralContainers[i].Env)
+		}
+	}
+	u, isUnstructured := obj.(*unstructured.Unstructured)
+	if isUnstructured && r.env != nil {
+		r.redactUnstructuredEnv(u.Object)
+	}
+	if len(r.paths) == 0 {
+		return obj, nil
+	}
+	// Typed objects are converted to their unstructured form to redact arbitrary paths, then back again
+	content := map[string]interface{}(nil)
+	if isUnstructured {
+		content = u.Object
+	} else {
+		var err error
+		if content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range r.paths {
+		redactField(content, path)
+	}
+	if isUnstructured {
+		return u, nil
+	}
+	redacted := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object) // an empty object of the same type
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, redacted); err != nil {
+		return nil, err
+	}
+	return redacted, nil
+}
+
+// redactEnv redacts the values of the environment variables with a sensitive name.
+// Variables referencing a Secret or ConfigMap have no value to redact.
+func (r *redactor) redactEnv(env []corev1.EnvVar) {
+	for i := range env {
+		if env[i].Value != "" && r.env.MatchString(env[i].Name) {
+			env[i].Value = Redacted
+		}
+	}
+}
+
+// redactUnstructuredEnv redacts the values of the environment variables with a sensitive name
+// of every env list of the unstructured content, such as those of the containers of a pod template
+func (r *redactor) redactUnstructuredEnv(content interface{}) {
+	switch value := content.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if env, ok := child.([]interface{}); ok && key == "env" {
+				for _, variable := range env {
+					if variable, ok := variable.(map[string]interface{}); ok {
+						name, _ := variable["name"].(string)
+						if v, ok := variable["value"].(string); ok && v != "" && r.env.MatchString(name) {
+							variable["value"] = Redacted
+						}
+					}
+				}
+				continue
+			}
+			r.redactUnstructuredEnv(child)
+		}
+	case []interface{}:
+		for _, element := range value {
+			r.redactUnstructuredEnv(element)
+		}
+	}
+}
+
+// redactField replaces the string values at path in the unstructured content, and every string value below it
+// when it selects a map or list. Values of other types are kept, as typed objects could not hold the replacement.
+func redactField(content interface{}, path []string) {
+	if len(path) == 0 {
+		redactStrings(content)
+		return
+	}
+	switch value := content.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if path[0] != "*" && key != path[0] {
+				continue
+			}
+			if s, ok := child.(string); ok && len(path) == 1 {
+				if s != "" {
+					value[key] = Redacted
+				}
+				continue
+			}
+			redactField(child, path[1:])
+		}
+	case []interface{}:
+		if path[0] != "*" {
+			return // only [*] is supported for lists
+		}
+		for i, element := range value {
+			if s, ok := element.(string); ok && len(path) == 1 {
+				if s != "" {
+					value[i] = Redacted
+				}
+				continue
+			}
+			redactField(element, path[1:])
+		}
+	}
+}
+
+// redactStrings replaces every string value below the unstructured content
+func redactStrings(content interface{}) {
+	switch value := content.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if s, ok := child.(string); ok {
+				if s != "" {
+					value[key] = Redacted
+				}
+				continue
+			}
+			redactStrings(child)
+		}
+	case []interface{}:
+		for i, element := range value {
+			if s, ok := element.(string); ok {
+				if s != "" {
+					value[i] = Redacted
+				}
+				continue
+			}
+			redactStrings(element)
+		}
+	}
+}
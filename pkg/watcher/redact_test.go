@@ -0,0 +1,223 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// secretPod returns a pod whose container has a sensitive and an ordinary variable, one taken from a Secret,
+// a sensitive and an ordinary annotation, and arguments
+func secretPod() *corev1.Pod {
+	pod := fakePod("default", "web")
+	pod.Annotations = map[string]string{"api-token": "hunter2", "team": "payments"}
+	pod.Spec.Containers[0].Args = []string{"--password=hunter2"}
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "DB_PASSWORD", Value: "hunter2"},
+		{Name: "MODE", Value: "debug"},
+		{Name: "API_KEY", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "api"}, Key: "key",
+		}}},
+	}
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init", Env: []corev1.EnvVar{{Name: "TOKEN", Value: "hunter2"}}}}
+	return pod
+}
+
+func TestRedact(t *testing.T) {
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{
+				"name": "web",
+				"env":  []interface{}{map[string]interface{}{"name": "SECRET_KEY", "value": "hunter2"}},
+			}},
+		}}},
+	}}
+	for _, tc := range []struct {
+		name    string
+		options RedactOptions
+		obj     runtime.Object
+		want    []string // in the YAML of the redacted object
+		notWant []string
+	}{{
+		name:    "env values",
+		options: RedactOptions{Builtin: true},
+		obj:     secretPod(),
+		want:    []string{"name: DB_PASSWORD\n      value: '***'", "name: MODE\n      value: debug", "name: TOKEN\n      value: '***'"},
+		notWant: []string{"value: hunter2"},
+	}, {
+		name:    "valueFrom",
+		options: RedactOptions{Builtin: true},
+		obj:     secretPod(),
+		want:    []string{"- name: API_KEY\n      valueFrom:\n        secretKeyRef:\n          key: key\n          name: api\n"},
+	}, {
+		name:    "annotations",
+		options: RedactOptions{Builtin: true},
+		obj:     secretPod(),
+		want:    []string{"api-token: '***'", "team: payments"},
+	}, {
+		name:    "custom env",
+		options: RedactOptions{Builtin: true, Env: "^MODE$"},
+		obj:     secretPod(),
+		want:    []string{"name: MODE\n      value: '***'", "name: DB_PASSWORD\n      value: hunter2"},
+	}, {
+		name:    "pod template env",
+		options: RedactOptions{Builtin: true},
+		obj:     deployment,
+		want:    []string{"name: SECRET_KEY\n          value: '***'"},
+	}, {
+		name:    "paths",
+		options: RedactOptions{Paths: []string{"spec.containers[*].args"}},
+		obj:     secretPod(),
+		want:    []string{"- '***'", "name: DB_PASSWORD\n      value: hunter2", "api-token: hunter2"},
+		notWant: []string{"--password=hunter2"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := newRedactor(&tc.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			original, err := yaml.Marshal(tc.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			redacted, err := r.redact(tc.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := yaml.Marshal(redacted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("redacted object lacks %q:\n%s", want, out)
+				}
+			}
+			for _, notWant := range tc.notWant {
+				if strings.Contains(string(out), notWant) {
+					t.Errorf("redacted object holds %q:\n%s", notWant, out)
+				}
+			}
+			// The original may be shared with the informer cache
+			if after, _ := yaml.Marshal(tc.obj); !bytes.Equal(original, after) {
+				t.Error("the original object was modified")
+			}
+		})
+	}
+}
+
+func TestNewRedactor(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options *RedactOptions
+		wantNil bool
+		wantErr string
+	}{
+		{name: "unset", options: nil, wantNil: true},
+		{name: "nothing redacted", options: &RedactOptions{Env: "SECRET"}, wantNil: true},
+		{name: "invalid env", options: &RedactOptions{Builtin: true, Env: "("}, wantErr: "--redact-env"},
+		{name: "invalid annotations", options: &RedactOptions{Builtin: true, Annotations: "["}, wantErr: "--redact-annotations"},
+		{name: "invalid path", options: &RedactOptions{Paths: []string{"spec..args"}}, wantErr: "--redact-path"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := newRedactor(tc.options)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want one about %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (r == nil) != tc.wantNil {
+				t.Errorf("got redactor %v, want nil: %v", r, tc.wantNil)
+			}
+		})
+	}
+}
+
+// syncBuffer is a bytes.Buffer written by the watcher while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// waitFor returns the content of the buffer once it holds s
+func (b *syncBuffer) waitFor(t *testing.T, s string) string {
+	t.Helper()
+	deadline := time.Now().Add(eventTimeout)
+	for {
+		b.mu.Lock()
+		content := b.buf.String()
+		b.mu.Unlock()
+		if strings.Contains(content, s) {
+			return content
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the output lacks %q:\n%s", s, content)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedactWatch(t *testing.T) {
+	cluster, clientset := NewFakeCluster("")
+	out := &syncBuffer{}
+	events := fakeWatch(t, cluster, WithMarkers("DB_PASSWORD"), WithRedaction(RedactOptions{Builtin: true}),
+		WithKubernetesEvents(), WithOutput(out, OutputDiff))
+	ctx := context.Background()
+
+	pod := secretPod()
+	pod.ResourceVersion = "1"
+	pod, err := clientset.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, "ADDED", "default/web")
+	// The diff of a new secret and phase only shows the phase
+	pod = pod.DeepCopy()
+	pod.ResourceVersion = "2"
+	pod.Spec.Containers[0].Env[0].Value = "hunter3"
+	pod.Status.Phase = corev1.PodRunning
+	if _, err := clientset.CoreV1().Pods("default").Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, "MODIFIED", "default/web")
+	// The Events about the pod are redacted by their annotations
+	kubeEvent := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: "web.1", ResourceVersion: "3", Annotations: map[string]string{"api-token": "hunter2"}},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web"},
+		Reason:         "Started",
+	}
+	if _, err := clientset.CoreV1().Events("default").Create(ctx, kubeEvent, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, KubeEvent, "default/web.1")
+
+	content := out.waitFor(t, "reason: Started")
+	if !strings.Contains(content, "+  phase: Running") {
+		t.Errorf("the diff lacks the phase:\n%s", content)
+	}
+	if strings.Contains(content, "value: hunter") || strings.Contains(content, "api-token: hunter") {
+		t.Errorf("the output holds a secret:\n%s", content)
+	}
+}
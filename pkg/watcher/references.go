@@ -0,0 +1,375 @@
+package watcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Kinds of the objects referenced by pods, followed with WithReferences
+const (
+	configMapKind = "ConfigMap"
+	secretKind    = "Secret"
+)
+
+// ReferenceChange is a change to a ConfigMap or Secret referenced by a matched pod, carried by REFERENCE events
+type ReferenceChange struct {
+	Kind   string `json:"kind"`   // ConfigMap or Secret
+	Name   string `json:"name"`   // in the namespace of the pod
+	Change string `json:"change"` // ADDED, MODIFIED or DELETED
+	// Keys are the keys of the data that changed: every key of an added or deleted object
+	Keys []string `json:"keys,omitempty"`
+	// Data is the data of the object after the change with WithReferences(true); nil otherwise
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// String formats the change compactly, e.g. "ConfigMap app-config MODIFIED (keys: config.yaml, log-level)"
+func (r *ReferenceChange) String() string {
+	text := r.Kind + " " + r.Name + " " + r.Change
+	if len(r.Keys) > 0 {
+		text += " (keys: " + strings.Join(r.Keys, ", ") + ")"
+	}
+	return text
+}
+
+// podReferences lists the ConfigMaps and Secrets a pod mounts or takes environment variables from, as kind/name
+func podReferences(pod *corev1.Pod) []string {
+	seen := make(map[string]bool)
+	var refs []string
+	add := func(kind, name string) {
+		if ref := kind + "/" + name; name != "" && !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.ConfigMap != nil:
+			add(configMapKind, volume.ConfigMap.Name)
+		case volume.Secret != nil:
+			add(secretKind, volume.Secret.SecretName)
+		case volume.Projected != nil:
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					add(configMapKind, source.ConfigMap.Name)
+				}
+				if source.Secret != nil {
+					add(secretKind, source.Secret.Name)
+				}
+			}
+		}
+	}
+	addEnv := func(envFrom []corev1.EnvFromSource, env []corev1.EnvVar) {
+		for _, source := range envFrom {
+			if source.ConfigMapRef != nil {
+				add(configMapKind, source.ConfigMapRef.Name)
+			}
+			if source.SecretRef != nil {
+				add(secretKind, source.SecretRef.Name)
+			}
+		}
+		for _, variable := range env {
+			if variable.ValueFrom == nil {
+				continue
+			}
+			if variable.ValueFrom.ConfigMapKeyRef != nil {
+				add(configMapKind, variable.ValueFrom.ConfigMapKeyRef.Name)
+			}
+			if variable.ValueFrom.SecretKeyRef != nil {
+				add(secretKind, variable.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		addEnv(container.EnvFrom, container.Env)
+	}
+	for _, container := range pod.Spec.Containers {
+		addEnv(container.EnvFrom, container.Env)
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		addEnv(container.EnvFrom, container.Env)
+	}
+	return refs
+}
+
+// podIndex indexes the matched pods by the objects they reference, as kind/name in their namespace,
+// so that a change to one of the objects is reported to every matched pod referencing it
+type podIndex struct {
+	references func(pod *corev1.Pod) []string // the objects the pod references
+	matched    func(id string) bool           // whether the pod, qualified by its cluster, currently matches
+
+	mu   sync.Mutex
+	pods map[string]*matchedObject  // pod key, qualified by its cluster -> its latest matched revision
+	refs map[string][]string        // pod key, qualified by its cluster -> the references of the pod, as kind/name
+	by   map[string]map[string]bool // reference, as kind/namespace/name qualified by its cluster -> the pods referencing it
+}
+
+func newPodIndex(references func(*corev1.Pod) []string, matched func(string) bool) *podIndex {
+	return &podIndex{references: references, matched: matched, pods: make(map[string]*matchedObject),
+		refs: make(map[string][]string), by: make(map[string]map[string]bool)}
+}
+
+// referenceID identifies a referenced object across clusters
+func referenceID(cluster, kind, namespace, name string) string {
+	return clusterKey(cluster, kind+"/"+namespace+"/"+name)
+}
+
+// update records the references of the latest revision of a matched pod, or forgets them once it is deleted
+func (x *podIndex) update(eventType string, m *matchedObject) {
+	pod, ok := m.obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.forget(m.id)
+	if eventType == string(watch.Deleted) {
+		return
+	}
+	refs := x.references(pod)
+	x.pods[m.id], x.refs[m.id] = m, refs
+	for _, ref := range refs {
+		kind, name, _ := strings.Cut(ref, "/")
+		id := referenceID(m.cluster, kind, pod.Namespace, name)
+		if x.by[id] == nil {
+			x.by[id] = make(map[string]bool)
+		}
+		x.by[id][m.id] = true
+	}
+}
+
+// evict removes the pod and its references
+func (x *podIndex) evict(podID string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.forget(podID)
+}
+
+// forget removes the pod and its references; the lock must be held
+func (x *podIndex) forget(podID string) {
+	m, ok := x.pods[podID]
+	if !ok {
+		return
+	}
+	namespace := ""
+	if pod, ok := m.obj.(*corev1.Pod); ok {
+		namespace = pod.Namespace
+	}
+	for _, ref := range x.refs[podID] {
+		kind, name, _ := strings.Cut(ref, "/")
+		id := referenceID(m.cluster, kind, namespace, name)
+		delete(x.by[id], podID)
+		if len(x.by[id]) == 0 {
+			delete(x.by, id)
+		}
+	}
+	delete(x.pods, podID)
+	delete(x.refs, podID)
+}
+
+// referencing returns the matched pods referencing the object, forgetting those that stopped matching
+func (x *podIndex) referencing(id string) []*matchedObject {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var pods []*matchedObject
+	for podID := range x.by[id] {
+		if !x.matched(podID) {
+			x.forget(podID)
+			continue
+		}
+		pods = append(pods, x.pods[podID])
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].id < pods[j].id })
+	return pods
+}
+
+// referenceTracker follows the ConfigMaps and Secrets referenced by the matched pods (--follow-references)
+type referenceTracker struct {
+	*podIndex
+	includeData bool // keep the data of the objects, reported with the changes, instead of its digests
+}
+
+func newReferenceTracker(includeData bool, matched func(string) bool) *referenceTracker {
+	return &referenceTracker{podIndex: newPodIndex(podReferences, matched), includeData: includeData}
+}
+
+// trim keeps only the name, namespace, resource version and data of a ConfigMap or Secret, which keeps the cache small.
+// Unless the data is included, its values are replaced by their digests, which are enough to tell which keys changed
+// without holding the values of the Secrets in memory.
+func (t *referenceTracker) trim(obj interface{}) (interface{}, error) {
+	switch obj := obj.(type) {
+	case *corev1.ConfigMap:
+		data := make(map[string]string, len(obj.Data)+len(obj.BinaryData))
+		for key, value := range obj.Data {
+			data[key] = t.value([]byte(value))
+		}
+		for key, value := range obj.BinaryData {
+			data[key] = t.value(value)
+		}
+		return &corev1.ConfigMap{ObjectMeta: trimmedMeta(obj.ObjectMeta), Data: data}, nil
+	case *corev1.Secret:
+		data := make(map[string][]byte, len(obj.Data))
+		for key, value := range obj.Data {
+			data[key] = []byte(t.value(value))
+		}
+		return &corev1.Secret{ObjectMeta: trimmedMeta(obj.ObjectMeta), Data: data}, nil
+	default:
+		return obj, nil // a tombstone
+	}
+}
+
+// value returns the value kept for a data entry: the value itself with the data included, its digest otherwise
+func (t *referenceTracker) value(value []byte) string {
+	if t.includeData {
+		return string(value)
+	}
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+func trimmedMeta(objMeta metav1.ObjectMeta) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: objMeta.Name, Namespace: objMeta.Namespace, ResourceVersion: objMeta.ResourceVersion}
+}
+
+// referencedData returns the metadata and data of a trimmed ConfigMap or Secret
+func referencedData(obj interface{}) (*metav1.ObjectMeta, map[string]string, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	switch obj := obj.(type) {
+	case *corev1.ConfigMap:
+		return &obj.ObjectMeta, obj.Data, true
+	case *corev1.Secret:
+		data := make(map[string]string, len(obj.Data))
+		for key, value := range obj.Data {
+			data[key] = string(value)
+		}
+		return &obj.ObjectMeta, data, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// changedKeys lists the keys whose values differ between the data of two revisions, in order
+func changedKeys(previous, current map[string]string) []string {
+	var keys []string
+	for key, value := range current {
+		if old, ok := previous[key]; !ok || old != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runReferenceInformers watches the ConfigMaps and Secrets in one namespace (metav1.NamespaceAll for every namespace)
+// of a cluster, reporting the changes to those referenced by matched pods until the context is canceled.
+// The objects that already existed when the watcher started are not reported, like the pods themselves.
+func (w *Watcher) runReferenceInformers(ctx context.Context, c *cluster, namespace string, processor *eventProcessor) error {
+	configMaps, secrets := c.clientset.CoreV1().ConfigMaps(namespace), c.clientset.CoreV1().Secrets(namespace)
+	informers := map[string]cache.SharedIndexInformer{
+		configMapKind: cache.NewSharedIndexInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return configMaps.List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				if timeout := watchTimeoutSeconds(w.watchTimeout); timeout != nil {
+					options.TimeoutSeconds = timeout
+				}
+				return configMaps.Watch(ctx, options)
+			},
+		}, &corev1.ConfigMap{}, 0, cache.Indexers{}),
+		secretKind: cache.NewSharedIndexInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return secrets.List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				if timeout := watchTimeoutSeconds(w.watchTimeout); timeout != nil {
+					options.TimeoutSeconds = timeout
+				}
+				return secrets.Watch(ctx, options)
+			},
+		}, &corev1.Secret{}, 0, cache.Indexers{}),
+	}
+	var wg sync.WaitGroup
+	for kind, informer := range informers {
+		if err := informer.SetTransform(processor.references.trim); err != nil {
+			return fmt.Errorf("could not register %s transform: %w", kind, err)
+		}
+		changed := func(change string, previous, current interface{}) {
+			objMeta, data, ok := referencedData(current)
+			if !ok {
+				return
+			}
+			_, previousData, _ := referencedData(previous)
+			reference := &ReferenceChange{Kind: kind, Name: objMeta.Name, Change: change}
+			if change == string(watch.Deleted) {
+				reference.Keys = changedKeys(data, nil)
+			} else {
+				reference.Keys = changedKeys(previousData, data)
+				if processor.references.includeData {
+					reference.Data = data
+				}
+			}
+			if change == string(watch.Modified) && len(reference.Keys) == 0 {
+				return // only the metadata changed
+			}
+			processor.emitReference(c.name, objMeta.Namespace, reference)
+		}
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				if !isInInitialList {
+					changed(string(watch.Added), nil, obj)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if !sameResourceVersion(oldObj, newObj) {
+					changed(string(watch.Modified), oldObj, newObj)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				changed(string(watch.Deleted), nil, obj)
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("could not register %s handler: %w", kind, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			informer.Run(ctx.Done())
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// emitReference emits a REFERENCE event to each matched pod referencing the changed object, unless its type is filtered out
+func (p *eventProcessor) emitReference(cluster, namespace string, reference *ReferenceChange) {
+	if p.eventTypes != nil && !p.eventTypes[ReferenceEvent] {
+		return
+	}
+	for _, m := range p.references.referencing(referenceID(cluster, reference.Kind, namespace, reference.Name)) {
+		event := Event{Type: ReferenceEvent, Key: m.key, Object: m.obj, Timestamp: time.Now().UTC(), Cluster: m.cluster, Reference: reference}
+		p.sinks.write(event)
+		p.publish(event)
+		eventsEmitted.WithLabelValues(ReferenceEvent).Inc()
+		p.health.eventEmitted()
+	}
+}
@@ -0,0 +1,89 @@
+package watcher
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WithRelistInterval lists the watched objects afresh at every interval, as a safety net against watch events missed
+// on a brief disconnect: each listed object that the informer's cache lacks, or holds at an older revision,
+// is emitted as a RESYNC event, after its filters, so that the stream is eventually consistent with the cluster
+func WithRelistInterval(interval time.Duration) Option {
+	return func(w *Watcher) { w.relistInterval = interval }
+}
+
+// runRelists lists the objects of the namespace, or shard of it, at every relist interval once the informer has
+// synced, handing those the store lacks or holds at an older revision to resync, until the context is canceled
+func (w *Watcher) runRelists(ctx context.Context, c *cluster, namespace string, shard *watchShard, store cache.Store, synced cache.InformerSynced,
+	resync func(obj runtime.Object)) {
+	if !cache.WaitForCacheSync(ctx.Done(), synced) {
+		return
+	}
+	ticker := time.NewTicker(w.relistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// A consistent read of the API server's storage, rather than of its watch cache, which may lag too
+		options := metav1.ListOptions{}
+		w.applySelectors(c, shard, &options)
+		list, err := c.client.List(ctx, namespace, options)
+		if err == nil {
+			var items []runtime.Object
+			if items, err = meta.ExtractList(list); err == nil {
+				missed := 0
+				for _, item := range items {
+					if missedBy(store, item) {
+						missed++
+						resync(item)
+					}
+				}
+				relistMissed.Add(float64(missed))
+				if missed > 0 {
+					slog.Warn("Relisting found changes the watch had not delivered", "cluster", c.name, "namespace", namespaceList([]string{namespace}),
+						"objects", missed)
+				}
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Could not relist the watched objects", "cluster", c.name, "namespace", namespaceList([]string{namespace}), "error", err)
+	}
+}
+
+// missedBy tells whether the store of the informer lacks the listed object, or holds an older revision of it
+func missedBy(store cache.Store, obj runtime.Object) bool {
+	listed, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return false
+	}
+	cached, exists, err := store.GetByKey(key)
+	if err != nil || !exists {
+		return err == nil
+	}
+	cachedMeta, err := meta.Accessor(cached)
+	if err != nil || cachedMeta.GetResourceVersion() == listed.GetResourceVersion() {
+		return false
+	}
+	// The resource versions are opaque, but those of etcd are increasing integers, which tell the newer revision
+	// when the watch delivered a change since the list was made
+	cachedVersion, cachedErr := strconv.ParseUint(cachedMeta.GetResourceVersion(), 10, 64)
+	listedVersion, listedErr := strconv.ParseUint(listed.GetResourceVersion(), 10, 64)
+	return cachedErr != nil || listedErr != nil || listedVersion > cachedVersion
+}
@@ -0,0 +1,40 @@
+package watcher
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func relistPod(name, resourceVersion string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: resourceVersion}}
+}
+
+func TestMissedBy(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, pod := range []*corev1.Pod{relistPod("current", "10"), relistPod("stale", "10"), relistPod("ahead", "20"), relistPod("opaque", "a")} {
+		if err := store.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		listed *corev1.Pod
+		missed bool
+	}{
+		{"same revision", relistPod("current", "10"), false},
+		{"missing from the cache", relistPod("created", "12"), true},
+		{"newer than the cache", relistPod("stale", "15"), true},
+		{"older than the cache", relistPod("ahead", "15"), false},
+		{"opaque revisions that differ", relistPod("opaque", "b"), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if missed := missedBy(store, test.listed); missed != test.missed {
+				t.Errorf("missedBy = %v, want %v", missed, test.missed)
+			}
+		})
+	}
+}
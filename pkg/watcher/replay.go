@@ -0,0 +1,240 @@
+package watcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// ReadEvents reads an event stream captured in the yaml, json or jsonl output format, optionally gzip-compressed.
+// Container log lines, changes and statistics are skipped. Streams in the diff format cannot be read back, as they do not hold every revision.
+// The yaml format does not record when the events happened, so their Timestamp is zero.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	in := bufio.NewReader(r)
+	if magic, _ := in.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		in = bufio.NewReader(gz)
+	}
+	// JSON envelopes start with a brace, YAML streams with a document separator
+	for {
+		b, err := in.ReadByte()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		_ = in.UnreadByte()
+		if b == '{' {
+			return readJSONEvents(in)
+		}
+		return readYAMLEvents(in)
+	}
+}
+
+// readJSONEvents reads a stream of JSON envelopes, indented or one per line
+func readJSONEvents(r io.Reader) ([]Event, error) {
+	decoder := json.NewDecoder(r)
+	var events []Event
+	for n := 1; ; n++ {
+		var envelope struct {
+			Type      string          `json:"type"`
+			Timestamp time.Time       `json:"timestamp"`
+			Cluster   string          `json:"cluster"`
+			Owner     *Owner          `json:"owner"`
+			Pod       json.RawMessage `json:"pod"`
+			Object    json.RawMessage `json:"object"`
+		}
+		if err := decoder.Decode(&envelope); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read event %d: %w", n, err)
+		}
+		if envelope.Type == logEvent || envelope.Type == ContainerEvent || envelope.Type == TimelineEvent || envelope.Type == AlertEvent || envelope.Type == MetricsEvent || envelope.Type == ReferenceEvent || envelope.Type == VolumeEvent ||
+			envelope.Type == TerminatingEvent || envelope.Type == FinalizedEvent || envelope.Type == InitEvent || envelope.Type == ImageEvent || envelope.Type == SecurityEvent ||
+			envelope.Type == BackfillEvent ||
+			envelope.Type == statsDocument || envelope.Type == heartbeatDocument || envelope.Type == warningDocument {
+			continue // derived from the pods, which are replayed
+		}
+		data, kind := envelope.Object, ""
+		if envelope.Pod != nil {
+			data, kind = envelope.Pod, storedPod
+		} else if envelope.Type == KubeEvent {
+			kind = storedEvent
+		}
+		if data == nil {
+			return nil, fmt.Errorf("event %d holds no object", n)
+		}
+		obj, err := decodeObject(kind, data)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode the object of event %d: %w", n, err)
+		}
+		event := newRecordedEvent(envelope.Type, envelope.Cluster, obj, envelope.Timestamp)
+		event.Owner = envelope.Owner
+		events = append(events, event)
+	}
+}
+
+// readYAMLEvents reads a YAML stream with one "## Event: TYPE" document per event
+func readYAMLEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	var eventType, cluster string
+	var owner *Owner
+	var document strings.Builder
+	n := 0
+	// flush decodes the document read so far
+	flush := func() error {
+		if eventType == "" {
+			if strings.TrimSpace(document.String()) != "" {
+				return fmt.Errorf("document %d is not an event (missing its \"## Event:\" line)", n)
+			}
+			return nil
+		}
+		obj, err := decodeYAMLObject(eventType, []byte(document.String()))
+		if err != nil {
+			return fmt.Errorf("could not decode the object of event %d: %w", n, err)
+		}
+		event := newRecordedEvent(eventType, cluster, obj, time.Time{})
+		event.Owner = owner
+		events = append(events, event)
+		eventType, cluster, owner = "", "", nil
+		document.Reset()
+		return nil
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "---":
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			n++
+		case strings.HasPrefix(line, "## Event: "):
+			eventType = strings.TrimPrefix(line, "## Event: ")
+		case strings.HasPrefix(line, "## Cluster: "):
+			cluster = strings.TrimPrefix(line, "## Cluster: ")
+		case strings.HasPrefix(line, "## Owner: "):
+			kind, name, _ := strings.Cut(strings.TrimPrefix(line, "## Owner: "), "/")
+			owner = &Owner{Kind: kind, Name: name}
+		case strings.HasPrefix(line, "## Diff: "):
+			return nil, fmt.Errorf("document %d is a diff: streams in the %s format cannot be replayed", n, OutputDiff)
+		case strings.HasPrefix(line, "## Log ["), strings.HasPrefix(line, "## Container ["):
+			// container log lines and changes carry no object
+		default:
+			document.WriteString(line)
+			document.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// decodeYAMLObject restores an object of the YAML stream. Pods carry no kind, as the informers strip it,
+// so objects without one are pods, and the objects of EVENT documents are Kubernetes Events.
+func decodeYAMLObject(eventType string, data []byte) (runtime.Object, error) {
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var typeMeta struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &typeMeta); err != nil {
+		return nil, err
+	}
+	kind := typeMeta.Kind
+	switch {
+	case eventType == KubeEvent:
+		kind = storedEvent
+	case kind == "" || (kind == "Pod" && typeMeta.APIVersion == "v1"):
+		kind = storedPod
+	}
+	return decodeObject(kind, data)
+}
+
+// newRecordedEvent rebuilds the event of a recorded object
+func newRecordedEvent(eventType string, cluster string, obj runtime.Object, timestamp time.Time) Event {
+	key := ""
+	if objMeta, err := meta.Accessor(obj); err == nil {
+		key = objMeta.GetName()
+		if objMeta.GetNamespace() != "" {
+			key = objMeta.GetNamespace() + "/" + key
+		}
+	}
+	return Event{Type: eventType, Key: key, Object: obj, Timestamp: timestamp, Cluster: cluster}
+}
+
+// Replay re-emits recorded events, in order, through the sinks configured by the options (WithSink, WithOutput,
+// WithOutputFile, WithWebhook, WithKafka, WithNATS or WithStore, and those of WithProfile); any other options are ignored.
+// With a speed of 0 the events are emitted as fast as the sinks accept them; otherwise the original intervals
+// between them are reproduced, divided by speed. The events keep their original timestamps;
+// those without one are emitted without a delay and stamped with the time of their replay. Canceling the context stops the replay and aborts deliveries in progress.
+func Replay(ctx context.Context, events []Event, speed float64, options ...Option) error {
+	if speed < 0 {
+		return fmt.Errorf("invalid replay speed %v (must be at least 0)", speed)
+	}
+	w := &Watcher{}
+	for _, option := range options {
+		option(w)
+	}
+	if err := w.compileProfiles(); err != nil {
+		return err
+	}
+	if err := w.openSinks(); err != nil {
+		return err
+	}
+	defer w.bindSinks(ctx)()
+	sinks := newFanOut(w.sinks)
+	defer sinks.close()
+	slog.Info("Replaying events", "events", len(events), "speed", speed)
+	var previous time.Time
+	for i, event := range events {
+		if speed > 0 && !previous.IsZero() && event.Timestamp.After(previous) {
+			delay := time.Duration(float64(event.Timestamp.Sub(previous)) / speed)
+			select {
+			case <-ctx.Done():
+				slog.Info("Replay canceled", "replayed", i, "events", len(events))
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		} else if ctx.Err() != nil {
+			slog.Info("Replay canceled", "replayed", i, "events", len(events))
+			return ctx.Err()
+		}
+		if event.Timestamp.IsZero() {
+			event.Timestamp = time.Now().UTC()
+		} else {
+			previous = event.Timestamp
+		}
+		sinks.write(event)
+		eventsEmitted.WithLabelValues(event.Type).Inc()
+	}
+	slog.Info("Replay complete", "events", len(events))
+	return nil
+}
@@ -0,0 +1,132 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// podsResource is the resource watched when --resource is not given
+var podsResource = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// resourceClient lists and watches one kind of resource in a namespace (metav1.NamespaceAll for every namespace).
+// Pods are served by the typed clientset so that pod-specific features see *corev1.Pod objects;
+// every other resource is served by the dynamic client as *unstructured.Unstructured.
+type resourceClient interface {
+	List(ctx context.Context, namespace string, opts metav1.ListOptions) (runtime.Object, error)
+	Watch(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error)
+	// ExampleObject returns an empty object of the type this client produces, for the informer
+	ExampleObject() runtime.Object
+}
+
+// podClient is the resourceClient for pods
+type podClient struct {
+	clientset kubernetes.Interface
+}
+
+func (c podClient) List(ctx context.Context, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+	return c.clientset.CoreV1().Pods(namespace).List(ctx, opts)
+}
+
+func (c podClient) Watch(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	return c.clientset.CoreV1().Pods(namespace).Watch(ctx, opts)
+}
+
+func (c podClient) ExampleObject() runtime.Object {
+	return &corev1.Pod{}
+}
+
+// dynamicClient is the resourceClient for any other GroupVersionResource
+type dynamicClient struct {
+	client     dynamic.Interface
+	resource   schema.GroupVersionResource
+	namespaced bool
+}
+
+func (c dynamicClient) List(ctx context.Context, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+	return c.resourceInterface(namespace).List(ctx, opts)
+}
+
+func (c dynamicClient) Watch(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	return c.resourceInterface(namespace).Watch(ctx, opts)
+}
+
+func (c dynamicClient) ExampleObject() runtime.Object {
+	return &unstructured.Unstructured{}
+}
+
+func (c dynamicClient) resourceInterface(namespace string) dynamic.ResourceInterface {
+	if !c.namespaced {
+		return c.client.Resource(c.resource)
+	}
+	return c.client.Resource(c.resource).Namespace(namespace)
+}
+
+// newResourceClient resolves the --resource argument and returns a client for it.
+// The argument is a resource name, optionally qualified by group and version
+// (e.g. "pods", "deployments.apps", "mycrds.example.com/v1"); short names and kinds are resolved via discovery.
+// It also reports whether the resource is namespaced.
+func newResourceClient(config *rest.Config, clientset kubernetes.Interface, arg string) (resourceClient, bool, error) {
+	if arg == "" {
+		return podClient{clientset: clientset}, true, nil
+	}
+	gvr, namespaced, err := ResolveResource(clientset.Discovery(), arg)
+	if err != nil {
+		return nil, false, err
+	}
+	if gvr == podsResource {
+		return podClient{clientset: clientset}, true, nil
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not create dynamic Kubernetes client: %w", err)
+	}
+	return dynamicClient{client: client, resource: gvr, namespaced: namespaced}, namespaced, nil
+}
+
+// ResolveResource maps a resource argument, as given to WithResource, to a GroupVersionResource using API discovery,
+// and reports whether the resource is namespaced.
+func ResolveResource(client discovery.DiscoveryInterface, arg string) (schema.GroupVersionResource, bool, error) {
+	var partial schema.GroupVersionResource
+	if groupResource, version, ok := strings.Cut(arg, "/"); ok {
+		partial = schema.ParseGroupResource(groupResource).WithVersion(version)
+	} else {
+		partial = schema.ParseGroupResource(arg).WithVersion("")
+	}
+	mapper := restmapper.NewShortcutExpander(restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(client)), client, nil)
+	gvr, err := mapper.ResourceFor(partial)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("could not resolve resource %q: %w", arg, err)
+	}
+	gvk, err := mapper.KindFor(gvr)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("could not resolve kind of resource %q: %w", arg, err)
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("could not resolve scope of resource %q: %w", arg, err)
+	}
+	return gvr, mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+// objectKey returns the "namespace/name" key of an object, or just the name for cluster-scoped objects
+func objectKey(obj metav1.Object) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
@@ -0,0 +1,179 @@
+package watcher
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FileRotation configures size-based rotation of the output file, and whether an existing file is continued
+type FileRotation struct {
+	MaxSize  int64 // rotate once the file reaches this many bytes; 0 disables rotation
+	MaxFiles int   // number of rotated files to keep
+	Compress bool  // gzip rotated files that were written uncompressed
+	// Append continues an existing file instead of truncating it; a compressed file is continued with a new gzip member
+	Append bool
+}
+
+// renameFile moves the files aside on rotation; the tests make it fail
+var renameFile = os.Rename
+
+// rotatingFile is the destination of the output file. It optionally gzip-compresses the stream and,
+// when rotation is enabled, moves the file aside between documents once it has grown past the size limit:
+// "pods.yaml" becomes "pods.yaml.1" (or "pods.yaml.1.gz"), the previous ".1" becomes ".2", and so on.
+type rotatingFile struct {
+	path     string
+	gzip     bool
+	rotation FileRotation
+	file     *os.File
+	gz       *gzip.Writer // nil unless the stream is compressed
+	size     int64        // bytes written to the current file
+}
+
+// openRotatingFile creates (or, unless appending, truncates) the output file at path
+func openRotatingFile(path string, compress bool, rotation FileRotation) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, gzip: compress, rotation: rotation}
+	if err := r.open(rotation.Append); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open creates the file at path, continuing it when appending
+func (r *rotatingFile) open(appending bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(r.path, flags, 0o666)
+	if err != nil {
+		return fmt.Errorf("could not create output file: %w", err)
+	}
+	r.file, r.size = f, 0
+	if info, err := f.Stat(); err == nil {
+		r.size = info.Size()
+	}
+	if r.gzip {
+		r.gz = gzip.NewWriter(countingWriter{w: f, n: &r.size})
+	}
+	return nil
+}
+
+// Write writes to the current file, through the gzip stream when compressing
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.file == nil {
+		return 0, errors.New("the output file is closed")
+	}
+	if r.gz != nil {
+		return r.gz.Write(p)
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// endDocument is called after each complete document and rotates the file if it has grown too large.
+// For a compressed stream the size only counts the compressed bytes flushed to disk so far.
+// When the file cannot be moved aside, it is continued, with a new gzip member if compressed, and rotation is
+// attempted again after the next document.
+func (r *rotatingFile) endDocument() error {
+	if r.rotation.MaxSize <= 0 || r.size < r.rotation.MaxSize {
+		return nil
+	}
+	err := r.Close()
+	if err == nil {
+		err = r.rotate()
+	}
+	if err != nil {
+		if openErr := r.open(true); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return err
+	}
+	return r.open(false)
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and moves the current file to ".1"
+func (r *rotatingFile) rotate() error {
+	if err := os.Remove(r.rotatedName(r.rotation.MaxFiles)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove oldest output file: %w", err)
+	}
+	for i := r.rotation.MaxFiles - 1; i >= 1; i-- {
+		if err := renameFile(r.rotatedName(i), r.rotatedName(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not rotate output file: %w", err)
+		}
+	}
+	if !r.gzip && r.rotation.Compress {
+		return gzipFile(r.path, r.rotatedName(1))
+	}
+	if err := renameFile(r.path, r.rotatedName(1)); err != nil {
+		return fmt.Errorf("could not rotate output file: %w", err)
+	}
+	return nil
+}
+
+// rotatedName returns the name of the i-th most recent rotated file, keeping any ".gz" extension last
+func (r *rotatingFile) rotatedName(i int) string {
+	base, compressed := strings.CutSuffix(r.path, ".gz")
+	if compressed || r.gzip || r.rotation.Compress {
+		return base + "." + strconv.Itoa(i) + ".gz"
+	}
+	return base + "." + strconv.Itoa(i)
+}
+
+// Close finalizes the gzip stream, if any, and closes the current file
+func (r *rotatingFile) Close() error {
+	var firstErr error
+	if r.gz != nil {
+		firstErr = r.gz.Close()
+		r.gz = nil
+	}
+	if r.file != nil {
+		if err := r.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		r.file = nil
+	}
+	return firstErr
+}
+
+// gzipFile compresses src into dst and removes src
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("could not compress rotated output file: %w", err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("could not compress rotated output file: %w", err)
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not compress rotated output file: %w", err)
+	}
+	return os.Remove(src)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
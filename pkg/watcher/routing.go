@@ -0,0 +1,128 @@
+package watcher
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// DefaultRoute is the route value of WithRoute receiving the events of the objects routed to no other sink:
+// those without the route key, or whose value has no route
+const DefaultRoute = "*"
+
+// WithRouteKey sets the label or annotation whose value routes the events of each object to the sinks of WithRoute,
+// e.g. pod-watcher.io/sink. The label is used when the object has both.
+func WithRouteKey(key string) Option {
+	return func(w *Watcher) { w.routeKey = key }
+}
+
+// WithRoute delivers the events of the objects whose route key (see WithRouteKey) has the given value only to the sinks
+// added by sinkOption, e.g. WithRoute("team-a", WithWebhook(options)); a value may list several routes, comma-separated.
+// The other sinks still receive every event. It can be given multiple times, also for the same value.
+func WithRoute(value string, sinkOption Option) Option {
+	return func(w *Watcher) {
+		w.routeValues = append(w.routeValues, value)
+		before := len(w.newSinks)
+		sinkOption(w)
+		for i := before; i < len(w.newSinks); i++ {
+			newSink := w.newSinks[i]
+			w.newSinks[i] = func() (Sink, error) {
+				sink, err := newSink()
+				if err != nil {
+					return nil, err
+				}
+				return &routedSink{Sink: sink, value: value, router: w.router}, nil
+			}
+		}
+	}
+}
+
+// routedSink is a sink only receiving the events routed to its value
+type routedSink struct {
+	Sink
+	value  string
+	router *router
+}
+
+func (s *routedSink) String() string {
+	return sinkName(s.Sink) + " (route " + s.value + ")"
+}
+
+func (s *routedSink) bind(ctx context.Context) {
+	if sink, ok := s.Sink.(bindable); ok {
+		sink.bind(ctx)
+	}
+}
+
+// accepts tells whether an event with the given routes is delivered to the sink
+func (s *routedSink) accepts(routes []string) bool {
+	if s.value == DefaultRoute {
+		return !slices.ContainsFunc(routes, s.router.routed)
+	}
+	return slices.Contains(routes, s.value)
+}
+
+// router finds the routes of the events from the route key of their objects. Events whose object is not the routed one,
+// Kubernetes Events and those carrying no object, are routed like the last revision of the object they are about.
+type router struct {
+	key    string
+	values map[string]bool // the values given a route by WithRoute
+
+	mu     sync.Mutex
+	routes map[string][]string // object key, qualified by its cluster -> the routes of its last revision
+}
+
+func newRouter(key string, values []string) *router {
+	r := &router{key: key, values: make(map[string]bool), routes: make(map[string][]string)}
+	for _, value := range values {
+		r.values[value] = true
+	}
+	return r
+}
+
+// evict forgets the routes of the object
+func (r *router) evict(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.routes, id)
+}
+
+// routed tells whether a value has a route of its own
+func (r *router) routed(value string) bool {
+	return r.values[value]
+}
+
+// route returns the routes of the event
+func (r *router) route(event Event) []string {
+	key := clusterKey(event.Cluster, event.Key)
+	if kubeEvent, ok := event.Object.(*corev1.Event); ok {
+		key = clusterKey(event.Cluster, kubeEvent.InvolvedObject.Namespace+"/"+kubeEvent.InvolvedObject.Name)
+	} else if objMeta, err := meta.Accessor(event.Object); err == nil {
+		value, ok := objMeta.GetLabels()[r.key]
+		if !ok {
+			value = objMeta.GetAnnotations()[r.key]
+		}
+		var routes []string
+		for _, route := range strings.Split(value, ",") {
+			if route = strings.TrimSpace(route); route != "" {
+				routes = append(routes, route)
+			}
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if event.Type == string(watch.Deleted) {
+			delete(r.routes, key)
+		} else {
+			r.routes[key] = routes
+		}
+		return routes
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.routes[key]
+}
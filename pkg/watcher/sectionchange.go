@@ -0,0 +1,110 @@
+package watcher
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// ChangeSection is the section of the objects whose changes WithChangesOnly emits
+type ChangeSection string
+
+const (
+	// SpecSection is the desired state of the object: its spec, or for an object without one, e.g. a ConfigMap,
+	// everything but its metadata and status
+	SpecSection ChangeSection = "spec"
+	// StatusSection is the observed state of the object, its status
+	StatusSection ChangeSection = "status"
+)
+
+// WithChangesOnly only emits the modifications of the objects that change the given section, suppressing e.g.
+// the status updates of the pods of a rollout with SpecSection, or the edits of their spec with StatusSection
+func WithChangesOnly(section ChangeSection) Option {
+	return func(w *Watcher) { w.changeSection = section }
+}
+
+// sectionTracker remembers a fingerprint of the section of each object last seen so that
+// MODIFIED events can be suppressed unless that section actually changed.
+type sectionTracker struct {
+	section ChangeSection
+	mu      sync.Mutex
+	seen    map[string]sectionFingerprint // object key, qualified by its cluster -> fingerprint of its last revision
+}
+
+// sectionFingerprint identifies the content of the section of a revision
+type sectionFingerprint struct {
+	generation int64 // metadata.generation, which the API server increments on the changes of the spec; 0 for the status
+	hash       uint64
+}
+
+func newSectionTracker(section ChangeSection) *sectionTracker {
+	return &sectionTracker{section: section, seen: make(map[string]sectionFingerprint)}
+}
+
+// shouldEmit reports whether an event for the object should be emitted, updating the cache as it goes.
+// ADDED events always emit and seed the cache, DELETED events always emit and evict the object,
+// and MODIFIED events only emit when the section differs from the last one seen.
+func (t *sectionTracker) shouldEmit(eventType watch.EventType, key string, obj runtime.Object) bool {
+	if eventType == watch.Deleted {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.seen, key)
+		return true
+	}
+	current, err := t.fingerprint(obj)
+	if err != nil {
+		return true // emit what cannot be compared
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous, seen := t.seen[key]
+	t.seen[key] = current
+	// An object we have not seen before (e.g. it existed before we started) counts as a change
+	return eventType != watch.Modified || !seen || previous != current
+}
+
+// evict forgets the object
+func (t *sectionTracker) evict(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.seen, key)
+}
+
+// fingerprint hashes the section of the object, along with its generation for the spec
+func (t *sectionTracker) fingerprint(obj runtime.Object) (sectionFingerprint, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return sectionFingerprint{}, err
+	}
+	var fingerprint sectionFingerprint
+	var section interface{}
+	switch t.section {
+	case StatusSection:
+		section = content["status"]
+	default:
+		if objMeta, err := meta.Accessor(obj); err == nil {
+			fingerprint.generation = objMeta.GetGeneration()
+		}
+		section = content["spec"]
+		if section == nil {
+			delete(content, "apiVersion")
+			delete(content, "kind")
+			delete(content, "metadata")
+			delete(content, "status")
+			section = content
+		}
+	}
+	// The keys of the maps are sorted, so equal sections serialize alike
+	data, err := json.Marshal(section)
+	if err != nil {
+		return sectionFingerprint{}, err
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	fingerprint.hash = h.Sum64()
+	return fingerprint, nil
+}
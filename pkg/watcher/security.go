@@ -0,0 +1,194 @@
+package watcher
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Security-relevant settings of a pod reported by the SECURITY events of WithSecurityWatch
+const (
+	SecurityHostNetwork = "hostNetwork" // the pod shares the network namespace of its node
+	SecurityHostPID     = "hostPID"     // the pod shares the process namespace of its node
+	SecurityHostIPC     = "hostIPC"     // the pod shares the IPC namespace of its node
+	SecurityPrivileged  = "privileged"  // the container runs privileged
+	SecurityCapability  = "capability"  // the container adds a Linux capability, named by the Value of the finding
+	SecurityRunAsRoot   = "runAsRoot"   // the container runs as user 0, as set for it or for its pod
+)
+
+// SecurityFinding is a security-relevant setting of a pod, or of one of its containers
+type SecurityFinding struct {
+	Setting   string `json:"setting"`             // SecurityHostNetwork, SecurityPrivileged, ...
+	Container string `json:"container,omitempty"` // the container, of any kind, having it; empty for the settings of the pod
+	Value     string `json:"value,omitempty"`     // the capability of SecurityCapability
+}
+
+// String formats the finding compactly, e.g. "hostNetwork" or "app: capability NET_ADMIN"
+func (f SecurityFinding) String() string {
+	text := f.Setting
+	if f.Value != "" {
+		text += " " + f.Value
+	}
+	if f.Container != "" {
+		text = f.Container + ": " + text
+	}
+	return text
+}
+
+// SecurityChange is the change of the security-relevant settings of a pod between two revisions, carried by
+// SECURITY events. A pod created with such settings has them all Added.
+type SecurityChange struct {
+	Added    []SecurityFinding `json:"added,omitempty"`
+	Removed  []SecurityFinding `json:"removed,omitempty"`
+	Findings []SecurityFinding `json:"findings"` // all the settings of the current revision
+}
+
+// String formats the change compactly, e.g. "added hostNetwork, app: privileged; removed app: capability NET_ADMIN"
+func (c *SecurityChange) String() string {
+	var parts []string
+	if len(c.Added) > 0 {
+		parts = append(parts, "added "+joinFindings(c.Added))
+	}
+	if len(c.Removed) > 0 {
+		parts = append(parts, "removed "+joinFindings(c.Removed))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func joinFindings(findings []SecurityFinding) string {
+	texts := make([]string, len(findings))
+	for i, finding := range findings {
+		texts[i] = finding.String()
+	}
+	return strings.Join(texts, ", ")
+}
+
+// securityFindings lists the security-relevant settings of the pod: those of the pod, then those of its init,
+// regular and ephemeral containers, in their order
+func securityFindings(pod *corev1.Pod) []SecurityFinding {
+	var findings []SecurityFinding
+	for _, setting := range []struct {
+		name string
+		set  bool
+	}{
+		{SecurityHostNetwork, pod.Spec.HostNetwork},
+		{SecurityHostPID, pod.Spec.HostPID},
+		{SecurityHostIPC, pod.Spec.HostIPC},
+	} {
+		if setting.set {
+			findings = append(findings, SecurityFinding{Setting: setting.name})
+		}
+	}
+	var podUser *int64
+	if pod.Spec.SecurityContext != nil {
+		podUser = pod.Spec.SecurityContext.RunAsUser
+	}
+	container := func(name string, sc *corev1.SecurityContext) {
+		user := podUser
+		if sc != nil && sc.RunAsUser != nil {
+			user = sc.RunAsUser
+		}
+		if sc != nil && sc.Privileged != nil && *sc.Privileged {
+			findings = append(findings, SecurityFinding{Setting: SecurityPrivileged, Container: name})
+		}
+		if sc != nil && sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Add {
+				value := strings.TrimPrefix(strings.ToUpper(string(capability)), "CAP_")
+				findings = append(findings, SecurityFinding{Setting: SecurityCapability, Container: name, Value: value})
+			}
+		}
+		if user != nil && *user == 0 {
+			findings = append(findings, SecurityFinding{Setting: SecurityRunAsRoot, Container: name})
+		}
+	}
+	for _, c := range pod.Spec.InitContainers {
+		container(c.Name, c.SecurityContext)
+	}
+	for _, c := range pod.Spec.Containers {
+		container(c.Name, c.SecurityContext)
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		container(c.Name, c.SecurityContext)
+	}
+	return findings
+}
+
+// securityTracker records the security-relevant settings of each pod (--security-watch) to report their changes
+// between revisions
+type securityTracker struct {
+	mu       sync.Mutex
+	findings map[string][]SecurityFinding // pod key, qualified by its cluster -> its settings
+}
+
+func newSecurityTracker() *securityTracker {
+	return &securityTracker{findings: make(map[string][]SecurityFinding)}
+}
+
+// evict forgets the pod
+func (t *securityTracker) evict(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.findings, key)
+}
+
+// update records the security-relevant settings of the pod, returning their change since the previous revision,
+// nil if there is none. A pod from the initial list only sets the baseline, and a deleted one is forgotten.
+func (t *securityTracker) update(eventType string, key string, pod *corev1.Pod, baseline bool) *SecurityChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if eventType == string(watch.Deleted) {
+		delete(t.findings, key)
+		return nil
+	}
+	previous := t.findings[key]
+	current := securityFindings(pod)
+	t.findings[key] = current
+	if baseline {
+		return nil
+	}
+	change := &SecurityChange{Added: subtractFindings(current, previous), Removed: subtractFindings(previous, current), Findings: current}
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return nil
+	}
+	return change
+}
+
+// subtractFindings returns the findings of a missing from b, in their order
+func subtractFindings(a, b []SecurityFinding) []SecurityFinding {
+	var missing []SecurityFinding
+	for _, finding := range a {
+		found := false
+		for _, other := range b {
+			if finding == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, finding)
+		}
+	}
+	return missing
+}
+
+// emitSecurityChange emits a SECURITY event for the change of the security-relevant settings of the pod, counting
+// the settings that appeared even when the type of the event is filtered out
+func (p *eventProcessor) emitSecurityChange(m *matchedObject, pod *corev1.Pod, change *SecurityChange) {
+	if change == nil {
+		return
+	}
+	for _, finding := range change.Added {
+		securityFindingsAdded.WithLabelValues(finding.Setting).Inc()
+	}
+	if p.eventTypes != nil && !p.eventTypes[SecurityEvent] {
+		return
+	}
+	event := Event{Type: SecurityEvent, Key: m.key, Object: pod, Timestamp: time.Now().UTC(), Cluster: m.cluster, Security: change}
+	p.sinks.write(event)
+	p.publish(event)
+	eventsEmitted.WithLabelValues(SecurityEvent).Inc()
+	p.health.eventEmitted()
+}
@@ -0,0 +1,161 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// WithShards splits the watch of each namespace into up to n watches, each with an informer of its own, by the values
+// of the label labelKey: at startup, the values found on the objects are spread over n-1 shards, each watching
+// the objects with its values, balanced by their number of objects, and the last shard watches every other object,
+// including those without the label or with a value that appeared since. The events of the shards are processed
+// concurrently by the workers, those of one object in order.
+//
+// The label should not change over the life of an object: an object moving to another shard is reported as
+// deleted by the one and added by the other, in no particular order.
+func WithShards(n int, labelKey string) Option {
+	return func(w *Watcher) { w.shards, w.shardLabel = n, labelKey }
+}
+
+// watchShard is one of the watches of a namespace split by WithShards; nil for a namespace that is not split
+type watchShard struct {
+	index    int
+	count    int
+	selector string          // the label selector of the shard, combined with that of the cluster
+	listed   *sync.WaitGroup // done once every shard of the namespace has delivered its initial list
+}
+
+// String names the shard, e.g. "2/4"; empty for a namespace that is not split
+func (s *watchShard) String() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.index+1, s.count)
+}
+
+// labelSelector returns the selector of the shard, combined with the given one
+func (s *watchShard) labelSelector(selector string) string {
+	switch {
+	case s == nil || s.selector == "":
+		return selector
+	case selector == "":
+		return s.selector
+	}
+	return selector + "," + s.selector
+}
+
+// synced records that the shard has delivered its initial list, waiting for the other shards of the namespace to
+// deliver theirs. It reports whether the caller resumes the deletions of the namespace from the checkpoint, which
+// only the last shard does, as the objects of the namespace are only all known once every shard has listed them.
+func (s *watchShard) synced() bool {
+	if s == nil {
+		return true
+	}
+	s.listed.Done()
+	s.listed.Wait()
+	return s.index == s.count-1
+}
+
+// validateShards checks the options of WithShards
+func (w *Watcher) validateShards() error {
+	if w.shards < 0 {
+		return fmt.Errorf("WithShards: must not be negative")
+	}
+	if w.shards > 1 {
+		if errs := validation.IsQualifiedName(w.shardLabel); len(errs) > 0 {
+			return fmt.Errorf("WithShards: invalid label %q: %s", w.shardLabel, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// planShards splits the watched namespaces of the cluster into the shards of WithShards, listing the values of
+// the shard label in each; without shards, each namespace has a single nil shard
+func (w *Watcher) planShards(ctx context.Context, c *cluster) error {
+	c.shards = make(map[string][]*watchShard)
+	for _, namespace := range c.watched {
+		if w.shards <= 1 {
+			c.shards[namespace] = []*watchShard{nil}
+			continue
+		}
+		shards, err := w.splitNamespace(ctx, c, namespace)
+		if err != nil {
+			return err
+		}
+		c.shards[namespace] = shards
+	}
+	return nil
+}
+
+// splitNamespace lists the objects of the namespace to spread the values of the shard label over the shards
+func (w *Watcher) splitNamespace(ctx context.Context, c *cluster, namespace string) ([]*watchShard, error) {
+	// Listing at resourceVersion 0 is answered from the API server's watch cache
+	options := metav1.ListOptions{ResourceVersion: "0"}
+	w.applySelectors(c, nil, &options)
+	list, err := c.client.List(ctx, namespace, options)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the values of the shard label in namespace %s: %w", namespaceList([]string{namespace}), err)
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, item := range items {
+		if objMeta, err := meta.Accessor(item); err == nil {
+			if value := objMeta.GetLabels()[w.shardLabel]; value != "" {
+				counts[value]++
+			}
+		}
+	}
+	// The most common values first, each to the shard with the fewest objects so far
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	slices.SortFunc(values, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	split := make([][]string, min(w.shards-1, len(values)))
+	sizes := make([]int, len(split))
+	for _, value := range values {
+		smallest := 0
+		for i := range sizes {
+			if sizes[i] < sizes[smallest] {
+				smallest = i
+			}
+		}
+		split[smallest] = append(split[smallest], value)
+		sizes[smallest] += counts[value]
+	}
+	listed := &sync.WaitGroup{}
+	listed.Add(len(split) + 1)
+	shards := make([]*watchShard, 0, len(split)+1)
+	for i, shardValues := range split {
+		slices.Sort(shardValues)
+		shards = append(shards, &watchShard{index: i, count: len(split) + 1, listed: listed,
+			selector: fmt.Sprintf("%s in (%s)", w.shardLabel, strings.Join(shardValues, ","))})
+	}
+	// The last shard takes every other object, without the label, with an empty value or one unknown at startup,
+	// or every object when none has the label
+	rest := &watchShard{index: len(split), count: len(split) + 1, listed: listed}
+	if len(values) > 0 {
+		slices.Sort(values)
+		rest.selector = fmt.Sprintf("%s notin (%s)", w.shardLabel, strings.Join(values, ","))
+	}
+	shards = append(shards, rest)
+	slog.Info("Split the watch into shards", "cluster", c.name, "namespace", namespaceList([]string{namespace}),
+		"label", w.shardLabel, "shards", len(shards), "objects", len(items), "labeled", sizes)
+	return shards, nil
+}
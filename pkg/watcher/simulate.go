@@ -0,0 +1,190 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// ReadObjects reads the objects of a stream of YAML documents or JSON objects, such as manifests or the output of
+// kubectl get -o yaml. The items of List objects are read as objects of their own. Pods are read as *corev1.Pod
+// without their kind and apiVersion, as the informers deliver them; any other object as *unstructured.Unstructured.
+func ReadObjects(r io.Reader) ([]runtime.Object, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	var objects []runtime.Object
+	for n := 1; ; n++ {
+		var document json.RawMessage
+		if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+			return objects, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read document %d: %w", n, err)
+		}
+		if len(document) == 0 || string(document) == "null" {
+			continue // an empty document
+		}
+		var list struct {
+			Kind  string            `json:"kind"`
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(document, &list); err != nil {
+			return nil, fmt.Errorf("could not read document %d: %w", n, err)
+		}
+		items := []json.RawMessage{document}
+		if strings.HasSuffix(list.Kind, "List") && list.Items != nil {
+			items = list.Items
+		}
+		for _, item := range items {
+			obj, err := decodeYAMLObject("", item) // JSON is YAML
+			if err != nil {
+				return nil, fmt.Errorf("could not decode an object of document %d: %w", n, err)
+			}
+			if objMeta, err := meta.Accessor(obj); err != nil || objMeta.GetName() == "" {
+				return nil, fmt.Errorf("an object of document %d has no name", n)
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				pod.TypeMeta = metav1.TypeMeta{}
+			}
+			objects = append(objects, obj)
+		}
+	}
+}
+
+// Simulate feeds local objects, such as those of ReadObjects, through the filters and sinks configured by the options
+// and the exec hook as synthetic events, without a cluster, to test markers, CEL filters, exclusions, templates and sinks.
+// The first occurrence of an object is an ADDED event, and every later one a MODIFIED event, in order.
+// The namespace and label selectors are applied to the objects, as the API server would; the field selector is not.
+// Options that need a cluster, such as WithLogTail, WithOwnerResolution or WithCheckpoint, are ignored.
+// With WithWaitFor, an *ExitError is returned unless an object met the condition.
+func Simulate(ctx context.Context, objects []runtime.Object, options ...Option) error {
+	w := &Watcher{health: newHealthState(), drainTimeout: DefaultDrainTimeout}
+	for _, option := range options {
+		option(w)
+	}
+	if err := w.compileFilters(); err != nil {
+		return err
+	}
+	if w.closePlugin != nil {
+		defer w.closePlugin()
+	}
+	if err := w.compileProfiles(); err != nil {
+		return err
+	}
+	selector, err := labels.Parse(joinSelectors(w.labelSelector, w.watchLabel))
+	if err != nil {
+		return fmt.Errorf("invalid label selector: %w", err)
+	}
+	namespaces := make(map[string]bool)
+	for _, namespace := range uniqueNamespaces(w.namespaces) {
+		namespaces[namespace] = true
+	}
+	if err := w.openSinks(); err != nil {
+		return err
+	}
+	defer w.bindSinks(ctx)()
+	sinks := newFanOut(w.sinks)
+	defer sinks.close()
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	processor := &eventProcessor{
+		watchCtx:   ctx,
+		sinks:      sinks,
+		strip:      w.strip,
+		redact:     w.redact,
+		filter:     w.filter,
+		cel:        w.cel,
+		status:     w.status,
+		plugin:     w.filterFunc,
+		optIn:      w.optIn,
+		exclude:    w.exclude,
+		condition:  w.condition,
+		maxEvents:  int64(w.maxEvents),
+		stop:       stop,
+		eventTypes: w.emitted,
+		health:     w.health,
+		tracked:    newTrackedObjects(w.maxTracked),
+	}
+	if w.stopOnDelete {
+		processor.target = &podTarget{}
+	}
+	if w.onImageChange {
+		processor.images = newImageTracker()
+	}
+	if w.changeSection != "" {
+		processor.sections = newSectionTracker(w.changeSection)
+	}
+	if w.trackContainers {
+		processor.containers = newContainerTracker()
+	}
+	if w.trackInit {
+		processor.inits = newInitTracker()
+	}
+	if w.trackImages {
+		processor.imageChanges = newImageChangeTracker()
+	}
+	if w.securityWatch {
+		processor.security = newSecurityTracker()
+	}
+	if w.auditFields {
+		processor.audit = newManagedFieldsTracker()
+	}
+	if w.timeline {
+		processor.timelines = newTimelineTracker()
+	}
+	if w.alerts != nil {
+		processor.alerts = newAlertDetector(*w.alerts)
+	}
+	if w.terminations != nil {
+		// The simulated events are not spread over time, so the pods are never stuck terminating
+		processor.terminations = newTerminationTracker(TerminationOptions{}, processor.emitAlerts)
+	}
+	if w.execCommand != "" {
+		processor.hook = newExecHook(w.execCommand, w.execConcurrency, w.execTimeout)
+		defer processor.hook.wait()
+	}
+	if w.minInterval > 0 || w.dedupe {
+		processor.throttle = newEventThrottle(w.minInterval, w.dedupe, processor.emit)
+		defer processor.throttle.flush()
+	}
+
+	slog.Info("Simulating events", "objects", len(objects), "markers", w.filter.String(), "status", w.status.String(), "exclude", w.exclude.String())
+	seen := make(map[string]bool)
+	for _, obj := range objects {
+		if ctx.Err() != nil {
+			break // canceled, or a stop condition such as --max-events was reached
+		}
+		objMeta, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		if len(namespaces) > 0 && !namespaces[metav1.NamespaceAll] && !namespaces[objMeta.GetNamespace()] {
+			continue
+		}
+		if !selector.Matches(labels.Set(objMeta.GetLabels())) {
+			continue
+		}
+		eventType := string(watch.Added)
+		if key := objectKey(objMeta); seen[key] {
+			eventType = string(watch.Modified)
+		} else {
+			seen[key] = true
+		}
+		processor.handle(ctx, "", eventType, obj)
+	}
+	if processor.condition != nil && !processor.satisfied.Load() {
+		return &ExitError{Code: 1, Message: fmt.Sprintf("No object met condition %s.", processor.condition)}
+	}
+	return nil
+}
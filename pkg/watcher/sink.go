@@ -0,0 +1,187 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sink receives the events emitted by a Watcher.
+// Each sink is written from a goroutine of its own, so Write is never called concurrently for one sink,
+// and a sink that fails or falls behind does not affect the others. The Watcher closes its sinks when Run returns.
+type Sink interface {
+	Write(event Event) error
+	Close() error
+}
+
+// DefaultDrainTimeout bounds the delivery of the queued events on shutdown unless WithDrainTimeout is given
+const DefaultDrainTimeout = 20 * time.Second
+
+// sinkQueueSize is the number of events buffered for each sink before the watcher waits for it to catch up
+const sinkQueueSize = 1024
+
+// bindable is implemented by sinks whose deliveries are aborted once the context given to Run is canceled
+type bindable interface {
+	bind(ctx context.Context)
+}
+
+// NewWriterSink returns a sink writing the events to w in the given format: yaml, json, jsonl, diff, table, wide,
+// go-template=TEMPLATE, or jsonpath=TEMPLATE, where the templates render the JSON envelopes of the events
+func NewWriterSink(w io.Writer, format string) (Sink, error) {
+	return newEventWriter(w, format)
+}
+
+// NewFileSink returns a sink writing the events to the file at path in the given format,
+// gzip-compressed when compress is true or the path ends in ".gz", and rotated as configured.
+// The file is created, or truncated unless appending, immediately.
+func NewFileSink(path string, format string, compress bool, rotation FileRotation) (Sink, error) {
+	return openOutputFile(path, compress, format, rotation)
+}
+
+// NewWebhookSink returns a sink POSTing each event as a JSON envelope to a webhook, or with a BatchSize greater than 1,
+// POSTing JSON arrays of envelopes. A batch that could not be delivered is retried with the next, keeping up to
+// 10 batches of events before dropping the oldest; the pending events are delivered when the sink is closed.
+func NewWebhookSink(options WebhookOptions) (Sink, error) {
+	sink, err := newWebhookSink(options.URL, options.Headers, options.Timeout, options.Retries, options.Backoff, options.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if options.CloudEvents {
+		sink.cloudEvents, sink.contentType = true, cloudEventsContentType
+	}
+	if options.BatchSize <= 1 {
+		return sink, nil
+	}
+	if options.CloudEvents {
+		sink.contentType = cloudEventsBatchContentType
+	}
+	return newBatchedWebhookSink(sink, options.BatchSize, options.BatchInterval)
+}
+
+// sinkName identifies a sink in logs and metrics
+func sinkName(sink Sink) string {
+	if s, ok := sink.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", sink)
+}
+
+// fanOut delivers every event to each sink through a queue of its own.
+// Write errors are logged and counted per sink without affecting the delivery to the other sinks.
+type fanOut struct {
+	sinks   []Sink
+	queues  []chan Event
+	workers sync.WaitGroup
+	summary *summaryRecorder // counts the events written; nil unless the run is summarized
+	router  *router          // of the routed sinks; nil without any
+	source  string           // of the CloudEvents of the events of an unnamed cluster
+
+	draining atomic.Bool  // whether close has been called
+	flushed  atomic.Int64 // events delivered while draining
+	dropped  atomic.Int64 // events that failed to be delivered while draining
+}
+
+func newFanOut(sinks []Sink) *fanOut {
+	f := &fanOut{sinks: sinks}
+	for _, sink := range sinks {
+		if routed, ok := sink.(*routedSink); ok {
+			f.router = routed.router
+		}
+		queue := make(chan Event, sinkQueueSize)
+		f.queues = append(f.queues, queue)
+		f.workers.Add(1)
+		go f.deliver(sink, queue)
+	}
+	return f
+}
+
+// deliver writes the queued events to the sink until the queue is closed
+func (f *fanOut) deliver(sink Sink, queue <-chan Event) {
+	defer f.workers.Done()
+	name := sinkName(sink)
+	for event := range queue {
+		if event.Type == forgetEvent {
+			if state, ok := unwrapSink(sink).(objectState); ok {
+				state.evict(clusterKey(event.Cluster, event.Key))
+			}
+			continue
+		}
+		ctx, span := startSpan(trace.ContextWithSpanContext(context.Background(), event.trace), "sink.write",
+			attribute.String("sink", name))
+		event.trace = trace.SpanContextFromContext(ctx)
+		err := sink.Write(event)
+		endSpan(span, err)
+		if err != nil {
+			slog.Error("Sink failed to write event", "sink", name, "type", event.Type, "key", event.Key, "error", err)
+			sinkErrors.WithLabelValues(name).Inc()
+		}
+		if f.draining.Load() {
+			if err != nil {
+				f.dropped.Add(1)
+			} else {
+				f.flushed.Add(1)
+			}
+		}
+	}
+}
+
+// write queues the event for every sink it is routed to, or whose profile accepts it, waiting only while a sink's queue is full
+func (f *fanOut) write(event Event) {
+	event.source = f.source
+	if f.summary != nil {
+		f.summary.emitted(event)
+	}
+	var routes []string
+	if f.router != nil {
+		routes = f.router.route(event)
+	}
+	var accepted map[*profile]bool // by the profiles of the sinks, each deciding once
+	for i, queue := range f.queues {
+		switch sink := f.sinks[i].(type) {
+		case *routedSink:
+			if !sink.accepts(routes) {
+				continue
+			}
+		case *profileSink:
+			ok, decided := accepted[sink.profile]
+			if !decided {
+				if accepted == nil {
+					accepted = make(map[*profile]bool)
+				}
+				ok = sink.profile.accepts(event)
+				accepted[sink.profile] = ok
+			}
+			if !ok {
+				continue
+			}
+		}
+		queue <- event
+	}
+}
+
+// close delivers the events still queued, reporting how many were flushed or dropped, and then closes every sink
+func (f *fanOut) close() {
+	start := time.Now()
+	f.draining.Store(true)
+	for _, queue := range f.queues {
+		close(queue)
+	}
+	f.workers.Wait()
+	if flushed, dropped := f.flushed.Load(), f.dropped.Load(); dropped > 0 {
+		slog.Warn("Drained the sinks, dropping some events", "flushed", flushed, "dropped", dropped, "duration", time.Since(start))
+	} else if flushed > 0 {
+		slog.Info("Drained the sinks", "flushed", flushed, "duration", time.Since(start))
+	}
+	for _, sink := range f.sinks {
+		if err := sink.Close(); err != nil {
+			slog.Error("Failed to close sink", "sink", sinkName(sink), "error", err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package watcher
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// recordingSink records the keys of the events written to it, waiting on gate before each write
+type recordingSink struct {
+	gate chan struct{} // nil never waits
+
+	mu      sync.Mutex
+	written []string
+	evicted []string
+	closed  bool
+}
+
+func (s *recordingSink) Write(event Event) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, event.Key)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// snapshot returns what the sink recorded so far
+func (s *recordingSink) snapshot() (written, evicted []string, closed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.written...), append([]string(nil), s.evicted...), s.closed
+}
+
+// statefulSink is a recordingSink keeping state per object, which records the objects it evicted
+type statefulSink struct {
+	recordingSink
+}
+
+func (s *statefulSink) evict(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evicted = append(s.evicted, id)
+}
+
+func TestFanOutBlocksWhileQueueFull(t *testing.T) {
+	sink := &recordingSink{gate: make(chan struct{})}
+	f := newFanOut([]Sink{sink})
+
+	// The sink holds one event while its queue fills up, after which the next write waits for it
+	for range sinkQueueSize + 1 {
+		f.write(testPodEvent("ADDED", "web-1", corev1.PodPending))
+	}
+	written := make(chan struct{})
+	go func() {
+		f.write(testPodEvent("MODIFIED", "web-1", corev1.PodRunning))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("the write did not wait for the full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(sink.gate)
+	select {
+	case <-written:
+	case <-time.After(eventTimeout):
+		t.Fatal("the write still waits once the sink caught up")
+	}
+	f.close()
+	if written, _, _ := sink.snapshot(); len(written) != sinkQueueSize+2 {
+		t.Errorf("the sink got %d events, want %d", len(written), sinkQueueSize+2)
+	}
+}
+
+func TestFanOutDrainsOnClose(t *testing.T) {
+	sink := &recordingSink{gate: make(chan struct{})}
+	f := newFanOut([]Sink{sink})
+	for _, name := range []string{"web-1", "web-2", "web-3"} {
+		f.write(testPodEvent("ADDED", name, corev1.PodPending))
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(sink.gate)
+	}()
+	f.close()
+	written, _, closed := sink.snapshot()
+	if len(written) != 3 || written[0] != "default/web-1" || written[2] != "default/web-3" {
+		t.Errorf("close delivered %v, want the 3 queued events in order", written)
+	}
+	if !closed {
+		t.Error("the sink was not closed")
+	}
+	if flushed := f.flushed.Load(); flushed != 3 {
+		t.Errorf("counted %d events flushed while draining, want 3", flushed)
+	}
+}
+
+func TestFanOutEvict(t *testing.T) {
+	stateful := &statefulSink{}
+	stateless := &recordingSink{}
+	f := newFanOut([]Sink{stateful, stateless})
+	f.write(testPodEvent("DELETED", "web-1", corev1.PodSucceeded))
+	f.evict("prod", "default/web-1")
+	// A forget event reaching a sink without state is skipped
+	f.queues[1] <- Event{Type: forgetEvent, Key: "default/web-1", Cluster: "prod"}
+	f.close()
+
+	// The state is dropped behind the events written before, and the forget events are never written
+	written, evicted, _ := stateful.snapshot()
+	if len(written) != 1 || len(evicted) != 1 || evicted[0] != clusterKey("prod", "default/web-1") {
+		t.Errorf("the stateful sink got %v and evicted %v", written, evicted)
+	}
+	if written, _, _ := stateless.snapshot(); len(written) != 1 {
+		t.Errorf("the stateless sink got %v, want the pod event only", written)
+	}
+}
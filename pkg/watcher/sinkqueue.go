@@ -0,0 +1,410 @@
+package watcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SinkQueueOptions configures the disk-backed retry queues of WithSinkQueue
+type SinkQueueOptions struct {
+	Dir string // holds a directory of its own for the queue of each sink
+	// Retention drops the queued events older than this without delivering them; 0 keeps them until delivered
+	Retention time.Duration
+	// MaxSize drops the oldest queued events of a sink once its queue holds more than this many bytes; 0 for no limit
+	MaxSize int64
+	// Backoff is the delay before the first redelivery attempt once a delivery failed, doubling after each failed
+	// attempt up to a minute (DefaultSinkQueueBackoff otherwise)
+	Backoff time.Duration
+}
+
+// DefaultSinkQueueBackoff is the delay before the first redelivery of the queued events unless SinkQueueOptions.Backoff is given
+const DefaultSinkQueueBackoff = 5 * time.Second
+
+// sinkQueueMaxBackoff bounds the delay between two redelivery attempts
+const sinkQueueMaxBackoff = time.Minute
+
+// WithSinkQueue persists the events the webhooks and Kafka fail to deliver in a queue on disk, one per sink under the
+// directory of the options, and redelivers them in order once the sink recovers, also after a restart, so that each
+// event is delivered at least once within the retention and size of the queue. While a sink has queued events, its new
+// events are queued behind them. Batched webhooks are not supported, as they keep the failed batches of their own.
+func WithSinkQueue(options SinkQueueOptions) Option {
+	return func(w *Watcher) { w.sinkQueue = &options }
+}
+
+// queueableSink is implemented by the sinks whose deliveries WithSinkQueue can persist and retry
+type queueableSink interface {
+	Sink
+	// queueID identifies the destination of the sink, naming its queue so that a restarted watcher finds it again
+	queueID() string
+	// encodeEvent serializes the event as delivered
+	encodeEvent(event Event) (queuedEvent, error)
+	// deliverQueued delivers a serialized event, reporting whether it was accepted
+	deliverQueued(event queuedEvent) error
+}
+
+// queuedEvent is an event as persisted by a sink queue
+type queuedEvent struct {
+	Time time.Time       `json:"time"`          // when the event was emitted
+	Key  string          `json:"key,omitempty"` // of the Kafka message
+	Body json.RawMessage `json:"body"`          // the JSON envelope of the event
+}
+
+// queueSink wraps the sink in a disk-backed retry queue if it supports one, passing the sinks of routes and profiles
+// through to the sink they deliver to
+func (w *Watcher) queueSink(sink Sink) (Sink, error) {
+	switch s := sink.(type) {
+	case *routedSink:
+		inner, err := w.queueSink(s.Sink)
+		s.Sink = inner
+		return s, err
+	case *profileSink:
+		inner, err := w.queueSink(s.Sink)
+		s.Sink = inner
+		return s, err
+	case *batchedWebhookSink, *batchedKafkaSink:
+		return sink, fmt.Errorf("--sink-queue-dir does not support batched webhooks and Kafka (--webhook-batch-size), which keep the failed batches of their own")
+	case queueableSink:
+		queued, err := newQueuedSink(s, *w.sinkQueue)
+		if err != nil {
+			return sink, err
+		}
+		return queued, nil
+	}
+	return sink, nil
+}
+
+// queuedSink delivers the events of a sink through its disk-backed retry queue: an event is delivered at once while
+// nothing is queued, and otherwise queued behind the others, which are redelivered in order with a backoff
+type queuedSink struct {
+	queueableSink
+	queue   *diskQueue
+	backoff time.Duration
+
+	sending sync.Mutex // serializes the deliveries, keeping the events in order
+	wake    chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newQueuedSink(sink queueableSink, options SinkQueueOptions) (*queuedSink, error) {
+	name := sinkName(sink)
+	sum := sha256.Sum256([]byte(sink.queueID()))
+	dir := filepath.Join(options.Dir, queueDirName(name)+"-"+hex.EncodeToString(sum[:4]))
+	queue, err := openDiskQueue(dir, name, options.Retention, options.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the queue of %s: %w", name, err)
+	}
+	backoff := options.Backoff
+	if backoff <= 0 {
+		backoff = DefaultSinkQueueBackoff
+	}
+	s := &queuedSink{queueableSink: sink, queue: queue, backoff: backoff,
+		wake: make(chan struct{}, 1), stop: make(chan struct{}), stopped: make(chan struct{})}
+	if n := queue.len(); n > 0 {
+		slog.Info("Redelivering the events queued by a previous run", "sink", name, "events", n, "queue", dir)
+	}
+	go s.run()
+	return s, nil
+}
+
+// unsafeQueueDirChars are the characters of the sink names replaced in the names of their queue directories
+var unsafeQueueDirChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// queueDirName makes a sink name safe as a directory name, e.g. "webhook:hooks.example.com" -> "webhook_hooks.example.com"
+func queueDirName(name string) string {
+	return unsafeQueueDirChars.ReplaceAllString(name, "_")
+}
+
+// Write delivers the event, or queues it when that fails or other events are queued already
+func (s *queuedSink) Write(event Event) error {
+	queued, err := s.encodeEvent(event)
+	if err != nil {
+		return err
+	}
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	if s.queue.len() == 0 {
+		err := s.deliverQueued(queued)
+		if err == nil {
+			return nil
+		}
+		slog.Warn("Sink failed to deliver an event, queueing it for redelivery", "sink", sinkName(s), "type", event.Type, "key", event.Key, "error", err)
+	}
+	if err := s.queue.push(queued); err != nil {
+		return fmt.Errorf("could not queue the event for redelivery: %w", err)
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run redelivers the queued events until the sink is closed, backing off while the sink keeps failing
+func (s *queuedSink) run() {
+	defer close(s.stopped)
+	backoff := s.backoff
+	var retry <-chan time.Time
+	if s.queue.len() > 0 {
+		retry = time.After(0)
+	}
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+			if retry != nil {
+				continue // a redelivery is already scheduled
+			}
+			retry = time.After(backoff)
+			continue
+		case <-retry:
+		}
+		retry = nil
+		if err := s.redeliver(); err != nil {
+			slog.Warn("Sink is still failing, keeping its events queued", "sink", sinkName(s), "queued", s.queue.len(), "retryIn", backoff, "error", err)
+			retry = time.After(backoff)
+			backoff = min(backoff*2, sinkQueueMaxBackoff)
+			continue
+		}
+		backoff = s.backoff
+	}
+}
+
+// redeliver delivers the queued events in order, stopping at the first that fails
+func (s *queuedSink) redeliver() error {
+	delivered := 0
+	defer func() {
+		if delivered > 0 {
+			slog.Info("Redelivered queued events", "sink", sinkName(s), "events", delivered, "queued", s.queue.len())
+		}
+	}()
+	for {
+		select {
+		case <-s.stop:
+			return nil
+		default:
+		}
+		s.sending.Lock()
+		queued, ok, err := s.queue.peek()
+		if err == nil && ok {
+			if err = s.deliverQueued(queued); err == nil {
+				err = s.queue.pop()
+				delivered++
+				sinkQueueRedelivered.WithLabelValues(sinkName(s)).Inc()
+			}
+		}
+		s.sending.Unlock()
+		if err != nil || !ok {
+			return err
+		}
+	}
+}
+
+// bind aborts the deliveries in progress once the context is canceled
+func (s *queuedSink) bind(ctx context.Context) {
+	if sink, ok := s.queueableSink.(bindable); ok {
+		sink.bind(ctx)
+	}
+}
+
+// Close stops the redeliveries, leaving the events still queued for the next run, and closes the sink
+func (s *queuedSink) Close() error {
+	close(s.stop)
+	<-s.stopped
+	if n := s.queue.len(); n > 0 {
+		slog.Warn("Leaving undelivered events queued for the next run", "sink", sinkName(s), "events", n, "queue", s.queue.dir)
+	}
+	return s.queueableSink.Close()
+}
+
+// String names the sink for logs and metrics
+func (s *queuedSink) String() string {
+	return sinkName(s.queueableSink)
+}
+
+// diskQueue is a queue of events persisted as a file per event, named after its position in the queue, in a directory
+type diskQueue struct {
+	dir       string
+	sink      string // the name of the sink, for logs and metrics
+	retention time.Duration
+	maxSize   int64
+
+	mu      sync.Mutex
+	entries []queueEntry // in order
+	size    int64        // of the entries
+	next    uint64       // the position of the next event
+}
+
+// queueEntry is a persisted event of a diskQueue
+type queueEntry struct {
+	seq  uint64
+	size int64
+	time time.Time // when it was queued
+}
+
+// openDiskQueue opens the queue in dir, creating it if needed, with the events persisted by previous runs
+func openDiskQueue(dir string, sink string, retention time.Duration, maxSize int64) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &diskQueue{dir: dir, sink: sink, retention: retention, maxSize: maxSize}
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok || file.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		q.entries = append(q.entries, queueEntry{seq: seq, size: info.Size(), time: info.ModTime()})
+		q.size += info.Size()
+	}
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].seq < q.entries[j].seq })
+	if len(q.entries) > 0 {
+		q.next = q.entries[len(q.entries)-1].seq + 1
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.trim(time.Now())
+	return q, nil
+}
+
+// path is the file of the event at the position
+func (q *diskQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+// len is the number of events queued
+func (q *diskQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// push persists the event at the end of the queue, dropping the oldest events beyond the maximum size
+func (q *diskQueue) push(event queuedEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		marshalErrors.Inc()
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	seq := q.next
+	tmp, err := os.CreateTemp(q.dir, ".queued-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), q.path(seq)); err != nil {
+		return err
+	}
+	q.next++
+	q.entries = append(q.entries, queueEntry{seq: seq, size: int64(len(data)), time: time.Now()})
+	q.size += int64(len(data))
+	q.trim(time.Now())
+	return nil
+}
+
+// peek reads the oldest event, if any, dropping the expired and unreadable ones
+func (q *diskQueue) peek() (queuedEvent, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.trim(time.Now())
+	for len(q.entries) > 0 {
+		var event queuedEvent
+		data, err := os.ReadFile(q.path(q.entries[0].seq))
+		if err == nil {
+			if err = json.Unmarshal(data, &event); err == nil {
+				return event, true, nil
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return event, false, err
+		}
+		slog.Error("Dropping an unreadable queued event", "sink", q.sink, "file", q.path(q.entries[0].seq), "error", err)
+		q.drop(1, "unreadable")
+	}
+	return queuedEvent{}, false, nil
+}
+
+// pop removes the oldest event once delivered
+func (q *diskQueue) pop() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return nil
+	}
+	if err := os.Remove(q.path(q.entries[0].seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	q.size -= q.entries[0].size
+	q.entries = q.entries[1:]
+	sinkQueueEvents.WithLabelValues(q.sink).Set(float64(len(q.entries)))
+	return nil
+}
+
+// trim drops the events older than the retention and the oldest beyond the maximum size; q.mu must be held
+func (q *diskQueue) trim(now time.Time) {
+	if q.retention > 0 {
+		expired := 0
+		for expired < len(q.entries) && now.Sub(q.entries[expired].time) > q.retention {
+			expired++
+		}
+		if expired > 0 {
+			slog.Warn("Dropping queued events older than the retention", "sink", q.sink, "dropped", expired, "retention", q.retention)
+			q.drop(expired, "expired")
+		}
+	}
+	if q.maxSize > 0 && q.size > q.maxSize {
+		over, size := 0, q.size
+		for over < len(q.entries) && size > q.maxSize {
+			size -= q.entries[over].size
+			over++
+		}
+		slog.Warn("Sink queue is full, dropping the oldest events", "sink", q.sink, "dropped", over, "maxSize", q.maxSize)
+		q.drop(over, "size")
+	}
+	sinkQueueEvents.WithLabelValues(q.sink).Set(float64(len(q.entries)))
+}
+
+// drop removes the n oldest events without delivering them; q.mu must be held
+func (q *diskQueue) drop(n int, reason string) {
+	for _, entry := range q.entries[:n] {
+		if err := os.Remove(q.path(entry.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Could not remove a queued event", "sink", q.sink, "file", q.path(entry.seq), "error", err)
+		}
+		q.size -= entry.size
+	}
+	q.entries = q.entries[n:]
+	sinkQueueDropped.WithLabelValues(q.sink, reason).Add(float64(n))
+}
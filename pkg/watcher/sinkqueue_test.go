@@ -0,0 +1,220 @@
+package watcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// queuedBody is the i-th event of the tests, all of the same size up to 10
+func queuedBody(i int) queuedEvent {
+	return queuedEvent{Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Body: json.RawMessage(fmt.Sprintf(`{"n":%d}`, i))}
+}
+
+// pushAll pushes the events with the bodies 0 to n-1
+func pushAll(t *testing.T, q *diskQueue, n int) {
+	t.Helper()
+	for i := range n {
+		if err := q.push(queuedBody(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// expectHead fails unless the oldest event of the queue has the body
+func expectHead(t *testing.T, q *diskQueue, want string) {
+	t.Helper()
+	event, ok, err := q.peek()
+	if err != nil || !ok {
+		t.Fatalf("peek: %v, %v", ok, err)
+	}
+	if string(event.Body) != want {
+		t.Errorf("the oldest event is %s, want %s", event.Body, want)
+	}
+}
+
+func TestDiskQueueOrder(t *testing.T) {
+	dir := t.TempDir()
+	q, err := openDiskQueue(dir, "test", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushAll(t, q, 3)
+
+	// A queue reopened by the next run resumes where it was, and pushes behind the events it holds
+	if q, err = openDiskQueue(dir, "test", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := q.len(); n != 3 {
+		t.Fatalf("the reopened queue holds %d events, want 3", n)
+	}
+	if err := q.push(queuedBody(3)); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 4 {
+		expectHead(t, q, fmt.Sprintf(`{"n":%d}`, i))
+		if err := q.pop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, _ := q.peek(); ok {
+		t.Error("the queue is not empty once every event was popped")
+	}
+}
+
+func TestDiskQueueMaxSize(t *testing.T) {
+	q, err := openDiskQueue(t.TempDir(), "test", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushAll(t, q, 1)
+	// Room for two events: the oldest are dropped beyond them
+	q.maxSize = 2 * q.size
+	pushAll(t, q, 4)
+	if n := q.len(); n != 2 {
+		t.Fatalf("the queue holds %d events, want 2", n)
+	}
+	expectHead(t, q, `{"n":2}`)
+	if files, _ := filepath.Glob(filepath.Join(q.dir, "*.json")); len(files) != 2 {
+		t.Errorf("the queue directory holds %d events, want 2", len(files))
+	}
+}
+
+func TestDiskQueueRetention(t *testing.T) {
+	q, err := openDiskQueue(t.TempDir(), "test", 50*time.Millisecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushAll(t, q, 2)
+	time.Sleep(100 * time.Millisecond)
+	if err := q.push(queuedBody(2)); err != nil {
+		t.Fatal(err)
+	}
+	if n := q.len(); n != 1 {
+		t.Fatalf("the queue holds %d events, want the one within the retention", n)
+	}
+	expectHead(t, q, `{"n":2}`)
+}
+
+func TestDiskQueueUnreadable(t *testing.T) {
+	q, err := openDiskQueue(t.TempDir(), "test", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushAll(t, q, 2)
+	if err := os.WriteFile(q.path(q.entries[0].seq), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expectHead(t, q, `{"n":1}`)
+}
+
+// flakySink is a queueableSink failing its deliveries until it is healed, recording those it accepted
+type flakySink struct {
+	dir string // identifies the queue
+
+	mu        sync.Mutex
+	healthy   bool
+	delivered []string
+}
+
+var errUnavailable = errors.New("unavailable")
+
+func (s *flakySink) Write(event Event) error { return errors.New("written without the queue") }
+func (s *flakySink) Close() error            { return nil }
+func (s *flakySink) String() string          { return "flaky" }
+func (s *flakySink) queueID() string         { return s.dir }
+
+func (s *flakySink) encodeEvent(event Event) (queuedEvent, error) {
+	return queuedEvent{Time: event.Timestamp, Body: json.RawMessage(`"` + event.Key + `"`)}, nil
+}
+
+func (s *flakySink) deliverQueued(event queuedEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.healthy {
+		return errUnavailable
+	}
+	s.delivered = append(s.delivered, string(event.Body))
+	return nil
+}
+
+func (s *flakySink) heal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.healthy = true
+}
+
+// waitDelivered waits until the sink delivered n events, returning them
+func (s *flakySink) waitDelivered(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(eventTimeout)
+	for {
+		s.mu.Lock()
+		delivered := append([]string(nil), s.delivered...)
+		s.mu.Unlock()
+		if len(delivered) >= n {
+			return delivered
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the sink delivered %v, want %d events", delivered, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueuedSinkRedelivers(t *testing.T) {
+	options := SinkQueueOptions{Dir: t.TempDir(), Backoff: 10 * time.Millisecond}
+	sink := &flakySink{dir: "test"}
+	queued, err := newQueuedSink(sink, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queued.Close()
+
+	// The events are queued while the sink fails, and redelivered in order once it recovers
+	for _, name := range []string{"web-1", "web-2"} {
+		if err := queued.Write(testPodEvent("ADDED", name, corev1.PodPending)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := queued.queue.len(); n != 2 {
+		t.Fatalf("%d events queued, want 2", n)
+	}
+	sink.heal()
+	delivered := sink.waitDelivered(t, 2)
+	if delivered[0] != `"default/web-1"` || delivered[1] != `"default/web-2"` {
+		t.Errorf("redelivered %v, want web-1 then web-2", delivered)
+	}
+}
+
+func TestQueuedSinkCloseKeepsQueue(t *testing.T) {
+	options := SinkQueueOptions{Dir: t.TempDir(), Backoff: 10 * time.Millisecond}
+	queued, err := newQueuedSink(&flakySink{dir: "test"}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queued.Write(testPodEvent("ADDED", "web-1", corev1.PodPending)); err != nil {
+		t.Fatal(err)
+	}
+	if err := queued.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next run redelivers what the previous one left queued on shutdown
+	sink := &flakySink{dir: "test", healthy: true}
+	queued, err = newQueuedSink(sink, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queued.Close()
+	if delivered := sink.waitDelivered(t, 1); delivered[0] != `"default/web-1"` {
+		t.Errorf("redelivered %v, want web-1", delivered)
+	}
+}
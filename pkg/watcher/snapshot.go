@@ -0,0 +1,40 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// WithSnapshot makes Run capture the matching objects at a point in time instead of watching them: every object
+// of the initial lists that matches the filters is emitted once, as an ADDED event, and Run returns once they have
+// all been delivered to the sinks, with the reason StopSnapshot. The lists are consistent reads of the API server's
+// storage rather than answers from its watch cache, and the changes received meanwhile are not emitted.
+func WithSnapshot() Option {
+	return func(w *Watcher) { w.snapshot = true }
+}
+
+// awaitSnapshot stops the watch once the given number of informers have delivered their initial list and the
+// objects of those lists have been processed, receiving the HasSynced of each informer from the processor
+func (p *eventProcessor) awaitSnapshot(ctx context.Context, wg *sync.WaitGroup, informers int) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		synced := make([]cache.InformerSynced, 0, informers)
+		for len(synced) < informers {
+			select {
+			case <-ctx.Done():
+				return
+			case hasSynced := <-p.snapshot:
+				synced = append(synced, hasSynced)
+			}
+		}
+		if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+			return
+		}
+		p.workers.wait()
+		p.snapshotted.Store(true)
+		p.stop()
+	}()
+}
@@ -0,0 +1,177 @@
+package watcher
+
+import (
+	"container/list"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Reasons of the evictions of the state kept per object
+const (
+	evictedDeleted   = "deleted"   // the object was deleted
+	evictedUnmatched = "unmatched" // the object stopped matching the filters
+	evictedCapacity  = "capacity"  // more objects than WithMaxTrackedObjects allows were tracked
+)
+
+// forgetEvent is the type of the control events queued to the sinks keeping state per object, behind the events of
+// the object, telling them to drop it; they are never written
+const forgetEvent = "FORGET"
+
+// WithMaxTrackedObjects bounds the number of objects whose state the watcher keeps, for the diff format, --dedupe,
+// --min-interval and the trackers of the other modes. Beyond it, the state of the least recently updated object is
+// dropped, so that its next revision is handled like that of an object seen for the first time. The state of
+// an object is always dropped once it is deleted or stops matching the filters; zero (the default) keeps every object.
+func WithMaxTrackedObjects(n int) Option {
+	return func(w *Watcher) { w.maxTracked = n }
+}
+
+// objectState is implemented by the trackers and sinks keeping state per object
+type objectState interface {
+	// evict drops the state of the object, qualified by its cluster
+	evict(id string)
+}
+
+// trackedObject is an object with state, in the order of the updates
+type trackedObject struct {
+	id      string // the key qualified by the cluster
+	cluster string
+	key     string
+}
+
+// trackedObjects keeps the objects with state in the order they were last updated, to evict the least recently
+// updated one when there are too many
+type trackedObjects struct {
+	max     int // 0 for no limit
+	mu      sync.Mutex
+	order   *list.List               // of *trackedObject, the least recently updated first
+	objects map[string]*list.Element // object key, qualified by its cluster -> its element in order
+}
+
+func newTrackedObjects(max int) *trackedObjects {
+	return &trackedObjects{max: max, order: list.New(), objects: make(map[string]*list.Element)}
+}
+
+// touch records an update of the object, returning the objects to evict to stay within the limit
+func (t *trackedObjects) touch(m *matchedObject) []*trackedObject {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if element, ok := t.objects[m.id]; ok {
+		t.order.MoveToBack(element)
+		return nil
+	}
+	t.objects[m.id] = t.order.PushBack(&trackedObject{id: m.id, cluster: m.cluster, key: m.key})
+	var evicted []*trackedObject
+	for t.max > 0 && t.order.Len() > t.max {
+		object := t.order.Remove(t.order.Front()).(*trackedObject)
+		delete(t.objects, object.id)
+		evicted = append(evicted, object)
+	}
+	trackedObjectsCount.Set(float64(t.order.Len()))
+	return evicted
+}
+
+// remove forgets the object, reporting whether it was tracked
+func (t *trackedObjects) remove(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	element, ok := t.objects[id]
+	if !ok {
+		return false
+	}
+	t.order.Remove(element)
+	delete(t.objects, id)
+	trackedObjectsCount.Set(float64(t.order.Len()))
+	return true
+}
+
+// track records an update of a matched object, evicting the least recently updated objects beyond the limit
+func (p *eventProcessor) track(m *matchedObject) {
+	for _, object := range p.tracked.touch(m) {
+		p.evict(object.cluster, object.key, object.id, evictedCapacity)
+	}
+}
+
+// untrack drops the state of an object that was deleted or stopped matching, once its events have been handled
+func (p *eventProcessor) untrack(eventType string, m *matchedObject) {
+	if !p.tracked.remove(m.id) {
+		return
+	}
+	reason := evictedUnmatched
+	if eventType == string(watch.Deleted) {
+		reason = evictedDeleted
+	}
+	p.evict(m.cluster, m.key, m.id, reason)
+}
+
+// evict drops the state of the object from every tracker and sink
+func (p *eventProcessor) evict(cluster string, key string, id string, reason string) {
+	for _, state := range p.objectStates() {
+		state.evict(id)
+	}
+	p.sinks.evict(cluster, key)
+	stateEvictions.WithLabelValues(reason).Inc()
+}
+
+// objectStates returns the trackers of the processor keeping state per object
+func (p *eventProcessor) objectStates() []objectState {
+	var states []objectState
+	for _, state := range []struct {
+		state objectState
+		ok    bool
+	}{
+		{p.images, p.images != nil},
+		{p.sections, p.sections != nil},
+		{p.audit, p.audit != nil},
+		{p.references, p.references != nil},
+		{p.volumes, p.volumes != nil},
+		{p.terminations, p.terminations != nil},
+		{p.containers, p.containers != nil},
+		{p.inits, p.inits != nil},
+		{p.imageChanges, p.imageChanges != nil},
+		{p.security, p.security != nil},
+		{p.timelines, p.timelines != nil},
+		{p.alerts, p.alerts != nil},
+		{p.usage, p.usage != nil},
+		{p.stats, p.stats != nil},
+		{p.capture, p.capture != nil},
+		{p.debug, p.debug != nil},
+		{p.throttle, p.throttle != nil},
+	} {
+		if state.ok {
+			states = append(states, state.state)
+		}
+	}
+	return states
+}
+
+// evict drops the state the sinks, their routes and profiles keep for the object. The sinks drop it once they have
+// written the events of the object queued before.
+func (f *fanOut) evict(cluster string, key string) {
+	id := clusterKey(cluster, key)
+	if f.router != nil {
+		f.router.evict(id)
+	}
+	event := Event{Type: forgetEvent, Key: key, Cluster: cluster}
+	evicted := make(map[*profile]bool)
+	for i, sink := range f.sinks {
+		if s, ok := sink.(*profileSink); ok && !evicted[s.profile] {
+			s.profile.evict(id)
+			evicted[s.profile] = true
+		}
+		if _, ok := unwrapSink(sink).(objectState); ok {
+			f.queues[i] <- event
+		}
+	}
+}
+
+// unwrapSink returns the sink a routed or profile sink delivers to
+func unwrapSink(sink Sink) Sink {
+	switch s := sink.(type) {
+	case *routedSink:
+		return s.Sink
+	case *profileSink:
+		return s.Sink
+	}
+	return sink
+}
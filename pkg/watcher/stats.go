@@ -0,0 +1,321 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// statsDocument is the type of the statistics documents in the JSON output formats
+const statsDocument = "STATS"
+
+// WithStatsInterval periodically writes a statistics document aggregating the matched objects into the output stream
+// and files, and the pod_watcher_stats_* metrics: their number by namespace and phase, by owner workload and by node,
+// the restarts of their containers, and their churn since the previous document. The owner is the top-level one with
+// WithOwnerResolution, otherwise the controller of the object.
+func WithStatsInterval(interval time.Duration) Option {
+	return func(w *Watcher) { w.statsInterval = interval }
+}
+
+// Stats aggregates the objects matching the filters, as written every WithStatsInterval
+type Stats struct {
+	Type       string           `json:"type"` // always "STATS"
+	Timestamp  time.Time        `json:"timestamp"`
+	Interval   string           `json:"interval"` // since the previous statistics, or the start of the watch
+	Pods       int              `json:"pods"`     // the matched objects
+	Phases     map[string]int   `json:"phases,omitempty"`
+	Restarts   int32            `json:"restarts"` // of the containers of the matched pods, in total
+	Churn      Churn            `json:"churn"`
+	Namespaces []NamespaceStats `json:"namespaces,omitempty"`
+	Owners     []OwnerStats     `json:"owners,omitempty"`
+	Nodes      []NodeStats      `json:"nodes,omitempty"`
+}
+
+// Churn counts the changes of the matched objects over the interval of the statistics
+type Churn struct {
+	Created   int     `json:"created"`
+	Deleted   int     `json:"deleted"`
+	Restarts  int32   `json:"restarts"`  // of containers
+	PerMinute float64 `json:"perMinute"` // the objects created and deleted per minute
+}
+
+// NamespaceStats aggregates the matched objects of one namespace
+type NamespaceStats struct {
+	Cluster   string         `json:"cluster,omitempty"`
+	Namespace string         `json:"namespace"`
+	Pods      int            `json:"pods"`
+	Phases    map[string]int `json:"phases,omitempty"`
+	Restarts  int32          `json:"restarts"`
+}
+
+// OwnerStats aggregates the matched objects of one owner workload
+type OwnerStats struct {
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	Owner     string `json:"owner"` // kind/name
+	Pods      int    `json:"pods"`
+	Ready     int    `json:"ready"`
+	Restarts  int32  `json:"restarts"`
+}
+
+// NodeStats aggregates the matched pods scheduled on one node
+type NodeStats struct {
+	Cluster string `json:"cluster,omitempty"`
+	Node    string `json:"node"`
+	Pods    int    `json:"pods"`
+}
+
+// String formats the statistics as comment lines, the totals followed by a line per namespace, owner and node
+func (s *Stats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Stats: %d pods", s.Pods)
+	if len(s.Phases) > 0 {
+		fmt.Fprintf(&b, " (%s)", formatCounts(s.Phases))
+	}
+	fmt.Fprintf(&b, ", %d restarts, churn %.1f/min (created %d, deleted %d, restarted %d in %s)",
+		s.Restarts, s.Churn.PerMinute, s.Churn.Created, s.Churn.Deleted, s.Churn.Restarts, s.Interval)
+	for _, n := range s.Namespaces {
+		fmt.Fprintf(&b, "\n##   Namespace %s: %d pods", clusterKey(n.Cluster, n.Namespace), n.Pods)
+		if len(n.Phases) > 0 {
+			fmt.Fprintf(&b, " (%s)", formatCounts(n.Phases))
+		}
+		fmt.Fprintf(&b, ", %d restarts", n.Restarts)
+	}
+	for _, o := range s.Owners {
+		fmt.Fprintf(&b, "\n##   Owner %s/%s: %d pods, %d ready, %d restarts", clusterKey(o.Cluster, o.Namespace), o.Owner, o.Pods, o.Ready, o.Restarts)
+	}
+	for _, n := range s.Nodes {
+		fmt.Fprintf(&b, "\n##   Node %s: %d pods", clusterKey(n.Cluster, n.Node), n.Pods)
+	}
+	return b.String()
+}
+
+// formatCounts formats counts by name, e.g. "Pending=2, Running=40"
+func formatCounts(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s=%d", name, counts[name])
+	}
+	return strings.Join(names, ", ")
+}
+
+// statsEntry is what the statistics keep of one matched object
+type statsEntry struct {
+	cluster   string
+	namespace string
+	phase     string
+	owner     string // kind/name, "" without an owner
+	node      string
+	ready     bool
+	restarts  int32
+}
+
+// statsTracker aggregates the matched objects for the statistics of --stats-interval
+type statsTracker struct {
+	interval time.Duration
+	outs     []*eventWriter // the sinks writing to an output stream or file
+	owners   *ownerResolver // nil unless --resolve-owners
+
+	mu       sync.Mutex
+	objects  map[string]*statsEntry // object key, qualified by its cluster -> what the statistics keep of it
+	created  int
+	deleted  int
+	restarts int32     // restarts of containers since the previous statistics
+	since    time.Time // of the previous statistics
+}
+
+func newStatsTracker(interval time.Duration, outs []*eventWriter, owners *ownerResolver) *statsTracker {
+	return &statsTracker{interval: interval, outs: outs, owners: owners, objects: make(map[string]*statsEntry), since: time.Now()}
+}
+
+// update records a revision of a matched object; initial tells whether it is one of the initial lists, which is not churn
+func (s *statsTracker) update(eventType string, m *matchedObject, initial bool) {
+	var entry *statsEntry
+	if eventType != string(watch.Deleted) {
+		entry = s.entry(m)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, seen := s.objects[m.id]
+	if entry == nil {
+		if seen {
+			delete(s.objects, m.id)
+			s.deleted++
+		}
+		return
+	}
+	if seen {
+		s.restarts += max(entry.restarts-previous.restarts, 0)
+	} else if eventType == string(watch.Added) && !initial {
+		s.created++
+		s.restarts += entry.restarts
+	}
+	s.objects[m.id] = entry
+}
+
+// entry reads what the statistics keep of the object
+func (s *statsTracker) entry(m *matchedObject) *statsEntry {
+	entry := &statsEntry{cluster: m.cluster}
+	if objMeta, err := meta.Accessor(m.obj); err == nil {
+		entry.namespace = objMeta.GetNamespace()
+		if s.owners == nil {
+			if ref := ownerReference(objMeta.GetOwnerReferences()); ref != nil {
+				entry.owner = ref.Kind + "/" + ref.Name
+			}
+		}
+	}
+	if s.owners != nil {
+		if owner := s.owners.resolve(m.cluster, m.obj); owner != nil {
+			entry.owner = owner.String()
+		}
+	}
+	if pod, ok := m.obj.(*corev1.Pod); ok {
+		entry.phase, entry.node = string(pod.Status.Phase), pod.Spec.NodeName
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady {
+				entry.ready = condition.Status == corev1.ConditionTrue
+			}
+		}
+		for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+			for _, status := range statuses {
+				entry.restarts += status.RestartCount
+			}
+		}
+	}
+	return entry
+}
+
+// evict forgets an object that stopped matching, without counting it as deleted
+func (s *statsTracker) evict(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, id)
+}
+
+// run writes the statistics every interval until the context is canceled
+func (s *statsTracker) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stats := s.collect(time.Now())
+		recordStats(stats)
+		for _, out := range s.outs {
+			out.writeDocument(statsDocument, stats.String(), stats)
+		}
+	}
+}
+
+// collect aggregates the matched objects and the churn since the previous statistics, starting a new interval
+func (s *statsTracker) collect(now time.Time) *Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	interval := now.Sub(s.since)
+	stats := &Stats{Type: statsDocument, Timestamp: now.UTC(), Interval: interval.Round(time.Second).String(), Pods: len(s.objects),
+		Phases: make(map[string]int), Churn: Churn{Created: s.created, Deleted: s.deleted, Restarts: s.restarts}}
+	if minutes := interval.Minutes(); minutes > 0 {
+		stats.Churn.PerMinute = math.Round(float64(s.created+s.deleted)/minutes*100) / 100
+	}
+	s.since, s.created, s.deleted, s.restarts = now, 0, 0, 0
+	namespaces := make(map[[2]string]*NamespaceStats)
+	owners := make(map[[3]string]*OwnerStats)
+	nodes := make(map[[2]string]*NodeStats)
+	for _, entry := range s.objects {
+		stats.Restarts += entry.restarts
+		namespace := namespaces[[2]string{entry.cluster, entry.namespace}]
+		if namespace == nil {
+			namespace = &NamespaceStats{Cluster: entry.cluster, Namespace: entry.namespace, Phases: make(map[string]int)}
+			namespaces[[2]string{entry.cluster, entry.namespace}] = namespace
+		}
+		namespace.Pods++
+		namespace.Restarts += entry.restarts
+		if entry.phase != "" {
+			stats.Phases[entry.phase]++
+			namespace.Phases[entry.phase]++
+		}
+		if entry.owner != "" {
+			owner := owners[[3]string{entry.cluster, entry.namespace, entry.owner}]
+			if owner == nil {
+				owner = &OwnerStats{Cluster: entry.cluster, Namespace: entry.namespace, Owner: entry.owner}
+				owners[[3]string{entry.cluster, entry.namespace, entry.owner}] = owner
+			}
+			owner.Pods++
+			owner.Restarts += entry.restarts
+			if entry.ready {
+				owner.Ready++
+			}
+		}
+		if entry.node != "" {
+			node := nodes[[2]string{entry.cluster, entry.node}]
+			if node == nil {
+				node = &NodeStats{Cluster: entry.cluster, Node: entry.node}
+				nodes[[2]string{entry.cluster, entry.node}] = node
+			}
+			node.Pods++
+		}
+	}
+	for _, namespace := range namespaces {
+		stats.Namespaces = append(stats.Namespaces, *namespace)
+	}
+	sort.Slice(stats.Namespaces, func(i, j int) bool {
+		a, b := stats.Namespaces[i], stats.Namespaces[j]
+		return clusterKey(a.Cluster, a.Namespace) < clusterKey(b.Cluster, b.Namespace)
+	})
+	for _, owner := range owners {
+		stats.Owners = append(stats.Owners, *owner)
+	}
+	sort.Slice(stats.Owners, func(i, j int) bool {
+		a, b := stats.Owners[i], stats.Owners[j]
+		return clusterKey(a.Cluster, a.Namespace+"/"+a.Owner) < clusterKey(b.Cluster, b.Namespace+"/"+b.Owner)
+	})
+	for _, node := range nodes {
+		stats.Nodes = append(stats.Nodes, *node)
+	}
+	sort.Slice(stats.Nodes, func(i, j int) bool {
+		a, b := stats.Nodes[i], stats.Nodes[j]
+		return clusterKey(a.Cluster, a.Node) < clusterKey(b.Cluster, b.Node)
+	})
+	return stats
+}
+
+// recordStats replaces the series of the pod_watcher_stats_* metrics with those of the statistics
+func recordStats(stats *Stats) {
+	statsPods.Reset()
+	statsRestarts.Reset()
+	statsOwnerPods.Reset()
+	statsNodePods.Reset()
+	for _, namespace := range stats.Namespaces {
+		statsRestarts.WithLabelValues(namespace.Cluster, namespace.Namespace).Set(float64(namespace.Restarts))
+		if len(namespace.Phases) == 0 {
+			statsPods.WithLabelValues(namespace.Cluster, namespace.Namespace, "").Set(float64(namespace.Pods))
+		}
+		for phase, n := range namespace.Phases {
+			statsPods.WithLabelValues(namespace.Cluster, namespace.Namespace, phase).Set(float64(n))
+		}
+	}
+	for _, owner := range stats.Owners {
+		statsOwnerPods.WithLabelValues(owner.Cluster, owner.Namespace, owner.Owner).Set(float64(owner.Pods))
+	}
+	for _, node := range stats.Nodes {
+		statsNodePods.WithLabelValues(node.Cluster, node.Node).Set(float64(node.Pods))
+	}
+	statsChurn.WithLabelValues("created").Set(float64(stats.Churn.Created))
+	statsChurn.WithLabelValues("deleted").Set(float64(stats.Churn.Deleted))
+	statsChurn.WithLabelValues("restarted").Set(float64(stats.Churn.Restarts))
+}
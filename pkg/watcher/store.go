@@ -0,0 +1,218 @@
+package watcher
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+)
+
+// storeSchema creates the event history table of a store
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS events (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp INTEGER NOT NULL, -- Unix time in nanoseconds
+	type      TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	name      TEXT NOT NULL,
+	kind      TEXT NOT NULL,
+	object    TEXT NOT NULL,    -- the object as JSON
+	cluster   TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS events_by_object ON events (namespace, name, timestamp);
+CREATE INDEX IF NOT EXISTS events_by_time ON events (timestamp);
+`
+
+// Store is the Sink persisting every event in an SQLite database, for later queries of the event history
+type Store struct {
+	db   *sql.DB
+	path string
+}
+
+// OpenStore opens, or creates, the SQLite event store at path
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open store %s: %w", path, err)
+	}
+	// SQLite allows a single writer; serializing the connections avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+	for _, pragma := range []string{"PRAGMA journal_mode=WAL", "PRAGMA busy_timeout=5000"} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("could not configure store %s: %w", path, err)
+		}
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create the schema of store %s: %w", path, err)
+	}
+	if err := addClusterColumn(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not upgrade the schema of store %s: %w", path, err)
+	}
+	return &Store{db: db, path: path}, nil
+}
+
+// addClusterColumn adds the cluster column to the events table of a store created before it existed
+func addClusterColumn(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('events') WHERE name = 'cluster'`).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	_, err := db.Exec(`ALTER TABLE events ADD COLUMN cluster TEXT NOT NULL DEFAULT ''`)
+	return err
+}
+
+// Write persists the event. The container changes of CONTAINER events, the timelines of TIMELINE events, the alerts
+// of ALERT events and the usage of METRICS events are not recorded, as the pod revisions they derive from are.
+func (s *Store) Write(event Event) error {
+	if event.Type == ContainerEvent || event.Type == TimelineEvent || event.Type == AlertEvent || event.Type == MetricsEvent || event.Type == ReferenceEvent || event.Type == VolumeEvent ||
+		event.Type == TerminatingEvent || event.Type == FinalizedEvent || event.Type == InitEvent || event.Type == ImageEvent || event.Type == SecurityEvent ||
+		event.Type == BackfillEvent {
+		return nil
+	}
+	data, err := json.Marshal(event.Object)
+	if err != nil {
+		marshalErrors.Inc()
+		return fmt.Errorf("could not marshal %s to JSON: %w", event.Key, err)
+	}
+	var namespace, name string
+	if objMeta, err := meta.Accessor(event.Object); err == nil {
+		namespace, name = objMeta.GetNamespace(), objMeta.GetName()
+	}
+	_, err = s.db.Exec(`INSERT INTO events (timestamp, type, cluster, namespace, name, kind, object) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		event.Timestamp.UnixNano(), event.Type, event.Cluster, namespace, name, objectKind(event.Object), string(data))
+	return err
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// String names the sink for logs and metrics
+func (s *Store) String() string {
+	return "sqlite:" + s.path
+}
+
+// StoreQuery selects events from the store; zero fields do not restrict the selection
+type StoreQuery struct {
+	Cluster   string
+	Namespace string
+	Name      string
+	Types     []string
+	Since     time.Time // inclusive
+	Until     time.Time // inclusive
+	// Latest only returns the most recent matching event of each object, i.e. the state of every object as of Until
+	Latest bool
+	Limit  int
+}
+
+// Query returns the events selected by the query, oldest first
+func (s *Store) Query(ctx context.Context, query StoreQuery) ([]Event, error) {
+	var where []string
+	var args []interface{}
+	if query.Cluster != "" {
+		where, args = append(where, "cluster = ?"), append(args, query.Cluster)
+	}
+	if query.Namespace != "" {
+		where, args = append(where, "namespace = ?"), append(args, query.Namespace)
+	}
+	if query.Name != "" {
+		where, args = append(where, "name = ?"), append(args, query.Name)
+	}
+	if len(query.Types) > 0 {
+		where = append(where, "type IN (?"+strings.Repeat(", ?", len(query.Types)-1)+")")
+		for _, eventType := range query.Types {
+			args = append(args, strings.ToUpper(eventType))
+		}
+	}
+	if !query.Since.IsZero() {
+		where, args = append(where, "timestamp >= ?"), append(args, query.Since.UnixNano())
+	}
+	if !query.Until.IsZero() {
+		where, args = append(where, "timestamp <= ?"), append(args, query.Until.UnixNano())
+	}
+	conditions := ""
+	if len(where) > 0 {
+		conditions = " WHERE " + strings.Join(where, " AND ")
+	}
+	statement := "SELECT timestamp, type, cluster, namespace, name, kind, object FROM events" + conditions
+	if query.Latest {
+		statement = "SELECT timestamp, type, cluster, namespace, name, kind, object FROM events WHERE id IN (SELECT MAX(id) FROM events" +
+			conditions + " GROUP BY cluster, namespace, name)"
+	}
+	statement += " ORDER BY timestamp, id"
+	if query.Limit > 0 {
+		statement += fmt.Sprintf(" LIMIT %d", query.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query store %s: %w", s.path, err)
+	}
+	defer rows.Close()
+	var events []Event
+	for rows.Next() {
+		var timestamp int64
+		var eventType, cluster, namespace, name, kind, data string
+		if err := rows.Scan(&timestamp, &eventType, &cluster, &namespace, &name, &kind, &data); err != nil {
+			return nil, err
+		}
+		obj, err := decodeObject(kind, []byte(data))
+		if err != nil {
+			return nil, fmt.Errorf("could not decode stored %s %s/%s: %w", kind, namespace, name, err)
+		}
+		key := name
+		if namespace != "" {
+			key = namespace + "/" + name
+		}
+		events = append(events, Event{Type: eventType, Key: key, Object: obj, Timestamp: time.Unix(0, timestamp).UTC(), Cluster: cluster})
+	}
+	return events, rows.Err()
+}
+
+// Kinds recorded for the typed objects, as opposed to the bare kind of unstructured objects
+const (
+	storedPod   = "v1/Pod"
+	storedEvent = "v1/Event"
+)
+
+// objectKind returns the kind recorded for an object in the store
+func objectKind(obj runtime.Object) string {
+	switch obj := obj.(type) {
+	case *corev1.Pod:
+		return storedPod
+	case *corev1.Event:
+		return storedEvent
+	default:
+		return obj.GetObjectKind().GroupVersionKind().Kind
+	}
+}
+
+// decodeObject restores a stored object, as a *corev1.Pod or *corev1.Event when it was one,
+// and as *unstructured.Unstructured otherwise
+func decodeObject(kind string, data []byte) (runtime.Object, error) {
+	var obj runtime.Object
+	switch kind {
+	case storedPod:
+		obj = &corev1.Pod{}
+	case storedEvent:
+		obj = &corev1.Event{}
+	default:
+		obj = &unstructured.Unstructured{}
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,405 @@
+package watcher
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/yaml"
+)
+
+// streamQueueSize is the number of events buffered for each client of an EventStream before it is disconnected as too slow
+const streamQueueSize = 256
+
+// streamKeepAlive is the interval of the comments keeping idle Server-Sent Events connections open through proxies
+const streamKeepAlive = 30 * time.Second
+
+// errStreamFull refuses the connections beyond the most clients of an EventStream
+var errStreamFull = errors.New("the event stream already serves its most clients")
+
+// EventStream streams the emitted events, as JSON envelopes, to HTTP clients such as browser dashboards:
+// /events serves them as Server-Sent Events and /ws over a WebSocket. Each connection narrows the events
+// with the query parameters marker (repeatable substrings of the object's YAML, any of which must match),
+// namespace, and event-type (both repeatable or comma-separated). Clients that cannot keep up are disconnected.
+// A stream outlives the watchers it is given to with WithEventStream, so that a reload does not disconnect the clients.
+type EventStream struct {
+	allowedOrigins []string // origins of the pages allowed to connect besides the stream's own; "*" allows any
+	token          string   // the bearer token the clients must send; empty leaves the endpoints open
+	maxClients     int      // the most clients connected at once; 0 is unlimited
+	certFile       string   // the PEM certificate served over TLS; empty serves plain HTTP
+	keyFile        string
+	upgrader       websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*streamClient]bool
+	closed  bool
+}
+
+// streamClient is one connection to an EventStream
+type streamClient struct {
+	markers    *markerFilter   // nil matches every object
+	namespaces map[string]bool // nil matches every namespace
+	types      map[string]bool // nil matches every event type
+	queue      chan []byte
+	done       chan struct{} // closed once the client is disconnected by the stream
+}
+
+// StreamOption configures an EventStream
+type StreamOption func(*EventStream)
+
+// WithAllowedOrigins accepts connections from the pages of the origins besides the stream's own,
+// e.g. https://dashboard.example.com, or from any page with "*"
+func WithAllowedOrigins(origins ...string) StreamOption {
+	return func(s *EventStream) {
+		s.allowedOrigins = append(s.allowedOrigins, origins...)
+	}
+}
+
+// WithStreamToken requires the clients to send the token, as a bearer token in the Authorization header or,
+// for the browsers that cannot set headers on an EventSource or a WebSocket, in the access_token query parameter
+func WithStreamToken(token string) StreamOption {
+	return func(s *EventStream) {
+		s.token = token
+	}
+}
+
+// WithStreamMaxClients refuses the connections beyond the most clients, with 503 Service Unavailable
+func WithStreamMaxClients(maxClients int) StreamOption {
+	return func(s *EventStream) {
+		s.maxClients = maxClients
+	}
+}
+
+// WithStreamTLS has ServeEventStream serve the endpoints over TLS with the PEM certificate and key files
+func WithStreamTLS(certFile, keyFile string) StreamOption {
+	return func(s *EventStream) {
+		s.certFile = certFile
+		s.keyFile = keyFile
+	}
+}
+
+// NewEventStream returns an EventStream configured by the options, accepting connections from the pages of its own
+// origin unless WithAllowedOrigins allows others
+func NewEventStream(options ...StreamOption) *EventStream {
+	s := &EventStream{clients: make(map[*streamClient]bool)}
+	for _, option := range options {
+		option(s)
+	}
+	s.upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || s.originAllowed(origin) {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	return s
+}
+
+// originAllowed reports whether pages of the origin may connect to the stream
+func (s *EventStream) originAllowed(origin string) bool {
+	return slices.Contains(s.allowedOrigins, "*") || slices.Contains(s.allowedOrigins, origin)
+}
+
+// Handler returns the /events and /ws endpoints of the stream, which require the token of WithStreamToken if any
+func (s *EventStream) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", s.serveSSE)
+	mux.HandleFunc("/ws", s.serveWebSocket)
+	if s.token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !s.authenticated(r) {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="pod-watcher"`)
+			http.Error(rw, "a valid bearer token is required", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(rw, r)
+	})
+}
+
+// authenticated reports whether the request carries the token of the stream
+func (s *EventStream) authenticated(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		token = r.URL.Query().Get("access_token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// Close disconnects every client of the stream and refuses new ones
+func (s *EventStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for client := range s.clients {
+		s.disconnectLocked(client)
+	}
+}
+
+// publish queues the event for every client whose filters it matches. When the object cannot be serialized to YAML,
+// only the clients filtering on markers miss the event.
+func (s *EventStream) publish(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(newEnvelope(event))
+	if err != nil {
+		marshalErrors.Inc()
+		return fmt.Errorf("could not marshal event: %w", err)
+	}
+	namespace := ""
+	if objMeta, err := meta.Accessor(event.Object); err == nil {
+		namespace = objMeta.GetNamespace()
+	}
+	objYAML := event.yaml
+	var yamlErr error
+	for client := range s.clients {
+		if client.types != nil && !client.types[event.Type] || client.namespaces != nil && !client.namespaces[namespace] {
+			continue
+		}
+		if client.markers != nil {
+			if objYAML == "" && yamlErr == nil {
+				serialized, err := yaml.Marshal(event.Object)
+				if err != nil {
+					marshalErrors.Inc()
+					yamlErr = fmt.Errorf("could not marshal %s to YAML: %w", event.Key, err)
+				}
+				objYAML = string(serialized)
+			}
+			if yamlErr != nil || !client.markers.matchesObject(event.Object, objYAML) {
+				continue
+			}
+		}
+		select {
+		case client.queue <- data:
+		default:
+			slog.Warn("Disconnecting an event stream client that does not keep up")
+			s.disconnectLocked(client)
+		}
+	}
+	return yamlErr
+}
+
+// connect registers a client with the filters of the query parameters of the request
+func (s *EventStream) connect(r *http.Request) (*streamClient, error) {
+	query := r.URL.Query()
+	client := &streamClient{queue: make(chan []byte, streamQueueSize), done: make(chan struct{})}
+	// Markers may contain commas, so they are only repeated
+	if markers := slices.DeleteFunc(query["marker"], func(marker string) bool { return marker == "" }); len(markers) > 0 {
+		filter, err := newMarkerFilter(markers, nil, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		client.markers = filter
+	}
+	if namespaces := queryValues(query["namespace"]); len(namespaces) > 0 {
+		client.namespaces = make(map[string]bool)
+		for _, namespace := range namespaces {
+			client.namespaces[namespace] = true
+		}
+	}
+	var err error
+	if client.types, err = parseEventTypes(queryValues(query["event-type"])); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("the event stream is closed")
+	}
+	if s.maxClients > 0 && len(s.clients) >= s.maxClients {
+		return nil, errStreamFull
+	}
+	s.clients[client] = true
+	return client, nil
+}
+
+// disconnect unregisters a client whose connection ended
+func (s *EventStream) disconnect(client *streamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnectLocked(client)
+}
+
+func (s *EventStream) disconnectLocked(client *streamClient) {
+	if s.clients[client] {
+		delete(s.clients, client)
+		close(client.done)
+	}
+}
+
+// connectError replies with the reason the client could not connect
+func connectError(rw http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, errStreamFull) {
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(rw, err.Error(), status)
+}
+
+// queryValues splits the comma-separated values of a repeated query parameter
+func queryValues(values []string) []string {
+	var split []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				split = append(split, v)
+			}
+		}
+	}
+	return split
+}
+
+// serveSSE streams the events of a client as Server-Sent Events, one envelope in the data of each
+func (s *EventStream) serveSSE(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && s.originAllowed(origin) {
+		rw.Header().Set("Access-Control-Allow-Origin", origin)
+		rw.Header().Add("Vary", "Origin")
+	}
+	client, err := s.connect(r)
+	if err != nil {
+		connectError(rw, err)
+		return
+	}
+	defer s.disconnect(client)
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.done:
+			return
+		case <-keepAlive.C:
+			if _, err := rw.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case data := <-client.queue:
+			if _, err := fmt.Fprintf(rw, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// serveWebSocket streams the events of a client over a WebSocket, one envelope in each text message
+func (s *EventStream) serveWebSocket(rw http.ResponseWriter, r *http.Request) {
+	client, err := s.connect(r)
+	if err != nil {
+		connectError(rw, err)
+		return
+	}
+	defer s.disconnect(client)
+	conn, err := s.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		return // the upgrader has replied with the error
+	}
+	defer conn.Close()
+	// The client sends nothing but control messages, read to answer pings and notice the connection closing
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-client.done:
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+			return
+		case data := <-client.queue:
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamSink is the Sink feeding an EventStream; closing it leaves the stream and its clients to the next watcher
+type streamSink struct {
+	stream *EventStream
+}
+
+func (s streamSink) Write(event Event) error {
+	return s.stream.publish(event)
+}
+
+func (s streamSink) Close() error {
+	return nil
+}
+
+// String names the sink for logs and metrics
+func (s streamSink) String() string {
+	return "event-stream"
+}
+
+// ServeEventStream listens on addr, returning the error if it cannot, then serves the endpoints of the stream in the
+// background until the context is canceled, when it disconnects the clients
+func ServeEventStream(ctx context.Context, addr string, stream *EventStream) error {
+	server := &http.Server{Addr: addr, Handler: stream.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	if stream.certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(stream.certFile, stream.keyFile)
+		if err != nil {
+			return fmt.Errorf("could not load the event stream TLS certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	slog.Info("Serving the event stream", "address", listener.Addr().String(), "paths", "/events, /ws", "tls", server.TLSConfig != nil,
+		"authenticated", stream.token != "", "maxClients", stream.maxClients)
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Event stream server failed", "error", err)
+		}
+	}()
+	return nil
+}
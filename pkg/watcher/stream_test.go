@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// getEvents opens /events of the server with the token, if any, returning the response once its headers are in
+func getEvents(t *testing.T, server *httptest.Server, token, query string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/events"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestEventStreamToken(t *testing.T) {
+	stream := NewEventStream(WithStreamToken("secret"))
+	server := httptest.NewServer(stream.Handler())
+	defer server.Close()
+	defer stream.Close()
+
+	for _, tc := range []struct {
+		name, token, query string
+		want               int
+	}{
+		{"missing", "", "", http.StatusUnauthorized},
+		{"wrong", "guess", "", http.StatusUnauthorized},
+		{"header", "secret", "", http.StatusOK},
+		{"query parameter", "", "?access_token=secret", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if resp := getEvents(t, server, tc.token, tc.query); resp.StatusCode != tc.want {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tc.want)
+			}
+		})
+	}
+}
+
+func TestEventStreamMaxClients(t *testing.T) {
+	stream := NewEventStream(WithStreamMaxClients(1))
+	server := httptest.NewServer(stream.Handler())
+	defer server.Close()
+	defer stream.Close()
+
+	if resp := getEvents(t, server, "", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first client got status %d", resp.StatusCode)
+	}
+	if resp := getEvents(t, server, "", ""); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("second client got status %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+// unserializablePod is a pod that cannot be serialized, which only the clients filtering on markers need
+type unserializablePod struct {
+	*corev1.Pod
+}
+
+func (unserializablePod) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("cannot serialize")
+}
+
+func TestEventStreamPublishYAMLError(t *testing.T) {
+	stream := NewEventStream()
+	server := httptest.NewServer(stream.Handler())
+	defer server.Close()
+	defer stream.Close()
+
+	plain := bufio.NewReader(getEvents(t, server, "", "").Body)
+	getEvents(t, server, "", "?marker=canary")
+	// A notice carries no object in its envelope, so only the YAML of the markers fails
+	event := testPodEvent(MetricsEvent, "web-1", corev1.PodRunning)
+	event.Object = unserializablePod{event.Object.(*corev1.Pod)}
+	if err := stream.publish(event); err == nil {
+		t.Error("publish did not report the YAML error")
+	}
+	line, err := plain.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data: ") {
+		t.Errorf("the client without markers got %q, %v, want the event", line, err)
+	}
+}
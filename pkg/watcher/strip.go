@@ -0,0 +1,158 @@
+package watcher
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DefaultStripPaths are removed when --strip is given without a value
+var DefaultStripPaths = []string{"metadata.managedFields"}
+
+// stripAliases are shorthand names accepted by --strip
+var stripAliases = map[string]string{
+	"managedFields": "metadata.managedFields",
+}
+
+// fieldStripper removes configured field paths from objects before they are serialized.
+// A path is a dot-separated list of fields, where "[key]" selects a map key containing dots
+// (e.g. metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]) and "[*]" every element of a list.
+type fieldStripper struct {
+	managedFields bool       // strip metadata.managedFields, which is handled without a conversion
+	paths         [][]string // every other path, split into its segments
+}
+
+// newFieldStripper parses the --strip paths, returning nil when nothing is stripped
+func newFieldStripper(paths []string) (*fieldStripper, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	s := &fieldStripper{}
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if alias, ok := stripAliases[path]; ok {
+			path = alias
+		}
+		if path == "metadata.managedFields" {
+			s.managedFields = true
+			continue
+		}
+		segments, err := splitFieldPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --strip path %q: %w", path, err)
+		}
+		s.paths = append(s.paths, segments)
+	}
+	return s, nil
+}
+
+// splitFieldPath splits a path such as spec.containers[*].env into its segments
+func splitFieldPath(path string) ([]string, error) {
+	var segments []string
+	for rest := path; rest != ""; {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [")
+			}
+			segments = append(segments, rest[1:end])
+			rest = strings.TrimPrefix(rest[end+1:], ".")
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty field name")
+			}
+			segments = append(segments, rest[:end])
+			rest = strings.TrimPrefix(rest[end:], ".")
+		}
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return segments, nil
+}
+
+// strip returns a copy of the object without the configured fields; the original, which may be
+// shared with the informer cache, is never modified.
+func (s *fieldStripper) strip(obj runtime.Object) (runtime.Object, error) {
+	obj = obj.DeepCopyObject()
+	if s.managedFields {
+		if objMeta, err := meta.Accessor(obj); err == nil {
+			objMeta.SetManagedFields(nil)
+		}
+	}
+	if len(s.paths) == 0 {
+		return obj, nil
+	}
+	// Typed objects are converted to their unstructured form to remove arbitrary paths, then back again
+	u, isUnstructured := obj.(*unstructured.Unstructured)
+	content := map[string]interface{}(nil)
+	if isUnstructured {
+		content = u.Object
+	} else {
+		var err error
+		if content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range s.paths {
+		removeField(content, path)
+	}
+	if isUnstructured {
+		return u, nil
+	}
+	stripped := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object) // an empty object of the same type
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, stripped); err != nil {
+		return nil, err
+	}
+	return stripped, nil
+}
+
+// dropManagedFields is the informer transform removing metadata.managedFields from the objects before they are cached.
+// The objects have just been decoded, so they are modified in place.
+func dropManagedFields(obj interface{}) (interface{}, error) {
+	if objMeta, err := meta.Accessor(obj); err == nil {
+		objMeta.SetManagedFields(nil)
+	}
+	return obj, nil
+}
+
+// removeField deletes the field at path from the unstructured content, if present
+func removeField(content interface{}, path []string) {
+	switch value := content.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			if path[0] == "*" {
+				clear(value)
+			} else {
+				delete(value, path[0])
+			}
+			return
+		}
+		if path[0] == "*" {
+			for _, child := range value {
+				removeField(child, path[1:])
+			}
+			return
+		}
+		removeField(value[path[0]], path[1:])
+	case []interface{}:
+		if path[0] != "*" {
+			return // only [*] is supported for lists
+		}
+		if len(path) == 1 {
+			return // removing every element would leave an empty list; strip the parent field instead
+		}
+		for _, element := range value {
+			removeField(element, path[1:])
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package watcher
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// managedPod returns a pod with managed fields, annotations, labels and a container with the fake marker in its environment
+func managedPod() *corev1.Pod {
+	pod := fakePod("default", "web")
+	pod.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationApply}}
+	pod.Annotations = map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}", "team": "payments"}
+	pod.Labels = map[string]string{"app": "web"}
+	return pod
+}
+
+func TestStrip(t *testing.T) {
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name": "web", "namespace": "default",
+			"managedFields": []interface{}{map[string]interface{}{"manager": "kubectl"}},
+		},
+		"spec": map[string]interface{}{"replicas": int64(2), "paused": true},
+	}}
+	for _, tc := range []struct {
+		name    string
+		paths   []string
+		obj     runtime.Object
+		want    []string // in the YAML of the stripped object
+		notWant []string
+	}{{
+		name:    "managedFields",
+		paths:   DefaultStripPaths,
+		obj:     managedPod(),
+		want:    []string{"name: web"},
+		notWant: []string{"managedFields", "manager: kubectl"},
+	}, {
+		name:    "managedFields alias",
+		paths:   []string{"managedFields"},
+		obj:     managedPod(),
+		notWant: []string{"managedFields"},
+	}, {
+		name:    "unstructured managedFields",
+		paths:   DefaultStripPaths,
+		obj:     deployment,
+		want:    []string{"replicas: 2"},
+		notWant: []string{"managedFields"},
+	}, {
+		name:    "nested",
+		paths:   []string{"status.phase"},
+		obj:     managedPod(),
+		want:    []string{"reason: ContainerCreating"},
+		notWant: []string{"phase:"},
+	}, {
+		name:    "map key with dots",
+		paths:   []string{"metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]"},
+		obj:     managedPod(),
+		want:    []string{"team: payments", "manager: kubectl"},
+		notWant: []string{"last-applied-configuration"},
+	}, {
+		name:    "every list element",
+		paths:   []string{"spec.containers[*].env"},
+		obj:     managedPod(),
+		want:    []string{"name: app"},
+		notWant: []string{"FakeMarker"},
+	}, {
+		name:    "every map value",
+		paths:   []string{"metadata.labels[*]"},
+		obj:     managedPod(),
+		want:    []string{"team: payments"},
+		notWant: []string{"app: web"},
+	}, {
+		name:    "unstructured nested",
+		paths:   []string{"spec.paused"},
+		obj:     deployment,
+		want:    []string{"replicas: 2"},
+		notWant: []string{"paused"},
+	}, {
+		name:  "unknown paths",
+		paths: []string{"spec.nothing.here", "status.phase.deeper", "spec.containers.env", "spec.containers[*]"},
+		obj:   managedPod(),
+		want:  []string{"phase: Pending", "value: " + FakeMarker, "name: app"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := newFieldStripper(tc.paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			original, err := yaml.Marshal(tc.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stripped, err := s.strip(tc.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := yaml.Marshal(stripped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("stripped object lacks %q:\n%s", want, out)
+				}
+			}
+			for _, notWant := range tc.notWant {
+				if strings.Contains(string(out), notWant) {
+					t.Errorf("stripped object holds %q:\n%s", notWant, out)
+				}
+			}
+			// The original may be shared with the informer cache
+			if after, _ := yaml.Marshal(tc.obj); !bytes.Equal(original, after) {
+				t.Error("the original object was modified")
+			}
+		})
+	}
+}
+
+func TestNewFieldStripper(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		paths   []string
+		wantNil bool
+		wantErr string
+	}{
+		{name: "unset", paths: nil, wantNil: true},
+		{name: "valid", paths: []string{"metadata.managedFields", "spec.containers[*].env", "metadata.annotations[a.b/c]"}},
+		{name: "empty field", paths: []string{"spec..env"}, wantErr: "empty field name"},
+		{name: "unterminated", paths: []string{"metadata.annotations[a.b"}, wantErr: "unterminated ["},
+		{name: "empty", paths: []string{" "}, wantErr: "empty path"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := newFieldStripper(tc.paths)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) || !strings.Contains(err.Error(), "--strip") {
+					t.Fatalf("got error %v, want a --strip one about %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (s == nil) != tc.wantNil {
+				t.Errorf("got stripper %v, want nil: %v", s, tc.wantNil)
+			}
+		})
+	}
+}
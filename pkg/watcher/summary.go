@@ -0,0 +1,170 @@
+package watcher
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Reasons for the watcher to stop, reported by Summary
+const (
+	StopCanceled     = "canceled"      // the context given to Run was canceled, e.g. on SIGINT or SIGTERM
+	StopDeadline     = "deadline"      // the deadline of WithDeadline passed
+	StopDeleted      = "deleted"       // the tracked objects were deleted, with WithStopOnDelete or WithWaitForDeleteAll
+	StopConditionMet = "condition-met" // an object met the condition of WithWaitFor
+	StopMaxEvents    = "max-events"    // the events of WithMaxEvents were emitted
+	StopSnapshot     = "snapshot"      // the objects of WithSnapshot were emitted
+	StopError        = "error"         // the watcher failed
+)
+
+// Summary reports how a run of the watcher went, for the CI jobs and scripts consuming it
+type Summary struct {
+	Started  time.Time `json:"started"`
+	Stopped  time.Time `json:"stopped"`
+	Duration string    `json:"duration"`
+	Reason   string    `json:"reason"`   // why the watcher stopped, one of the Stop reasons
+	ExitCode int       `json:"exitCode"` // that of an *ExitError returned by Run, 1 for other errors, and 0 otherwise
+	Error    string    `json:"error,omitempty"`
+	Matched  int       `json:"matched"` // objects that matched the filters at any time
+	// Events counts the emitted events by type
+	Events map[string]int64 `json:"events"`
+	// Pods are the matched objects, pods or those of WithResource, with their last known state, in order
+	Pods []PodSummary `json:"pods"`
+}
+
+// PodSummary is the last known state of a matched object
+type PodSummary struct {
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Phase     string `json:"phase,omitempty"` // the status.phase of the last revision seen, if it has one
+	Deleted   bool   `json:"deleted,omitempty"`
+}
+
+// summaryRecorder records the run of a watcher for its Summary
+type summaryRecorder struct {
+	mu      sync.Mutex
+	summary Summary
+	pods    map[string]*PodSummary // object key, qualified by its cluster -> its last known state
+}
+
+func newSummaryRecorder() *summaryRecorder {
+	return &summaryRecorder{pods: make(map[string]*PodSummary)}
+}
+
+// start resets the recorder at the start of a run
+func (r *summaryRecorder) start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.summary = Summary{Started: time.Now().UTC(), Events: make(map[string]int64)}
+	r.pods = make(map[string]*PodSummary)
+}
+
+// emitted counts an event emitted to the sinks
+func (r *summaryRecorder) emitted(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.summary.Events != nil {
+		r.summary.Events[event.Type]++
+	}
+}
+
+// update records the state of a matched object
+func (r *summaryRecorder) update(eventType string, m *matchedObject) {
+	objMeta, err := meta.Accessor(m.obj)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pod, ok := r.pods[m.id]
+	if !ok {
+		pod = &PodSummary{Cluster: m.cluster, Namespace: objMeta.GetNamespace(), Name: objMeta.GetName()}
+		r.pods[m.id] = pod
+	}
+	pod.Deleted = eventType == string(watch.Deleted)
+	switch obj := m.obj.(type) {
+	case *corev1.Pod:
+		pod.Phase = string(obj.Status.Phase)
+	case *unstructured.Unstructured:
+		pod.Phase, _, _ = unstructured.NestedString(obj.Object, "status", "phase")
+	}
+}
+
+// stopped records why the watcher stopped, unless already recorded
+func (r *summaryRecorder) stopped(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.summary.Reason == "" {
+		r.summary.Reason = reason
+	}
+}
+
+// finish records the end of a run returning err
+func (r *summaryRecorder) finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.summary.Stopped = time.Now().UTC()
+	r.summary.Duration = r.summary.Stopped.Sub(r.summary.Started).Round(time.Millisecond).String()
+	var exit *ExitError
+	switch {
+	case errors.As(err, &exit):
+		r.summary.ExitCode, r.summary.Error = exit.Code, exit.Message
+	case err != nil:
+		r.summary.ExitCode, r.summary.Error, r.summary.Reason = 1, err.Error(), StopError
+	}
+	if r.summary.Reason == "" {
+		r.summary.Reason = StopCanceled
+	}
+}
+
+// get returns a copy of the summary
+func (r *summaryRecorder) get() *Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary := r.summary
+	summary.Events = make(map[string]int64, len(r.summary.Events))
+	for eventType, n := range r.summary.Events {
+		summary.Events[eventType] = n
+	}
+	summary.Matched = len(r.pods)
+	summary.Pods = make([]PodSummary, 0, len(r.pods))
+	for _, pod := range r.pods {
+		summary.Pods = append(summary.Pods, *pod)
+	}
+	sort.Slice(summary.Pods, func(i, j int) bool {
+		a, b := summary.Pods[i], summary.Pods[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return &summary
+}
+
+// stopReason tells why the processor stopped the watch, if it did, or whether the deadline passed
+func (p *eventProcessor) stopReason(timedOut bool) string {
+	switch {
+	case p.deleted.Load():
+		return StopDeleted
+	case p.satisfied.Load():
+		return StopConditionMet
+	case p.maxEvents > 0 && p.emitted.Load() >= p.maxEvents:
+		return StopMaxEvents
+	case p.snapshotted.Load():
+		return StopSnapshot
+	case timedOut:
+		return StopDeadline
+	default:
+		return StopCanceled
+	}
+}
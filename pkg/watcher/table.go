@@ -0,0 +1,141 @@
+package watcher
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// Columns of the table formats, with the widths they start with; the wide format adds tableWideColumns,
+// --resolve-owners an OWNER column after the NAME, and the events of named clusters are preceded by a CLUSTER column
+var (
+	tableColumns     = []string{"TIME", "EVENT", "NAMESPACE", "NAME", "PHASE", "READY", "RESTARTS", "NODE", "AGE"}
+	tableWidths      = []int{8, 8, 16, 32, 9, 5, 8, 16, 6}
+	tableWideColumns = []string{"IP", "CONDITIONS"}
+	tableWideWidths  = []int{15, 0}
+)
+
+// tableOwnerColumn is the position of the OWNER column, after the NAME, and tableOwnerWidth its initial width
+const (
+	tableOwnerColumn = 4
+	tableOwnerWidth  = 24
+)
+
+// tableNone fills the columns that do not apply to the object, as kubectl does
+const tableNone = "<none>"
+
+// writeTable outputs the event as one line of the table, preceded by the header on the first line.
+// As the rows are written as they come, columns are padded to the widest value seen so far.
+func (e *eventWriter) writeTable(event Event) error {
+	if err := e.writeTableHeader(event); err != nil {
+		return err
+	}
+	row := tableRow(event, e.format == OutputWide)
+	if e.owners {
+		owner := tableNone
+		if event.Owner != nil {
+			owner = event.Owner.String()
+		}
+		row = slices.Insert(row, tableOwnerColumn, owner)
+	}
+	if e.clustered {
+		row = append([]string{orNone(event.Cluster)}, row...)
+	}
+	_, err := fmt.Fprintln(e.w, e.colors.event(event.Type, e.colors.highlight(e.tableLine(row))))
+	return err
+}
+
+// writeTableHeader writes the header line ahead of the first line of the table
+func (e *eventWriter) writeTableHeader(event Event) error {
+	if e.widths != nil {
+		return nil
+	}
+	columns := append([]string{}, tableColumns...)
+	e.widths = append([]int{}, tableWidths...)
+	if e.format == OutputWide {
+		columns = append(columns, tableWideColumns...)
+		e.widths = append(e.widths, tableWideWidths...)
+	}
+	if e.owners {
+		columns = slices.Insert(columns, tableOwnerColumn, "OWNER")
+		e.widths = slices.Insert(e.widths, tableOwnerColumn, tableOwnerWidth)
+	}
+	// Whether the events come from named clusters is known from the first one
+	if e.clustered = event.Cluster != ""; e.clustered {
+		columns = append([]string{"CLUSTER"}, columns...)
+		e.widths = append([]int{12}, e.widths...)
+	}
+	_, err := fmt.Fprintln(e.w, e.colors.paint(ansiBold, e.tableLine(columns)))
+	return err
+}
+
+// tableLine pads the cells to the column widths, widening the columns as needed
+func (e *eventWriter) tableLine(cells []string) string {
+	var line strings.Builder
+	for i, cell := range cells {
+		if i == len(cells)-1 {
+			line.WriteString(cell)
+			break
+		}
+		e.widths[i] = max(e.widths[i], len(cell))
+		line.WriteString(cell)
+		line.WriteString(strings.Repeat(" ", e.widths[i]-len(cell)+3))
+	}
+	return line.String()
+}
+
+// tableRow returns the cells of the event; the pod columns are <none> for other objects
+func tableRow(event Event, wide bool) []string {
+	namespace, name, age := tableNone, event.Key, tableNone
+	if objMeta, err := meta.Accessor(event.Object); err == nil {
+		name = objMeta.GetName()
+		if objMeta.GetNamespace() != "" {
+			namespace = objMeta.GetNamespace()
+		}
+		if created := objMeta.GetCreationTimestamp(); !created.IsZero() {
+			age = duration.HumanDuration(event.Timestamp.Sub(created.Time))
+		}
+	}
+	phase, ready, restarts, node, ip, conditions := tableNone, tableNone, tableNone, tableNone, tableNone, tableNone
+	if pod, ok := event.Object.(*corev1.Pod); ok {
+		if pod.Status.Phase != "" {
+			phase = string(pod.Status.Phase)
+		}
+		readyCount, restartCount := 0, int32(0)
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Ready {
+				readyCount++
+			}
+			restartCount += status.RestartCount
+		}
+		ready = fmt.Sprintf("%d/%d", readyCount, len(pod.Spec.Containers))
+		restarts = strconv.Itoa(int(restartCount))
+		node = orNone(pod.Spec.NodeName)
+		ip = orNone(pod.Status.PodIP)
+		var met []string
+		for _, condition := range pod.Status.Conditions {
+			if condition.Status == corev1.ConditionTrue {
+				met = append(met, string(condition.Type))
+			}
+		}
+		conditions = orNone(strings.Join(met, ","))
+	}
+	row := []string{event.Timestamp.Local().Format("15:04:05"), event.Type, namespace, name, phase, ready, restarts, node, age}
+	if wide {
+		row = append(row, ip, conditions)
+	}
+	return row
+}
+
+// orNone returns the value, or <none> if it is empty
+func orNone(value string) string {
+	if value == "" {
+		return tableNone
+	}
+	return value
+}
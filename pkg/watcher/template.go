@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Template output formats, given as "go-template=TEMPLATE" or "jsonpath=EXPRESSION" like kubectl's --output
+const (
+	OutputGoTemplate = "go-template" // each event rendered through a Go template, e.g. go-template='{{.type}} {{.name}}'
+	OutputJSONPath   = "jsonpath"    // each event rendered through a JSONPath template, e.g. jsonpath='{.type} {.name}'
+)
+
+// outputTemplate renders the JSON envelopes of the events, and the log lines, through a Go or JSONPath template.
+// Templates see the fields of the envelopes as in the jsonl format, e.g. .type, .namespace, .name and .pod.status.phase.
+type outputTemplate struct {
+	goTemplate *template.Template // nil unless go-template
+	jsonPath   *jsonpath.JSONPath // nil unless jsonpath
+}
+
+// parseOutputTemplate compiles the template of a template output format, reporting false for the other formats
+func parseOutputTemplate(format string) (*outputTemplate, bool, error) {
+	kind, text, _ := strings.Cut(format, "=")
+	if kind != OutputGoTemplate && kind != OutputJSONPath {
+		return nil, false, nil
+	}
+	if text == "" {
+		return nil, true, fmt.Errorf("the %s output format requires a template, e.g. %s=%s", kind, kind, exampleTemplate(kind))
+	}
+	if kind == OutputGoTemplate {
+		tmpl, err := template.New("output").Parse(text)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid %s template: %w", kind, err)
+		}
+		return &outputTemplate{goTemplate: tmpl}, true, nil
+	}
+	// Like kubectl, fields missing from an event render as empty rather than failing it
+	jp := jsonpath.New("output").AllowMissingKeys(true)
+	if err := jp.Parse(text); err != nil {
+		return nil, true, fmt.Errorf("invalid %s template: %w", kind, err)
+	}
+	return &outputTemplate{jsonPath: jp}, true, nil
+}
+
+// exampleTemplate returns a template of the kind for error messages
+func exampleTemplate(kind string) string {
+	if kind == OutputGoTemplate {
+		return "'{{.type}} {{.namespace}}/{{.name}}'"
+	}
+	return "'{.type} {.namespace}/{.name}'"
+}
+
+// render renders the document, without the trailing newline of the template if it ends with one
+func (t *outputTemplate) render(document any) (string, error) {
+	// Go through JSON so that the templates see the field names of the JSON formats, not those of the Go types
+	data, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	var fields any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if t.goTemplate != nil {
+		err = t.goTemplate.Execute(&out, fields)
+	} else {
+		err = t.jsonPath.Execute(&out, fields)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(out.String(), "\n"), nil
+}
@@ -0,0 +1,211 @@
+package watcher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// AlertStuckTerminating is the reason of the ALERT events of WithTerminationTracking, raised for a pod
+// still terminating once its threshold has passed since its deletion was requested
+const AlertStuckTerminating = "StuckTerminating"
+
+// TerminationOptions configures the tracking of the deletions of WithTerminationTracking
+type TerminationOptions struct {
+	// StuckThreshold raises an ALERT for a pod still terminating this long after its deletion was requested; zero disables it
+	StuckThreshold time.Duration
+}
+
+// Termination is the deletion of a pod, carried by TERMINATING and FINALIZED events
+type Termination struct {
+	// Requested is when the deletion was requested: the deletionTimestamp of the pod less its grace period
+	Requested time.Time `json:"requested"`
+	// Deadline is the deletionTimestamp of the pod, when the kubelet kills the containers still running
+	Deadline    *time.Time `json:"deadline,omitempty"`
+	GracePeriod string     `json:"gracePeriod,omitempty"`
+	Finalizers  []string   `json:"finalizers,omitempty"` // the finalizers of the pod, which must be removed before it is
+	// Removed is when the pod disappeared from the API server, as observed by the watcher; FINALIZED only
+	Removed *time.Time `json:"removed,omitempty"`
+	// Duration is the time from the request of the deletion to the removal of the pod, e.g. "31.2s"; FINALIZED only
+	Duration string `json:"duration,omitempty"`
+	// Forced tells whether the pod was removed while containers of it were still running, as with
+	// kubectl delete --force --grace-period=0, or with its node lost; FINALIZED only
+	Forced bool `json:"forced,omitempty"`
+}
+
+// String formats the termination compactly, e.g. "finalized in 31.2s (grace period 30s)"
+func (t *Termination) String() string {
+	var s string
+	if t.Removed == nil {
+		s = "deletion requested at " + t.Requested.Format(time.RFC3339)
+	} else {
+		s = "finalized in " + t.Duration
+	}
+	if t.GracePeriod != "" {
+		s += " (grace period " + t.GracePeriod + ")"
+	}
+	if len(t.Finalizers) > 0 {
+		s += fmt.Sprintf(", finalizers %v", t.Finalizers)
+	}
+	if t.Forced {
+		s += ", forced while containers were running"
+	}
+	return s
+}
+
+// podTermination is the deletion in progress of one pod
+type podTermination struct {
+	termination *Termination
+	cluster     string
+	key         string
+	pod         *corev1.Pod // the latest revision, carried by the ALERT event
+	timer       *time.Timer // raises the ALERT once the threshold passes; nil without one
+}
+
+// terminationTracker follows the deletions of the matched pods, from their deletionTimestamp to their removal
+// (--track-termination), raising an ALERT for those still terminating beyond --terminating-threshold
+type terminationTracker struct {
+	options TerminationOptions
+	alert   func(m *matchedObject, pod *corev1.Pod, alerts []*Alert) // emits the ALERT of a stuck pod
+	mu      sync.Mutex
+	pods    map[string]*podTermination // pod key, qualified by its cluster -> its deletion in progress
+	stopped bool
+}
+
+func newTerminationTracker(options TerminationOptions, alert func(m *matchedObject, pod *corev1.Pod, alerts []*Alert)) *terminationTracker {
+	return &terminationTracker{options: options, alert: alert, pods: make(map[string]*podTermination)}
+}
+
+// update records a revision of the pod observed at the given time. It returns the termination of a pod whose deletion
+// was just requested, for its TERMINATING event, and that of a deleted pod, for its FINALIZED event. A pod from
+// the initial list that is already terminating is followed without being reported as such.
+func (t *terminationTracker) update(eventType string, m *matchedObject, pod *corev1.Pod, observed time.Time, baseline bool) (terminating, finalized *Termination) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	current, seen := t.pods[m.id]
+	if eventType == string(watch.Deleted) {
+		termination := newTermination(pod)
+		if seen {
+			if current.timer != nil {
+				current.timer.Stop()
+			}
+			delete(t.pods, m.id)
+			termination.Requested, termination.Deadline = current.termination.Requested, current.termination.Deadline
+			termination.GracePeriod = current.termination.GracePeriod
+		} else if pod.DeletionTimestamp == nil {
+			// Removed at once, as a pod not yet scheduled
+			termination.Requested = observed
+		}
+		duration := max(observed.Sub(termination.Requested), 0).Round(100 * time.Millisecond)
+		termination.Removed, termination.Duration = &observed, duration.String()
+		termination.Forced = pod.Spec.NodeName != "" && containersRunning(pod)
+		terminationDuration.Observe(duration.Seconds())
+		if termination.Forced {
+			forcedDeletions.Inc()
+		}
+		return nil, termination
+	}
+	if seen {
+		current.pod = pod
+		current.termination.Finalizers = pod.Finalizers
+		return nil, nil
+	}
+	if pod.DeletionTimestamp == nil {
+		return nil, nil
+	}
+	current = &podTermination{termination: newTermination(pod), cluster: m.cluster, key: m.key, pod: pod}
+	t.pods[m.id] = current
+	if t.options.StuckThreshold > 0 && !t.stopped {
+		id := m.id
+		current.timer = time.AfterFunc(time.Until(current.termination.Requested.Add(t.options.StuckThreshold)), func() { t.stuck(id) })
+	}
+	if baseline {
+		return nil, nil
+	}
+	return current.termination, nil
+}
+
+// stuck raises the ALERT of a pod still terminating once the threshold has passed
+func (t *terminationTracker) stuck(id string) {
+	t.mu.Lock()
+	current, ok := t.pods[id]
+	if !ok || t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	// Keep the lock while emitting, so that the alert does not race the end of the watch
+	defer t.mu.Unlock()
+	current.timer = nil
+	message := fmt.Sprintf("still terminating %s after its deletion was requested", time.Since(current.termination.Requested).Round(time.Second))
+	if len(current.pod.Finalizers) > 0 {
+		message += fmt.Sprintf(", held by finalizers %v", current.pod.Finalizers)
+	}
+	t.alert(&matchedObject{key: current.key, cluster: current.cluster, id: id}, current.pod,
+		[]*Alert{{Reason: AlertStuckTerminating, Count: 1, Window: t.options.StuckThreshold.String(), Message: message}})
+}
+
+// evict forgets the deletion of the pod, cancelling its alert
+func (t *terminationTracker) evict(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if current, ok := t.pods[key]; ok {
+		if current.timer != nil {
+			current.timer.Stop()
+		}
+		delete(t.pods, key)
+	}
+}
+
+// stop cancels the pending alerts once the watch ends
+func (t *terminationTracker) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	for _, current := range t.pods {
+		if current.timer != nil {
+			current.timer.Stop()
+		}
+	}
+}
+
+// newTermination reads the deletion of the pod from its metadata
+func newTermination(pod *corev1.Pod) *Termination {
+	termination := &Termination{Finalizers: pod.Finalizers}
+	if pod.DeletionTimestamp == nil {
+		return termination
+	}
+	deadline := pod.DeletionTimestamp.Time
+	termination.Requested, termination.Deadline = deadline, &deadline
+	if pod.DeletionGracePeriodSeconds != nil {
+		gracePeriod := time.Duration(*pod.DeletionGracePeriodSeconds) * time.Second
+		termination.Requested, termination.GracePeriod = deadline.Add(-gracePeriod), gracePeriod.String()
+	}
+	return termination
+}
+
+// containersRunning reports whether a container of the pod is running
+func containersRunning(pod *corev1.Pod) bool {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.State.Running != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emitTermination emits the TERMINATING or FINALIZED event of the pod, unless its type is filtered out
+func (p *eventProcessor) emitTermination(eventType string, m *matchedObject, pod *corev1.Pod, termination *Termination) {
+	if termination == nil || (p.eventTypes != nil && !p.eventTypes[eventType]) {
+		return
+	}
+	event := Event{Type: eventType, Key: m.key, Object: pod, Timestamp: time.Now().UTC(), Cluster: m.cluster, Termination: termination}
+	p.sinks.write(event)
+	p.publish(event)
+	eventsEmitted.WithLabelValues(eventType).Inc()
+	p.health.eventEmitted()
+}
@@ -0,0 +1,168 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Reasons for suppressing events, as pod_watcher_events_suppressed_total labels
+const (
+	suppressedDuplicate   = "duplicate"
+	suppressedRateLimited = "rate-limited"
+)
+
+// eventThrottle suppresses MODIFIED events that repeat the last emitted revision of an object (--dedupe),
+// and holds back those arriving within minInterval of the previous emission (--min-interval).
+// A held-back event is replaced by any later one for the same object and emitted once the interval has passed,
+// so the latest revision of every object is still emitted. ADDED, DELETED and RESYNC events are never suppressed.
+type eventThrottle struct {
+	mu          sync.Mutex
+	minInterval time.Duration
+	dedupe      bool
+	emit        func(eventType string, m *matchedObject)
+	objects     map[string]*throttledObject // object key, qualified by its cluster -> what was last emitted
+	timers      sync.WaitGroup              // held-back events being emitted
+	closed      bool
+}
+
+// throttledObject is the emission state of one object
+type throttledObject struct {
+	emitted     time.Time
+	fingerprint [sha256.Size]byte // of the last emitted revision, with --dedupe
+	pending     *matchedObject    // the latest held-back MODIFIED event, if any
+	timer       *time.Timer       // emits the pending event
+}
+
+func newEventThrottle(minInterval time.Duration, dedupe bool, emit func(eventType string, m *matchedObject)) *eventThrottle {
+	return &eventThrottle{minInterval: minInterval, dedupe: dedupe, emit: emit, objects: make(map[string]*throttledObject)}
+}
+
+// shouldEmit reports whether the event should be emitted now, holding it back for later if it is rate limited
+func (t *eventThrottle) shouldEmit(eventType string, m *matchedObject) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.objects[m.id]
+	if eventType == string(watch.Deleted) {
+		if state != nil {
+			t.discardPending(state)
+			delete(t.objects, m.id)
+		}
+		return true
+	}
+	if state == nil {
+		state = &throttledObject{}
+		t.objects[m.id] = state
+	}
+	var fingerprint [sha256.Size]byte
+	if t.dedupe {
+		fingerprint = revisionFingerprint(m.obj)
+	}
+	if eventType != string(watch.Modified) {
+		// ADDED and RESYNC events carry the latest revision, superseding anything held back
+		t.discardPending(state)
+		state.emitted, state.fingerprint = time.Now(), fingerprint
+		return true
+	}
+	if t.dedupe && !state.emitted.IsZero() && fingerprint == state.fingerprint {
+		// Back to the last emitted revision: anything held back in between is outdated
+		t.discardPending(state)
+		eventsSuppressed.WithLabelValues(suppressedDuplicate).Inc()
+		return false
+	}
+	if next := state.emitted.Add(t.minInterval); t.minInterval > 0 && time.Now().Before(next) {
+		if state.pending != nil {
+			eventsSuppressed.WithLabelValues(suppressedRateLimited).Inc()
+		}
+		state.pending = m
+		if state.timer == nil && !t.closed {
+			key := m.id
+			state.timer = time.AfterFunc(time.Until(next), func() { t.emitPending(key) })
+		}
+		return false
+	}
+	t.discardPending(state)
+	state.emitted, state.fingerprint = time.Now(), fingerprint
+	return true
+}
+
+// evict forgets the object, dropping the event held back for it
+func (t *eventThrottle) evict(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state := t.objects[key]; state != nil {
+		t.discardPending(state)
+		delete(t.objects, key)
+	}
+}
+
+// emitPending emits the event held back for the object once its interval has passed
+func (t *eventThrottle) emitPending(key string) {
+	t.mu.Lock()
+	state := t.objects[key]
+	if t.closed || state == nil || state.pending == nil {
+		t.mu.Unlock()
+		return
+	}
+	m := state.pending
+	state.pending, state.timer = nil, nil
+	state.emitted = time.Now()
+	if t.dedupe {
+		state.fingerprint = revisionFingerprint(m.obj)
+	}
+	t.timers.Add(1)
+	t.mu.Unlock()
+	defer t.timers.Done()
+	t.emit(string(watch.Modified), m)
+}
+
+// discardPending drops the event held back for the object, if any
+func (t *eventThrottle) discardPending(state *throttledObject) {
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+	if state.pending != nil {
+		state.pending = nil
+		eventsSuppressed.WithLabelValues(suppressedRateLimited).Inc()
+	}
+}
+
+// flush emits the events still held back, waiting for those already being emitted,
+// so that the latest revision of every object is delivered before the sinks are closed
+func (t *eventThrottle) flush() {
+	t.mu.Lock()
+	t.closed = true
+	var pending []*matchedObject
+	for _, state := range t.objects {
+		if state.timer != nil {
+			state.timer.Stop()
+			state.timer = nil
+		}
+		if state.pending != nil {
+			pending = append(pending, state.pending)
+			state.pending = nil
+		}
+	}
+	t.mu.Unlock()
+	t.timers.Wait()
+	for _, m := range pending {
+		t.emit(string(watch.Modified), m)
+	}
+}
+
+// revisionFingerprint hashes the object without the fields that change on every update
+func revisionFingerprint(obj runtime.Object) [sha256.Size]byte {
+	obj = obj.DeepCopyObject()
+	if objMeta, err := meta.Accessor(obj); err == nil {
+		objMeta.SetResourceVersion("")
+		objMeta.SetManagedFields(nil)
+	}
+	data, _ := json.Marshal(obj)
+	return sha256.Sum256(data)
+}
@@ -0,0 +1,253 @@
+package watcher
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Milestones of the pod timelines of WithTimeline
+const (
+	MilestoneCreated   = "Created"
+	MilestoneScheduled = "Scheduled"    // bound to a node, given as the detail
+	MilestonePulled    = "ImagesPulled" // every container has its image
+	MilestoneStarted   = "Started"      // every container has started
+	MilestoneReady     = "Ready"
+	MilestoneRestarted = "Restarted" // a container restarted, given as the detail with the reason of its termination
+	MilestoneDeleted   = "Deleted"
+)
+
+// Milestone is one step of the lifecycle of a pod
+type Milestone struct {
+	Name   string    `json:"name"`
+	Time   time.Time `json:"time"`
+	Detail string    `json:"detail,omitempty"`
+	// Elapsed is the time since the previous milestone, e.g. "1.5s"; empty for the first one
+	Elapsed string `json:"elapsed,omitempty"`
+}
+
+// Timeline is the lifecycle of a pod, carried by TIMELINE events: its milestones in order,
+// from its creation to its deletion, with the durations between them
+type Timeline struct {
+	Milestones []Milestone `json:"milestones"`
+	Total      string      `json:"total,omitempty"` // from the first milestone to the last
+}
+
+// String formats the timeline with one milestone per line
+func (t *Timeline) String() string {
+	var lines []string
+	for _, m := range t.Milestones {
+		line := fmt.Sprintf("%-12s %s", m.Name, m.Time.Format(time.RFC3339))
+		if m.Elapsed != "" {
+			line += fmt.Sprintf(" (+%s)", m.Elapsed)
+		}
+		if m.Detail != "" {
+			line += " " + m.Detail
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// podTimeline collects the milestones of one pod from its revisions
+type podTimeline struct {
+	pod                                        *corev1.Pod // the latest revision
+	created, scheduled, pulled, started, ready time.Time
+	deleted                                    time.Time
+	node                                       string
+	restarts                                   []Milestone
+	restartCounts                              map[string]int32 // container name -> last restart count
+}
+
+// update records the milestones reached by a revision of the pod observed at the given time
+func (t *podTimeline) update(eventType string, pod *corev1.Pod, observed time.Time) {
+	t.pod = pod
+	if t.created.IsZero() {
+		t.created = pod.CreationTimestamp.Time
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case corev1.PodScheduled:
+			if t.scheduled.IsZero() {
+				t.scheduled, t.node = orObserved(condition.LastTransitionTime.Time, observed), pod.Spec.NodeName
+			}
+		case corev1.PodReady:
+			if t.ready.IsZero() {
+				t.ready = orObserved(condition.LastTransitionTime.Time, observed)
+			}
+		}
+	}
+	statuses := pod.Status.ContainerStatuses
+	if len(statuses) > 0 && len(statuses) == len(pod.Spec.Containers) {
+		pulled, started := true, true
+		var lastStart time.Time
+		for _, status := range statuses {
+			pulled = pulled && status.ImageID != ""
+			switch {
+			case status.State.Running != nil:
+				lastStart = latest(lastStart, status.State.Running.StartedAt.Time)
+			case status.State.Terminated != nil:
+				lastStart = latest(lastStart, status.State.Terminated.StartedAt.Time)
+			default:
+				started = started && status.RestartCount > 0
+			}
+		}
+		// The kubelet does not record when an image was pulled, only that the container has one
+		if pulled && t.pulled.IsZero() {
+			t.pulled = observed
+		}
+		if started && t.started.IsZero() {
+			t.started = orObserved(lastStart, observed)
+		}
+	}
+	if t.restartCounts == nil {
+		t.restartCounts = make(map[string]int32)
+	}
+	for _, status := range slices.Concat(pod.Status.InitContainerStatuses, statuses) {
+		previous, seen := t.restartCounts[status.Name]
+		t.restartCounts[status.Name] = status.RestartCount
+		if !seen || status.RestartCount <= previous {
+			continue
+		}
+		restart := Milestone{Name: MilestoneRestarted, Time: observed, Detail: fmt.Sprintf("container %s (restarts: %d)", status.Name, status.RestartCount)}
+		if last := status.LastTerminationState.Terminated; last != nil {
+			restart.Time = orObserved(last.FinishedAt.Time, observed)
+			restart.Detail = fmt.Sprintf("container %s (exit code %d, %s, restarts: %d)", status.Name, last.ExitCode, last.Reason, status.RestartCount)
+		}
+		t.restarts = append(t.restarts, restart)
+	}
+	if eventType == string(watch.Deleted) {
+		t.deleted = observed
+	}
+}
+
+// timeline returns the milestones reached so far, in order, with the durations between them
+func (t *podTimeline) timeline() *Timeline {
+	var milestones []Milestone
+	add := func(name string, at time.Time, detail string) {
+		if !at.IsZero() {
+			milestones = append(milestones, Milestone{Name: name, Time: at.UTC(), Detail: detail})
+		}
+	}
+	add(MilestoneCreated, t.created, "")
+	add(MilestoneScheduled, t.scheduled, t.node)
+	add(MilestonePulled, t.pulled, "")
+	add(MilestoneStarted, t.started, "")
+	add(MilestoneReady, t.ready, "")
+	for _, restart := range t.restarts {
+		add(restart.Name, restart.Time, restart.Detail)
+	}
+	add(MilestoneDeleted, t.deleted, "")
+	// Observed milestones may be recorded later than those with a timestamp of their own, so order them
+	sort.SliceStable(milestones, func(i, j int) bool { return milestones[i].Time.Before(milestones[j].Time) })
+	timeline := &Timeline{Milestones: milestones}
+	for i := 1; i < len(milestones); i++ {
+		milestones[i].Elapsed = milestones[i].Time.Sub(milestones[i-1].Time).Round(time.Millisecond).String()
+	}
+	if len(milestones) > 1 {
+		timeline.Total = milestones[len(milestones)-1].Time.Sub(milestones[0].Time).Round(time.Millisecond).String()
+	}
+	return timeline
+}
+
+// orObserved returns the time recorded by Kubernetes, or the time the revision was observed if there is none
+func orObserved(recorded time.Time, observed time.Time) time.Time {
+	if recorded.IsZero() {
+		return observed
+	}
+	return recorded
+}
+
+// latest returns the later of two times
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// timelineTracker collects the timelines of the matched pods (--timeline)
+type timelineTracker struct {
+	mu   sync.Mutex
+	pods map[string]*podTimeline // pod key, qualified by its cluster -> its timeline
+}
+
+func newTimelineTracker() *timelineTracker {
+	return &timelineTracker{pods: make(map[string]*podTimeline)}
+}
+
+// update records a revision of the pod, returning its timeline once it has been deleted, and nil until then
+func (t *timelineTracker) update(eventType string, key string, pod *corev1.Pod, observed time.Time) *Timeline {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timeline, ok := t.pods[key]
+	if !ok {
+		timeline = &podTimeline{}
+		t.pods[key] = timeline
+	}
+	timeline.update(eventType, pod, observed)
+	if eventType != string(watch.Deleted) {
+		return nil
+	}
+	delete(t.pods, key)
+	return timeline.timeline()
+}
+
+// evict forgets the pod and its timeline
+func (t *timelineTracker) evict(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pods, key)
+}
+
+// emitTimeline emits the TIMELINE event of a deleted pod, unless its type is filtered out
+func (p *eventProcessor) emitTimeline(m *matchedObject, pod *corev1.Pod, timeline *Timeline) {
+	if timeline == nil || (p.eventTypes != nil && !p.eventTypes[TimelineEvent]) {
+		return
+	}
+	event := Event{Type: TimelineEvent, Key: m.key, Object: pod, Timestamp: time.Now().UTC(), Cluster: m.cluster, Timeline: timeline}
+	p.sinks.write(event)
+	p.publish(event)
+	eventsEmitted.WithLabelValues(TimelineEvent).Inc()
+	p.health.eventEmitted()
+}
+
+// BuildTimelines returns a TIMELINE event with the timeline of each pod of the recorded events, such as those of
+// Store.Query, in the order the pods first appear. The pods need not have been deleted; their timelines end with the
+// last milestone they reached. The events of other objects are ignored.
+func BuildTimelines(events []Event) []Event {
+	var order []string
+	pods := make(map[string]*podTimeline)
+	clusters := make(map[string]string)
+	keys := make(map[string]string)
+	for _, event := range events {
+		pod, ok := event.Object.(*corev1.Pod)
+		if !ok || event.Container != nil || event.Timeline != nil {
+			continue
+		}
+		id := clusterKey(event.Cluster, event.Key)
+		timeline, ok := pods[id]
+		if !ok {
+			timeline = &podTimeline{}
+			pods[id], clusters[id], keys[id] = timeline, event.Cluster, event.Key
+			order = append(order, id)
+		}
+		timeline.update(event.Type, pod, event.Timestamp)
+	}
+	var timelines []Event
+	for _, id := range order {
+		timeline := pods[id]
+		timelines = append(timelines, Event{Type: TimelineEvent, Key: keys[id], Object: timeline.pod, Timestamp: time.Now().UTC(),
+			Cluster: clusters[id], Timeline: timeline.timeline()})
+	}
+	return timelines
+}
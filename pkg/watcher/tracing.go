@@ -0,0 +1,69 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the event pipeline
+const tracerName = "github.com/stephenc/pod-watcher/pkg/watcher"
+
+// tracer creates the spans of the event pipeline; they are dropped unless SetupTracing has installed a provider.
+// Each change received from a watch is the root of a trace, with a span for its wait for a worker, its serialization,
+// and its filtering, and one for its delivery to each sink. Webhook requests carry the W3C trace context of the latter.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// SetupTracing exports the spans of the event pipeline over OTLP/gRPC to the endpoint, e.g. http://otel-collector:4317
+// (https:// for TLS), and returns a function flushing and stopping the exporter. The service name defaults to
+// pod-watcher, and the standard OTEL_* environment variables, such as OTEL_TRACES_SAMPLER, are honored.
+func SetupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("could not create the OTLP exporter: %w", err)
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "pod-watcher")),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the OpenTelemetry resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// startSpan starts a child span of the traced event in ctx; outside of a traced event it does nothing
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan ends the span, recording the error if there is one
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext adds the W3C trace context of ctx, if any, to the headers of an outgoing request
+func injectTraceContext(ctx context.Context, header map[string][]string) {
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(header))
+}
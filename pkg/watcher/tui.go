@@ -0,0 +1,586 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultTUIHistory is the number of events the terminal UI keeps per object unless TUIOptions gives another
+const DefaultTUIHistory = 100
+
+// TUIOptions configures the terminal UI of NewTUI
+type TUIOptions struct {
+	History int // events kept per object; DefaultTUIHistory if zero
+	// ExportDir receives the histories exported with the x key, in a file per object named as by NewDirSink;
+	// the working directory if empty
+	ExportDir string
+}
+
+// TUI is an interactive terminal UI listing the matched objects with their live status. Selecting one shows its
+// latest YAML, the diffs between its revisions and its recent events; keys pause the stream, narrow the list with a
+// filter, and export the history of an object to a file.
+// It is a Sink receiving the events of every watcher it is added to with WithSink, so it outlives the watchers
+// replaced on a config reload: Close leaves it running until Run returns.
+type TUI struct {
+	program *tea.Program
+}
+
+// NewTUI returns a terminal UI drawn on stdout and driven by the keys read from stdin once it is Run
+func NewTUI(options TUIOptions) *TUI {
+	if options.History <= 0 {
+		options.History = DefaultTUIHistory
+	}
+	if options.ExportDir == "" {
+		options.ExportDir = "."
+	}
+	model := &tuiModel{options: options, objects: make(map[string]*tuiObject), colors: newColorizer(nil)}
+	return &TUI{program: tea.NewProgram(model, tea.WithAltScreen())}
+}
+
+// Run draws the UI until it is quit with q or Ctrl+C, or the context is canceled
+func (t *TUI) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, t.program.Quit)
+	defer stop()
+	_, err := t.program.Run()
+	return err
+}
+
+// Write adds the event to the UI, unless it has been quit
+func (t *TUI) Write(event Event) error {
+	t.program.Send(tuiEventMsg(event))
+	return nil
+}
+
+// Close does nothing: the UI keeps showing the events received so far
+func (t *TUI) Close() error {
+	return nil
+}
+
+// String names the sink for logs and metrics
+func (t *TUI) String() string {
+	return "tui"
+}
+
+// Stopped shows that the watcher stopped, with the error it returned if any, leaving the UI to browse until it is quit
+func (t *TUI) Stopped(err error) {
+	text := "Watcher stopped"
+	if err != nil {
+		text += ": " + err.Error()
+	}
+	t.program.Send(tuiStoppedMsg(text))
+}
+
+// LogWriter returns a writer showing the last line written to it in the status line of the UI,
+// which takes the place of stderr for the operational logs while the UI is drawn
+func (t *TUI) LogWriter() io.Writer {
+	return tuiLogWriter{program: t.program}
+}
+
+type tuiLogWriter struct {
+	program *tea.Program
+}
+
+func (w tuiLogWriter) Write(p []byte) (int, error) {
+	lines := strings.Split(strings.TrimRight(string(p), "\n"), "\n")
+	w.program.Send(tuiLogMsg(lines[len(lines)-1]))
+	return len(p), nil
+}
+
+// Messages of the UI, besides the keys and window sizes
+type (
+	tuiEventMsg   Event
+	tuiLogMsg     string
+	tuiStoppedMsg string
+)
+
+// Tabs of the details of an object
+const (
+	tuiYAML = iota
+	tuiDiffs
+	tuiEvents
+)
+
+var tuiTabs = []string{"YAML", "Diffs", "Events"}
+
+// tuiObject is a matched object listed by the UI, with its recent events
+type tuiObject struct {
+	key       string // namespace/name, qualified by the cluster
+	cluster   string
+	namespace string
+	name      string
+	latest    Event // the last revision of the object
+	history   []tuiEntry
+}
+
+// tuiEntry is an event of an object, with the YAML of the object for revisions
+type tuiEntry struct {
+	event Event
+	yaml  string
+}
+
+// tuiModel is the state of the UI, only ever touched by the event loop of the program
+type tuiModel struct {
+	options TUIOptions
+	objects map[string]*tuiObject
+	listed  []string // the keys of the objects passing the filter, in order
+	cursor  int      // the position of the selected object in listed
+	offset  int      // the first listed object on screen
+	current string   // the key of the selected object, which keeps it selected as the list changes
+
+	details bool // whether the details of the selected object are shown instead of the list
+	tab     int
+	scroll  int
+
+	filter  string
+	editing bool // whether the filter is being typed
+	paused  bool
+	held    []Event // received while paused
+
+	status  string // the last log line, or the outcome of the last key
+	stopped string
+	width   int
+	height  int
+	colors  *colorizer
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tuiEventMsg:
+		if m.paused {
+			m.held = append(m.held, Event(msg))
+			break
+		}
+		m.add(Event(msg))
+		m.list()
+	case tuiLogMsg:
+		m.status = string(msg)
+	case tuiStoppedMsg:
+		m.stopped = string(msg)
+	case tea.KeyMsg:
+		if m.editing {
+			m.editFilter(msg)
+			break
+		}
+		return m, m.key(msg.String())
+	}
+	return m, nil
+}
+
+// key handles a key pressed outside of the filter
+func (m *tuiModel) key(key string) tea.Cmd {
+	switch key {
+	case "q", "ctrl+c":
+		return tea.Quit
+	case "p":
+		m.paused = !m.paused
+		if !m.paused {
+			for _, event := range m.held {
+				m.add(event)
+			}
+			m.held = nil
+			m.list()
+		}
+	case "x":
+		m.export()
+	case "/":
+		if !m.details {
+			m.editing = true
+		}
+	case "enter":
+		if !m.details && m.current != "" {
+			m.details, m.tab, m.scroll = true, tuiYAML, 0
+		}
+	case "esc", "backspace":
+		if m.details {
+			m.details = false
+		} else {
+			m.filter = ""
+			m.list()
+		}
+	case "tab", "right", "l":
+		if m.details {
+			m.tab, m.scroll = (m.tab+1)%len(tuiTabs), 0
+		}
+	case "shift+tab", "left", "h":
+		if m.details {
+			m.tab, m.scroll = (m.tab+len(tuiTabs)-1)%len(tuiTabs), 0
+		}
+	case "y", "d", "e":
+		if m.details {
+			m.tab, m.scroll = strings.Index("yde", key), 0
+		}
+	case "up", "k":
+		m.move(-1)
+	case "down", "j":
+		m.move(1)
+	case "pgup":
+		m.move(-m.rows())
+	case "pgdown", " ":
+		m.move(m.rows())
+	case "home", "g":
+		m.move(-len(m.listed) - m.scroll)
+	case "end", "G":
+		m.move(len(m.listed) + len(m.detailLines()))
+	}
+	return nil
+}
+
+// editFilter handles a key pressed while typing the filter, which applies as it is typed
+func (m *tuiModel) editFilter(msg tea.KeyMsg) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.editing = false
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.editing, m.filter = false, ""
+	case tea.KeyBackspace:
+		if m.filter != "" {
+			runes := []rune(m.filter)
+			m.filter = string(runes[:len(runes)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.filter += string(msg.Runes)
+	}
+	m.list()
+}
+
+// move moves the selection in the list, or scrolls the details, by n lines
+func (m *tuiModel) move(n int) {
+	if m.details {
+		m.scroll = max(0, min(m.scroll+n, len(m.detailLines())-m.rows()))
+		return
+	}
+	if len(m.listed) == 0 {
+		return
+	}
+	m.cursor = max(0, min(m.cursor+n, len(m.listed)-1))
+	m.current = m.listed[m.cursor]
+}
+
+// rows is the number of lines of the list or the details that fit on screen, between the header and the status line
+func (m *tuiModel) rows() int {
+	return max(1, m.height-3)
+}
+
+// add records an event in the history of its object
+func (m *tuiModel) add(event Event) {
+	cluster, namespace, name := eventSubject(event)
+	key := clusterKey(cluster, name)
+	if namespace != "" {
+		key = clusterKey(cluster, namespace+"/"+name)
+	}
+	object, ok := m.objects[key]
+	if !ok {
+		if event.Type == KubeEvent {
+			return // about an object that is no longer listed
+		}
+		object = &tuiObject{key: key, cluster: cluster, namespace: namespace, name: name}
+		m.objects[key] = object
+	}
+	entry := tuiEntry{event: event}
+	if !event.notice() && event.Type != KubeEvent {
+		entry.yaml = event.yaml
+		if entry.yaml == "" {
+			if data, err := yaml.Marshal(event.Object); err == nil {
+				entry.yaml = string(data)
+			}
+		}
+		object.latest = event
+	}
+	object.history = append(object.history, entry)
+	if len(object.history) > m.options.History {
+		object.history = object.history[len(object.history)-m.options.History:]
+	}
+}
+
+// list lists the objects passing the filter in order, keeping the selected object selected
+func (m *tuiModel) list() {
+	filter := strings.ToLower(m.filter)
+	m.listed = m.listed[:0]
+	for key, object := range m.objects {
+		if filter == "" || strings.Contains(strings.ToLower(strings.Join(m.cells(object), " ")), filter) {
+			m.listed = append(m.listed, key)
+		}
+	}
+	sort.Strings(m.listed)
+	m.cursor = min(m.cursor, max(0, len(m.listed)-1))
+	for i, key := range m.listed {
+		if key == m.current {
+			m.cursor = i
+		}
+	}
+	if len(m.listed) == 0 {
+		m.current = ""
+		return
+	}
+	m.current = m.listed[m.cursor]
+}
+
+// cells returns the columns of the row of an object in the list, those of the table output format
+func (m *tuiModel) cells(object *tuiObject) []string {
+	row := tableRow(object.latest, false)
+	row[3] = object.name // even when the object has no revision yet
+	if object.cluster != "" {
+		row = append([]string{object.cluster}, row...)
+	}
+	return row
+}
+
+func (m *tuiModel) View() string {
+	if m.width == 0 {
+		return "" // until the size of the terminal is known
+	}
+	var lines []string
+	if m.details {
+		lines = m.viewDetails()
+	} else {
+		lines = m.viewList()
+	}
+	for len(lines) < m.height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:m.height-1], m.footer())
+	return strings.Join(lines, "\n")
+}
+
+// viewList draws the list of the objects, with a header and the columns of the table output format
+func (m *tuiModel) viewList() []string {
+	title := fmt.Sprintf("pod-watcher: %d objects", len(m.objects))
+	if m.filter != "" || m.editing {
+		title += fmt.Sprintf(", %d shown, filter: %s", len(m.listed), m.filter)
+		if m.editing {
+			title += "_"
+		}
+	}
+	lines := []string{m.title(title)}
+	columns := append([]string{}, tableColumns...)
+	rows := make([][]string, 0, m.rows())
+	m.offset = max(0, min(m.offset, m.cursor, len(m.listed)-m.rows()))
+	if m.cursor >= m.offset+m.rows() {
+		m.offset = m.cursor - m.rows() + 1
+	}
+	clustered := false
+	for _, key := range m.listed[m.offset:min(len(m.listed), m.offset+m.rows())] {
+		object := m.objects[key]
+		clustered = clustered || object.cluster != ""
+		rows = append(rows, m.cells(object))
+	}
+	if clustered {
+		columns = append([]string{"CLUSTER"}, columns...)
+		for i, row := range rows {
+			if len(row) < len(columns) {
+				rows[i] = append([]string{tableNone}, row...)
+			}
+		}
+	}
+	widths := make([]int, len(columns))
+	for _, row := range append([][]string{columns}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	lines = append(lines, m.colors.paint(ansiBold, m.truncate(tuiRow(columns, widths))))
+	for i, row := range rows {
+		object := m.objects[m.listed[m.offset+i]]
+		line := m.colors.event(object.latest.Type, m.truncate(tuiRow(row, widths)))
+		if m.offset+i == m.cursor {
+			line = "\x1b[7m" + line + ansiReset
+		}
+		lines = append(lines, line)
+	}
+	if len(m.listed) == 0 {
+		lines = append(lines, m.colors.paint(ansiGray, "No matched objects yet"))
+	}
+	return lines
+}
+
+// tuiRow pads the cells of a row to the column widths
+func tuiRow(cells []string, widths []int) string {
+	var line strings.Builder
+	for i, cell := range cells {
+		line.WriteString(cell)
+		if i < len(cells)-1 {
+			line.WriteString(strings.Repeat(" ", widths[i]-len(cell)+3))
+		}
+	}
+	return line.String()
+}
+
+// viewDetails draws the tab shown of the selected object
+func (m *tuiModel) viewDetails() []string {
+	object := m.objects[m.current]
+	var tabs []string
+	for i, tab := range tuiTabs {
+		if i == m.tab {
+			tab = "\x1b[7m " + tab + " " + ansiReset
+		} else {
+			tab = " " + tab + " "
+		}
+		tabs = append(tabs, tab)
+	}
+	lines := []string{m.title(object.key) + "   " + strings.Join(tabs, " ")}
+	body := m.detailLines()
+	m.scroll = max(0, min(m.scroll, len(body)-m.rows()))
+	return append(lines, body[m.scroll:min(len(body), m.scroll+m.rows())]...)
+}
+
+// detailLines returns the lines of the tab shown of the selected object, truncated to the width of the screen and colored
+func (m *tuiModel) detailLines() []string {
+	object, ok := m.objects[m.current]
+	if !m.details || !ok {
+		return nil
+	}
+	var lines []string
+	switch m.tab {
+	case tuiYAML:
+		for _, line := range splitLines(tuiLatestYAML(object)) {
+			lines = append(lines, m.truncate(line))
+		}
+	case tuiDiffs:
+		// The newest change first, each against the revision before it
+		var revisions []tuiEntry
+		for _, entry := range object.history {
+			if entry.yaml != "" {
+				revisions = append(revisions, entry)
+			}
+		}
+		for i := len(revisions) - 1; i >= 0; i-- {
+			event := revisions[i].event
+			lines = append(lines, m.colors.event(event.Type, m.colors.paint(ansiBold, m.truncate(fmt.Sprintf("## %s %s", event.Timestamp.Local().Format("15:04:05"), event.Type)))))
+			diff := "(the first revision received)"
+			if i > 0 {
+				if diff = unifiedDiff(revisions[i-1].yaml, revisions[i].yaml); diff == "" {
+					diff = "(unchanged)"
+				}
+			}
+			for _, line := range splitLines(diff) {
+				lines = append(lines, m.colors.diff(m.truncate(line)))
+			}
+			lines = append(lines, "")
+		}
+	case tuiEvents:
+		// The newest event first
+		for i := len(object.history) - 1; i >= 0; i-- {
+			event := object.history[i].event
+			text := strings.Split(tuiEventText(event), "\n")
+			for j, line := range text {
+				prefix := strings.Repeat(" ", 21)
+				if j == 0 {
+					prefix = fmt.Sprintf("%s  %-9s  ", event.Timestamp.Local().Format("15:04:05"), event.Type)
+				}
+				lines = append(lines, m.colors.event(event.Type, m.truncate(prefix+line)))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, m.colors.paint(ansiGray, "Nothing received yet"))
+	}
+	return lines
+}
+
+// tuiLatestYAML returns the YAML of the last revision of the object
+func tuiLatestYAML(object *tuiObject) string {
+	for i := len(object.history) - 1; i >= 0; i-- {
+		if object.history[i].yaml != "" {
+			return object.history[i].yaml
+		}
+	}
+	return ""
+}
+
+// tuiEventText describes an event in the events of an object: a revision by the status of the object,
+// a Kubernetes Event by its reason and message, and a notice by its text
+func tuiEventText(event Event) string {
+	if event.notice() {
+		var lines []string
+		for _, line := range strings.Split(noticeText(event), "\n") {
+			lines = append(lines, strings.TrimPrefix(strings.TrimPrefix(line, "##"), " "))
+		}
+		return strings.Join(lines, "\n")
+	}
+	if kubeEvent, ok := event.Object.(*corev1.Event); ok {
+		return fmt.Sprintf("%s %s: %s", kubeEvent.Type, kubeEvent.Reason, kubeEvent.Message)
+	}
+	row := tableRow(event, false)
+	text := fmt.Sprintf("phase %s, ready %s, restarts %s, node %s", row[4], row[5], row[6], row[7])
+	for _, change := range event.Changes {
+		text += "\nchanged by " + change.String()
+	}
+	return text
+}
+
+// title draws a header line
+func (m *tuiModel) title(text string) string {
+	return m.colors.paint(ansiBold, m.truncate(text))
+}
+
+// footer draws the status line: the state of the stream, then the last log line or the keys
+func (m *tuiModel) footer() string {
+	var state []string
+	if m.paused {
+		state = append(state, fmt.Sprintf("PAUSED (%d held)", len(m.held)))
+	}
+	if m.stopped != "" {
+		state = append(state, m.stopped)
+	}
+	help := "↑/↓ select  enter details  / filter  p pause  x export  q quit"
+	if m.details {
+		help = "↑/↓ scroll  tab y/d/e switch  esc back  p pause  x export  q quit"
+	}
+	text := help
+	if m.status != "" {
+		text = m.status
+	}
+	if len(state) > 0 {
+		return m.colors.paint(ansiYellow, m.truncate(strings.Join(state, ", ")+"  "+text))
+	}
+	return m.colors.paint(ansiGray, m.truncate(text))
+}
+
+// truncate cuts a line to the width of the screen
+func (m *tuiModel) truncate(line string) string {
+	line = strings.ReplaceAll(line, "\t", "    ")
+	runes := []rune(line)
+	if len(runes) <= m.width {
+		return line
+	}
+	return string(runes[:max(0, m.width-1)]) + "…"
+}
+
+// export writes the history of the selected object to its file in the export directory, as the YAML stream of NewDirSink
+func (m *tuiModel) export() {
+	object, ok := m.objects[m.current]
+	if !ok || len(object.history) == 0 {
+		return
+	}
+	sink, err := NewDirSink(m.options.ExportDir, OutputYAML, false)
+	if err != nil {
+		m.status = "Could not export: " + err.Error()
+		return
+	}
+	defer sink.Close()
+	for _, entry := range object.history {
+		if err := sink.Write(entry.event); err != nil {
+			m.status = "Could not export: " + err.Error()
+			return
+		}
+	}
+	path := sink.(*dirSink).path(object.history[0].event)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	m.status = fmt.Sprintf("Exported %d events of %s to %s", len(object.history), object.key, path)
+}
@@ -0,0 +1,240 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// DefaultMetricsInterval is how often the resource usage is polled unless UsageOptions gives an interval
+const DefaultMetricsInterval = 30 * time.Second
+
+// UsageOptions configures the resource usage enrichment of WithResourceUsage
+type UsageOptions struct {
+	Interval time.Duration // how often the metrics server is polled; DefaultMetricsInterval if zero
+	Events   bool          // also emit a METRICS event with the usage of every matched pod after each poll
+}
+
+// PodUsage is the resource usage of a pod as last reported by the metrics server
+type PodUsage struct {
+	Timestamp  time.Time        `json:"timestamp"` // of the measurement
+	Window     string           `json:"window"`    // over which the CPU usage was averaged
+	CPU        string           `json:"cpu"`       // of all the containers, e.g. "250m"
+	Memory     string           `json:"memory"`    // of all the containers, e.g. "128Mi"
+	Containers []ContainerUsage `json:"containers"`
+}
+
+// ContainerUsage is the resource usage of one container of a pod
+type ContainerUsage struct {
+	Name   string `json:"name"`
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+}
+
+// String formats the usage compactly, e.g. "cpu=250m memory=128Mi (app: cpu=200m memory=100Mi, sidecar: ...)"
+func (u *PodUsage) String() string {
+	text := fmt.Sprintf("cpu=%s memory=%s", u.CPU, u.Memory)
+	var containers []string
+	for _, c := range u.Containers {
+		containers = append(containers, fmt.Sprintf("%s: cpu=%s memory=%s", c.Name, c.CPU, c.Memory))
+	}
+	if len(containers) > 1 {
+		text += " (" + strings.Join(containers, ", ") + ")"
+	}
+	return text
+}
+
+// podMetricsList is the list of the PodMetrics of the metrics.k8s.io API, decoded without depending on its client
+type podMetricsList struct {
+	Items []struct {
+		Metadata   metav1.ObjectMeta `json:"metadata"`
+		Timestamp  metav1.Time       `json:"timestamp"`
+		Window     metav1.Duration   `json:"window"`
+		Containers []struct {
+			Name  string              `json:"name"`
+			Usage corev1.ResourceList `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// usageTracker polls the metrics.k8s.io API of every watched cluster for the usage of the matched pods (--include-metrics),
+// which is added to their events. With events, the usage of every matched pod is also emitted after each poll.
+type usageTracker struct {
+	clusters []*cluster
+	interval time.Duration
+	matched  func(id string) bool // whether the pod, qualified by its cluster, currently matches
+
+	mu     sync.Mutex
+	usage  map[string]*PodUsage      // pod key, qualified by its cluster -> its latest usage
+	pods   map[string]*matchedObject // the matched pods, for the METRICS events; nil without them
+	failed map[string]bool           // clusters whose last poll failed, whose failures are only logged once
+
+	cancel context.CancelFunc // stops the polls started by start
+	done   chan struct{}      // closed once they have stopped
+}
+
+func newUsageTracker(options UsageOptions, clusters []*cluster, matched func(string) bool) *usageTracker {
+	if options.Interval <= 0 {
+		options.Interval = DefaultMetricsInterval
+	}
+	u := &usageTracker{clusters: clusters, interval: options.Interval, matched: matched, usage: make(map[string]*PodUsage), failed: make(map[string]bool)}
+	if options.Events {
+		u.pods = make(map[string]*matchedObject)
+	}
+	return u
+}
+
+// get returns the latest usage of the pod, or nil if none is known yet
+func (u *usageTracker) get(id string) *PodUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.usage[id]
+}
+
+// update records the latest revision of a matched pod for the METRICS events, or forgets it once deleted
+func (u *usageTracker) update(eventType string, m *matchedObject) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if eventType == string(watch.Deleted) {
+		delete(u.usage, m.id)
+		delete(u.pods, m.id)
+		return
+	}
+	if u.pods != nil {
+		u.pods[m.id] = m
+	}
+}
+
+// evict forgets the pod and its usage
+func (u *usageTracker) evict(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.usage, id)
+	delete(u.pods, id)
+}
+
+// start polls the usage in the background until the context is canceled or stop is called
+func (u *usageTracker) start(ctx context.Context, emit func(m *matchedObject, usage *PodUsage)) {
+	ctx, u.cancel = context.WithCancel(ctx)
+	u.done = make(chan struct{})
+	go func() {
+		defer close(u.done)
+		u.run(ctx, emit)
+	}()
+}
+
+// stop stops the polls and waits for them, so that no usage is handed to emit afterwards
+func (u *usageTracker) stop() {
+	if u.cancel == nil {
+		return
+	}
+	u.cancel()
+	<-u.done
+}
+
+// run polls the usage until the context is canceled, handing the usage of every tracked pod to emit after each poll
+func (u *usageTracker) run(ctx context.Context, emit func(m *matchedObject, usage *PodUsage)) {
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	for {
+		u.poll(ctx)
+		u.mu.Lock()
+		var polled []*matchedObject
+		for id, m := range u.pods {
+			if !u.matched(id) {
+				delete(u.pods, id)
+			} else if u.usage[id] != nil {
+				polled = append(polled, m)
+			}
+		}
+		u.mu.Unlock()
+		for _, m := range polled {
+			if usage := u.get(m.id); usage != nil && ctx.Err() == nil {
+				emit(m, usage)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll fetches the usage of the pods of every watched namespace of every cluster, keeping that of the matched pods
+func (u *usageTracker) poll(ctx context.Context) {
+	usage := make(map[string]*PodUsage)
+	for _, c := range u.clusters {
+		for _, namespace := range c.watched {
+			list, err := u.list(ctx, c, namespace)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				if !u.failed[c.name] {
+					slog.Warn("Could not get the resource usage of the pods from the metrics server", "namespace", namespaceList([]string{namespace}), "cluster", c.name, "error", err)
+				}
+				u.failed[c.name] = true
+				continue
+			}
+			u.failed[c.name] = false
+			for _, item := range list.Items {
+				id := clusterKey(c.name, item.Metadata.Namespace+"/"+item.Metadata.Name)
+				if !u.matched(id) {
+					continue
+				}
+				pod := &PodUsage{Timestamp: item.Timestamp.UTC(), Window: item.Window.Duration.String()}
+				var cpu, memory resource.Quantity
+				for _, container := range item.Containers {
+					containerCPU, containerMemory := container.Usage[corev1.ResourceCPU], container.Usage[corev1.ResourceMemory]
+					cpu.Add(containerCPU)
+					memory.Add(containerMemory)
+					pod.Containers = append(pod.Containers, ContainerUsage{Name: container.Name, CPU: containerCPU.String(), Memory: containerMemory.String()})
+				}
+				pod.CPU, pod.Memory = cpu.String(), memory.String()
+				usage[id] = pod
+			}
+		}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.usage = usage
+}
+
+// list gets the PodMetrics of a namespace of the cluster (metav1.NamespaceAll for every namespace), always in JSON
+func (u *usageTracker) list(ctx context.Context, c *cluster, namespace string) (*podMetricsList, error) {
+	path := "/apis/metrics.k8s.io/v1beta1/pods"
+	if namespace != metav1.NamespaceAll {
+		path = "/apis/metrics.k8s.io/v1beta1/namespaces/" + namespace + "/pods"
+	}
+	data, err := c.clientset.CoreV1().RESTClient().Get().AbsPath(path).SetHeader("Accept", "application/json").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := &podMetricsList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("could not decode the pod metrics: %w", err)
+	}
+	return list, nil
+}
+
+// emitUsage emits the METRICS event of a pod that still matches, unless its type is filtered out
+func (p *eventProcessor) emitUsage(m *matchedObject, usage *PodUsage) {
+	if p.eventTypes != nil && !p.eventTypes[MetricsEvent] || !p.matched.contains(m.id) {
+		return
+	}
+	event := Event{Type: MetricsEvent, Key: m.key, Object: m.obj, Timestamp: time.Now().UTC(), Cluster: m.cluster, Usage: usage}
+	p.sinks.write(event)
+	p.publish(event)
+	eventsEmitted.WithLabelValues(MetricsEvent).Inc()
+	p.health.eventEmitted()
+}
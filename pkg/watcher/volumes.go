@@ -0,0 +1,283 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Kinds of the storage objects followed with WithVolumeTracking
+const (
+	claimKind      = "PersistentVolumeClaim"
+	attachmentKind = "VolumeAttachment"
+)
+
+// VolumeChange is a change to the state of a volume of a matched pod, carried by VOLUME events:
+// to the PersistentVolumeClaim it mounts, or to the VolumeAttachment of the volume bound to the claim
+type VolumeChange struct {
+	Kind   string `json:"kind"`   // PersistentVolumeClaim or VolumeAttachment
+	Name   string `json:"name"`   // of the claim or the attachment
+	Claim  string `json:"claim"`  // the claim mounted by the pod, in its namespace
+	Change string `json:"change"` // ADDED, MODIFIED or DELETED
+	// Status is the phase of a claim, Pending, Bound or Lost, or whether an attachment is attached or detached
+	Status string `json:"status"`
+	Volume string `json:"volume,omitempty"` // the PersistentVolume bound to the claim
+	Node   string `json:"node,omitempty"`   // the node of the attachment
+	Error  string `json:"error,omitempty"`  // the attach or detach error of the attachment
+}
+
+// String formats the change compactly, e.g. "PersistentVolumeClaim data-web-0 MODIFIED: Bound (volume pvc-3f2a)"
+// or "VolumeAttachment csi-8d1e (claim data-web-0) MODIFIED: detached on node-3, error: timed out waiting for external-attacher"
+func (v *VolumeChange) String() string {
+	text := v.Kind + " " + v.Name
+	if v.Kind == attachmentKind {
+		text += " (claim " + v.Claim + ")"
+	}
+	text += " " + v.Change + ": " + v.Status
+	if v.Kind == claimKind && v.Volume != "" {
+		text += " (volume " + v.Volume + ")"
+	}
+	if v.Node != "" {
+		text += " on " + v.Node
+	}
+	if v.Error != "" {
+		text += ", error: " + v.Error
+	}
+	return text
+}
+
+// podClaims lists the PersistentVolumeClaims a pod mounts, as kind/name, including those of its generic ephemeral volumes
+func podClaims(pod *corev1.Pod) []string {
+	var claims []string
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			claims = append(claims, claimKind+"/"+volume.PersistentVolumeClaim.ClaimName)
+		case volume.Ephemeral != nil:
+			claims = append(claims, claimKind+"/"+pod.Name+"-"+volume.Name) // named after the pod and the volume
+		}
+	}
+	return claims
+}
+
+// volumeTracker follows the PersistentVolumeClaims of the matched pods and the VolumeAttachments of the volumes bound
+// to them (--track-volumes), so that a change to the state of a volume is reported to every matched pod mounting it
+type volumeTracker struct {
+	*podIndex
+
+	mu      sync.Mutex
+	volumes map[string]string // PersistentVolume, qualified by its cluster -> the namespace/name of the claim bound to it
+}
+
+func newVolumeTracker(matched func(string) bool) *volumeTracker {
+	return &volumeTracker{podIndex: newPodIndex(podClaims, matched), volumes: make(map[string]string)}
+}
+
+// bind records the volume bound to a claim, or forgets it once the claim is deleted
+func (t *volumeTracker) bind(cluster string, claim *corev1.PersistentVolumeClaim, deleted bool) {
+	if claim.Spec.VolumeName == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if deleted {
+		delete(t.volumes, clusterKey(cluster, claim.Spec.VolumeName))
+	} else {
+		t.volumes[clusterKey(cluster, claim.Spec.VolumeName)] = claim.Namespace + "/" + claim.Name
+	}
+}
+
+// claim returns the namespace/name of the claim bound to the volume, or an empty string if it is not known
+func (t *volumeTracker) claim(cluster, volume string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.volumes[clusterKey(cluster, volume)]
+}
+
+// trimClaim keeps only the fields of a claim reported in VolumeChange, which keeps the cache small
+func trimClaim(obj interface{}) (interface{}, error) {
+	claim, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return obj, nil // a tombstone
+	}
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: trimmedMeta(claim.ObjectMeta),
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: claim.Spec.VolumeName},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: claim.Status.Phase},
+	}, nil
+}
+
+// claimChange describes the state of a claim, reporting whether it differs from its previous revision, if any
+func claimChange(change string, previous, current *corev1.PersistentVolumeClaim) (*VolumeChange, bool) {
+	v := &VolumeChange{Kind: claimKind, Name: current.Name, Claim: current.Name, Change: change,
+		Status: string(current.Status.Phase), Volume: current.Spec.VolumeName}
+	if previous == nil {
+		return v, true
+	}
+	return v, previous.Status.Phase != current.Status.Phase || previous.Spec.VolumeName != current.Spec.VolumeName
+}
+
+// attachmentChange describes the state of an attachment, reporting whether it differs from its previous revision, if any
+func attachmentChange(change, claim string, previous, current *storagev1.VolumeAttachment) (*VolumeChange, bool) {
+	v := &VolumeChange{Kind: attachmentKind, Name: current.Name, Claim: claim, Change: change,
+		Status: attachmentStatus(current), Node: current.Spec.NodeName, Error: attachmentError(current)}
+	if previous == nil {
+		return v, true
+	}
+	return v, attachmentStatus(previous) != v.Status || attachmentError(previous) != v.Error
+}
+
+func attachmentStatus(attachment *storagev1.VolumeAttachment) string {
+	if attachment.Status.Attached {
+		return "attached"
+	}
+	return "detached"
+}
+
+// attachmentError returns the latest attach or detach error of the attachment, if any
+func attachmentError(attachment *storagev1.VolumeAttachment) string {
+	if attachment.Status.DetachError != nil {
+		return attachment.Status.DetachError.Message
+	}
+	if attachment.Status.AttachError != nil {
+		return attachment.Status.AttachError.Message
+	}
+	return ""
+}
+
+// runClaimInformer watches the PersistentVolumeClaims in one namespace (metav1.NamespaceAll for every namespace)
+// of a cluster, reporting the changes to those mounted by matched pods until the context is canceled.
+// The claims of the initial list are only recorded, to relate their volumes to them.
+func (w *Watcher) runClaimInformer(ctx context.Context, c *cluster, namespace string, processor *eventProcessor) error {
+	claims := c.clientset.CoreV1().PersistentVolumeClaims(namespace)
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return claims.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			if timeout := watchTimeoutSeconds(w.watchTimeout); timeout != nil {
+				options.TimeoutSeconds = timeout
+			}
+			return claims.Watch(ctx, options)
+		},
+	}, &corev1.PersistentVolumeClaim{}, 0, cache.Indexers{})
+	if err := informer.SetTransform(trimClaim); err != nil {
+		return fmt.Errorf("could not register claim transform: %w", err)
+	}
+	changed := func(change string, previous, current interface{}) {
+		if tombstone, ok := current.(cache.DeletedFinalStateUnknown); ok {
+			current = tombstone.Obj
+		}
+		claim, ok := current.(*corev1.PersistentVolumeClaim)
+		if !ok {
+			return
+		}
+		processor.volumes.bind(c.name, claim, change == string(watch.Deleted))
+		previousClaim, _ := previous.(*corev1.PersistentVolumeClaim)
+		if v, differs := claimChange(change, previousClaim, claim); differs {
+			processor.emitVolume(c.name, claim.Namespace, v)
+		}
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if isInInitialList {
+				if claim, ok := obj.(*corev1.PersistentVolumeClaim); ok {
+					processor.volumes.bind(c.name, claim, false)
+				}
+				return
+			}
+			changed(string(watch.Added), nil, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !sameResourceVersion(oldObj, newObj) {
+				changed(string(watch.Modified), oldObj, newObj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			changed(string(watch.Deleted), nil, obj)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not register claim handler: %w", err)
+	}
+	informer.Run(ctx.Done())
+	return nil
+}
+
+// runAttachmentInformer watches the VolumeAttachments of a cluster, which are cluster-scoped, reporting the changes
+// to those of the volumes bound to claims mounted by matched pods until the context is canceled
+func (w *Watcher) runAttachmentInformer(ctx context.Context, c *cluster, processor *eventProcessor) error {
+	attachments := c.clientset.StorageV1().VolumeAttachments()
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return attachments.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			if timeout := watchTimeoutSeconds(w.watchTimeout); timeout != nil {
+				options.TimeoutSeconds = timeout
+			}
+			return attachments.Watch(ctx, options)
+		},
+	}, &storagev1.VolumeAttachment{}, 0, cache.Indexers{})
+	changed := func(change string, previous, current interface{}) {
+		if tombstone, ok := current.(cache.DeletedFinalStateUnknown); ok {
+			current = tombstone.Obj
+		}
+		attachment, ok := current.(*storagev1.VolumeAttachment)
+		if !ok || attachment.Spec.Source.PersistentVolumeName == nil {
+			return // inline volumes have no claim
+		}
+		claim := processor.volumes.claim(c.name, *attachment.Spec.Source.PersistentVolumeName)
+		if claim == "" {
+			return
+		}
+		namespace, name, _ := strings.Cut(claim, "/")
+		previousAttachment, _ := previous.(*storagev1.VolumeAttachment)
+		if v, differs := attachmentChange(change, name, previousAttachment, attachment); differs {
+			processor.emitVolume(c.name, namespace, v)
+		}
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				changed(string(watch.Added), nil, obj)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !sameResourceVersion(oldObj, newObj) {
+				changed(string(watch.Modified), oldObj, newObj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			changed(string(watch.Deleted), nil, obj)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not register volume attachment handler: %w", err)
+	}
+	informer.Run(ctx.Done())
+	return nil
+}
+
+// emitVolume emits a VOLUME event to each matched pod mounting the claim of the change, unless its type is filtered out
+func (p *eventProcessor) emitVolume(cluster, namespace string, volume *VolumeChange) {
+	if p.eventTypes != nil && !p.eventTypes[VolumeEvent] {
+		return
+	}
+	for _, m := range p.volumes.referencing(referenceID(cluster, claimKind, namespace, volume.Claim)) {
+		event := Event{Type: VolumeEvent, Key: m.key, Object: m.obj, Timestamp: time.Now().UTC(), Cluster: m.cluster, Volume: volume}
+		p.sinks.write(event)
+		p.publish(event)
+		eventsEmitted.WithLabelValues(VolumeEvent).Inc()
+		p.health.eventEmitted()
+	}
+}
@@ -0,0 +1,1463 @@
+// Package watcher watches Kubernetes pods, or any other resource, for objects matching marker strings and selectors,
+// and emits every change to the matching objects as an event.
+//
+// A Watcher is configured with functional options and started with Run:
+//
+//	w, err := watcher.New(config,
+//		watcher.WithNamespaces("team-a"),
+//		watcher.WithMarkers("DEBUG_MODE"),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	go func() {
+//		for event := range w.Events() {
+//			log.Printf("%s %s", event.Type, event.Key)
+//		}
+//	}()
+//	return w.Run(ctx)
+//
+// Besides the Events channel, the events are delivered to any number of sinks: the built-in ones write to
+// an output stream or file (WithOutput, WithOutputFile) or POST to a webhook (WithWebhook), and WithSink adds
+// any other implementation of the Sink interface. Each event can also be handed to a command (WithExec).
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Event types beyond the watch.EventType values ADDED, MODIFIED and DELETED
+const (
+	ResyncEvent    = "RESYNC"    // a cached object re-delivered by the periodic resync
+	KubeEvent      = "EVENT"     // a Kubernetes Event about a matched pod, with WithKubernetesEvents
+	ContainerEvent = "CONTAINER" // a change of the status of a container of a matched pod, with WithContainerTracking
+	TimelineEvent  = "TIMELINE"  // the lifecycle of a deleted pod, with WithTimeline
+	AlertEvent     = "ALERT"     // a matched pod restarting or losing readiness too often, with WithAlerts
+	MetricsEvent   = "METRICS"   // the resource usage of a matched pod, polled from the metrics server, with WithResourceUsage
+	ReferenceEvent = "REFERENCE" // a change to a ConfigMap or Secret referenced by a matched pod, with WithReferences
+	VolumeEvent    = "VOLUME"    // a change to the state of a volume of a matched pod, with WithVolumeTracking
+	// TerminatingEvent is the start of the deletion of a matched pod, with WithTerminationTracking
+	TerminatingEvent = "TERMINATING"
+	// FinalizedEvent is the removal of a deleted pod, with the time it took to terminate, with WithTerminationTracking
+	FinalizedEvent = "FINALIZED"
+	// InitEvent is a milestone of an init container or native sidecar of a matched pod, with WithInitTracking
+	InitEvent = "INIT"
+	// ImageEvent is a change of the image or image digest of a container of a matched pod, with WithImageTracking
+	ImageEvent = "IMAGE_CHANGED"
+	// SecurityEvent is a change of the security-relevant settings of a matched pod, with WithSecurityWatch
+	SecurityEvent = "SECURITY"
+	// BackfillEvent is a past occurrence in the life of a matched pod, reconstructed at startup with WithBackfill
+	BackfillEvent = "BACKFILL"
+)
+
+// Event is a change to a matching object, as emitted by the Watcher
+type Event struct {
+	Type      string         // ADDED, MODIFIED, DELETED, RESYNC, EVENT, CONTAINER, TIMELINE, ALERT, METRICS, REFERENCE, VOLUME, TERMINATING, FINALIZED, INIT, IMAGE_CHANGED, SECURITY or BACKFILL
+	Key       string         // "namespace/name" of the object, or just the name for cluster-scoped objects
+	Object    runtime.Object // the object after field stripping: a *corev1.Pod for pods, *unstructured.Unstructured otherwise
+	Timestamp time.Time
+	Cluster   string // the name of the cluster of the object, when watching named clusters with NewMultiCluster
+	Owner     *Owner // the top-level owner of the object with WithOwnerResolution; nil if it has none
+	// Container is the change of a CONTAINER event, whose Object is the pod; nil for the other types
+	Container *ContainerChange
+	// Timeline is the lifecycle of the pod of a TIMELINE event, whose Object is the last revision of the pod; nil for the other types
+	Timeline *Timeline
+	// Alert is the detection of an ALERT event, whose Object is the pod; nil for the other types
+	Alert *Alert
+	// Changes are the writes of the field managers to the object since its previous revision with WithManagedFieldsSummary;
+	// MODIFIED events only
+	Changes []*Change
+	// Node is the summary of the node of the pod with WithNodeInfo; nil until the pod is scheduled
+	Node *NodeInfo
+	// Usage is the latest resource usage of the pod with WithResourceUsage, carried by METRICS events instead of the pod;
+	// nil until the metrics server reported it
+	Usage *PodUsage
+	// Reference is the change of a REFERENCE event, whose Object is the referencing pod; nil for the other types
+	Reference *ReferenceChange
+	// Volume is the change of a VOLUME event, whose Object is the pod mounting the volume; nil for the other types
+	Volume *VolumeChange
+	// Termination is the deletion of the pod of a TERMINATING or FINALIZED event; nil for the other types
+	Termination *Termination
+	// Init is the milestone of an init container of an INIT event, whose Object is the pod; nil for the other types
+	Init *InitChange
+	// Image is the change of an IMAGE_CHANGED event, whose Object is the pod; nil for the other types
+	Image *ImageChange
+	// Security is the change of the security-relevant settings of a SECURITY event, whose Object is the pod;
+	// nil for the other types
+	Security *SecurityChange
+	// Deletion is the reason of the deletion of the pod of a DELETED event with WithDeletionAttribution
+	Deletion *Deletion
+	// Backfill is the past occurrence of a BACKFILL event, whose Object is the pod as listed; nil for the other types
+	Backfill *Backfill
+
+	yaml   string            // the object serialized by the filters, reused by the YAML output formats
+	trace  trace.SpanContext // of the span of the event, or of its delivery to the sink it is written to; invalid if not traced
+	source string            // the source of its CloudEvent when its cluster has no name, as of WithCloudEventsSource
+}
+
+// ExitError is returned by Run when the watcher stopped cleanly but its outcome calls for a non-zero exit code:
+// a wait-for condition that was not met, or tracked pods deleted without having succeeded.
+type ExitError struct {
+	Code    int
+	Message string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%s (exit code %d)", e.Message, e.Code)
+}
+
+// WebhookOptions configures the delivery of events to a webhook
+type WebhookOptions struct {
+	URL     string
+	Headers []string // extra request headers, as "Name: value"
+	Timeout time.Duration
+	Retries int           // additional attempts after the first failure
+	Backoff time.Duration // delay before the first retry, doubling after each attempt
+	Secret  string        // HMAC-SHA256 signing key; empty disables signing
+	// BatchSize POSTs the events as JSON arrays of up to this many events when greater than 1,
+	// delivered once full or every BatchInterval (see NewWebhookSink)
+	BatchSize     int
+	BatchInterval time.Duration
+	// CloudEvents POSTs the events as CloudEvents 1.0 in the structured JSON mode rather than as envelopes,
+	// and the batches as JSON arrays of them
+	CloudEvents bool
+}
+
+// Option configures a Watcher
+type Option func(*Watcher)
+
+// WithNamespaces restricts the watch to the given namespaces; by default every namespace is watched
+func WithNamespaces(namespaces ...string) Option {
+	return func(w *Watcher) { w.namespaces = append(w.namespaces, namespaces...) }
+}
+
+// WithResource watches another resource instead of pods, given as a resource name optionally qualified
+// by group and version, e.g. "deployments.apps" or "mycrds.example.com/v1"
+func WithResource(resource string) Option {
+	return func(w *Watcher) { w.resource = resource }
+}
+
+// WithMarkers matches objects whose YAML serialization contains any of the substrings
+func WithMarkers(substrings ...string) Option {
+	return func(w *Watcher) { w.markers = append(w.markers, substrings...) }
+}
+
+// WithMarkerRegexes matches objects whose YAML serialization matches any of the regular expressions
+func WithMarkerRegexes(expressions ...string) Option {
+	return func(w *Watcher) { w.markerRegexes = append(w.markerRegexes, expressions...) }
+}
+
+// WithMarkerPaths only matches the markers against the values at the given field paths,
+// e.g. "metadata.annotations.debug" or "spec.containers[*].env[*].value"
+func WithMarkerPaths(paths ...string) Option {
+	return func(w *Watcher) { w.markerPaths = append(w.markerPaths, paths...) }
+}
+
+// WithMarkerAll requires every marker to match instead of any one of them
+func WithMarkerAll() Option {
+	return func(w *Watcher) { w.markerAll = true }
+}
+
+// WithCELFilters only matches objects for which every CEL expression evaluates to true,
+// e.g. "pod.status.phase == 'Running'"; they combine with the markers, both having to match
+func WithCELFilters(expressions ...string) Option {
+	return func(w *Watcher) { w.celFilters = append(w.celFilters, expressions...) }
+}
+
+// WithPhases only matches objects whose status.phase is one of the phases, e.g. "Pending" or "Failed", compared
+// case-insensitively; they combine with the markers, both having to match
+func WithPhases(phases ...string) Option {
+	return func(w *Watcher) { w.phases = append(w.phases, phases...) }
+}
+
+// WithConditions only matches objects satisfying every condition of their status.conditions, given as TYPE=STATUS
+// or TYPE!=STATUS, e.g. "Ready=False", compared case-insensitively; a condition the object does not report only satisfies
+// TYPE!=STATUS. They combine with the markers and phases, all having to match.
+func WithConditions(conditions ...string) Option {
+	return func(w *Watcher) { w.conditions = append(w.conditions, conditions...) }
+}
+
+// WithExcludeNamespaces drops the objects in the given namespaces, even when they match
+func WithExcludeNamespaces(namespaces ...string) Option {
+	return func(w *Watcher) { w.excludeNamespaces = append(w.excludeNamespaces, namespaces...) }
+}
+
+// WithExcludeMarkers drops the objects containing any of the substrings, even when they match.
+// They are matched against the same fields as the markers (see WithMarkerPaths).
+func WithExcludeMarkers(substrings ...string) Option {
+	return func(w *Watcher) { w.excludeMarkers = append(w.excludeMarkers, substrings...) }
+}
+
+// WithExcludeLabelSelector drops the objects whose labels match the selector, even when they match
+func WithExcludeLabelSelector(selector string) Option {
+	return func(w *Watcher) { w.excludeLabelSelector = selector }
+}
+
+// WithLabelSelector applies a label selector server-side
+func WithLabelSelector(selector string) Option {
+	return func(w *Watcher) { w.labelSelector = selector }
+}
+
+// WithWatchLabel only watches the objects carrying the label, given as KEY=VALUE or just KEY for any value,
+// such as DefaultOptIn. It is applied server-side along with the label selector.
+func WithWatchLabel(label string) Option {
+	return func(w *Watcher) { w.watchLabel = label }
+}
+
+// WithWatchAnnotation only watches the objects carrying the annotation, given as KEY=VALUE or just KEY for any value,
+// such as DefaultOptIn. Annotations cannot be selected server-side, so the objects without it are still received and cached.
+func WithWatchAnnotation(annotation string) Option {
+	return func(w *Watcher) { w.watchAnnotation = annotation }
+}
+
+// WithWorkload only watches the pods of a workload, given as kind/name such as "deployment/web" or "job/migrate-db",
+// by looking up its label selector when the Watcher is created. The workload must be in the single watched namespace.
+func WithWorkload(workload string) Option {
+	return func(w *Watcher) { w.workload = workload }
+}
+
+// WithFieldSelector applies a field selector server-side
+func WithFieldSelector(selector string) Option {
+	return func(w *Watcher) { w.fieldSelector = selector }
+}
+
+// WithEventTypes only emits the given event types (ADDED, MODIFIED, DELETED, RESYNC)
+func WithEventTypes(types ...string) Option {
+	return func(w *Watcher) { w.eventTypes = append(w.eventTypes, types...) }
+}
+
+// WithResyncPeriod periodically re-delivers every cached match as a RESYNC event
+func WithResyncPeriod(period time.Duration) Option {
+	return func(w *Watcher) { w.resyncPeriod = period }
+}
+
+// WithWatchTimeout asks the API server to close each watch after the timeout so it is routinely restarted
+func WithWatchTimeout(timeout time.Duration) Option {
+	return func(w *Watcher) { w.watchTimeout = timeout }
+}
+
+// WithBackoff sets how failing lists and watches are retried, with exponential backoff, jitter and a circuit breaker
+// (the defaults of BackoffOptions otherwise)
+func WithBackoff(options BackoffOptions) Option {
+	return func(w *Watcher) { w.backoff = options }
+}
+
+// WithEmitInitial emits the objects matching at startup as ADDED events, instead of only reporting them once they change
+func WithEmitInitial() Option {
+	return func(w *Watcher) { w.emitInitial = true }
+}
+
+// WithSkipInitial never emits the revisions of the objects that existed at startup, not even when a relist re-delivers them
+func WithSkipInitial() Option {
+	return func(w *Watcher) { w.skipInitial = true }
+}
+
+// WithWatchList streams the initial state of the objects from the API server with a watch (the WatchList feature
+// of Kubernetes 1.27 and later) instead of listing them all at once, falling back to a list where it is not supported.
+// The API servers are probed at the start of the watch, and a warning is written for those lacking it.
+// This turns on the WatchListClient feature of client-go, which is process-wide, unless none supports it.
+func WithWatchList() Option {
+	return func(w *Watcher) { w.watchList = true }
+}
+
+// WithPageSize lists the objects in pages of this many, each a consistent read of the API server's storage,
+// instead of in one response from its watch cache
+func WithPageSize(size int64) Option {
+	return func(w *Watcher) { w.pageSize = size }
+}
+
+// WithWorkers processes the notifications of the informers on this many goroutines, each queueing up to queueSize of them
+// before the informers wait (DefaultWorkers and DefaultQueueSize otherwise). The events of one object are always
+// processed in order, those of different objects concurrently.
+func WithWorkers(workers int, queueSize int) Option {
+	return func(w *Watcher) { w.workers, w.queueSize = workers, queueSize }
+}
+
+// WithDrainTimeout bounds how long the events still queued for the sinks are delivered once the context given to Run
+// is canceled, before the deliveries in progress are aborted (DefaultDrainTimeout otherwise; 0 aborts them at once)
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(w *Watcher) { w.drainTimeout = timeout }
+}
+
+// WithStopOnDelete locks onto the first matching object and stops once it is deleted
+func WithStopOnDelete() Option {
+	return func(w *Watcher) { w.stopOnDelete = true }
+}
+
+// WithWaitForDeleteAll tracks every matching object and stops once all of them have been deleted
+func WithWaitForDeleteAll() Option {
+	return func(w *Watcher) { w.waitForDeleteAll = true }
+}
+
+// WithExitCodeOnDelete makes Run return an ExitError with the code when the tracked pods
+// were deleted without all of them having succeeded
+func WithExitCodeOnDelete(code int) Option {
+	return func(w *Watcher) { w.exitCodeOnDelete = code }
+}
+
+// WithWaitFor stops once a matching object meets the condition, such as "Ready", "phase=Succeeded"
+// or "jsonpath={.status.podIP}"; Run returns an ExitError if the watcher stops before then
+func WithWaitFor(condition string) Option {
+	return func(w *Watcher) { w.waitFor = condition }
+}
+
+// WithDeadline stops the watcher at the given time.
+// Unlike a context deadline, deliveries already in progress are completed before Run returns.
+func WithDeadline(deadline time.Time) Option {
+	return func(w *Watcher) { w.deadline = deadline }
+}
+
+// WithMaxEvents stops the watcher after emitting the given number of events
+func WithMaxEvents(n int) Option {
+	return func(w *Watcher) { w.maxEvents = n }
+}
+
+// WithImageChange only emits pod modifications that change the container images
+func WithImageChange() Option {
+	return func(w *Watcher) { w.onImageChange = true }
+}
+
+// WithMinInterval emits at most one MODIFIED event per object in each interval.
+// Modifications arriving sooner are held back, and only the latest one is emitted once the interval has passed.
+func WithMinInterval(interval time.Duration) Option {
+	return func(w *Watcher) { w.minInterval = interval }
+}
+
+// WithRedaction replaces sensitive values with Redacted before objects are matched and emitted: with options.Builtin
+// the values of environment variables and annotations with sensitive names, and the values at options.Paths
+func WithRedaction(options RedactOptions) Option {
+	return func(w *Watcher) { w.redactOptions = &options }
+}
+
+// WithManagedFieldsSummary attributes each modification of a matched object to the field managers that wrote it,
+// such as controllers, kubectl or the kubelet, by comparing its managed fields with those of the previous revision,
+// and adds a summary of each write to the MODIFIED events in place of the managed fields, which are stripped
+func WithManagedFieldsSummary() Option {
+	return func(w *Watcher) { w.auditFields = true }
+}
+
+// WithDedupe suppresses MODIFIED events leaving the object, after WithStrip, unchanged since its last emitted revision
+func WithDedupe() Option {
+	return func(w *Watcher) { w.dedupe = true }
+}
+
+// WithStrip removes the given field paths from objects before they are matched and emitted
+func WithStrip(paths ...string) Option {
+	return func(w *Watcher) { w.stripPaths = append(w.stripPaths, paths...) }
+}
+
+// WithKubernetesEvents also emits the Kubernetes Events about matched pods, as EVENT events
+func WithKubernetesEvents() Option {
+	return func(w *Watcher) { w.includeEvents = true }
+}
+
+// WithReferences also watches the ConfigMaps and Secrets that matched pods mount or take environment variables from,
+// emitting a REFERENCE event to each pod referencing one when it is created, modified or deleted.
+// The events name the keys that changed; with includeData, they also carry the data, of the Secrets too.
+func WithReferences(includeData bool) Option {
+	return func(w *Watcher) { w.references, w.referenceData = true, includeData }
+}
+
+// WithVolumeTracking also watches the PersistentVolumeClaims that matched pods mount, and the VolumeAttachments of the
+// volumes bound to them, emitting a VOLUME event to each pod mounting one when its phase, or the attachment of its
+// volume to a node, changes
+func WithVolumeTracking() Option {
+	return func(w *Watcher) { w.trackVolumes = true }
+}
+
+// WithLogTail streams the container logs of matched pods into the output stream
+func WithLogTail() Option {
+	return func(w *Watcher) { w.tailLogs = true }
+}
+
+// WithContainerTracking diffs the container statuses of each revision of a matched pod against the previous one,
+// emitting a CONTAINER event for each container that restarted, crashed, started waiting, or became ready or not ready,
+// before the pod event. With WithEventTypes(ContainerEvent) only these events are emitted.
+func WithContainerTracking() Option {
+	return func(w *Watcher) { w.trackContainers = true }
+}
+
+// WithInitTracking diffs the init container statuses of each revision of a matched pod against the previous one,
+// emitting an INIT event when each init container starts, completes or fails, with its exit code, before the pod event.
+// Native sidecars, init containers with the restart policy Always, are reported as such, when they start, become ready,
+// fail or are restarted. With WithEventTypes(InitEvent) only these events are emitted.
+func WithInitTracking() Option {
+	return func(w *Watcher) { w.trackInit = true }
+}
+
+// WithImageTracking records the image of each container of a matched pod and the digest it resolved to, the imageID
+// of its status, emitting an IMAGE_CHANGED event before the pod event when either changes between two revisions, such as
+// when the image is updated in place or a restarted container pulls a tag that moved. With WithEventTypes(ImageEvent)
+// only these events are emitted.
+func WithImageTracking() Option {
+	return func(w *Watcher) { w.trackImages = true }
+}
+
+// WithSecurityWatch inspects each revision of a matched pod for security-relevant settings: privileged containers,
+// the host network, PID and IPC namespaces, added capabilities and containers running as root. It emits a SECURITY event
+// before the pod event when any appears or disappears, including when a pod is created with them, and counts the
+// settings appearing in pod_watcher_security_findings_total. With WithEventTypes(SecurityEvent) only these events are emitted.
+func WithSecurityWatch() Option {
+	return func(w *Watcher) { w.securityWatch = true }
+}
+
+// WithTimeline collects the milestones of each matched pod, from its creation, scheduling, image pulls and container starts
+// to its readiness, restarts and deletion, and emits them as a TIMELINE event after the pod is deleted.
+// With WithEventTypes(TimelineEvent) only these events are emitted.
+func WithTimeline() Option {
+	return func(w *Watcher) { w.timeline = true }
+}
+
+// WithAlerts tracks the restarts and readiness of each matched pod over a sliding window, and emits an ALERT event
+// when its containers restart, or it stops being ready, as many times as the thresholds allow within the window.
+// With WithEventTypes(AlertEvent) only these events are emitted.
+func WithAlerts(options AlertOptions) Option {
+	return func(w *Watcher) { w.alerts = &options }
+}
+
+// WithTerminationTracking follows the deletion of each matched pod, emitting a TERMINATING event when its deletion is
+// requested, and a FINALIZED event with the time it took to terminate, and whether it was forced, once it is removed.
+// With a StuckThreshold, an ALERT event is emitted for a pod still terminating that long after its deletion was requested.
+func WithTerminationTracking(options TerminationOptions) Option {
+	return func(w *Watcher) { w.terminations = &options }
+}
+
+// WithNodeInfo caches the nodes of the watched clusters through an informer, and adds a summary of the node
+// each matched pod runs on to its events: its name, taints, conditions and allocatable resources.
+// This requires permission to list and watch nodes.
+func WithNodeInfo() Option {
+	return func(w *Watcher) { w.includeNode = true }
+}
+
+// WithResourceUsage polls the metrics.k8s.io API of the metrics server for the CPU and memory usage of the matched pods
+// and their containers, and adds the latest usage to their events. With options.Events a METRICS event with the usage
+// of every matched pod is also emitted after each poll.
+func WithResourceUsage(options UsageOptions) Option {
+	return func(w *Watcher) { w.usage = &options }
+}
+
+// WithFailureCapture writes a bundle of artifacts into a directory under dir whenever a matched pod fails
+// or a container of it enters CrashLoopBackOff: the pod YAML, the logs of its terminated containers and of the previous
+// instance of restarted ones, its Kubernetes Events, and its node
+func WithFailureCapture(dir string) Option {
+	return func(w *Watcher) { w.captureDir = dir }
+}
+
+// WithDebugContainer attaches an ephemeral debug container running the image to each matched pod, once, when it meets
+// the trigger of the options, such as a crash-looping container, through the ephemeralcontainers subresource.
+// The result of each injection is logged.
+func WithDebugContainer(options DebugOptions) Option {
+	return func(w *Watcher) { w.debug = &options }
+}
+
+// WithOwnerResolution follows the owner references of the emitted objects to their top-level owner,
+// e.g. Pod -> ReplicaSet -> Deployment or Pod -> Job -> CronJob, and adds it to the events
+func WithOwnerResolution() Option {
+	return func(w *Watcher) { w.resolveOwners = true }
+}
+
+// WithSink delivers the events to the sink, which is closed when Run returns. It can be given multiple times.
+func WithSink(sink Sink) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) { return sink, nil })
+	}
+}
+
+// WithOutput writes the events to out in the given format: yaml, json, jsonl, diff, table, wide,
+// go-template=TEMPLATE or jsonpath=TEMPLATE (see NewWriterSink)
+func WithOutput(out io.Writer, format string) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) {
+			if err := checkFraming(w.framing, format); err != nil {
+				return nil, err
+			}
+			e, err := newEventWriter(out, format)
+			if err != nil {
+				return nil, err
+			}
+			if w.color {
+				e.colors = newColorizer(w.filter)
+			}
+			e.frame(w.framing)
+			return e, nil
+		})
+	}
+}
+
+// WithColor colorizes the output streams of WithOutput for a terminal: the events by type, the changes of diffs,
+// and the markers wherever they appear. Output files are never colorized.
+func WithColor() Option {
+	return func(w *Watcher) { w.color = true }
+}
+
+// WithOutputFile writes the events to the file at path in the given format (see NewFileSink)
+func WithOutputFile(path string, format string, compress bool, rotation FileRotation) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) {
+			if err := checkFraming(w.framing, format); err != nil {
+				return nil, err
+			}
+			e, err := openOutputFile(path, compress, format, rotation)
+			if err != nil {
+				return nil, err
+			}
+			e.frame(w.framing)
+			return e, nil
+		})
+	}
+}
+
+// WithOutputDir writes the events of each object to a file of its own in dir, in the given format (see NewDirSink)
+func WithOutputDir(dir string, format string, appendFiles bool) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) { return NewDirSink(dir, format, appendFiles) })
+	}
+}
+
+// WithKafka publishes each emitted event as a JSON envelope to a Kafka topic (see NewKafkaSink)
+func WithKafka(options KafkaOptions) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) { return NewKafkaSink(options) })
+	}
+}
+
+// WithNATS publishes each emitted event as a JSON envelope to a NATS subject (see NewNATSSink)
+func WithNATS(options NATSOptions) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) { return NewNATSSink(options) })
+	}
+}
+
+// WithArchive uploads the events to S3 or GCS in compressed chunks, listed by a manifest (see NewArchiveSink)
+func WithArchive(options ArchiveOptions) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) { return NewArchiveSink(options) })
+	}
+}
+
+// WithNotifications posts a message to a Slack or Teams channel when an event matches one of the triggers (see NewNotifySink)
+func WithNotifications(options NotifyOptions) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) { return NewNotifySink(options) })
+	}
+}
+
+// WithEventStream also delivers the emitted events to the clients of the stream (see NewEventStream).
+// The stream is not closed when Run returns, so that it can be handed to the next watcher.
+func WithEventStream(stream *EventStream) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) { return streamSink{stream: stream}, nil })
+	}
+}
+
+// WithCheckpoint periodically saves the state of the watch, so that the next run resumes where this one left off,
+// reporting the changes made in between rather than taking the state it finds as the new baseline (see CheckpointOptions)
+func WithCheckpoint(options CheckpointOptions) Option {
+	return func(w *Watcher) { w.checkpoint = &options }
+}
+
+// WithStore persists each emitted event in the SQLite event store at path (see OpenStore)
+func WithStore(path string) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) { return OpenStore(path) })
+	}
+}
+
+// WithWebhook POSTs each emitted event as a JSON envelope to a webhook (see NewWebhookSink)
+func WithWebhook(options WebhookOptions) Option {
+	return func(w *Watcher) {
+		w.newSinks = append(w.newSinks, func() (Sink, error) { return NewWebhookSink(options) })
+	}
+}
+
+// WithExec runs the shell command for each emitted event, with the object as JSON on stdin,
+// running at most concurrency commands at once and killing any that run longer than timeout
+func WithExec(command string, concurrency int, timeout time.Duration) Option {
+	return func(w *Watcher) { w.execCommand, w.execConcurrency, w.execTimeout = command, concurrency, timeout }
+}
+
+// WithLeaderElection only watches while this replica holds a coordination.k8s.io Lease,
+// so that of several replicas exactly one emits events, and another takes over once it stops or fails
+func WithLeaderElection(options LeaderElectionOptions) Option {
+	return func(w *Watcher) { w.leaderElection = &options }
+}
+
+// Cluster is one of the clusters watched by a Watcher created with NewMultiCluster
+type Cluster struct {
+	Name   string // tags the events from the cluster; may be empty when watching a single cluster
+	Config *rest.Config
+	// Clientset is used instead of a clientset created from Config when given, e.g. the fake clientset of
+	// k8s.io/client-go/kubernetes/fake to run the watcher without a cluster. Config may then be nil, in which case
+	// only pods can be watched, and without WithResourceUsage.
+	Clientset kubernetes.Interface
+}
+
+// cluster is a watched cluster with its clients
+type cluster struct {
+	name          string
+	clientset     kubernetes.Interface
+	client        resourceClient
+	watched       []string // metav1.NamespaceAll for every namespace
+	labelSelector string   // the label selector of the watcher and --watch-label, combined with that of the --for workload
+
+	// capabilities are those of its API server, probed at the start of each watch
+	capabilities Capabilities
+	// shards are those of the watch of each watched namespace, split by WithShards, for the current watch
+	shards map[string][]*watchShard
+}
+
+// Watcher watches one kind of resource for matching objects and emits their changes.
+// A Watcher is run once.
+type Watcher struct {
+	clusters  []*cluster
+	clientset kubernetes.Interface // of the first cluster, which holds the leader election lease
+
+	namespaces           []string
+	resource             string
+	markers              []string
+	markerRegexes        []string
+	markerPaths          []string
+	markerAll            bool
+	celFilters           []string
+	phases               []string
+	conditions           []string
+	filterFunc           FilterFunc
+	filterPlugin         string
+	excludeNamespaces    []string
+	excludeMarkers       []string
+	excludeLabelSelector string
+	labelSelector        string
+	watchLabel           string
+	watchAnnotation      string
+	workload             string
+	fieldSelector        string
+	eventTypes           []string
+	resyncPeriod         time.Duration
+	relistInterval       time.Duration
+	watchTimeout         time.Duration
+	backoff              BackoffOptions
+	watchList            bool
+	pageSize             int64
+	shards               int
+	shardLabel           string
+	workers              int
+	queueSize            int
+	drainTimeout         time.Duration
+	emitInitial          bool
+	snapshot             bool
+	skipInitial          bool
+	stopOnDelete         bool
+	waitForDeleteAll     bool
+	exitCodeOnDelete     int
+	waitFor              string
+	deadline             time.Time
+	maxEvents            int
+	onImageChange        bool
+	changeSection        ChangeSection
+	maxTracked           int
+	minInterval          time.Duration
+	dedupe               bool
+	stripPaths           []string
+	redactOptions        *RedactOptions
+	auditFields          bool
+	includeEvents        bool
+	references           bool
+	referenceData        bool
+	trackVolumes         bool
+	tailLogs             bool
+	resolveOwners        bool
+	attributeDeletions   bool
+	trackContainers      bool
+	trackInit            bool
+	trackImages          bool
+	securityWatch        bool
+	timeline             bool
+	alerts               *AlertOptions
+	terminations         *TerminationOptions
+	usage                *UsageOptions
+	statsInterval        time.Duration
+	heartbeatInterval    time.Duration
+	authCheckInterval    time.Duration
+	cloudEventsSource    string
+	backfill             time.Duration
+	framing              string
+	sinkQueue            *SinkQueueOptions
+	includeNode          bool
+	captureDir           string
+	debug                *DebugOptions
+	newSinks             []func() (Sink, error)
+	routeKey             string
+	routeValues          []string // of WithRoute
+	profiles             []*profile
+	color                bool
+	execCommand          string
+	execConcurrency      int
+	execTimeout          time.Duration
+	leaderElection       *LeaderElectionOptions
+	checkpoint           *CheckpointOptions
+	checkpointStore      checkpointStore // of the checkpoint; nil without one
+
+	// Parsed from the options by New
+	workloadKind string // of the --for workload
+	workloadName string
+	filter       *markerFilter
+	optIn        *optIn           // nil without --watch-annotation
+	cel          *celFilter       // nil without CEL filters
+	status       *statusFilter    // nil without phases or conditions
+	closePlugin  func()           // releases the filter plugin; nil without one
+	exclude      *exclusionFilter // nil without exclusions
+	emitted      map[string]bool
+	condition    *waitCondition
+	strip        *fieldStripper
+	redact       *redactor
+	sinks        []Sink
+	router       *router        // of the routed sinks; nil without WithRoute
+	writers      []*eventWriter // the sinks writing to an output stream or file, which also receive the container logs
+	health       *healthState
+	summary      *summaryRecorder
+
+	eventsOnce sync.Once
+	events     chan Event // nil unless Events is called
+}
+
+// New validates the options and resolves the watched resource, returning a Watcher ready to Run
+func New(config *rest.Config, options ...Option) (*Watcher, error) {
+	return NewMultiCluster([]Cluster{{Config: config}}, options...)
+}
+
+// NewMultiCluster returns a Watcher watching every one of the clusters, and emitting their events together,
+// each tagged with the name of its cluster. The stop conditions apply across the clusters,
+// and with leader election the lease is held in the first cluster.
+func NewMultiCluster(clusters []Cluster, options ...Option) (_ *Watcher, err error) {
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("no cluster to watch")
+	}
+	w := &Watcher{health: newHealthState(), summary: newSummaryRecorder(), workers: DefaultWorkers, queueSize: DefaultQueueSize, drainTimeout: DefaultDrainTimeout}
+	for _, option := range options {
+		option(w)
+	}
+	// Release the filter plugin if the options turn out to be invalid after it was loaded; Run releases it otherwise
+	defer func() {
+		if err != nil && w.closePlugin != nil {
+			w.closePlugin()
+		}
+	}()
+	if w.workload != "" {
+		if w.workloadKind, w.workloadName, err = parseWorkload(w.workload); err != nil {
+			return nil, err
+		}
+		if watched := uniqueNamespaces(w.namespaces); len(watched) != 1 || watched[0] == metav1.NamespaceAll {
+			return nil, fmt.Errorf("WithWorkload: requires the single namespace of the workload")
+		}
+	}
+	if err := w.compileFilters(); err != nil {
+		return nil, err
+	}
+	if err := w.compileProfiles(); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, c := range clusters {
+		if names[c.Name] {
+			return nil, fmt.Errorf("cluster %q is given more than once", c.Name)
+		}
+		names[c.Name] = true
+		watched, err := w.newCluster(c)
+		if err != nil {
+			return nil, err
+		}
+		w.clusters = append(w.clusters, watched)
+	}
+	w.clientset = w.clusters[0].clientset
+	if w.workers < 1 {
+		return nil, fmt.Errorf("WithWorkers: must be at least 1")
+	}
+	if w.queueSize < 1 {
+		return nil, fmt.Errorf("WithWorkers: the queue size must be at least 1")
+	}
+	if w.maxTracked < 0 {
+		return nil, fmt.Errorf("WithMaxTrackedObjects: must not be negative")
+	}
+	if err := w.validateShards(); err != nil {
+		return nil, err
+	}
+	if w.pageSize < 0 {
+		return nil, fmt.Errorf("WithPageSize: must not be negative")
+	}
+	if w.statsInterval < 0 {
+		return nil, fmt.Errorf("WithStatsInterval: must not be negative")
+	}
+	if w.heartbeatInterval < 0 {
+		return nil, fmt.Errorf("WithHeartbeatInterval: must not be negative")
+	}
+	if w.authCheckInterval < 0 {
+		return nil, fmt.Errorf("WithAuthCheckInterval: must not be negative")
+	}
+	if w.backfill < 0 {
+		return nil, fmt.Errorf("WithBackfill: must not be negative")
+	}
+	if w.sinkQueue != nil {
+		if w.sinkQueue.Dir == "" {
+			return nil, fmt.Errorf("WithSinkQueue: requires a directory")
+		}
+		if w.sinkQueue.Retention < 0 || w.sinkQueue.MaxSize < 0 {
+			return nil, fmt.Errorf("WithSinkQueue: the retention and maximum size must not be negative")
+		}
+	}
+	if w.watchList {
+		if err := setWatchList(true); err != nil {
+			return nil, err
+		}
+	}
+	if w.snapshot && (w.skipInitial || w.checkpoint != nil || w.leaderElection != nil) {
+		return nil, fmt.Errorf("WithSnapshot: cannot be combined with WithSkipInitial, WithCheckpoint or WithLeaderElection")
+	}
+	if w.emitInitial && w.skipInitial {
+		return nil, fmt.Errorf("WithEmitInitial: cannot be combined with WithSkipInitial")
+	}
+	if w.emitInitial && w.checkpoint != nil {
+		return nil, fmt.Errorf("WithEmitInitial: cannot be combined with WithCheckpoint, which decides what is emitted at startup")
+	}
+	if w.backfill > 0 && w.checkpoint != nil {
+		return nil, fmt.Errorf("WithBackfill: cannot be combined with WithCheckpoint, which resumes where the previous run left off")
+	}
+	if w.skipInitial && w.checkpoint != nil {
+		return nil, fmt.Errorf("WithSkipInitial: cannot be combined with WithCheckpoint, which decides what is emitted at startup")
+	}
+	if w.stopOnDelete && w.waitForDeleteAll {
+		return nil, fmt.Errorf("WithStopOnDelete: cannot be combined with WithWaitForDeleteAll")
+	}
+	if w.waitFor != "" && (w.stopOnDelete || w.waitForDeleteAll) {
+		return nil, fmt.Errorf("WithWaitFor: cannot be combined with WithStopOnDelete or WithWaitForDeleteAll")
+	}
+	if w.leaderElection != nil {
+		options, err := w.leaderElection.defaulted()
+		if err != nil {
+			return nil, err
+		}
+		w.leaderElection = &options
+	}
+	if w.checkpoint != nil {
+		if w.checkpointStore, err = newCheckpointStore(*w.checkpoint, w.clientset); err != nil {
+			return nil, err
+		}
+		if w.checkpoint.Interval <= 0 {
+			w.checkpoint.Interval = 10 * time.Second
+		}
+	}
+	_, pods := w.clusters[0].client.(podClient)
+	if w.tailLogs && !pods {
+		return nil, fmt.Errorf("WithLogTail: only supported when watching pods")
+	}
+	if w.trackContainers && !pods {
+		return nil, fmt.Errorf("WithContainerTracking: only supported when watching pods")
+	}
+	if w.timeline && !pods {
+		return nil, fmt.Errorf("WithTimeline: only supported when watching pods")
+	}
+	if w.alerts != nil {
+		if !pods {
+			return nil, fmt.Errorf("WithAlerts: only supported when watching pods")
+		}
+		if w.alerts.RestartThreshold < 0 || w.alerts.FlapThreshold < 0 {
+			return nil, fmt.Errorf("WithAlerts: the restart and flap thresholds must not be negative")
+		}
+	}
+	if w.terminations != nil {
+		if !pods {
+			return nil, fmt.Errorf("WithTerminationTracking: only supported when watching pods")
+		}
+		if w.terminations.StuckThreshold < 0 {
+			return nil, fmt.Errorf("WithTerminationTracking: the stuck threshold must not be negative")
+		}
+	}
+	if w.trackInit && !pods {
+		return nil, fmt.Errorf("WithInitTracking: only supported when watching pods")
+	}
+	if w.trackImages && !pods {
+		return nil, fmt.Errorf("WithImageTracking: only supported when watching pods")
+	}
+	if w.securityWatch && !pods {
+		return nil, fmt.Errorf("WithSecurityWatch: only supported when watching pods")
+	}
+	if w.includeNode && !pods {
+		return nil, fmt.Errorf("WithNodeInfo: only supported when watching pods")
+	}
+	if w.attributeDeletions && !pods {
+		return nil, fmt.Errorf("WithDeletionAttribution: only supported when watching pods")
+	}
+	if w.backfill > 0 && !pods {
+		return nil, fmt.Errorf("WithBackfill: only supported when watching pods")
+	}
+	if w.usage != nil && !pods {
+		return nil, fmt.Errorf("WithResourceUsage: only supported when watching pods")
+	}
+	if w.debug != nil {
+		if !pods {
+			return nil, fmt.Errorf("WithDebugContainer: only supported when watching pods")
+		}
+		if w.debug.Image == "" {
+			return nil, fmt.Errorf("WithDebugContainer: requires an image")
+		}
+		if w.debug.Trigger, err = parseDebugTrigger(w.debug.Trigger); err != nil {
+			return nil, err
+		}
+	}
+	if w.captureDir != "" && !pods {
+		return nil, fmt.Errorf("WithFailureCapture: only supported when watching pods")
+	}
+	if w.includeEvents && !pods {
+		return nil, fmt.Errorf("WithKubernetesEvents: only supported when watching pods")
+	}
+	if w.references && !pods {
+		return nil, fmt.Errorf("WithReferences: only supported when watching pods")
+	}
+	if w.trackVolumes && !pods {
+		return nil, fmt.Errorf("WithVolumeTracking: only supported when watching pods")
+	}
+	// Create the sinks last, so that a file is only created once everything else is valid
+	if err := w.openSinks(); err != nil {
+		return nil, err
+	}
+	if w.tailLogs && len(w.writers) == 0 {
+		w.closeSinks()
+		return nil, fmt.Errorf("WithLogTail: requires WithOutput or WithOutputFile")
+	}
+	return w, nil
+}
+
+// compileFilters parses the options deciding which objects are emitted, and how they are transformed beforehand
+func (w *Watcher) compileFilters() error {
+	var err error
+	if w.watchLabel != "" {
+		label, err := parseOptIn("watch-label", w.watchLabel, true)
+		if err != nil {
+			return err
+		}
+		w.watchLabel = label.selector()
+	}
+	if w.watchAnnotation != "" {
+		if w.optIn, err = parseOptIn("watch-annotation", w.watchAnnotation, false); err != nil {
+			return err
+		}
+	}
+	if w.filter, err = newMarkerFilter(w.markers, w.markerRegexes, w.markerAll, w.markerPaths); err != nil {
+		return err
+	}
+	if w.status, err = newStatusFilter(w.phases, w.conditions); err != nil {
+		return err
+	}
+	if len(w.celFilters) > 0 {
+		if w.cel, err = newCELFilter(w.celFilters); err != nil {
+			return err
+		}
+	}
+	if w.filterPlugin != "" {
+		if w.filterFunc, w.closePlugin, err = loadFilterPlugin(w.filterPlugin); err != nil {
+			return err
+		}
+	}
+	if w.exclude, err = newExclusionFilter(w.excludeNamespaces, w.excludeMarkers, w.excludeLabelSelector, w.markerPaths); err != nil {
+		return err
+	}
+	if w.emitted, err = parseEventTypes(w.eventTypes); err != nil {
+		return err
+	}
+	if w.waitFor != "" {
+		if w.condition, err = parseWaitCondition(w.waitFor); err != nil {
+			return err
+		}
+	}
+	// The managed fields are summarized instead of emitted
+	if w.auditFields {
+		w.stripPaths = append(w.stripPaths, DefaultStripPaths...)
+	}
+	if w.strip, err = newFieldStripper(w.stripPaths); err != nil {
+		return err
+	}
+	if w.redact, err = newRedactor(w.redactOptions); err != nil {
+		return err
+	}
+	return nil
+}
+
+// newCluster creates the clients of a cluster and resolves the watched resource in it
+func (w *Watcher) newCluster(c Cluster) (*cluster, error) {
+	// Create a Kubernetes clientset from the config, unless one is given
+	clientset := c.Clientset
+	if clientset == nil {
+		var err error
+		if clientset, err = kubernetes.NewForConfig(c.Config); err != nil {
+			return nil, fmt.Errorf("could not create Kubernetes client%s: %w", clusterSuffix(c.Name), err)
+		}
+	} else if c.Config == nil && (w.resource != "" || w.usage != nil) {
+		return nil, fmt.Errorf("a clientset without a config%s only supports watching pods, without --include-metrics", clusterSuffix(c.Name))
+	}
+	// Resolve the watched resource (pods unless another resource is given)
+	client, namespaced, err := newResourceClient(c.Config, clientset, w.resource)
+	if err != nil {
+		if c.Name != "" {
+			return nil, fmt.Errorf("cluster %s: %w", c.Name, err)
+		}
+		return nil, err
+	}
+	watched := uniqueNamespaces(w.namespaces)
+	if !namespaced {
+		if len(w.namespaces) > 0 {
+			return nil, fmt.Errorf("resource %q is cluster-scoped and cannot be watched per namespace", w.resource)
+		}
+		watched = []string{metav1.NamespaceAll}
+	}
+	selector := joinSelectors(w.labelSelector, w.watchLabel)
+	if w.workload != "" {
+		if _, pods := client.(podClient); !pods {
+			return nil, fmt.Errorf("WithWorkload: only supported when watching pods")
+		}
+		podSelector, err := workloadSelector(context.Background(), clientset, watched[0], w.workloadKind, w.workloadName)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve the pods of %s in namespace %s%s: %w", w.workload, watched[0], clusterSuffix(c.Name), err)
+		}
+		selector = joinSelectors(podSelector, w.labelSelector, w.watchLabel)
+	}
+	return &cluster{name: c.Name, clientset: clientset, client: client, watched: watched, labelSelector: selector}, nil
+}
+
+// clusterSuffix names the cluster in errors and logs, if it has a name
+func clusterSuffix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return " for cluster " + name
+}
+
+// clusterNames lists the names of the watched clusters for logging
+func (w *Watcher) clusterNames() string {
+	var names []string
+	for _, c := range w.clusters {
+		names = append(names, c.name)
+	}
+	return strings.Join(names, ",")
+}
+
+// informerCount returns the number of resource informers of a watch, one per shard of each watched namespace of every cluster
+func (w *Watcher) informerCount() int {
+	n := 0
+	for _, c := range w.clusters {
+		for _, namespace := range c.watched {
+			n += max(len(c.shards[namespace]), 1)
+		}
+	}
+	return n
+}
+
+// openSinks creates the configured sinks, closing those already created if one fails
+func (w *Watcher) openSinks() error {
+	if len(w.routeValues) > 0 {
+		if w.routeKey == "" {
+			return fmt.Errorf("WithRoute: requires WithRouteKey")
+		}
+		w.router = newRouter(w.routeKey, w.routeValues)
+	}
+	for _, newSink := range w.newSinks {
+		sink, err := newSink()
+		if err == nil && w.sinkQueue != nil {
+			sink, err = w.queueSink(sink)
+			if err != nil {
+				_ = sink.Close()
+			}
+		}
+		if err != nil {
+			w.closeSinks()
+			return err
+		}
+		w.sinks = append(w.sinks, sink)
+		if writer, ok := sink.(*eventWriter); ok {
+			writer.owners = w.resolveOwners
+			writer.source = w.cloudEventsSource
+			w.writers = append(w.writers, writer)
+		}
+	}
+	if err := w.openProfileSinks(); err != nil {
+		w.closeSinks()
+		return err
+	}
+	return nil
+}
+
+// closeSinks closes the sinks created so far when New fails
+func (w *Watcher) closeSinks() {
+	for _, sink := range w.sinks {
+		_ = sink.Close()
+	}
+}
+
+// Events returns a channel receiving every emitted event, which is closed once Run returns.
+// It must be called before Run, and the channel must be drained, as the watcher waits for each event to be received.
+func (w *Watcher) Events() <-chan Event {
+	w.eventsOnce.Do(func() {
+		w.events = make(chan Event, 64)
+	})
+	return w.events
+}
+
+// Summary returns the summary of the last run of the watcher, which is complete once Run has returned
+func (w *Watcher) Summary() *Summary {
+	return w.summary.get()
+}
+
+// Run watches until the context is canceled or a configured stop condition is reached.
+// Canceling the context also aborts deliveries in progress.
+func (w *Watcher) Run(ctx context.Context) (err error) {
+	if w.events != nil {
+		defer close(w.events)
+	}
+	defer w.health.setRole(roleStopped)
+	if w.closePlugin != nil {
+		defer w.closePlugin()
+	}
+	w.summary.start()
+	defer func() { w.summary.finish(err) }()
+	slog.Info("Starting pod watcher", "resource", w.resourceName(), "markers", w.filter.String(), "cel", strings.Join(w.celFilters, " AND "),
+		"status", w.status.String(), "exclude", w.exclude.String(), "clusters", w.clusterNames(), "namespaces", namespaceList(w.clusters[0].watched),
+		"labelSelector", w.clusters[0].labelSelector, "watchAnnotation", w.watchAnnotation, "for", w.workload, "fieldSelector", w.fieldSelector, "stopOnDelete", w.stopOnDelete, "resyncPeriod", w.resyncPeriod, "relistInterval", w.relistInterval)
+	for _, p := range w.profiles {
+		slog.Info("Watch profile", "profile", p.name, "markers", p.config.filter.String(), "cel", strings.Join(p.config.celFilters, " AND "),
+			"status", p.config.status.String(), "exclude", p.config.exclude.String(), "namespaces", namespaceList(uniqueNamespaces(p.config.namespaces)), "labelSelector", p.config.labelSelector)
+	}
+
+	// Closing the sinks on return delivers the queued events and finalizes any compression,
+	// within the drain timeout once the context is canceled
+	defer w.bindSinks(ctx)()
+	sinks := newFanOut(w.sinks)
+	sinks.summary = w.summary
+	sinks.source = w.cloudEventsSource
+	defer sinks.close()
+	// Heartbeats are written while standing by for the lease too, so that a standby replica is seen alive
+	if w.heartbeatInterval > 0 {
+		heartbeatCtx, stopHeartbeats := context.WithCancel(ctx)
+		defer stopHeartbeats()
+		go w.runHeartbeats(heartbeatCtx, w.heartbeatInterval)
+	}
+	// The credentials are kept fresh while standing by too, for the watch to start at once when elected
+	if w.authCheckInterval > 0 {
+		authCtx, stopAuthChecks := context.WithCancel(ctx)
+		defer stopAuthChecks()
+		go w.runAuthChecks(authCtx, w.authCheckInterval)
+	}
+	if w.leaderElection != nil {
+		return w.runElected(ctx, sinks)
+	}
+	return w.watch(ctx, sinks)
+}
+
+// bindSinks binds the sinks to a context that outlives ctx by the drain timeout, so that the events still queued
+// when ctx is canceled are delivered for that long before the deliveries are aborted; the returned function cancels it
+func (w *Watcher) bindSinks(ctx context.Context) context.CancelFunc {
+	drainCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(w.drainTimeout, cancel)
+	})
+	for _, sink := range w.sinks {
+		if sink, ok := sink.(bindable); ok {
+			sink.bind(drainCtx)
+		}
+	}
+	return func() {
+		stop()
+		cancel()
+	}
+}
+
+// watch runs the informers, emitting to the sinks, until the context is canceled or a stop condition is reached
+func (w *Watcher) watch(ctx context.Context, sinks *fanOut) error {
+	runCtx := ctx // outlives the watch, so that the work in progress when it stops can complete
+	// Every informer stops once this context is canceled: by the caller, once the deadline passes,
+	// or by the processor when stop-on-delete, wait-for or max-events completes.
+	if !w.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, w.deadline)
+		defer cancel()
+	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	w.probeCapabilities(ctx)
+	processor := &eventProcessor{
+		watchCtx:    ctx,
+		sinks:       sinks,
+		strip:       w.strip,
+		redact:      w.redact,
+		filter:      w.filter,
+		cel:         w.cel,
+		status:      w.status,
+		plugin:      w.filterFunc,
+		optIn:       w.optIn,
+		exclude:     w.exclude,
+		condition:   w.condition,
+		maxEvents:   int64(w.maxEvents),
+		stop:        stop,
+		eventTypes:  w.emitted,
+		events:      w.events,
+		health:      w.health,
+		tracked:     newTrackedObjects(w.maxTracked),
+		summary:     w.summary,
+		emitInitial: w.emitInitial || w.snapshot,
+	}
+	w.health.startWatch(&processor.matched)
+	w.health.setRole(roleWatching)
+	defer w.health.endWatch()
+	if w.stopOnDelete {
+		processor.target = &podTarget{}
+	}
+	if w.skipInitial {
+		processor.listed = newListedVersions()
+	}
+	if w.onImageChange {
+		processor.images = newImageTracker()
+	}
+	if w.changeSection != "" {
+		processor.sections = newSectionTracker(w.changeSection)
+	}
+	if w.trackContainers {
+		processor.containers = newContainerTracker()
+	}
+	if w.trackInit {
+		processor.inits = newInitTracker()
+	}
+	if w.trackImages {
+		processor.imageChanges = newImageChangeTracker()
+	}
+	if w.securityWatch {
+		processor.security = newSecurityTracker()
+	}
+	if w.auditFields {
+		processor.audit = newManagedFieldsTracker()
+	}
+	if w.timeline {
+		processor.timelines = newTimelineTracker()
+	}
+	if w.alerts != nil {
+		processor.alerts = newAlertDetector(*w.alerts)
+	}
+	if w.terminations != nil {
+		processor.terminations = newTerminationTracker(*w.terminations, processor.emitAlerts)
+		defer processor.terminations.stop()
+	}
+	if w.usage != nil {
+		processor.usage = newUsageTracker(*w.usage, w.clusters, processor.matched.contains)
+	}
+	if w.references {
+		processor.references = newReferenceTracker(w.referenceData, processor.matched.contains)
+	}
+	if w.trackVolumes {
+		processor.volumes = newVolumeTracker(processor.matched.contains)
+	}
+	if w.waitForDeleteAll {
+		processor.waiter = newDeleteWaiter()
+	}
+	if w.tailLogs {
+		processor.logs = newLogTailer(ctx, w.clusters, w.writers)
+	}
+	if w.captureDir != "" {
+		processor.capture = newFailureCapture(runCtx, w.captureDir, w.clusters)
+		defer processor.capture.wait()
+	}
+	if w.debug != nil {
+		processor.debug = newDebugInjector(runCtx, *w.debug, w.clusters)
+		defer processor.debug.wait()
+	}
+	if w.resolveOwners {
+		processor.owners = newOwnerResolver(ctx, w.clusters)
+	}
+	if w.attributeDeletions {
+		processor.deletions = newDeletionAttributor(ctx, w.clusters)
+	}
+	if w.backfill > 0 {
+		processor.backfill = newBackfiller(ctx, w.clusters, w.backfill)
+	}
+	if w.statsInterval > 0 {
+		processor.stats = newStatsTracker(w.statsInterval, w.writers, processor.owners)
+		go processor.stats.run(ctx)
+	}
+	if w.checkpointStore != nil {
+		processor.checkpoint = newCheckpointer(runCtx, w.checkpointStore, w.resourceName(), w.checkpoint.Interval)
+		go processor.checkpoint.run(ctx)
+		// Save the final state once the informers have stopped, even when the context given to Run is canceled
+		defer func() {
+			saveCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			processor.checkpoint.save(saveCtx)
+		}()
+	}
+	if w.execCommand != "" {
+		processor.hook = newExecHook(w.execCommand, w.execConcurrency, w.execTimeout)
+		defer processor.hook.wait()
+	}
+	if w.minInterval > 0 || w.dedupe {
+		processor.throttle = newEventThrottle(w.minInterval, w.dedupe, processor.emit)
+		// Deliver the held-back events before the sinks are closed and the hook is waited for
+		defer processor.throttle.flush()
+	}
+
+	// With shards, split the watch of each namespace by the values of the shard label found in it
+	for _, c := range w.clusters {
+		if err := w.planShards(ctx, c); err != nil {
+			return err
+		}
+	}
+	w.health.planInformers(w.informerCount())
+	// Run one informer per namespace, or shard of it, of every cluster, all feeding the same processor and output stream
+	processor.workers = newWorkerPool(w.workers, w.queueSize)
+	if processor.usage != nil {
+		// The METRICS events go through the worker of their pod, after the changes to it already queued
+		processor.usage.start(ctx, func(m *matchedObject, usage *PodUsage) {
+			processor.workers.submit(m.id, func() { processor.emitUsage(m, usage) })
+		})
+		defer processor.usage.stop()
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*w.informerCount()+len(w.clusters))
+	// A snapshot stops once every informer has delivered its initial list
+	if w.snapshot {
+		processor.snapshot = make(chan func() bool, w.informerCount())
+		processor.awaitSnapshot(ctx, &wg, w.informerCount())
+	}
+	// If includeNode mode, cache the nodes of every cluster before the pods are watched
+	if w.includeNode {
+		nodes, err := newNodeCache(ctx, w.clusters, w.watchTimeout)
+		if err != nil {
+			return err
+		}
+		processor.nodes = nodes
+		processor.nodes.run(ctx, &wg)
+	}
+	for _, c := range w.clusters {
+		// If trackVolumes mode, watch the VolumeAttachments of the cluster, which are cluster-scoped
+		if w.trackVolumes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := w.runAttachmentInformer(ctx, c, processor); err != nil {
+					errs <- err
+					stop()
+				}
+			}()
+		}
+		for _, namespace := range c.watched {
+			for _, shard := range c.shards[namespace] {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := w.runInformer(ctx, c, namespace, shard, processor); err != nil {
+						errs <- err
+						stop()
+					}
+				}()
+			}
+			// If includeEvents mode, watch the Kubernetes Events of the same namespace alongside the pods
+			if w.includeEvents {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := w.runEventInformer(ctx, c, namespace, processor); err != nil {
+						errs <- err
+						stop()
+					}
+				}()
+			}
+			// If followReferences mode, watch the ConfigMaps and Secrets of the same namespace alongside the pods
+			if w.references {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := w.runReferenceInformers(ctx, c, namespace, processor); err != nil {
+						errs <- err
+						stop()
+					}
+				}()
+			}
+			// If trackVolumes mode, watch the PersistentVolumeClaims of the same namespace alongside the pods
+			if w.trackVolumes {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := w.runClaimInformer(ctx, c, namespace, processor); err != nil {
+						errs <- err
+						stop()
+					}
+				}()
+			}
+		}
+	}
+	wg.Wait()
+	// No more METRICS events may be submitted once the workers stop
+	if processor.usage != nil {
+		processor.usage.stop()
+	}
+	processor.workers.stop()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+	w.summary.stopped(processor.stopReason(timedOut))
+	if processor.condition != nil && !processor.satisfied.Load() {
+		if timedOut {
+			return &ExitError{Code: 1, Message: fmt.Sprintf("Timed out waiting for condition %s.", processor.condition)}
+		}
+		return &ExitError{Code: 1, Message: fmt.Sprintf("Watcher stopped before condition %s was met.", processor.condition)}
+	}
+	if timedOut {
+		slog.Info("Deadline reached, watcher stopped", "deadline", w.deadline.Format(time.RFC3339))
+	}
+	if code := processor.exitCode(w.exitCodeOnDelete); code != 0 {
+		return &ExitError{Code: code, Message: "Tracked pods were deleted without all of them having succeeded."}
+	}
+	return nil
+}
+
+// resourceName returns the watched resource as given, for logging
+func (w *Watcher) resourceName() string {
+	if w.resource == "" {
+		return podsResource.Resource
+	}
+	return w.resource
+}
+
+// uniqueNamespaces returns the namespaces to watch, where metav1.NamespaceAll means every namespace.
+func uniqueNamespaces(namespaces []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, namespace := range namespaces {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" || seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+		result = append(result, namespace)
+	}
+	if len(result) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	return result
+}
+
+// namespaceList formats the watched namespaces for logging
+func namespaceList(watched []string) string {
+	if len(watched) == 1 && watched[0] == metav1.NamespaceAll {
+		return "<all>"
+	}
+	return strings.Join(watched, ",")
+}
@@ -0,0 +1,217 @@
+package watcher
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// eventTimeout bounds the wait for each event of the watcher
+const eventTimeout = 10 * time.Second
+
+// fakeWatch runs a watcher of the fake cluster with the options, returning its events once it is ready
+func fakeWatch(t *testing.T, cluster Cluster, options ...Option) <-chan Event {
+	t.Helper()
+	w, err := NewMultiCluster([]Cluster{cluster}, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := w.Events()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		for range events {
+		}
+		if err := <-done; err != nil {
+			t.Errorf("watcher failed: %v", err)
+		}
+	})
+	deadline := time.Now().Add(eventTimeout)
+	for !w.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("watcher not ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return events
+}
+
+// nextEvent returns the next event of the watcher
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events closed")
+		}
+		return event
+	case <-time.After(eventTimeout):
+		t.Fatal("no event")
+	}
+	return Event{}
+}
+
+// expectEvent fails unless the next event of the watcher is of the type and about the pod
+func expectEvent(t *testing.T, events <-chan Event, eventType, key string) Event {
+	t.Helper()
+	event := nextEvent(t, events)
+	if event.Type != eventType || event.Key != key {
+		t.Fatalf("got %s %s, want %s %s", event.Type, event.Key, eventType, key)
+	}
+	return event
+}
+
+// testMarker is in the pods matching the filters of the tests, rather than FakeMarker, which every fake pod carries
+const testMarker = "TRACE_ME"
+
+// fakePods creates, updates and deletes pods in the fake cluster, bumping their resource versions as an API server would
+type fakePods struct {
+	t         *testing.T
+	clientset kubernetes.Interface
+	version   int
+}
+
+func (f *fakePods) create(namespace, name, env string) *corev1.Pod {
+	f.t.Helper()
+	pod := fakePod(namespace, name)
+	pod.Spec.Containers[0].Env = nil
+	if env != "" {
+		pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: env, Value: "true"}}
+	}
+	f.version++
+	pod.ResourceVersion = strconv.Itoa(f.version)
+	created, err := f.clientset.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return created
+}
+
+func (f *fakePods) update(pod *corev1.Pod, phase corev1.PodPhase) *corev1.Pod {
+	f.t.Helper()
+	pod = pod.DeepCopy()
+	pod.Status.Phase = phase
+	f.version++
+	pod.ResourceVersion = strconv.Itoa(f.version)
+	updated, err := f.clientset.CoreV1().Pods(pod.Namespace).UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return updated
+}
+
+func (f *fakePods) delete(pod *corev1.Pod) {
+	f.t.Helper()
+	if err := f.clientset.CoreV1().Pods(pod.Namespace).Delete(context.Background(), pod.Name, metav1.DeleteOptions{}); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func TestWatchLifecycle(t *testing.T) {
+	cluster, clientset := NewFakeCluster("")
+	pods := &fakePods{t: t, clientset: clientset}
+	events := fakeWatch(t, cluster, WithMarkers(testMarker))
+
+	pod := pods.create("default", "web", testMarker)
+	event := expectEvent(t, events, "ADDED", "default/web")
+	if _, ok := event.Object.(*corev1.Pod); !ok {
+		t.Fatalf("ADDED event carries %T, want the pod", event.Object)
+	}
+	pod = pods.update(pod, corev1.PodRunning)
+	event = expectEvent(t, events, "MODIFIED", "default/web")
+	if phase := event.Object.(*corev1.Pod).Status.Phase; phase != corev1.PodRunning {
+		t.Errorf("MODIFIED event carries phase %s, want %s", phase, corev1.PodRunning)
+	}
+	pods.delete(pod)
+	expectEvent(t, events, "DELETED", "default/web")
+}
+
+func TestWatchInitialList(t *testing.T) {
+	cluster, clientset := NewFakeCluster("")
+	pods := &fakePods{t: t, clientset: clientset}
+	existing := pods.create("default", "existing", testMarker)
+	events := fakeWatch(t, cluster, WithMarkers(testMarker))
+
+	// The pods listed when the watch starts are only reported once they change
+	pods.create("default", "created", testMarker)
+	expectEvent(t, events, "ADDED", "default/created")
+	pods.update(existing, corev1.PodRunning)
+	expectEvent(t, events, "MODIFIED", "default/existing")
+}
+
+func TestWatchEmitInitial(t *testing.T) {
+	cluster, clientset := NewFakeCluster("")
+	pods := &fakePods{t: t, clientset: clientset}
+	pods.create("default", "existing", testMarker)
+	pods.create("default", "unmarked", "")
+	events := fakeWatch(t, cluster, WithMarkers(testMarker), WithEmitInitial())
+
+	expectEvent(t, events, "ADDED", "default/existing")
+	pods.create("default", "created", testMarker)
+	expectEvent(t, events, "ADDED", "default/created")
+}
+
+func TestWatchFilters(t *testing.T) {
+	cluster, clientset := NewFakeCluster("")
+	pods := &fakePods{t: t, clientset: clientset}
+	events := fakeWatch(t, cluster, WithMarkers(testMarker), WithNamespaces("team-a"))
+
+	// Neither the pod without the marker nor that of another namespace is emitted, before the matching one
+	pods.create("team-a", "unmarked", "")
+	pods.create("team-b", "elsewhere", testMarker)
+	marked := pods.create("team-a", "marked", testMarker)
+	expectEvent(t, events, "ADDED", "team-a/marked")
+	pods.delete(marked)
+	expectEvent(t, events, "DELETED", "team-a/marked")
+}
+
+func TestWatchEventTypes(t *testing.T) {
+	cluster, clientset := NewFakeCluster("")
+	pods := &fakePods{t: t, clientset: clientset}
+	events := fakeWatch(t, cluster, WithMarkers(testMarker), WithEventTypes("DELETED"))
+
+	pod := pods.create("default", "batch", testMarker)
+	pod = pods.update(pod, corev1.PodSucceeded)
+	pods.delete(pod)
+	event := expectEvent(t, events, "DELETED", "default/batch")
+	if phase := event.Object.(*corev1.Pod).Status.Phase; phase != corev1.PodSucceeded {
+		t.Errorf("DELETED event carries phase %s, want %s", phase, corev1.PodSucceeded)
+	}
+}
+
+func TestNewMultiClusterInvalidOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		option  Option
+		wantErr string
+	}{
+		{name: "workers", option: WithWorkers(0, DefaultQueueSize), wantErr: "WithWorkers: must be at least 1"},
+		{name: "queue size", option: WithWorkers(DefaultWorkers, 0), wantErr: "WithWorkers: the queue size must be at least 1"},
+		{name: "page size", option: WithPageSize(-1), wantErr: "WithPageSize: must not be negative"},
+		{name: "shards", option: WithShards(-1, "app"), wantErr: "WithShards: must not be negative"},
+		{name: "combined", option: func(w *Watcher) { WithEmitInitial()(w); WithSkipInitial()(w) }, wantErr: "WithEmitInitial: cannot be combined with WithSkipInitial"},
+		{name: "route", option: WithRoute("team-a", WithOutput(io.Discard, OutputYAML)), wantErr: "WithRoute: requires WithRouteKey"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster, _ := NewFakeCluster("")
+			// A filter plugin loaded before the invalid option is found is released
+			released := false
+			loadedPlugin := func(w *Watcher) { w.closePlugin = func() { released = true } }
+			_, err := NewMultiCluster([]Cluster{cluster}, loadedPlugin, tc.option)
+			if err == nil || err.Error() != tc.wantErr {
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+			if !released {
+				t.Error("the filter plugin was not released")
+			}
+		})
+	}
+}
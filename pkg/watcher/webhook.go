@@ -0,0 +1,171 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// signatureHeader carries the HMAC-SHA256 of the request body when --webhook-secret is set
+const signatureHeader = "X-Pod-Watcher-Signature"
+
+// webhookSink is the Sink POSTing each event as a JSON envelope to an HTTP endpoint,
+// retrying failed deliveries with exponential backoff.
+type webhookSink struct {
+	ctx     context.Context // aborts deliveries in progress once canceled
+	url     string
+	headers http.Header
+	secret  []byte // HMAC signing key; nil disables signing
+	retries int    // additional attempts after the first failure
+	backoff time.Duration
+	client  *http.Client
+	// cloudEvents posts the events as CloudEvents rather than as envelopes, with their contentType
+	cloudEvents bool
+	contentType string
+}
+
+// newWebhookSink validates the webhook flags and returns the sink
+func newWebhookSink(url string, headers []string, timeout time.Duration, retries int, backoff time.Duration, secret string) (*webhookSink, error) {
+	sink := &webhookSink{
+		ctx:         context.Background(),
+		url:         url,
+		headers:     make(http.Header),
+		retries:     retries,
+		backoff:     backoff,
+		client:      &http.Client{Timeout: timeout},
+		contentType: "application/json",
+	}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid --webhook-header %q (must be \"Name: value\")", header)
+		}
+		sink.headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if secret != "" {
+		sink.secret = []byte(secret)
+	}
+	return sink, nil
+}
+
+// bind aborts the deliveries once the context is canceled
+func (s *webhookSink) bind(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// Write delivers the event, counting it as failed once every attempt has failed.
+// The requests carry the trace context of the delivery of a traced event.
+func (s *webhookSink) Write(event Event) error {
+	ctx := trace.ContextWithSpanContext(s.ctx, event.trace)
+	body, err := s.marshal(event)
+	if err == nil {
+		err = s.deliver(ctx, body)
+	}
+	if err != nil {
+		webhookFailures.Inc()
+		return err
+	}
+	return nil
+}
+
+// marshal serializes the event as a JSON envelope, or as a CloudEvent
+func (s *webhookSink) marshal(event Event) ([]byte, error) {
+	body, err := marshalEvent(event, s.cloudEvents)
+	if err != nil {
+		marshalErrors.Inc()
+		return nil, fmt.Errorf("could not marshal event: %w", err)
+	}
+	return body, nil
+}
+
+// Close releases the idle connections to the webhook
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// String names the sink for logs and metrics
+func (s *webhookSink) String() string {
+	if u, err := url.Parse(s.url); err == nil {
+		return "webhook:" + u.Host
+	}
+	return "webhook"
+}
+
+// queueID identifies the webhook for WithSinkQueue
+func (s *webhookSink) queueID() string {
+	return s.url
+}
+
+// encodeEvent serializes the event for WithSinkQueue
+func (s *webhookSink) encodeEvent(event Event) (queuedEvent, error) {
+	body, err := s.marshal(event)
+	if err != nil {
+		return queuedEvent{}, err
+	}
+	return queuedEvent{Time: event.Timestamp, Body: body}, nil
+}
+
+// deliverQueued delivers an event of the queue of WithSinkQueue with the retries of deliver. A failed delivery stays queued,
+// so it is not counted as a failure.
+func (s *webhookSink) deliverQueued(event queuedEvent) error {
+	return s.deliver(s.ctx, event.Body)
+}
+
+// deliver POSTs the body, retrying until it is accepted, the retries are exhausted, or the context is canceled
+func (s *webhookSink) deliver(ctx context.Context, body []byte) error {
+	var err error
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		err = s.post(ctx, body)
+		if err == nil || attempt >= s.retries || ctx.Err() != nil {
+			return err
+		}
+		slog.Warn("Webhook delivery failed, retrying", "attempt", attempt+1, "attempts", s.retries+1, "retryIn", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt
+func (s *webhookSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range s.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	injectTraceContext(ctx, req.Header)
+	if s.secret != nil {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(body)
+		req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package watcher
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	const secret = "s3cret"
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(signatureHeader)
+	}))
+	defer server.Close()
+	sink, err := newWebhookSink(server.URL, nil, time.Second, 0, time.Millisecond, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write(testPodEvent("ADDED", "web-1", corev1.PodPending)); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("got signature %q, want %q over the body %s", signature, want, body)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	signed := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, signed = r.Header[signatureHeader]
+	}))
+	defer server.Close()
+	sink, err := newWebhookSink(server.URL, nil, time.Second, 0, time.Millisecond, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write(testPodEvent("ADDED", "web-1", corev1.PodPending)); err != nil {
+		t.Fatal(err)
+	}
+	if signed {
+		t.Errorf("the request carries %s without a secret", signatureHeader)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	sink, err := newWebhookSink(server.URL, nil, time.Second, 2, time.Millisecond, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write(testPodEvent("ADDED", "web-1", corev1.PodPending)); err != nil {
+		t.Fatal(err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("got %d attempts, want 3", n)
+	}
+}
+
+func TestWebhookRetriesCanceled(t *testing.T) {
+	var attempts atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		time.AfterFunc(50*time.Millisecond, cancel)
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	sink, err := newWebhookSink(server.URL, nil, time.Second, 5, time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.bind(ctx)
+
+	// Canceling the context during the backoff stops the retries at once
+	done := make(chan error, 1)
+	go func() { done <- sink.Write(testPodEvent("ADDED", "web-1", corev1.PodPending)) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("the delivery succeeded, want it to fail")
+		}
+	case <-time.After(eventTimeout):
+		t.Fatal("the retries did not stop once the context was canceled")
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("got %d attempts, want 1", n)
+	}
+}
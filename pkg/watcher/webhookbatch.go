@@ -0,0 +1,42 @@
+package watcher
+
+import (
+	"fmt"
+	"time"
+)
+
+// batchedWebhookSink is the webhookSink POSTing the envelopes of the events as JSON arrays of up to size events,
+// once a batch is full or at every interval, retrying the batches that could not be delivered as an eventBatcher does.
+// The requests do not carry the trace context of the events, as a batch spans several traces.
+type batchedWebhookSink struct {
+	*webhookSink
+	batcher *eventBatcher
+}
+
+func newBatchedWebhookSink(sink *webhookSink, size int, interval time.Duration) (*batchedWebhookSink, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("--webhook-batch-interval must be positive when batching webhook deliveries")
+	}
+	s := &batchedWebhookSink{webhookSink: sink}
+	s.batcher = newEventBatcher(sink.String(), size, interval, webhookFailures, func(batch []byte) error {
+		return s.deliver(s.ctx, batch)
+	})
+	return s, nil
+}
+
+// Write queues the event for the next batch
+func (s *batchedWebhookSink) Write(event Event) error {
+	body, err := s.marshal(event)
+	if err != nil {
+		return err
+	}
+	s.batcher.add(body)
+	return nil
+}
+
+// Close delivers the pending events, counting those that could not be delivered as failed
+func (s *batchedWebhookSink) Close() error {
+	err := s.batcher.close()
+	_ = s.webhookSink.Close()
+	return err
+}
@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// DefaultWorkers and DefaultQueueSize size the pool processing the informer notifications unless WithWorkers is given
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 1000
+)
+
+// workerPool filters, serializes and emits the informer notifications on a fixed number of goroutines (--workers),
+// each with a bounded queue (--queue-size), so that the informers only wait once a queue is full.
+// The notifications of one object always go to the same worker, which processes them in order.
+type workerPool struct {
+	queues  []chan func()
+	workers sync.WaitGroup
+}
+
+func newWorkerPool(workers int, queueSize int) *workerPool {
+	p := &workerPool{}
+	for range workers {
+		queue := make(chan func(), queueSize)
+		p.queues = append(p.queues, queue)
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for job := range queue {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues the job on the worker of the object with the given key, waiting while its queue is full
+func (p *workerPool) submit(key string, job func()) {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	queueDepth.Inc()
+	p.queues[hash.Sum32()%uint32(len(p.queues))] <- func() {
+		queueDepth.Dec()
+		job()
+	}
+}
+
+// wait waits for the jobs submitted so far to be processed
+func (p *workerPool) wait() {
+	var done sync.WaitGroup
+	done.Add(len(p.queues))
+	for _, queue := range p.queues {
+		queue <- done.Done
+	}
+	done.Wait()
+}
+
+// stop processes the jobs still queued and then stops the workers; no job may be submitted afterwards
+func (p *workerPool) stop() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.workers.Wait()
+}
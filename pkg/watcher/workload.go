@@ -0,0 +1,92 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// workloadKinds maps the accepted spellings of the workload kinds of --for to their kind, as kubectl does
+var workloadKinds = map[string]string{
+	"deployment": "Deployment", "deployments": "Deployment", "deploy": "Deployment", "deployments.apps": "Deployment",
+	"statefulset": "StatefulSet", "statefulsets": "StatefulSet", "sts": "StatefulSet", "statefulsets.apps": "StatefulSet",
+	"daemonset": "DaemonSet", "daemonsets": "DaemonSet", "ds": "DaemonSet", "daemonsets.apps": "DaemonSet",
+	"replicaset": "ReplicaSet", "replicasets": "ReplicaSet", "rs": "ReplicaSet", "replicasets.apps": "ReplicaSet",
+	"job": "Job", "jobs": "Job", "jobs.batch": "Job",
+}
+
+// parseWorkload validates the --for workload, given as kind/name, returning its kind and name
+func parseWorkload(workload string) (string, string, error) {
+	kindArg, name, ok := strings.Cut(workload, "/")
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid --for %q: must be kind/name, e.g. deployment/web or job/migrate-db", workload)
+	}
+	kind, ok := workloadKinds[strings.ToLower(kindArg)]
+	if !ok {
+		return "", "", fmt.Errorf("invalid --for %q: unsupported kind %q (must be a deployment, statefulset, daemonset, replicaset or job)", workload, kindArg)
+	}
+	return kind, name, nil
+}
+
+// workloadSelector looks up the workload in the namespace and returns the label selector of its pods
+func workloadSelector(ctx context.Context, clientset kubernetes.Interface, namespace, kind, name string) (string, error) {
+	var selector *metav1.LabelSelector
+	switch kind {
+	case "Deployment":
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = deployment.Spec.Selector
+	case "StatefulSet":
+		statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = statefulSet.Spec.Selector
+	case "DaemonSet":
+		daemonSet, err := clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = daemonSet.Spec.Selector
+	case "ReplicaSet":
+		replicaSet, err := clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = replicaSet.Spec.Selector
+	case "Job":
+		job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = job.Spec.Selector
+	}
+	if selector == nil {
+		return "", fmt.Errorf("%s/%s has no pod selector", kind, name)
+	}
+	parsed, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", fmt.Errorf("invalid selector of %s/%s: %w", kind, name, err)
+	}
+	if parsed.Empty() {
+		// An empty selector would watch every pod of the namespace
+		return "", fmt.Errorf("%s/%s has no pod selector", kind, name)
+	}
+	return parsed.String(), nil
+}
+
+// joinSelectors combines label selectors, all of which must match
+func joinSelectors(selectors ...string) string {
+	var nonEmpty []string
+	for _, selector := range selectors {
+		if selector != "" {
+			nonEmpty = append(nonEmpty, selector)
+		}
+	}
+	return strings.Join(nonEmpty, ",")
+}
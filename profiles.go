@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+// watchProfiles are the profiles of the config file, each selecting the pods of a team for sinks of its own
+var watchProfiles []watchProfile
+
+// watchProfile is a profile of the config file, with its settings keyed like the flags they stand for
+type watchProfile struct {
+	name                 string
+	namespaces           []string
+	markers              []string
+	markerRegexes        []string
+	markerPaths          []string
+	markerAll            bool
+	filterCEL            []string
+	phases               []string
+	conditions           []string
+	labelSelector        string
+	excludeNamespaces    []string
+	excludeMarkers       []string
+	excludeLabelSelector string
+	eventTypes           []string
+	sinks                []string
+}
+
+// parseProfiles reads the profiles setting of the config file: a map from the name of each profile to its settings
+func parseProfiles(value interface{}) ([]watchProfile, error) {
+	if value == nil {
+		return nil, nil
+	}
+	settings, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid setting \"profiles\" in --config %s: must map the name of each profile to its settings", configFile)
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var profiles []watchProfile
+	for _, name := range names {
+		profile, err := parseProfile(name, settings[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile %q in --config %s: %w", name, configFile, err)
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+func parseProfile(name string, value interface{}) (watchProfile, error) {
+	profile := watchProfile{name: name}
+	settings, ok := value.(map[string]interface{})
+	if !ok {
+		return profile, fmt.Errorf("must be a map of settings")
+	}
+	for key, value := range settings {
+		var err error
+		switch key {
+		case "namespace":
+			profile.namespaces, err = profileList(value, true)
+		case "marker":
+			profile.markers, err = profileList(value, false)
+		case "marker-regex":
+			profile.markerRegexes, err = profileList(value, false)
+		case "marker-path":
+			profile.markerPaths, err = profileList(value, false)
+		case "marker-all":
+			if profile.markerAll, ok = value.(bool); !ok {
+				err = fmt.Errorf("must be true or false")
+			}
+		case "filter-cel":
+			profile.filterCEL, err = profileList(value, false)
+		case "phase":
+			profile.phases, err = profileList(value, true)
+		case "condition":
+			profile.conditions, err = profileList(value, true)
+		case "label-selector":
+			profile.labelSelector, err = profileString(value)
+		case "exclude-namespace":
+			profile.excludeNamespaces, err = profileList(value, true)
+		case "exclude-marker":
+			profile.excludeMarkers, err = profileList(value, false)
+		case "exclude-label-selector":
+			profile.excludeLabelSelector, err = profileString(value)
+		case "event-types":
+			profile.eventTypes, err = profileList(value, true)
+		case "sink":
+			profile.sinks, err = profileList(value, false)
+		default:
+			err = fmt.Errorf("not a setting of a profile (namespace, marker, marker-regex, marker-path, marker-all, filter-cel, " +
+				"phase, condition, label-selector, exclude-namespace, exclude-marker, exclude-label-selector, event-types, sink)")
+		}
+		if err != nil {
+			return profile, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if len(profile.markers) == 0 && len(profile.markerRegexes) == 0 && len(profile.filterCEL) == 0 && profile.labelSelector == "" {
+		return profile, fmt.Errorf("requires a marker, marker-regex, filter-cel or label-selector")
+	}
+	if len(profile.sinks) == 0 {
+		return profile, fmt.Errorf("requires a sink")
+	}
+	return profile, nil
+}
+
+// profileList reads a setting given as a list or a single value, which is comma-separated like the flag it stands for
+func profileList(value interface{}, commaSeparated bool) ([]string, error) {
+	var values []string
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			values = append(values, fmt.Sprint(item))
+		}
+	case map[string]interface{}:
+		return nil, fmt.Errorf("must be a scalar or a list")
+	default:
+		values = []string{fmt.Sprint(value)}
+		if commaSeparated {
+			values = strings.Split(values[0], ",")
+		}
+	}
+	return values, nil
+}
+
+func profileString(value interface{}) (string, error) {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return "", fmt.Errorf("must be a scalar")
+	}
+	return fmt.Sprint(value), nil
+}
+
+// profileOptions returns the options of the profiles, along with the number of files among their sinks
+func profileOptions(rotation watcher.FileRotation) ([]watcher.Option, int, error) {
+	var options []watcher.Option
+	files := 0
+	for _, profile := range watchProfiles {
+		profileOptions := []watcher.Option{
+			watcher.WithNamespaces(profile.namespaces...),
+			watcher.WithMarkers(profile.markers...),
+			watcher.WithMarkerRegexes(profile.markerRegexes...),
+			watcher.WithMarkerPaths(profile.markerPaths...),
+			watcher.WithCELFilters(profile.filterCEL...),
+			watcher.WithPhases(profile.phases...),
+			watcher.WithConditions(profile.conditions...),
+			watcher.WithLabelSelector(profile.labelSelector),
+			watcher.WithExcludeNamespaces(profile.excludeNamespaces...),
+			watcher.WithExcludeMarkers(profile.excludeMarkers...),
+			watcher.WithExcludeLabelSelector(profile.excludeLabelSelector),
+			watcher.WithEventTypes(profile.eventTypes...),
+		}
+		if profile.markerAll {
+			profileOptions = append(profileOptions, watcher.WithMarkerAll())
+		}
+		for _, spec := range profile.sinks {
+			option, file, err := sinkSpecOption("profiles."+profile.name+".sink", spec, rotation)
+			if err != nil {
+				return nil, 0, err
+			}
+			profileOptions = append(profileOptions, option)
+			if file {
+				files++
+			}
+		}
+		options = append(options, watcher.WithProfile(profile.name, profileOptions...))
+	}
+	return options, files, nil
+}
+
+// profileNamespaces returns the namespaces of every profile, or nil when a profile selects none, watching every namespace
+func profileNamespaces() []string {
+	var namespaces []string
+	for _, profile := range watchProfiles {
+		if len(profile.namespaces) == 0 {
+			return nil
+		}
+		namespaces = append(namespaces, profile.namespaces...)
+	}
+	return namespaces
+}
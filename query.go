@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+var (
+	queryPod        string
+	queryCluster    string
+	queryNamespace  string
+	querySince      string
+	queryUntil      string
+	queryAt         string
+	queryEventTypes []string
+	queryLimit      int
+	queryOutput     string
+)
+
+// queryCmd prints the event history recorded with --store
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Query the event history recorded with --store",
+	Long: `query prints the events recorded in an event store by pod-watcher --store, oldest first,
+selected by pod, namespace, time range, or event type.
+Times are RFC 3339 (2024-06-01T14:03:00Z), a time of day today (14:03), or a duration ago (90m).
+
+Examples:
+  pod-watcher query --store sqlite:events.db --pod default/web-0
+  pod-watcher query --store sqlite:events.db --pod default/web-0 --at 14:03
+  pod-watcher query --store sqlite:events.db -n team-a --since 2h --event-types DELETED -o jsonl
+`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runQuery(cmd)
+	},
+}
+
+func init() {
+	queryCmd.Flags().StringVar(&queryPod, "pod", "", "Only show the events of this pod (or other object), as namespace/name or name")
+	queryCmd.Flags().StringVar(&queryCluster, "cluster", "", "Only show the events from this cluster, as named by its context when watching several")
+	queryCmd.Flags().StringVarP(&queryNamespace, "namespace", "n", "", "Only show the events of objects in this namespace")
+	queryCmd.Flags().StringVar(&querySince, "since", "", "Only show events at or after this time")
+	queryCmd.Flags().StringVar(&queryUntil, "until", "", "Only show events at or before this time")
+	queryCmd.Flags().StringVar(&queryAt, "at", "", "Show the state of each object at this time, i.e. its last event at or before it")
+	queryCmd.Flags().StringSliceVar(&queryEventTypes, "event-types", nil, "Only show these event types (comma-separated)")
+	queryCmd.Flags().IntVar(&queryLimit, "limit", 0, "Show at most this many events (0 for no limit)")
+	queryCmd.Flags().StringVarP(&queryOutput, "output", "o", watcher.OutputYAML, "Output format: yaml, json, jsonl, cloudevents, diff, table, wide, go-template=TEMPLATE, or jsonpath=TEMPLATE")
+	queryCmd.MarkFlagsMutuallyExclusive("at", "since")
+	queryCmd.MarkFlagsMutuallyExclusive("at", "until")
+	rootCmd.AddCommand(queryCmd)
+}
+
+// runQuery prints the events selected by the query flags
+func runQuery(cmd *cobra.Command) error {
+	if storeSpec == "" {
+		return fmt.Errorf("query requires --store")
+	}
+	path, err := storePath(storeSpec)
+	if err != nil {
+		return err
+	}
+	store, err := watcher.OpenStore(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	now := time.Now()
+	query := watcher.StoreQuery{Cluster: queryCluster, Namespace: queryNamespace, Types: queryEventTypes, Limit: queryLimit}
+	if queryPod != "" {
+		if namespace, name, ok := strings.Cut(queryPod, "/"); ok {
+			query.Namespace, query.Name = namespace, name
+		} else {
+			query.Name = queryPod
+		}
+	}
+	if query.Since, err = parseQueryTime(querySince, now); err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	if query.Until, err = parseQueryTime(queryUntil, now); err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+	if queryAt != "" {
+		if query.Until, err = parseQueryTime(queryAt, now); err != nil {
+			return fmt.Errorf("invalid --at: %w", err)
+		}
+		query.Latest = true
+	}
+	events, err := store.Query(cmd.Context(), query)
+	if err != nil {
+		return err
+	}
+	out, err := watcher.NewWriterSink(os.Stdout, queryOutput)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, event := range events {
+		if err := out.Write(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storePath returns the path of the event store given as sqlite:PATH
+func storePath(spec string) (string, error) {
+	path, ok := strings.CutPrefix(spec, "sqlite:")
+	if !ok || path == "" {
+		return "", fmt.Errorf("unsupported store %q (must be sqlite:PATH)", spec)
+	}
+	return path, nil
+}
+
+// parseQueryTime parses an RFC 3339 time, a time of day today in the local time zone, or a duration before now.
+// An empty value is the zero time.
+func parseQueryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not an RFC 3339 time, a time of day, or a duration", value)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	releaseVersion   string
+	releasePlatforms []string
+	releaseSource    string
+	releaseDist      string
+)
+
+// defaultReleasePlatforms are the platforms of the release archives, those of the krew manifest
+var defaultReleasePlatforms = []string{"linux/amd64", "linux/arm64", "darwin/amd64", "darwin/arm64", "windows/amd64"}
+
+// releaseFiles are the files of the source tree shipped in the release archives alongside the binary
+var releaseFiles = []string{"LICENSE", "README.md"}
+
+// releaseCmd builds the release archives of every platform from a source checkout
+var releaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: "Build the release archives of every platform from a source checkout (for maintainers)",
+	Long: `release cross-compiles pod-watcher from the --source checkout for each of the --platforms with the Go toolchain
+on the $PATH, and packages each binary, as kubectl-pod_watch, with the LICENSE and README into the archives the krew
+manifest .krew.yaml refers to: pod-watcher_<os>_<arch>.tar.gz, or .zip for Windows. checksums.txt lists their SHA-256.
+
+The binaries are static (CGO_ENABLED=0), stamped with the --version, the commit and its time as the build date,
+and the archives take the time of the commit too, so that building the same commit again gives the same archives.
+
+  pod-watcher release --version v1.2.3
+`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRelease(cmd.Context())
+	},
+}
+
+func init() {
+	releaseCmd.Flags().StringVar(&releaseVersion, "version", "", "Version stamped into the binaries, e.g. v1.2.3 (defaults to git describe of the checkout)")
+	releaseCmd.Flags().StringSliceVar(&releasePlatforms, "platforms", defaultReleasePlatforms, "Platforms to build, as os/arch")
+	releaseCmd.Flags().StringVar(&releaseSource, "source", ".", "Source checkout of pod-watcher to build")
+	releaseCmd.Flags().StringVar(&releaseDist, "dist", "dist", "Directory receiving the archives and checksums.txt")
+	rootCmd.AddCommand(releaseCmd)
+}
+
+// runRelease builds and packages the binary of every platform
+func runRelease(ctx context.Context) error {
+	if _, err := exec.LookPath("go"); err != nil {
+		return fmt.Errorf("release requires the Go toolchain on the $PATH: %w", err)
+	}
+	commit, err := gitOutput(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	seconds, err := gitOutput(ctx, "show", "-s", "--format=%ct", "HEAD")
+	if err != nil {
+		return err
+	}
+	unix, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid commit time %q: %w", seconds, err)
+	}
+	committed := time.Unix(unix, 0).UTC()
+	version := releaseVersion
+	if version == "" {
+		if version, err = gitOutput(ctx, "describe", "--tags", "--always", "--dirty"); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(releaseDist, 0o755); err != nil {
+		return err
+	}
+	build, err := os.MkdirTemp("", "pod-watcher-release-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(build)
+	ldflags := fmt.Sprintf("-s -w -X main.version=%s -X main.commit=%s -X main.buildDate=%s", version, commit, committed.Format(time.RFC3339))
+	var checksums strings.Builder
+	for _, platform := range releasePlatforms {
+		goos, goarch, ok := strings.Cut(platform, "/")
+		if !ok || goos == "" || goarch == "" {
+			return fmt.Errorf("invalid platform %q: must be os/arch, e.g. linux/amd64", platform)
+		}
+		binary := "kubectl-pod_watch"
+		if goos == "windows" {
+			binary += ".exe"
+		}
+		output := filepath.Join(build, goos+"_"+goarch, binary)
+		fmt.Fprintf(os.Stderr, "Building %s\n", platform)
+		goBuild := exec.CommandContext(ctx, "go", "build", "-trimpath", "-ldflags", ldflags, "-o", output, ".")
+		goBuild.Dir = releaseSource
+		goBuild.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED=0")
+		goBuild.Stdout, goBuild.Stderr = os.Stderr, os.Stderr
+		if err := goBuild.Run(); err != nil {
+			return fmt.Errorf("could not build %s: %w", platform, err)
+		}
+		files := map[string]string{binary: output}
+		for _, name := range releaseFiles {
+			files[name] = filepath.Join(releaseSource, name)
+		}
+		names := append([]string{binary}, releaseFiles...)
+		archive := filepath.Join(releaseDist, "pod-watcher_"+goos+"_"+goarch)
+		if goos == "windows" {
+			archive += ".zip"
+			err = writeZip(archive, names, files, committed)
+		} else {
+			archive += ".tar.gz"
+			err = writeTarGz(archive, names, files, committed)
+		}
+		if err != nil {
+			return fmt.Errorf("could not package %s: %w", platform, err)
+		}
+		sum, err := sha256File(archive)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&checksums, "%s  %s\n", sum, filepath.Base(archive))
+		fmt.Println(archive)
+	}
+	return os.WriteFile(filepath.Join(releaseDist, "checksums.txt"), []byte(checksums.String()), 0o644)
+}
+
+// gitOutput runs git in the source checkout, returning its trimmed output
+func gitOutput(ctx context.Context, args ...string) (string, error) {
+	git := exec.CommandContext(ctx, "git", args...)
+	git.Dir = releaseSource
+	git.Stderr = os.Stderr
+	output, err := git.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed in %s: %w", strings.Join(args, " "), releaseSource, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// writeTarGz packages the files, by their name in the archive, into a gzip-compressed tarball, the binary executable
+func writeTarGz(path string, names []string, files map[string]string, modified time.Time) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for i, name := range names {
+		content, err := os.ReadFile(files[name])
+		if err != nil {
+			return err
+		}
+		mode := int64(0o644)
+		if i == 0 {
+			mode = 0o755
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: mode, Size: int64(len(content)), ModTime: modified, Format: tar.FormatPAX}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// writeZip packages the files, by their name in the archive, into a zip archive
+func writeZip(path string, names []string, files map[string]string, modified time.Time) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for _, name := range names {
+		in, err := os.Open(files[name])
+		if err != nil {
+			return err
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+		if err == nil {
+			_, err = io.Copy(w, in)
+		}
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// sha256File returns the hex SHA-256 of the file
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
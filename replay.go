@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+var (
+	replaySpeed float64
+	replaySince string
+	replayUntil string
+)
+
+// replayCmd re-emits a recorded event stream through the sinks
+var replayCmd = &cobra.Command{
+	Use:   "replay SOURCE",
+	Short: "Re-emit a recorded event stream through the configured sinks",
+	Long: `replay reads the events recorded by pod-watcher and emits them again, in order, through the sinks configured
+with the usual output flags (--output, --sink, --webhook-url, --kafka-brokers, --nats-url, --store), e.g. to reproduce
+an incident against downstream tooling.
+SOURCE is a stream captured with --output yaml, json, or jsonl (optionally gzip-compressed; - for stdin),
+or an event store given as sqlite:PATH.
+By default the events are emitted as fast as the sinks accept them; --speed reproduces the original intervals
+between them, divided by the given factor. Streams in the yaml format do not record the event times.
+
+Examples:
+  pod-watcher replay events.jsonl --webhook-url http://localhost:8080/pods
+  pod-watcher replay sqlite:events.db --since 2024-06-01T14:00:00Z --until 2024-06-01T15:00:00Z --speed 1
+  pod-watcher replay incident.json.gz --speed 10 --sink stdout=jsonl --kafka-brokers kafka:9092 --kafka-topic pods-test
+`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runReplay(cmd, args[0])
+	},
+}
+
+func init() {
+	replayCmd.Flags().Float64Var(&replaySpeed, "speed", 0, "Reproduce the original intervals between the events, divided by this factor, e.g. 1 for real time or 10 for ten times faster (0 emits them without delay)")
+	replayCmd.Flags().StringVar(&replaySince, "since", "", "Only replay the events at or after this time (RFC 3339, a time of day today, or a duration ago)")
+	replayCmd.Flags().StringVar(&replayUntil, "until", "", "Only replay the events at or before this time (RFC 3339, a time of day today, or a duration ago)")
+	addSinkFlags(replayCmd)
+	registerCompletions(replayCmd)
+	rootCmd.AddCommand(replayCmd)
+}
+
+// runReplay reads the recorded events of the source and replays them through the sinks
+func runReplay(cmd *cobra.Command, source string) error {
+	now := time.Now()
+	since, err := parseQueryTime(replaySince, now)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	until, err := parseQueryTime(replayUntil, now)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+	if source == storeSpec {
+		return fmt.Errorf("cannot replay the --store %s into itself", storeSpec)
+	}
+	options, err := sinkOptions()
+	if err != nil {
+		return err
+	}
+	var events []watcher.Event
+	if strings.HasPrefix(source, "sqlite:") {
+		path, err := storePath(source)
+		if err != nil {
+			return err
+		}
+		store, err := watcher.OpenStore(path)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if events, err = store.Query(cmd.Context(), watcher.StoreQuery{Since: since, Until: until}); err != nil {
+			return err
+		}
+	} else {
+		if events, err = readEventFile(source); err != nil {
+			return err
+		}
+		if events, err = eventsBetween(events, since, until); err != nil {
+			return err
+		}
+	}
+	return watcher.Replay(cmd.Context(), events, replaySpeed, options...)
+}
+
+// readEventFile reads the events recorded in the file at path, or on stdin for -
+func readEventFile(path string) ([]watcher.Event, error) {
+	if path == "-" {
+		return watcher.ReadEvents(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	events, err := watcher.ReadEvents(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	return events, nil
+}
+
+// eventsBetween returns the events from since to until, both inclusive; zero times do not restrict the selection
+func eventsBetween(events []watcher.Event, since, until time.Time) ([]watcher.Event, error) {
+	if since.IsZero() && until.IsZero() {
+		return events, nil
+	}
+	var selected []watcher.Event
+	for _, event := range events {
+		if event.Timestamp.IsZero() {
+			return nil, fmt.Errorf("--since and --until require recorded event times, which the yaml format does not have")
+		}
+		if (since.IsZero() || !event.Timestamp.Before(since)) && (until.IsZero() || !event.Timestamp.After(until)) {
+			selected = append(selected, event)
+		}
+	}
+	return selected, nil
+}
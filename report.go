@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+var (
+	reportPod       string
+	reportCluster   string
+	reportNamespace string
+	reportSince     string
+	reportUntil     string
+	reportOutput    string
+)
+
+// reportCmd prints the lifecycle timelines of the pods recorded with --store
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report the lifecycle timeline of the pods recorded with --store",
+	Long: `report prints the timeline of each pod recorded in an event store by pod-watcher --store:
+when it was created, scheduled onto its node, got its images, started its containers, became ready,
+restarted, and was deleted, with the time between each of these milestones.
+Pods that still exist are reported up to their last recorded revision.
+Times are RFC 3339 (2024-06-01T14:03:00Z), a time of day today (14:03), or a duration ago (90m).
+
+Examples:
+  pod-watcher report --store sqlite:events.db --pod default/web-0
+  pod-watcher report --store sqlite:events.db -n team-a --since 2h -o jsonl
+`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runReport(cmd)
+	},
+}
+
+func init() {
+	reportCmd.Flags().StringVar(&reportPod, "pod", "", "Only report this pod, as namespace/name or name")
+	reportCmd.Flags().StringVar(&reportCluster, "cluster", "", "Only report the pods from this cluster, as named by its context when watching several")
+	reportCmd.Flags().StringVarP(&reportNamespace, "namespace", "n", "", "Only report the pods in this namespace")
+	reportCmd.Flags().StringVar(&reportSince, "since", "", "Only use the events at or after this time")
+	reportCmd.Flags().StringVar(&reportUntil, "until", "", "Only use the events at or before this time")
+	reportCmd.Flags().StringVarP(&reportOutput, "output", "o", watcher.OutputYAML, "Output format: yaml, json, jsonl, go-template=TEMPLATE, or jsonpath=TEMPLATE")
+	rootCmd.AddCommand(reportCmd)
+}
+
+// runReport prints the timelines of the pods selected by the report flags
+func runReport(cmd *cobra.Command) error {
+	if storeSpec == "" {
+		return fmt.Errorf("report requires --store")
+	}
+	path, err := storePath(storeSpec)
+	if err != nil {
+		return err
+	}
+	store, err := watcher.OpenStore(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	now := time.Now()
+	query := watcher.StoreQuery{
+		Cluster:   reportCluster,
+		Namespace: reportNamespace,
+		Types:     []string{string(watch.Added), string(watch.Modified), string(watch.Deleted), watcher.ResyncEvent},
+	}
+	if reportPod != "" {
+		if namespace, name, ok := strings.Cut(reportPod, "/"); ok {
+			query.Namespace, query.Name = namespace, name
+		} else {
+			query.Name = reportPod
+		}
+	}
+	if query.Since, err = parseQueryTime(reportSince, now); err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	if query.Until, err = parseQueryTime(reportUntil, now); err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+	events, err := store.Query(cmd.Context(), query)
+	if err != nil {
+		return err
+	}
+	out, err := watcher.NewWriterSink(os.Stdout, reportOutput)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, timeline := range watcher.BuildTimelines(events) {
+		if err := out.Write(timeline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
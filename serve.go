@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	podwatcherv1 "github.com/stephenc/pod-watcher/pkg/api/podwatcher/v1"
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+var (
+	grpcAddr       string
+	grpcTLSCert    string
+	grpcTLSKey     string
+	grpcTokenFile  string
+	grpcMaxStreams int
+)
+
+// defaultGRPCMaxStreams caps the concurrent WatchPods calls, each of which runs a watcher of its own
+const defaultGRPCMaxStreams = 16
+
+// serveCmd serves filtered pod change streams over gRPC
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve filtered pod change streams over gRPC",
+	Long: `serve exposes the PodWatcher gRPC service of pkg/api/podwatcher/v1, whose WatchPods call streams the changes
+to the pods matching a FilterSpec (namespaces, markers, CEL filters, selectors, and event types), so that other
+services can subscribe to them without running informers of their own.
+Each call watches the pods it selects, in the clusters of the kubeconfig contexts given with --context,
+until the client cancels it; at most --grpc-max-streams calls run at once. The standard gRPC health service
+reports the server as serving.
+The API is only served on localhost unless the clients authenticate over TLS: any other address requires
+--grpc-token-file, whose bearer token the clients must send, together with --grpc-tls-cert and --grpc-tls-key.
+
+Examples:
+  pod-watcher serve
+  pod-watcher serve --grpc-addr :50051 --grpc-token-file token --grpc-tls-cert tls.crt --grpc-tls-key tls.key
+  pod-watcher serve --context staging --context production
+`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServe(cmd.Context())
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&grpcAddr, "grpc-addr", "localhost:50051", "Serve the gRPC API on this address; any other than localhost requires --grpc-token-file and --grpc-tls-cert")
+	serveCmd.Flags().StringVar(&grpcTLSCert, "grpc-tls-cert", "", "PEM certificate served over TLS (plaintext by default)")
+	serveCmd.Flags().StringVar(&grpcTLSKey, "grpc-tls-key", "", "PEM key of the --grpc-tls-cert certificate")
+	serveCmd.Flags().StringVar(&grpcTokenFile, "grpc-token-file", "", "File holding the token the clients must send as a bearer token in the authorization metadata of their calls")
+	serveCmd.Flags().IntVar(&grpcMaxStreams, "grpc-max-streams", defaultGRPCMaxStreams, "Maximum number of concurrent WatchPods calls, each running a watcher of its own")
+	serveCmd.Flags().BoolVar(&skipPermissionCheck, "skip-permission-check", false, "Serve without first checking, with SelfSubjectAccessReviews, that the RBAC grants the watch of the pods of every namespace")
+	serveCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (defaults to in-cluster or default config)")
+	serveCmd.Flags().StringArrayVar(&kubecontexts, "context", nil, "The kubeconfig context to watch (defaults to the current context; repeatable to watch several clusters at once)")
+	serveCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9090 (disabled by default)")
+	serveCmd.MarkFlagsRequiredTogether("grpc-tls-cert", "grpc-tls-key")
+	addConnectionFlags(serveCmd)
+	rootCmd.AddCommand(serveCmd)
+}
+
+// runServe serves the gRPC API until the context is canceled
+func runServe(ctx context.Context) error {
+	if grpcMaxStreams < 1 {
+		return fmt.Errorf("--grpc-max-streams must be at least 1")
+	}
+	var token string
+	if grpcTokenFile != "" {
+		var err error
+		if token, err = readTokenFile(grpcTokenFile); err != nil {
+			return fmt.Errorf("could not read the gRPC token: %w", err)
+		}
+	}
+	if !loopbackAddr(grpcAddr) && (grpcTokenFile == "" || grpcTLSCert == "") {
+		return fmt.Errorf("serving the gRPC API on %s, beyond localhost, requires --grpc-token-file and --grpc-tls-cert so that the clients authenticate over TLS", grpcAddr)
+	}
+	clusters, err := clusterConfigs()
+	if err != nil {
+		return fmt.Errorf("could not load Kubernetes config: %w", err)
+	}
+	// Fail with the missing permissions up front, rather than in every call
+	if !skipPermissionCheck {
+		if err := checkPermissions(clusters); err != nil {
+			return err
+		}
+	}
+	serverOptions := []grpc.ServerOption{grpc.ChainStreamInterceptor(authenticate(token))}
+	if grpcTLSCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(grpcTLSCert, grpcTLSKey)
+		if err != nil {
+			return fmt.Errorf("could not load the gRPC TLS certificate: %w", err)
+		}
+		serverOptions = append(serverOptions, grpc.Creds(creds))
+	}
+	listener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		return err
+	}
+	if metricsAddr != "" {
+		go watcher.ServeMetrics(ctx, metricsAddr)
+	}
+	server := grpc.NewServer(serverOptions...)
+	podwatcherv1.RegisterPodWatcherServer(server, &podWatcherServer{clusters: clusters, streams: make(chan struct{}, grpcMaxStreams)})
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go func() {
+		<-ctx.Done()
+		// The streams only end when canceled, so there is nothing to wait for
+		healthServer.Shutdown()
+		server.Stop()
+	}()
+	slog.Info("Serving the gRPC API", "address", listener.Addr().String(), "tls", grpcTLSCert != "", "authenticated", token != "",
+		"maxStreams", grpcMaxStreams)
+	return server.Serve(listener)
+}
+
+// loopbackAddr tells whether the address only listens on the loopback interface
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// readTokenFile returns the token held by the file, failing when it is empty
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("the token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// authenticate requires the calls of the PodWatcher service to carry the token as a bearer token, unless it is empty.
+// The health service is left open for the probes.
+func authenticate(token string) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if token == "" || !strings.HasPrefix(info.FullMethod, "/"+podwatcherv1.PodWatcher_ServiceDesc.ServiceName+"/") {
+			return handler(srv, stream)
+		}
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		for _, authorization := range md.Get("authorization") {
+			bearer, found := strings.CutPrefix(authorization, "Bearer ")
+			if found && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+				return handler(srv, stream)
+			}
+		}
+		return status.Error(codes.Unauthenticated, "a valid bearer token is required")
+	}
+}
+
+// podWatcherServer implements WatchPods with a watcher of its own for each call
+type podWatcherServer struct {
+	podwatcherv1.UnimplementedPodWatcherServer
+	clusters []watcher.Cluster
+	streams  chan struct{} // holds a token for each call in progress, up to --grpc-max-streams
+}
+
+// WatchPods streams the events of the pods matching the filter until the call is canceled
+func (s *podWatcherServer) WatchPods(filter *podwatcherv1.FilterSpec, stream grpc.ServerStreamingServer[podwatcherv1.PodEvent]) error {
+	// Like the command line, a filter must select the pods rather than stream every pod of the clusters
+	if len(filter.GetMarkers()) == 0 && len(filter.GetMarkerRegexes()) == 0 && len(filter.GetFilterCel()) == 0 &&
+		filter.GetLabelSelector() == "" && filter.GetFieldSelector() == "" {
+		return status.Error(codes.InvalidArgument, "the filter must select pods with markers, marker regexes, CEL filters, or a label or field selector")
+	}
+	select {
+	case s.streams <- struct{}{}:
+		defer func() { <-s.streams }()
+	default:
+		return status.Errorf(codes.ResourceExhausted, "the server already serves %d WatchPods calls", cap(s.streams))
+	}
+	options := []watcher.Option{
+		watcher.WithNamespaces(filter.GetNamespaces()...),
+		watcher.WithMarkers(filter.GetMarkers()...),
+		watcher.WithMarkerRegexes(filter.GetMarkerRegexes()...),
+		watcher.WithMarkerPaths(filter.GetMarkerPaths()...),
+		watcher.WithCELFilters(filter.GetFilterCel()...),
+		watcher.WithLabelSelector(filter.GetLabelSelector()),
+		watcher.WithFieldSelector(filter.GetFieldSelector()),
+		watcher.WithEventTypes(filter.GetEventTypes()...),
+	}
+	if filter.GetMarkerAll() {
+		options = append(options, watcher.WithMarkerAll())
+	}
+	w, err := watcher.NewMultiCluster(s.clusters, options...)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	client := "unknown"
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		client = p.Addr.String()
+	}
+	slog.Info("WatchPods call started", "client", client)
+	defer slog.Info("WatchPods call ended", "client", client)
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	events := w.Events()
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	var sendErr error
+	for event := range events {
+		if sendErr != nil {
+			continue // drain the events emitted before the watcher stops
+		}
+		message, err := podEvent(event)
+		if err == nil {
+			err = stream.Send(message)
+		}
+		if err != nil {
+			sendErr = err
+			cancel()
+		}
+	}
+	if err := <-done; err != nil && ctx.Err() == nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	return status.FromContextError(stream.Context().Err()).Err()
+}
+
+// podEvent converts an event to its message
+func podEvent(event watcher.Event) (*podwatcherv1.PodEvent, error) {
+	pod, err := json.Marshal(event.Object)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not marshal %s: %v", event.Key, err)
+	}
+	return &podwatcherv1.PodEvent{
+		Type:      event.Type,
+		Key:       event.Key,
+		Cluster:   event.Cluster,
+		Timestamp: timestamppb.New(event.Timestamp),
+		Pod:       pod,
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals are the signals shutting the watcher down gracefully: Ctrl+C (SIGINT), SIGTERM as sent by
+// Kubernetes, systemd or kill, and the hangup of its terminal (SIGHUP), which would otherwise kill it without
+// draining the sinks
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
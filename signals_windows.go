@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals are the console events shutting the watcher down gracefully. The Go runtime delivers both
+// Ctrl+C and Ctrl+Break as os.Interrupt, the latter being the event sent to a process group started with
+// CREATE_NEW_PROCESS_GROUP, e.g. by a service wrapper, and the closing of the console window, the logoff and
+// the shutdown of the system as SIGTERM, after which Windows allows a few seconds before terminating the process.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+var simulateInputs []string
+
+// simulatedFlags are the flags of the watch that the simulate command shares, those deciding what is emitted and how
+var simulatedFlags = []string{
+	"marker", "marker-regex", "marker-path", "marker-all", "filter-cel", "filter-plugin", "phase", "condition", "namespace", "all-namespaces", "label-selector",
+	"exclude-namespace", "exclude-marker", "exclude-label-selector", "watch-label", "watch-annotation", "event-types",
+	"strip", "redact", "redact-env", "redact-annotations", "redact-path", "include-managed-fields-summary",
+	"dedupe", "on-image-change", "spec-changes-only", "status-changes-only", "track-containers", "track-init", "track-images", "security-watch", "timeline",
+	"restart-threshold", "flap-threshold", "flap-window", "track-termination",
+	"wait-for", "max-events", "exec", "exec-concurrency", "exec-timeout",
+}
+
+// simulateCmd feeds local manifests through the filters and sinks
+var simulateCmd = &cobra.Command{
+	Use:   "simulate --input FILE",
+	Short: "Feed local manifests through the filters and sinks as synthetic events, without a cluster",
+	Long: `simulate reads pods, or other objects, from local YAML or JSON files and feeds them through the same filters,
+transformations and sinks as a watch, as synthetic events: the first occurrence of an object is ADDED, and every later one
+MODIFIED. This validates markers, CEL expressions, exclusions, templates and sinks without cluster access.
+An input is a file of YAML documents or JSON objects, such as the output of kubectl get -o yaml, a directory of such
+files (*.yaml, *.yml and *.json, in name order), or - for stdin. The namespace and label selectors are applied to the
+objects; features that need a cluster, such as --tail-logs or --resolve-owners, are not available.
+
+Examples:
+  pod-watcher simulate --input pods.yaml --marker DEBUG_MODE
+  kubectl get pods -A -o yaml | pod-watcher simulate --input - --filter-cel "pod.status.phase == 'Failed'" -o table
+  pod-watcher simulate --input manifests/ --marker team-a -o 'go-template={{.name}} {{.pod.status.phase}}'
+`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := runSimulate(cmd)
+		var exit *watcher.ExitError
+		if errors.As(err, &exit) {
+			slog.Error(exit.Message, "exitCode", exit.Code)
+			os.Exit(exit.Code)
+		}
+		return err
+	},
+}
+
+func init() {
+	simulateCmd.Flags().StringArrayVarP(&simulateInputs, "input", "i", nil, "File or directory of YAML or JSON manifests to simulate, or - for stdin (repeatable)")
+	_ = simulateCmd.MarkFlagRequired("input")
+	for _, name := range simulatedFlags {
+		simulateCmd.Flags().AddFlag(rootCmd.Flags().Lookup(name))
+	}
+	addSinkFlags(simulateCmd)
+	registerCompletions(simulateCmd)
+	rootCmd.AddCommand(simulateCmd)
+}
+
+// runSimulate reads the objects of the inputs and simulates their events
+func runSimulate(cmd *cobra.Command) error {
+	var objects []runtime.Object
+	for _, input := range simulateInputs {
+		read, err := readManifests(input)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, read...)
+	}
+	options, err := watcherOptions(time.Now())
+	if err != nil {
+		return err
+	}
+	return watcher.Simulate(cmd.Context(), objects, options...)
+}
+
+// readManifests reads the objects of a file, of the manifests of a directory, or of stdin for -
+func readManifests(path string) ([]runtime.Object, error) {
+	if path == "-" {
+		return watcher.ReadObjects(os.Stdin)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return readManifestFile(path)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var objects []runtime.Object
+	for _, entry := range entries {
+		if entry.IsDir() || !slices.Contains([]string{".yaml", ".yml", ".json"}, strings.ToLower(filepath.Ext(entry.Name()))) {
+			continue
+		}
+		read, err := readManifestFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, read...)
+	}
+	return objects, nil
+}
+
+// readManifestFile reads the objects of one file
+func readManifestFile(path string) ([]runtime.Object, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	objects, err := watcher.ReadObjects(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	return objects, nil
+}
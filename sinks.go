@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+// eventOutput is the stdout of the event stream, which the bench command discards
+var eventOutput io.Writer = os.Stdout
+
+// addSinkFlags defines the flags configuring the sinks on a command that emits events
+func addSinkFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVarP(&outputFormat, "output", "o", watcher.OutputYAML, "Output format: yaml, json, jsonl, cloudevents, diff, table, wide, go-template=TEMPLATE, or jsonpath=TEMPLATE")
+	flags.StringVar(&colorMode, "color", "auto", "Colorize the output on stdout: auto (when it is a terminal and $NO_COLOR is not set), always, or never")
+	flags.StringVar(&framing, "framing", "", "Frame the records of the output for stream processors: yaml (--- before and ... after each document), json-seq (RFC 7464), length-prefixed (4-byte big-endian length) or nul (NUL-terminated); defaults to the separators of the --output format")
+	flags.StringArrayVar(&sinkSpecs, "sink", nil, "Deliver events to this sink: stdout[=FORMAT], file=PATH, or webhook=URL (repeatable; replaces the default stdout output)")
+	flags.StringVar(&cloudEventsSource, "cloudevents-source", "", "Source of the CloudEvents of --output cloudevents, --webhook-cloudevents and --kafka-cloudevents (defaults to the kubeconfig context; the context of each cluster when watching several)")
+	flags.StringVar(&routeKey, "route-key", "", "Label or annotation of the pods routing their events to the sinks of --route, e.g. pod-watcher.io/sink")
+	flags.StringArrayVar(&routes, "route", nil, "Deliver the events of the pods whose --route-key has VALUE only to this sink, as VALUE=SINK with SINK as for --sink; VALUE * receives the pods routed nowhere else (repeatable)")
+	flags.StringVar(&webhookURL, "webhook-url", "", "POST each emitted event as a JSON envelope to this URL")
+	flags.StringArrayVar(&webhookHeaders, "webhook-header", nil, "Extra header for webhook requests, as \"Name: value\" (repeatable)")
+	flags.DurationVar(&webhookTimeout, "webhook-timeout", 10*time.Second, "Timeout for each webhook request")
+	flags.IntVar(&webhookRetries, "webhook-retries", 3, "Number of times to retry a failed webhook delivery")
+	flags.DurationVar(&webhookBackoff, "webhook-backoff", time.Second, "Delay before the first webhook retry, doubling after each attempt")
+	flags.IntVar(&webhookBatchSize, "webhook-batch-size", 0, "POST the events to the webhooks, and publish them to Kafka, as JSON arrays of up to this many events (0 or 1 delivers each event on its own)")
+	flags.DurationVar(&webhookBatchInterval, "webhook-batch-interval", time.Second, "Maximum time an event waits for its webhook or Kafka batch to fill up")
+	flags.BoolVar(&webhookCloudEvents, "webhook-cloudevents", false, "POST the events to the webhooks as CloudEvents 1.0 in the structured JSON mode, and the batches of --webhook-batch-size as CloudEvents batches")
+	flags.StringVar(&webhookSecret, "webhook-secret", "", "Sign webhook payloads with HMAC-SHA256 using this secret, sent in the X-Pod-Watcher-Signature header (defaults to $POD_WATCHER_WEBHOOK_SECRET)")
+	flags.StringSliceVar(&kafkaBrokers, "kafka-brokers", nil, "Publish each emitted event to Kafka via these bootstrap brokers (host:port, comma-separated)")
+	flags.StringVar(&kafkaTopic, "kafka-topic", "", "Kafka topic the events are published to, keyed by namespace/name")
+	flags.BoolVar(&kafkaTLS, "kafka-tls", false, "Connect to the Kafka brokers over TLS")
+	flags.StringVar(&kafkaTLSCA, "kafka-tls-ca", "", "PEM CA bundle used to verify the Kafka brokers (defaults to the system roots)")
+	flags.StringVar(&kafkaTLSCert, "kafka-tls-cert", "", "PEM client certificate for mutual TLS with the Kafka brokers")
+	flags.StringVar(&kafkaTLSKey, "kafka-tls-key", "", "PEM key of the --kafka-tls-cert client certificate")
+	flags.BoolVar(&kafkaTLSInsecure, "kafka-tls-insecure", false, "Skip verification of the Kafka brokers' certificates")
+	flags.StringVar(&kafkaSASL, "kafka-sasl-mechanism", "", "SASL mechanism for Kafka: plain, scram-sha-256, or scram-sha-512 (disabled by default)")
+	flags.StringVar(&kafkaUsername, "kafka-sasl-username", "", "SASL username for Kafka")
+	flags.StringVar(&kafkaPassword, "kafka-sasl-password", "", "SASL password for Kafka (defaults to $POD_WATCHER_KAFKA_PASSWORD)")
+	flags.BoolVar(&kafkaCloudEvents, "kafka-cloudevents", false, "Publish the events to Kafka as CloudEvents 1.0 in the structured JSON mode")
+	flags.IntVar(&kafkaBatchSize, "kafka-batch-size", 100, "Maximum number of events per Kafka produce request")
+	flags.DurationVar(&kafkaBatchTimeout, "kafka-batch-timeout", time.Second, "Maximum time an event waits for its Kafka batch to fill up")
+	flags.StringVar(&natsURL, "nats-url", "", "Publish each emitted event to NATS via this server URL (comma-separated for a cluster)")
+	flags.StringVar(&natsSubject, "nats-subject", "", "NATS subject the events are published to")
+	flags.BoolVar(&natsJetStream, "nats-jetstream", false, "Publish to a JetStream stream and wait for its acknowledgement of each event")
+	flags.DurationVar(&natsAckTimeout, "nats-ack-timeout", 5*time.Second, "How long to wait for a JetStream acknowledgement")
+	flags.StringVar(&natsCredentials, "nats-credentials", "", "NATS user credentials file")
+	flags.StringVar(&natsToken, "nats-token", "", "NATS authentication token (defaults to $POD_WATCHER_NATS_TOKEN)")
+	flags.BoolVar(&natsTLS, "nats-tls", false, "Connect to NATS over TLS")
+	flags.StringVar(&natsTLSCA, "nats-tls-ca", "", "PEM CA bundle used to verify the NATS servers (defaults to the system roots)")
+	flags.StringVar(&natsTLSCert, "nats-tls-cert", "", "PEM client certificate for mutual TLS with NATS")
+	flags.StringVar(&natsTLSKey, "nats-tls-key", "", "PEM key of the --nats-tls-cert client certificate")
+	flags.BoolVar(&natsTLSInsecure, "nats-tls-insecure", false, "Skip verification of the NATS servers' certificates")
+	flags.StringVar(&archiveURL, "archive", "", "Upload the event stream to S3 or GCS, as s3://BUCKET[/PREFIX] or gs://BUCKET[/PREFIX], in gzip-compressed JSON lines chunks listed by a manifest")
+	flags.StringVar(&archiveChunkSize, "archive-chunk-size", "64Mi", "Close an archive chunk once it holds this many bytes of events, before compression")
+	flags.DurationVar(&archiveChunkInterval, "archive-chunk-interval", watcher.DefaultArchiveChunkInterval, "Close an archive chunk once it has been open for this long, and retry the failed uploads as often")
+	flags.StringVar(&archiveEncryption, "archive-encryption", "", "Server-side encryption of the S3 archive: AES256, aws:kms, or aws:kms:dsse (defaults to that of the bucket)")
+	flags.StringVar(&archiveKMSKey, "archive-kms-key", "", "KMS key encrypting the archive: the key of --archive-encryption aws:kms for S3, or a Cloud KMS key name for GCS")
+	flags.StringVar(&archiveRegion, "archive-region", "", "Region of the S3 archive bucket (defaults to that of the AWS configuration)")
+	flags.StringVar(&archiveEndpoint, "archive-endpoint", "", "Endpoint of an S3-compatible store, e.g. MinIO, receiving the archive")
+	flags.StringVar(&sinkQueueDir, "sink-queue-dir", "", "Persist the events the webhooks and Kafka fail to deliver in a queue under this directory, and redeliver them in order once they recover, also after a restart")
+	flags.DurationVar(&sinkQueueRetention, "sink-queue-retention", 24*time.Hour, "Drop the queued events of --sink-queue-dir older than this (0 keeps them until delivered)")
+	flags.StringVar(&sinkQueueMaxSize, "sink-queue-max-size", "1Gi", "Drop the oldest queued events of a sink once its queue in --sink-queue-dir exceeds this size (0 for no limit)")
+	flags.StringVar(&slackWebhook, "slack-webhook", "", "Post a notification to this Slack incoming webhook when an event matches --notify-on (defaults to $POD_WATCHER_SLACK_WEBHOOK)")
+	flags.StringVar(&teamsWebhook, "teams-webhook", "", "Post a notification to this Microsoft Teams workflow webhook when an event matches --notify-on (defaults to $POD_WATCHER_TEAMS_WEBHOOK)")
+	flags.StringSliceVar(&notifyOn, "notify-on", []string{watcher.TriggerDeleted, watcher.TriggerFailed, watcher.TriggerRestarted}, "Triggers of the Slack and Teams notifications: deleted, failed (the pod entered the Failed phase), restarted (a container restarted), alert (an ALERT event of --restart-threshold, --flap-threshold or --terminating-threshold)")
+	flags.DurationVar(&notifyInterval, "notify-interval", 5*time.Minute, "Minimum time between two notifications of the same trigger for the same object (0 disables throttling)")
+	flags.StringVar(&outputFile, "output-file", "", "Write the event stream to this file instead of stdout (gzip-compressed if it ends in .gz)")
+	flags.StringVar(&outputDir, "output-dir", "", "Write the events of each pod to a file of its own in this directory, named <namespace>__<name>.yaml, instead of stdout")
+	flags.BoolVar(&gzipOutput, "gzip", false, "Gzip-compress the event stream written to --output-file")
+	flags.StringVar(&maxFileSize, "max-file-size", "", "Rotate --output-file once it reaches this size, e.g. 100Mi (disabled by default)")
+	flags.IntVar(&maxFiles, "max-files", 5, "Number of rotated output files to keep")
+	flags.BoolVar(&compressRotate, "compress-rotated", false, "Gzip-compress rotated output files")
+	cmd.MarkFlagsRequiredTogether("kafka-brokers", "kafka-topic")
+	cmd.MarkFlagsRequiredTogether("nats-url", "nats-subject")
+}
+
+// sinkOptions translates --sink, --route, --output-file, --output-dir, --webhook-url, --kafka-brokers, --nats-url, --archive, --slack-webhook, --teams-webhook
+// and --store into watcher options. Without --sink the event stream goes to stdout, or to the --output-file and --output-dir instead,
+// and to the webhook, Kafka, NATS, the archive and the store if set; Slack and Teams only receive the notifications of --notify-on.
+// Each --sink adds one destination: stdout in the --output format or a given one, a file, or a webhook.
+// Each --route adds one likewise, receiving only the events of the pods routed to it by --route-key.
+func sinkOptions() ([]watcher.Option, error) {
+	rotation, err := outputRotation()
+	if err != nil {
+		return nil, err
+	}
+	var options []watcher.Option
+	color, err := colorOutput()
+	if err != nil {
+		return nil, err
+	}
+	if color {
+		options = append(options, watcher.WithColor())
+	}
+	if framing != "" {
+		options = append(options, watcher.WithFraming(framing))
+	}
+	files := 0
+	for _, spec := range sinkSpecs {
+		option, file, err := sinkSpecOption("--sink", spec, rotation)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+		if file {
+			files++
+		}
+	}
+	for _, route := range routes {
+		value, spec, ok := strings.Cut(route, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid --route %q (must be VALUE=SINK)", route)
+		}
+		option, file, err := sinkSpecOption("--route", spec, rotation)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, watcher.WithRoute(value, option))
+		if file {
+			files++
+		}
+	}
+	if routeKey != "" {
+		options = append(options, watcher.WithRouteKey(routeKey))
+	}
+	profiles, profileFiles, err := profileOptions(rotation)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, profiles...)
+	files += profileFiles
+	if outputFile != "" {
+		options = append(options, watcher.WithOutputFile(outputFile, outputFormat, gzipOutput, rotation))
+		files++
+	} else if len(sinkSpecs) == 0 && outputDir == "" && !tuiMode && len(watchProfiles) == 0 {
+		options = append(options, watcher.WithOutput(eventOutput, outputFormat))
+	}
+	if outputDir != "" {
+		// A watcher replacing another after a config reload continues the files of the pods
+		options = append(options, watcher.WithOutputDir(outputDir, outputFormat, reloading))
+	}
+	if files == 0 {
+		if gzipOutput {
+			return nil, fmt.Errorf("--gzip requires --output-file")
+		}
+		if rotation.MaxSize > 0 {
+			return nil, fmt.Errorf("--max-file-size requires --output-file")
+		}
+	}
+	if rotation.MaxSize > 0 && rotation.MaxFiles < 1 {
+		return nil, fmt.Errorf("--max-files must be at least 1 when rotating output files")
+	}
+	if sinkQueueDir != "" {
+		size, err := resource.ParseQuantity(sinkQueueMaxSize)
+		if err != nil || size.Value() < 0 {
+			return nil, fmt.Errorf("invalid --sink-queue-max-size %q (must be a size, e.g. 1Gi)", sinkQueueMaxSize)
+		}
+		options = append(options, watcher.WithSinkQueue(watcher.SinkQueueOptions{
+			Dir:       sinkQueueDir,
+			Retention: sinkQueueRetention,
+			MaxSize:   size.Value(),
+		}))
+	}
+	if webhookURL != "" {
+		options = append(options, watcher.WithWebhook(webhookOptions(webhookURL)))
+	}
+	if len(kafkaBrokers) > 0 || kafkaTopic != "" {
+		password := kafkaPassword
+		if password == "" {
+			password = os.Getenv("POD_WATCHER_KAFKA_PASSWORD")
+		}
+		options = append(options, watcher.WithKafka(watcher.KafkaOptions{
+			Brokers: kafkaBrokers,
+			Topic:   kafkaTopic,
+			TLS: watcher.TLSOptions{
+				Enabled:            kafkaTLS,
+				CAFile:             kafkaTLSCA,
+				CertFile:           kafkaTLSCert,
+				KeyFile:            kafkaTLSKey,
+				InsecureSkipVerify: kafkaTLSInsecure,
+			},
+			SASLMechanism: kafkaSASL,
+			SASLUsername:  kafkaUsername,
+			SASLPassword:  password,
+			BatchSize:     kafkaBatchSize,
+			BatchTimeout:  kafkaBatchTimeout,
+			CloudEvents:   kafkaCloudEvents,
+			ArraySize:     webhookBatchSize,
+			ArrayInterval: webhookBatchInterval,
+		}))
+	}
+	if natsURL != "" || natsSubject != "" {
+		token := natsToken
+		if token == "" {
+			token = os.Getenv("POD_WATCHER_NATS_TOKEN")
+		}
+		options = append(options, watcher.WithNATS(watcher.NATSOptions{
+			URL:         natsURL,
+			Subject:     natsSubject,
+			Credentials: natsCredentials,
+			Token:       token,
+			TLS: watcher.TLSOptions{
+				Enabled:            natsTLS,
+				CAFile:             natsTLSCA,
+				CertFile:           natsTLSCert,
+				KeyFile:            natsTLSKey,
+				InsecureSkipVerify: natsTLSInsecure,
+			},
+			JetStream:  natsJetStream,
+			AckTimeout: natsAckTimeout,
+		}))
+	}
+	if archiveURL != "" {
+		size, err := resource.ParseQuantity(archiveChunkSize)
+		if err != nil || size.Value() <= 0 {
+			return nil, fmt.Errorf("invalid --archive-chunk-size %q (must be a positive size, e.g. 64Mi)", archiveChunkSize)
+		}
+		options = append(options, watcher.WithArchive(watcher.ArchiveOptions{
+			URL:           archiveURL,
+			ChunkSize:     size.Value(),
+			ChunkInterval: archiveChunkInterval,
+			Encryption:    archiveEncryption,
+			KMSKey:        archiveKMSKey,
+			Region:        archiveRegion,
+			Endpoint:      archiveEndpoint,
+		}))
+	}
+	for _, notify := range []struct{ service, url, env string }{
+		{watcher.NotifySlack, slackWebhook, "POD_WATCHER_SLACK_WEBHOOK"},
+		{watcher.NotifyTeams, teamsWebhook, "POD_WATCHER_TEAMS_WEBHOOK"},
+	} {
+		url := notify.url
+		if url == "" {
+			url = os.Getenv(notify.env)
+		}
+		if url == "" {
+			continue
+		}
+		options = append(options, watcher.WithNotifications(watcher.NotifyOptions{
+			Service:  notify.service,
+			URL:      url,
+			Triggers: notifyOn,
+			Interval: notifyInterval,
+			Timeout:  webhookTimeout,
+			Retries:  webhookRetries,
+			Backoff:  webhookBackoff,
+		}))
+	}
+	if storeSpec != "" {
+		path, err := storePath(storeSpec)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, watcher.WithStore(path))
+	}
+	return options, nil
+}
+
+// sinkSpecOption translates the sink spec of a --sink or --route flag, stdout[=FORMAT], file=PATH, or webhook=URL,
+// reporting whether the sink is a file
+func sinkSpecOption(flag, spec string, rotation watcher.FileRotation) (watcher.Option, bool, error) {
+	kind, value, _ := strings.Cut(spec, "=")
+	switch strings.ToLower(kind) {
+	case "stdout":
+		if tuiMode {
+			return nil, false, fmt.Errorf("%s %s cannot be used with --tui, which draws on stdout", flag, spec)
+		}
+		format := outputFormat
+		if value != "" {
+			format = value
+		}
+		return watcher.WithOutput(eventOutput, format), false, nil
+	case "file":
+		if value == "" {
+			return nil, false, fmt.Errorf("invalid %s %q: missing file path", flag, spec)
+		}
+		return watcher.WithOutputFile(value, outputFormat, gzipOutput, rotation), true, nil
+	case "webhook":
+		if value == "" {
+			return nil, false, fmt.Errorf("invalid %s %q: missing URL", flag, spec)
+		}
+		return watcher.WithWebhook(webhookOptions(value)), false, nil
+	default:
+		return nil, false, fmt.Errorf("invalid %s %q (must be stdout[=FORMAT], file=PATH, or webhook=URL)", flag, spec)
+	}
+}
+
+// webhookOptions returns the --webhook-* settings for a webhook at url
+func webhookOptions(url string) watcher.WebhookOptions {
+	secret := webhookSecret
+	if secret == "" {
+		secret = os.Getenv("POD_WATCHER_WEBHOOK_SECRET")
+	}
+	return watcher.WebhookOptions{
+		URL:           url,
+		Headers:       webhookHeaders,
+		Timeout:       webhookTimeout,
+		Retries:       webhookRetries,
+		Backoff:       webhookBackoff,
+		Secret:        secret,
+		BatchSize:     webhookBatchSize,
+		BatchInterval: webhookBatchInterval,
+		CloudEvents:   webhookCloudEvents,
+	}
+}
+
+// colorOutput reports whether the output on stdout should be colorized according to --color
+func colorOutput() (bool, error) {
+	switch colorMode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && term.IsTerminal(int(os.Stdout.Fd())), nil
+	default:
+		return false, fmt.Errorf("invalid --color %q (must be auto, always, or never)", colorMode)
+	}
+}
+
+// outputRotation parses the output file rotation flags
+func outputRotation() (watcher.FileRotation, error) {
+	// A watcher replacing another after a config reload continues its output files
+	rotation := watcher.FileRotation{MaxFiles: maxFiles, Compress: compressRotate, Append: reloading}
+	if maxFileSize == "" {
+		return rotation, nil
+	}
+	size, err := resource.ParseQuantity(maxFileSize)
+	if err != nil {
+		return rotation, fmt.Errorf("invalid --max-file-size %q: %w", maxFileSize, err)
+	}
+	rotation.MaxSize = size.Value()
+	return rotation, nil
+}
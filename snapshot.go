@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stephenc/pod-watcher/pkg/watcher"
+)
+
+// snapshotMode is set by the snapshot command, making the watcher emit the matching pods once and stop
+var snapshotMode bool
+
+// snapshotFlags are the flags of the watch that the snapshot command shares, those selecting the cluster and the pods
+// and shaping what is emitted
+var snapshotFlags = []string{
+	"kubeconfig", "context", "all-contexts", "skip-permission-check", "resource",
+	"marker", "marker-regex", "marker-path", "marker-all", "filter-cel", "filter-plugin", "phase", "condition", "namespace", "all-namespaces",
+	"label-selector", "field-selector", "for", "exclude-namespace", "exclude-marker", "exclude-label-selector",
+	"watch-label", "watch-annotation", "strip", "redact", "redact-env", "redact-annotations", "redact-path",
+	"include-managed-fields-summary", "include-node", "resolve-owners", "page-size", "use-watch-list",
+	"timeout", "max-events", "summary", "summary-file", "exec", "exec-concurrency", "exec-timeout", "fake",
+}
+
+// snapshotCmd emits the pods matching at a point in time once, without watching them
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Emit the pods matching the filters now to the sinks, once, and exit",
+	Long: `snapshot lists the pods, or the objects of --resource, with the same filters as a watch, emits every matching one
+to the sinks as an ADDED event, and exits once they have all been delivered, to capture the state of a cluster in
+scripts without starting a watch. The lists are consistent reads of the API server's storage.
+
+Examples:
+  pod-watcher snapshot --marker DEBUG_MODE -o jsonl > debug-pods.jsonl
+  pod-watcher snapshot --all-namespaces --filter-cel "pod.status.phase == 'Failed'" -o table
+  pod-watcher snapshot --namespace team-a --label-selector app=web --sink webhook=https://example.com/inventory
+`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		snapshotMode = true
+		err := runSnapshot(cmd)
+		var exit *watcher.ExitError
+		if errors.As(err, &exit) {
+			slog.Error(exit.Message, "exitCode", exit.Code)
+			os.Exit(exit.Code)
+		}
+		return err
+	},
+}
+
+func init() {
+	for _, name := range snapshotFlags {
+		snapshotCmd.Flags().AddFlag(rootCmd.Flags().Lookup(name))
+	}
+	addSinkFlags(snapshotCmd)
+	addConnectionFlags(snapshotCmd)
+	registerCompletions(snapshotCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
+
+// runSnapshot emits the matching pods once
+func runSnapshot(cmd *cobra.Command) error {
+	start := time.Now()
+	if fakeEvents {
+		cluster, _ := watcher.NewFakeCluster("")
+		fakeClusters = []watcher.Cluster{cluster}
+	}
+	w, err := newWatcher(start)
+	if err != nil {
+		return err
+	}
+	currentWatcher.Store(w)
+	if printSummary || summaryFile != "" {
+		defer writeSummary()
+	}
+	return w.Run(cmd.Context())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	unitExecutable string
+	unitConfig     string
+	unitUser       string
+	unitWatchdog   time.Duration
+)
+
+// systemdUnitCmd prints an example unit running the watcher as a host service
+var systemdUnitCmd = &cobra.Command{
+	Use:   "systemd-unit [-- WATCH FLAGS...]",
+	Short: "Print an example systemd unit running the watcher as a long-lived host service",
+	Long: `systemd-unit prints a systemd service unit running pod-watcher --daemon with the --config file, logging natively to
+journald, restarted on failure and by its watchdog, with the flags after -- added to its command line. Install it with:
+
+  pod-watcher systemd-unit -- --kubeconfig /etc/pod-watcher/kubeconfig > /etc/systemd/system/pod-watcher.service
+  systemctl daemon-reload && systemctl enable --now pod-watcher
+
+The service runs as --user, which must exist, with a read-only view of the system except for its state directory,
+/var/lib/pod-watcher, its working directory.
+`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		executable := unitExecutable
+		if executable == "" {
+			path, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("could not find the path of pod-watcher, give it with --executable: %w", err)
+			}
+			if executable, err = filepath.EvalSymlinks(path); err != nil {
+				return fmt.Errorf("could not resolve the path of pod-watcher, give it with --executable: %w", err)
+			}
+		}
+		command := []string{executable, "--daemon", "--log-format", "journald"}
+		if unitConfig != "" {
+			command = append(command, "--config", unitConfig)
+		}
+		command = append(command, args...)
+		_, err := fmt.Print(systemdUnit(command, unitUser, unitWatchdog))
+		return err
+	},
+}
+
+func init() {
+	systemdUnitCmd.Flags().StringVar(&unitExecutable, "executable", "", "Path of pod-watcher in the unit (defaults to that of this binary)")
+	systemdUnitCmd.Flags().StringVar(&unitConfig, "config-path", "/etc/pod-watcher/config.yaml", "Config file of the service, given to its --config (empty for none)")
+	systemdUnitCmd.Flags().StringVar(&unitUser, "user", "pod-watcher", "User running the service")
+	systemdUnitCmd.Flags().DurationVar(&unitWatchdog, "watchdog", time.Minute, "Restart the service when it stops pinging the watchdog for this long (0 disables the watchdog)")
+	rootCmd.AddCommand(systemdUnitCmd)
+}
+
+// systemdUnit returns the unit running the command
+func systemdUnit(command []string, user string, watchdog time.Duration) string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = systemdQuote(arg)
+	}
+	var unit strings.Builder
+	unit.WriteString(`[Unit]
+Description=Pod Watcher
+Documentation=https://github.com/stephenc/pod-watcher
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+`)
+	fmt.Fprintf(&unit, "ExecStart=%s\n", strings.Join(quoted, " "))
+	if user != "" {
+		fmt.Fprintf(&unit, "User=%s\n", user)
+	}
+	unit.WriteString(`Restart=on-failure
+RestartSec=5s
+`)
+	if watchdog > 0 {
+		fmt.Fprintf(&unit, "WatchdogSec=%ds\n", max(int(watchdog.Seconds()), 1))
+	}
+	unit.WriteString(`StateDirectory=pod-watcher
+WorkingDirectory=/var/lib/pod-watcher
+NoNewPrivileges=yes
+ProtectSystem=strict
+ProtectHome=yes
+PrivateTmp=yes
+
+[Install]
+WantedBy=multi-user.target
+`)
+	return unit.String()
+}
+
+// systemdQuote quotes an argument of ExecStart, escaping the specifiers and variables that systemd would expand
+func systemdQuote(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// Build information, set at release time with -ldflags "-X main.version=v1.2.3 -X main.commit=... -X main.buildDate=..."
+// and otherwise taken from the module and VCS information embedded by the Go toolchain (the build date then being the commit time)
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+var versionOutput string
+
+// versionCmd prints the build information
+var versionCmd = &cobra.Command{
+	Use:          "version",
+	Short:        "Print the version and build information",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info := currentBuildInfo()
+		switch versionOutput {
+		case "":
+			fmt.Printf("%s version %s\n", cmd.Root().DisplayName(), info.Version)
+			if info.Commit != "" {
+				fmt.Printf("  commit:   %s\n", info.Commit)
+			}
+			if info.BuildDate != "" {
+				fmt.Printf("  built:    %s\n", info.BuildDate)
+			}
+			fmt.Printf("  go:       %s\n", info.GoVersion)
+			fmt.Printf("  platform: %s\n", info.Platform)
+			return nil
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(info)
+		default:
+			return fmt.Errorf("invalid --output %q: must be json", versionOutput)
+		}
+	},
+}
+
+func init() {
+	versionCmd.Flags().StringVarP(&versionOutput, "output", "o", "", "Output format: json (defaults to text)")
+	rootCmd.AddCommand(versionCmd)
+}
+
+// buildInfo describes the build of the binary
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// currentBuildInfo returns the build information set with -ldflags, filling the rest in from the embedded build settings
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if embedded, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && embedded.Main.Version != "(devel)" {
+			info.Version = embedded.Main.Version
+		}
+		modified := false
+		for _, setting := range embedded.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && commit == "" && info.Commit != "" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.Version == "" {
+		info.Version = "devel"
+	}
+	return info
+}